@@ -0,0 +1,195 @@
+package clover
+
+import (
+	"errors"
+	"sort"
+
+	d "github.com/ostafen/clover/v2/document"
+	"github.com/ostafen/clover/v2/index"
+	"github.com/ostafen/clover/v2/internal"
+	"github.com/ostafen/clover/v2/query"
+	"github.com/ostafen/clover/v2/store"
+	"github.com/ostafen/clover/v2/util"
+)
+
+// Aggregate groups the documents satisfying the criteria of q according to agg, and returns a document for each group.
+// Each result document contains the grouping fields, followed by the output fields of the accumulators.
+// Results are sorted by the values of the grouping fields, unless q specifies different sort options.
+// Skip and limit options of q apply to the result documents.
+func (db *DB) Aggregate(q *query.Query, agg *query.Aggregation) ([]*d.Document, error) {
+	tx, err := db.store.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	return db.aggregate(tx, q, agg)
+}
+
+func (db *DB) aggregate(tx store.Tx, q *query.Query, agg *query.Aggregation) ([]*d.Document, error) {
+	q, err := normalizeCriteria(q)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := db.getCollectionMeta(q.Collection(), tx)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*d.Document, 0)
+	output := &consumerNode{consumer: func(doc *d.Document) error {
+		results = append(results, doc)
+		return nil
+	}}
+
+	nd := buildAggregationPlan(q, agg, db.getIndexes(tx, q.Collection(), meta), output)
+	return results, execPlan(nd, tx)
+}
+
+// buildAggregationPlan builds a plan where the documents selected by the criteria of q are grouped by an aggregateNode.
+// Sort, skip and limit options of q are applied to the groups.
+func buildAggregationPlan(q *query.Query, agg *query.Aggregation, indexes []index.Index, outputNode planNode) inputNode {
+	aggNode := &aggregateNode{agg: agg}
+	inputNode := buildQueryPlan(query.NewQuery(q.Collection()).Where(q.Criteria()), indexes, aggNode)
+
+	prevNode := appendSortAndSkipLimit(aggNode, q, false)
+	prevNode.SetNext(outputNode)
+	return inputNode
+}
+
+type accumulatorState struct {
+	n        int
+	intSum   int64
+	floatSum float64
+	isFloat  bool
+	value    interface{}
+}
+
+func (s *accumulatorState) add(acc query.Accumulator, doc *d.Document) {
+	if acc.OpType == query.CountOp {
+		s.n++
+		return
+	}
+
+	v := doc.Get(acc.Field)
+	switch acc.OpType {
+	case query.SumOp, query.AvgOp:
+		if !util.IsNumber(v) {
+			return
+		}
+
+		s.n++
+		if _, isFloat := v.(float64); isFloat || s.isFloat {
+			if !s.isFloat {
+				s.floatSum = float64(s.intSum)
+				s.isFloat = true
+			}
+			s.floatSum += util.ToFloat64(v)
+		} else {
+			s.intSum += util.ToInt64(v)
+		}
+	case query.MinOp, query.MaxOp:
+		if v == nil { // missing fields and null values are ignored
+			return
+		}
+
+		s.n++
+		if s.n == 1 {
+			s.value = v
+			return
+		}
+
+		res := internal.Compare(v, s.value)
+		if (acc.OpType == query.MinOp && res < 0) || (acc.OpType == query.MaxOp && res > 0) {
+			s.value = v
+		}
+	}
+}
+
+func (s *accumulatorState) result(acc query.Accumulator) interface{} {
+	switch acc.OpType {
+	case query.CountOp:
+		return s.n
+	case query.SumOp:
+		if s.isFloat {
+			return s.floatSum
+		}
+		return s.intSum
+	case query.AvgOp:
+		if s.n == 0 {
+			return nil
+		}
+
+		if s.isFloat {
+			return s.floatSum / float64(s.n)
+		}
+		return float64(s.intSum) / float64(s.n)
+	}
+	return s.value
+}
+
+type aggregateGroup struct {
+	key    []interface{}
+	states []accumulatorState
+}
+
+// aggregateNode keeps the groups sorted by key, so that keys are compared in the same way documents are sorted.
+type aggregateNode struct {
+	planNodeBase
+	agg    *query.Aggregation
+	groups []*aggregateGroup
+}
+
+func (nd *aggregateNode) getGroup(key []interface{}) *aggregateGroup {
+	i := sort.Search(len(nd.groups), func(i int) bool {
+		return internal.Compare(nd.groups[i].key, key) >= 0
+	})
+
+	if i < len(nd.groups) && internal.Compare(nd.groups[i].key, key) == 0 {
+		return nd.groups[i]
+	}
+
+	group := &aggregateGroup{
+		key:    key,
+		states: make([]accumulatorState, len(nd.agg.Accumulators())),
+	}
+
+	nd.groups = append(nd.groups, nil)
+	copy(nd.groups[i+1:], nd.groups[i:])
+	nd.groups[i] = group
+	return group
+}
+
+func (nd *aggregateNode) Callback(doc *d.Document) error {
+	key := make([]interface{}, 0, len(nd.agg.GroupFields()))
+	for _, field := range nd.agg.GroupFields() {
+		key = append(key, doc.Get(field))
+	}
+
+	group := nd.getGroup(key)
+	for i, acc := range nd.agg.Accumulators() {
+		group.states[i].add(acc, doc)
+	}
+	return nil
+}
+
+func (nd *aggregateNode) Finish() error {
+	for _, group := range nd.groups {
+		doc := d.NewDocument()
+		for i, field := range nd.agg.GroupFields() {
+			doc.Set(field, group.key[i])
+		}
+
+		for i, acc := range nd.agg.Accumulators() {
+			doc.Set(acc.Name, group.states[i].result(acc))
+		}
+
+		if err := nd.CallNext(doc); err != nil {
+			if errors.Is(err, internal.ErrStopIteration) {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
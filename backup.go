@@ -0,0 +1,468 @@
+package clover
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	d "github.com/ostafen/clover/v2/document"
+	"github.com/ostafen/clover/v2/index"
+	"github.com/ostafen/clover/v2/store"
+)
+
+// ErrInvalidBackup is returned when restoring from a corrupted, truncated or unsupported backup.
+var ErrInvalidBackup = errors.New("invalid backup")
+
+// A backup starts with a header, made of backupMagic and backupVersion, followed by a sequence of records.
+// Each record is made of a type byte, the length of its payload encoded as an uvarint, and the payload itself.
+// The last record holds the SHA-256 checksum of all the preceding bytes, including its own type and length.
+const (
+	backupMagic   = "CLOVERDB"
+	backupVersion = 1
+
+	collectionRecord = 'c' // payload: JSON encoded backupCollection
+	documentRecord   = 'd' // payload: encoded document, belonging to the last collection
+	checksumRecord   = 'z' // payload: SHA-256 checksum
+)
+
+// maxRecordSize bounds the memory allocated for a single record, so that a corrupted length cannot cause huge allocations.
+const maxRecordSize = 1 << 30
+
+type backupCollection struct {
+	Name string
+	Meta *collectionMetadata
+}
+
+// Backup writes a snapshot of the whole database to w. Collections are saved together with their options and index definitions.
+// The snapshot is taken inside a single read transaction, so it is consistent even if the database is concurrently modified.
+// Backups are independent from the underlying store, and can be restored into any store by calling Restore.
+func (db *DB) Backup(w io.Writer) error {
+	tx, err := db.store.Begin(false)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	bw := bufio.NewWriter(w)
+	h := sha256.New()
+	out := io.MultiWriter(bw, h)
+
+	if _, err := out.Write(append([]byte(backupMagic), backupVersion)); err != nil {
+		return err
+	}
+
+	collections, err := listCollections(tx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, coll := range collections {
+		data, err := json.Marshal(coll)
+		if err != nil {
+			return err
+		}
+
+		if err := writeRecord(out, collectionRecord, data); err != nil {
+			return err
+		}
+
+		err = iteratePrefix([]byte(getDocumentKeyPrefix(coll.Name)), tx, func(item store.Item) error {
+			doc, err := d.Decode(item.Value)
+			if err != nil {
+				return err
+			}
+
+			// expired documents which have not been purged yet are no longer visible
+			if doc.IsExpired(now) {
+				return nil
+			}
+			return writeRecord(out, documentRecord, item.Value)
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := writeRecordHeader(out, checksumRecord, h.Size()); err != nil {
+		return err
+	}
+
+	if _, err := bw.Write(h.Sum(nil)); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func listCollections(tx store.Tx) ([]backupCollection, error) {
+	collections := make([]backupCollection, 0)
+
+	prefix := []byte(getCollectionKeyPrefix())
+	err := iteratePrefix(prefix, tx, func(item store.Item) error {
+		meta := &collectionMetadata{}
+		if err := json.Unmarshal(item.Value, meta); err != nil {
+			return err
+		}
+
+		name := string(bytes.TrimPrefix(item.Key, prefix))
+		collections = append(collections, backupCollection{Name: name, Meta: meta})
+		return nil
+	})
+	return collections, err
+}
+
+func writeRecordHeader(w io.Writer, recordType byte, size int) error {
+	buf := make([]byte, 1+binary.MaxVarintLen64)
+	buf[0] = recordType
+	n := binary.PutUvarint(buf[1:], uint64(size))
+
+	_, err := w.Write(buf[:1+n])
+	return err
+}
+
+func writeRecord(w io.Writer, recordType byte, payload []byte) error {
+	if err := writeRecordHeader(w, recordType, len(payload)); err != nil {
+		return err
+	}
+
+	_, err := w.Write(payload)
+	return err
+}
+
+// Restore reads a backup produced by Backup, and loads its collections into the supplied store, rebuilding their indexes.
+// The whole backup is verified, including its checksum, before any data is written: if r is not an io.ReadSeeker,
+// it is first copied to a temporary file. If any collection of the backup already exists in the store, an ErrCollectionExist is returned.
+// Documents are then loaded in batches, each one committed by a separate transaction, so that large backups can be restored
+// into stores which limit the size of transactions. Since the backup has already been verified, loading can only fail because of
+// an error of the store: in that case, the collections restored so far are left in the store, and must be dropped before retrying.
+func Restore(r io.Reader, dataStore store.Store) error {
+	rs, cleanup, err := stageBackup(r)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	db := &DB{store: dataStore}
+
+	collections, err := verifyBackup(rs)
+	if err != nil {
+		return err
+	}
+
+	if err := db.checkRestoredCollections(collections); err != nil {
+		return err
+	}
+
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return db.restore(rs)
+}
+
+// stageBackup returns a seekable reader over the content of r, so that the backup can be read twice.
+// The returned function releases the resources allocated for the staging, if any.
+func stageBackup(r io.Reader) (io.ReadSeeker, func(), error) {
+	if rs, ok := r.(io.ReadSeeker); ok {
+		return rs, func() {}, nil
+	}
+
+	f, err := ioutil.TempFile("", "clover-restore-*")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cleanup := func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return f, cleanup, nil
+}
+
+type backupReader struct {
+	r *bufio.Reader
+	h hash.Hash
+}
+
+func newBackupReader(r io.Reader) (*backupReader, error) {
+	br := &backupReader{r: bufio.NewReader(r), h: sha256.New()}
+
+	header := make([]byte, len(backupMagic)+1)
+	if err := br.readFull(header); err != nil || string(header[:len(backupMagic)]) != backupMagic {
+		return nil, fmt.Errorf("%w: bad header", ErrInvalidBackup)
+	}
+
+	if header[len(backupMagic)] != backupVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidBackup, header[len(backupMagic)])
+	}
+	return br, nil
+}
+
+func (br *backupReader) ReadByte() (byte, error) {
+	b, err := br.r.ReadByte()
+	if err == nil {
+		br.h.Write([]byte{b})
+	}
+	return b, err
+}
+
+func (br *backupReader) readFull(buf []byte) error {
+	if _, err := io.ReadFull(br.r, buf); err != nil {
+		return err
+	}
+	br.h.Write(buf)
+	return nil
+}
+
+// readRecord returns the next record of the backup. Reaching the end of data before the checksum record is reported as an ErrInvalidBackup.
+func (br *backupReader) readRecord() (byte, []byte, error) {
+	recordType, payload, err := br.readRawRecord()
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return 0, nil, fmt.Errorf("%w: unexpected end of data", ErrInvalidBackup)
+	}
+	return recordType, payload, err
+}
+
+func (br *backupReader) readRawRecord() (byte, []byte, error) {
+	recordType, err := br.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	size, err := binary.ReadUvarint(br)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if size > maxRecordSize {
+		return 0, nil, fmt.Errorf("%w: record too large", ErrInvalidBackup)
+	}
+
+	// the checksum covers all the bytes preceding the payload of the checksum record
+	if recordType == checksumRecord {
+		sum := br.h.Sum(nil)
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(br.r, payload); err != nil {
+			return 0, nil, err
+		}
+
+		if !bytes.Equal(sum, payload) {
+			return 0, nil, fmt.Errorf("%w: checksum mismatch", ErrInvalidBackup)
+		}
+		return recordType, nil, nil
+	}
+
+	payload := make([]byte, size)
+	return recordType, payload, br.readFull(payload)
+}
+
+func decodeBackupCollection(payload []byte) (*backupCollection, error) {
+	coll := &backupCollection{}
+	if err := json.Unmarshal(payload, coll); err != nil || coll.Meta == nil {
+		return nil, fmt.Errorf("%w: bad collection record", ErrInvalidBackup)
+	}
+	return coll, nil
+}
+
+// verifyBackup reads the whole backup, checking its structure and its checksum. It returns the names of the collections of the backup.
+func verifyBackup(r io.Reader) ([]string, error) {
+	br, err := newBackupReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	collections := make([]string, 0)
+	for {
+		recordType, payload, err := br.readRecord()
+		if err != nil {
+			return nil, err
+		}
+
+		switch recordType {
+		case collectionRecord:
+			coll, err := decodeBackupCollection(payload)
+			if err != nil {
+				return nil, err
+			}
+			collections = append(collections, coll.Name)
+		case documentRecord:
+			if len(collections) == 0 {
+				return nil, fmt.Errorf("%w: document outside of a collection", ErrInvalidBackup)
+			}
+
+			if _, err := d.Decode(payload); err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidBackup, err.Error())
+			}
+		case checksumRecord:
+			return collections, nil
+		default:
+			return nil, fmt.Errorf("%w: unknown record type %d", ErrInvalidBackup, recordType)
+		}
+	}
+}
+
+func (db *DB) checkRestoredCollections(collections []string) error {
+	tx, err := db.store.Begin(false)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, name := range collections {
+		exists, err := db.hasCollection(name, tx)
+		if err != nil {
+			return err
+		}
+
+		if exists {
+			return ErrCollectionExist
+		}
+	}
+	return nil
+}
+
+// Each transaction of a restore writes at most restoreBatchSize documents, or restoreBatchBytes bytes of encoded documents.
+const (
+	restoreBatchSize  = DefaultImportBatchSize
+	restoreBatchBytes = 1 << 20
+)
+
+// collectionRestore loads the documents of a collection in batches. The metadata of the collection is saved with each batch,
+// so that its size always matches the number of documents committed so far.
+type collectionRestore struct {
+	db      *DB
+	coll    *backupCollection
+	tx      store.Tx
+	indexes []index.Index
+
+	batchSize, batchBytes int
+}
+
+func (db *DB) restore(r io.Reader) error {
+	br, err := newBackupReader(r)
+	if err != nil {
+		return err
+	}
+
+	var cr *collectionRestore
+	defer func() {
+		if cr != nil {
+			cr.tx.Rollback()
+		}
+	}()
+
+	for {
+		recordType, payload, err := br.readRecord()
+		if err != nil {
+			return err
+		}
+
+		switch recordType {
+		case collectionRecord:
+			if err := cr.commit(); err != nil {
+				return err
+			}
+
+			if cr, err = db.startCollectionRestore(payload); err != nil {
+				return err
+			}
+		case documentRecord:
+			if err := cr.restoreDocument(payload); err != nil {
+				return err
+			}
+		case checksumRecord:
+			return cr.commit()
+		}
+	}
+}
+
+func (db *DB) startCollectionRestore(payload []byte) (*collectionRestore, error) {
+	coll, err := decodeBackupCollection(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	// the size is recomputed, since expired documents are not included in the backup
+	coll.Meta.Size = 0
+
+	cr := &collectionRestore{db: db, coll: coll}
+	if err := cr.begin(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+func (cr *collectionRestore) begin() error {
+	tx, err := cr.db.store.Begin(true)
+	if err != nil {
+		return err
+	}
+
+	cr.tx = tx
+	cr.indexes = cr.db.getIndexes(tx, cr.coll.Name, cr.coll.Meta)
+	cr.batchSize, cr.batchBytes = 0, 0
+	return nil
+}
+
+func (cr *collectionRestore) commit() error {
+	if cr == nil {
+		return nil
+	}
+
+	defer cr.tx.Rollback()
+
+	if err := cr.db.saveCollectionMetadata(cr.coll.Name, cr.coll.Meta, cr.tx); err != nil {
+		return err
+	}
+	return cr.tx.Commit()
+}
+
+// restoreDocument stores the encoded document as it is, so that the version of documents of versioned collections is preserved.
+func (cr *collectionRestore) restoreDocument(data []byte) error {
+	if cr.batchSize > 0 && (cr.batchSize >= restoreBatchSize || cr.batchBytes+len(data) > restoreBatchBytes) {
+		if err := cr.commit(); err != nil {
+			return err
+		}
+
+		if err := cr.begin(); err != nil {
+			return err
+		}
+	}
+
+	doc, err := d.Decode(data)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidBackup, err.Error())
+	}
+
+	if err := cr.db.addDocToIndexes(cr.tx, cr.indexes, doc); err != nil {
+		return err
+	}
+
+	if err := updateExpiration(cr.tx, cr.coll.Name, nil, doc); err != nil {
+		return err
+	}
+
+	cr.coll.Meta.Size++
+	cr.batchSize++
+	cr.batchBytes += len(data)
+	return cr.tx.Set([]byte(getDocumentKey(cr.coll.Name, doc.ObjectId())), data)
+}
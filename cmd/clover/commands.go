@@ -0,0 +1,479 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	c "github.com/ostafen/clover/v2"
+	d "github.com/ostafen/clover/v2/document"
+	"github.com/ostafen/clover/v2/index"
+	"github.com/ostafen/clover/v2/query"
+	"github.com/ostafen/clover/v2/server"
+	"github.com/ostafen/clover/v2/shell"
+)
+
+func init() {
+	commands = []*command{
+		{name: "collections", help: "list collections, with their size and number of indexes", run: listCollections},
+		{name: "indexes", args: "<collection>", help: "list the indexes of a collection", run: listIndexes},
+		{name: "count", args: "<collection>", help: "count the documents matching a filter", run: countDocs, flags: queryFlags},
+		{name: "find", args: "<collection>", help: "print the documents matching a filter", run: findDocs, flags: findFlags},
+		{name: "sql", args: "<statement>", help: "print the documents selected by a SQL-like statement, such as \"SELECT * FROM todos WHERE completed = false\"", run: runSQL, flags: sqlFlags},
+		{name: "create-index", args: "<collection> <field>...", help: "create an index (a compound one, if more fields are given). Descending fields are prefixed by -, and must follow --", run: createIndex, write: true, flags: createIndexFlags},
+		{name: "drop-index", args: "<collection> <index>", help: "drop an index", run: dropIndex, write: true},
+		{name: "import", args: "<collection> <file>", help: "import documents from a file (- for stdin)", run: importDocs, write: true, flags: importFlags},
+		{name: "export", args: "<collection> <file>", help: "export the documents matching a filter to a file (- for stdout)", run: exportDocs, flags: exportFlags},
+		{name: "check", help: "check the integrity of the database", run: checkIntegrity},
+		{name: "shell", help: "start an interactive shell to query the database", run: runShell, flags: shellFlags},
+		{name: "serve", help: "serve the database over HTTP, until interrupted", run: serve, write: true, flags: serveFlags},
+	}
+}
+
+func requireArgs(fs *flag.FlagSet, args []string, n int) error {
+	if len(args) < n {
+		fs.Usage()
+		return errUsage
+	}
+	return nil
+}
+
+func listCollections(env *env, fs *flag.FlagSet, args []string) error {
+	collections, err := env.db.ListCollections()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(env.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "COLLECTION\tDOCUMENTS\tINDEXES")
+	for _, name := range collections {
+		n, err := env.db.Count(query.NewQuery(name))
+		if err != nil {
+			return err
+		}
+
+		indexes, err := env.db.ListIndexes(name)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\n", name, n, len(indexes))
+	}
+	return w.Flush()
+}
+
+func listIndexes(env *env, fs *flag.FlagSet, args []string) error {
+	if err := requireArgs(fs, args, 1); err != nil {
+		return err
+	}
+
+	indexes, err := env.db.ListIndexes(args[0])
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(env.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "INDEX\tFIELDS\tUNIQUE")
+	for _, info := range indexes {
+		fields := info.Field
+		if info.Type != index.SingleField {
+			fields = formatSortOptions(info.Fields)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%t\n", info.Field, fields, info.Unique)
+	}
+	return w.Flush()
+}
+
+func formatSortOptions(opts []query.SortOption) string {
+	fields := make([]string, 0, len(opts))
+	for _, opt := range opts {
+		if opt.Direction < 0 {
+			fields = append(fields, "-"+opt.Field)
+		} else {
+			fields = append(fields, opt.Field)
+		}
+	}
+	return strings.Join(fields, ",")
+}
+
+// parseSortOptions parses a comma separated list of fields. Fields prefixed by a minus sign are sorted in descending order.
+func parseSortOptions(s string) []query.SortOption {
+	return query.ParseSortOptions(strings.Split(s, ","))
+}
+
+type queryOptions struct {
+	filter string
+	where  string
+	sort   string
+	skip   int
+	limit  int
+	fields string
+	format string
+}
+
+var queryOpts queryOptions
+
+func queryFlags(fs *flag.FlagSet) {
+	queryOpts = queryOptions{limit: -1}
+	fs.StringVar(&queryOpts.filter, "filter", "", `JSON filter, such as {"age": {"$gt": 30}}`)
+	fs.StringVar(&queryOpts.where, "where", "", "SQL-like condition, such as \"age > 30 AND name LIKE 'J%'\" (alternative to -filter)")
+}
+
+func findFlags(fs *flag.FlagSet) {
+	queryFlags(fs)
+	fs.StringVar(&queryOpts.sort, "sort", "", "comma separated list of sort fields, prefixed by - for descending order")
+	fs.IntVar(&queryOpts.skip, "skip", 0, "number of documents to skip")
+	fs.IntVar(&queryOpts.limit, "limit", -1, "maximum number of documents to print")
+	fs.StringVar(&queryOpts.fields, "select", "", "comma separated list of fields to print")
+	fs.StringVar(&queryOpts.format, "format", "pretty", "output format (pretty, json, ndjson or csv)")
+}
+
+func buildQuery(collection string) (*query.Query, error) {
+	if queryOpts.filter != "" && queryOpts.where != "" {
+		return nil, fmt.Errorf("-filter and -where cannot be used together")
+	}
+
+	q := query.NewQuery(collection)
+	if queryOpts.filter != "" {
+		criteria, err := query.ParseFilter([]byte(queryOpts.filter))
+		if err != nil {
+			return nil, err
+		}
+
+		if criteria != nil {
+			q = q.Where(criteria)
+		}
+	}
+
+	if queryOpts.where != "" {
+		criteria, err := query.ParseWhere(queryOpts.where)
+		if err != nil {
+			return nil, err
+		}
+		q = q.Where(criteria)
+	}
+
+	if opts := parseSortOptions(queryOpts.sort); len(opts) > 0 {
+		q = q.Sort(opts...)
+	}
+
+	if queryOpts.fields != "" {
+		q = q.Select(strings.Split(queryOpts.fields, ",")...)
+	}
+	return q.Skip(queryOpts.skip).Limit(queryOpts.limit), nil
+}
+
+func countDocs(env *env, fs *flag.FlagSet, args []string) error {
+	if err := requireArgs(fs, args, 1); err != nil {
+		return err
+	}
+
+	q, err := buildQuery(args[0])
+	if err != nil {
+		return err
+	}
+
+	n, err := env.db.Count(q)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(env.out, n)
+	return err
+}
+
+func findDocs(env *env, fs *flag.FlagSet, args []string) error {
+	if err := requireArgs(fs, args, 1); err != nil {
+		return err
+	}
+
+	q, err := buildQuery(args[0])
+	if err != nil {
+		return err
+	}
+
+	if queryOpts.format == "pretty" {
+		return printDocs(env, q)
+	}
+	return writeDocs(env.db, env.out, q, queryOpts.format)
+}
+
+var sqlFormat string
+
+func sqlFlags(fs *flag.FlagSet) {
+	fs.StringVar(&sqlFormat, "format", "pretty", "output format (pretty, json, ndjson or csv)")
+}
+
+func runSQL(env *env, fs *flag.FlagSet, args []string) error {
+	if err := requireArgs(fs, args, 1); err != nil {
+		return err
+	}
+
+	q, err := query.ParseSQL(strings.Join(args, " "))
+	if err != nil {
+		return err
+	}
+
+	if sqlFormat == "pretty" {
+		return printDocs(env, q)
+	}
+	return writeDocs(env.db, env.out, q, sqlFormat)
+}
+
+func printDocs(env *env, q *query.Query) error {
+	var printErr error
+	err := env.db.ForEach(q, func(doc *d.Document) bool {
+		data, err := json.MarshalIndent(doc.AsMap(), "", "  ")
+		if err == nil {
+			_, err = fmt.Fprintln(env.out, string(data))
+		}
+		printErr = err
+		return err == nil
+	})
+
+	if err != nil {
+		return err
+	}
+	return printErr
+}
+
+func writeDocs(db *c.DB, w io.Writer, q *query.Query, format string) error {
+	var err error
+	switch format {
+	case "json":
+		_, err = db.Export(w, q, c.ExportOptions{Format: c.FormatJSON})
+	case "ndjson":
+		_, err = db.Export(w, q, c.ExportOptions{Format: c.FormatNDJSON})
+	case "csv":
+		_, err = db.ExportCSV(w, q)
+	default:
+		err = fmt.Errorf("unknown format %q", format)
+	}
+	return err
+}
+
+type createIndexOptions struct {
+	unique bool
+	text   bool
+	geo    bool
+}
+
+var createIndexOpts createIndexOptions
+
+func createIndexFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&createIndexOpts.unique, "unique", false, "create a unique index (only for single field indexes)")
+	fs.BoolVar(&createIndexOpts.text, "text", false, "create a full-text index over a single string field")
+	fs.BoolVar(&createIndexOpts.geo, "geo", false, "create a geospatial index over a single field holding points")
+}
+
+func createIndex(env *env, fs *flag.FlagSet, args []string) error {
+	if err := requireArgs(fs, args, 2); err != nil {
+		return err
+	}
+
+	collection, fields := args[0], args[1:]
+	if createIndexOpts.text || createIndexOpts.geo {
+		if len(fields) > 1 || createIndexOpts.unique || (createIndexOpts.text && createIndexOpts.geo) {
+			return fmt.Errorf("full-text and geospatial indexes must be built over a single field, and cannot be unique")
+		}
+
+		if createIndexOpts.geo {
+			return env.db.CreateGeoIndex(collection, fields[0])
+		}
+		return env.db.CreateFullTextIndex(collection, fields[0])
+	}
+
+	if len(fields) > 1 {
+		if createIndexOpts.unique {
+			return fmt.Errorf("compound indexes cannot be unique")
+		}
+		return env.db.CreateCompoundIndex(collection, parseSortOptions(strings.Join(fields, ","))...)
+	}
+
+	if createIndexOpts.unique {
+		return env.db.CreateUniqueIndex(collection, fields[0])
+	}
+	return env.db.CreateIndex(collection, fields[0])
+}
+
+func dropIndex(env *env, fs *flag.FlagSet, args []string) error {
+	if err := requireArgs(fs, args, 2); err != nil {
+		return err
+	}
+	return env.db.DropIndex(args[0], args[1])
+}
+
+type importOptions struct {
+	format    string
+	mode      string
+	batchSize int
+}
+
+var importOpts importOptions
+
+func importFlags(fs *flag.FlagSet) {
+	fs.StringVar(&importOpts.format, "format", "json", "input format (json, ndjson or csv)")
+	fs.StringVar(&importOpts.mode, "mode", "append", "import mode (append, replace or upsert)")
+	fs.IntVar(&importOpts.batchSize, "batch", c.DefaultImportBatchSize, "number of documents inserted by each transaction")
+}
+
+func parseImportMode(mode string) (c.ImportMode, error) {
+	switch mode {
+	case "append":
+		return c.ImportAppend, nil
+	case "replace":
+		return c.ImportReplace, nil
+	case "upsert":
+		return c.ImportUpsert, nil
+	}
+	return 0, fmt.Errorf("unknown import mode %q", mode)
+}
+
+func importDocs(env *env, fs *flag.FlagSet, args []string) error {
+	if err := requireArgs(fs, args, 2); err != nil {
+		return err
+	}
+
+	mode, err := parseImportMode(importOpts.mode)
+	if err != nil {
+		return err
+	}
+
+	r := io.Reader(os.Stdin)
+	if args[1] != "-" {
+		file, err := os.Open(args[1])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	var n int
+	switch importOpts.format {
+	case "json", "ndjson":
+		format := c.FormatJSON
+		if importOpts.format == "ndjson" {
+			format = c.FormatNDJSON
+		}
+		n, err = env.db.Import(r, args[0], c.ImportOptions{Format: format, Mode: mode, BatchSize: importOpts.batchSize})
+	case "csv":
+		n, err = env.db.ImportCSV(r, args[0], c.CSVImportOptions{Mode: mode, BatchSize: importOpts.batchSize})
+	default:
+		return fmt.Errorf("unknown format %q", importOpts.format)
+	}
+
+	if err != nil {
+		return fmt.Errorf("import failed after %d documents: %w", n, err)
+	}
+
+	_, err = fmt.Fprintf(env.out, "%d documents imported\n", n)
+	return err
+}
+
+var exportFormat string
+
+func exportFlags(fs *flag.FlagSet) {
+	queryFlags(fs)
+	fs.StringVar(&exportFormat, "format", "json", "output format (json, ndjson or csv)")
+}
+
+func exportDocs(env *env, fs *flag.FlagSet, args []string) error {
+	if err := requireArgs(fs, args, 2); err != nil {
+		return err
+	}
+
+	q, err := buildQuery(args[0])
+	if err != nil {
+		return err
+	}
+
+	if args[1] == "-" {
+		return writeDocs(env.db, env.out, q, exportFormat)
+	}
+
+	file, err := os.Create(args[1])
+	if err != nil {
+		return err
+	}
+
+	if err := writeDocs(env.db, file, q, exportFormat); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func checkIntegrity(env *env, fs *flag.FlagSet, args []string) error {
+	issues, err := env.db.CheckIntegrity()
+	if err != nil {
+		return err
+	}
+
+	for _, issue := range issues {
+		fmt.Fprintln(env.out, issue)
+	}
+
+	if len(issues) > 0 {
+		return fmt.Errorf("%d integrity issues found", len(issues))
+	}
+
+	_, err = fmt.Fprintln(env.out, "no issues found")
+	return err
+}
+
+var historyFile string
+
+func shellFlags(fs *flag.FlagSet) {
+	defaultHistory := ""
+	if home, err := os.UserHomeDir(); err == nil {
+		defaultHistory = filepath.Join(home, ".clover_history")
+	}
+	fs.StringVar(&historyFile, "history", defaultHistory, "file where the command history is saved (empty to disable)")
+}
+
+func runShell(env *env, fs *flag.FlagSet, args []string) error {
+	return shell.New(env.db, shell.Options{HistoryFile: historyFile}).Run(os.Stdin, env.out)
+}
+
+var serveAddr string
+
+func serveFlags(fs *flag.FlagSet) {
+	fs.StringVar(&serveAddr, "addr", "localhost:8080", "address to listen on")
+}
+
+func serve(env *env, fs *flag.FlagSet, args []string) error {
+	listener, err := net.Listen("tcp", serveAddr)
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{Handler: server.New(env.db)}
+
+	done := make(chan error, 1)
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		done <- srv.Shutdown(ctx)
+	}()
+
+	fmt.Fprintf(env.out, "listening on http://%s\n", listener.Addr())
+	if err := srv.Serve(listener); err != http.ErrServerClosed {
+		return err
+	}
+	return <-done
+}
@@ -0,0 +1,213 @@
+// Command clover inspects and administers clover databases.
+//
+// Usage:
+//
+//	clover [-engine bbolt|badger] <dir> <command> [flags] [args]
+//
+// Commands which only read data open the database in read-only mode, so that they can be safely run on a live data directory
+// (as long as the store supports concurrent readers). Run "clover -h" for the list of the available commands.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	c "github.com/ostafen/clover/v2"
+	"github.com/ostafen/clover/v2/store"
+	badgerstore "github.com/ostafen/clover/v2/store/badger"
+	bboltstore "github.com/ostafen/clover/v2/store/bbolt"
+	"go.etcd.io/bbolt"
+)
+
+// errUsage is returned when the command line is malformed. The usage message has already been printed.
+var errUsage = errors.New("invalid usage")
+
+type command struct {
+	name  string
+	args  string
+	help  string
+	write bool
+	run   func(env *env, fs *flag.FlagSet, args []string) error
+	flags func(fs *flag.FlagSet)
+}
+
+type env struct {
+	db  *c.DB
+	out io.Writer
+}
+
+var commands []*command
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		if err != errUsage {
+			fmt.Fprintln(os.Stderr, "clover:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+func run(args []string, out, errOut io.Writer) error {
+	fs := flag.NewFlagSet("clover", flag.ContinueOnError)
+	fs.SetOutput(errOut)
+	engine := fs.String("engine", "", "storage engine (bbolt or badger). If empty, it is detected from the content of the directory")
+	fs.Usage = func() { printUsage(fs, errOut) }
+
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return errUsage
+	}
+
+	dir, cmdName := fs.Arg(0), fs.Arg(1)
+	cmd := findCommand(cmdName)
+	if cmd == nil {
+		fmt.Fprintf(errOut, "unknown command %q\n", cmdName)
+		fs.Usage()
+		return errUsage
+	}
+
+	cmdFlags := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	cmdFlags.SetOutput(errOut)
+	cmdFlags.Usage = func() {
+		fmt.Fprintf(errOut, "usage: clover <dir> %s [flags] %s\n\n%s\n", cmd.name, cmd.args, cmd.help)
+		cmdFlags.PrintDefaults()
+	}
+
+	if cmd.flags != nil {
+		cmd.flags(cmdFlags)
+	}
+
+	cmdArgs, err := parseInterspersed(cmdFlags, fs.Args()[2:])
+	if err != nil {
+		return errUsage
+	}
+
+	dataStore, err := openStore(dir, *engine, !cmd.write)
+	if err != nil {
+		return err
+	}
+
+	// documents are never purged by the tool, which could be running on a read-only store
+	db, err := c.OpenWithStore(dataStore, c.ExpirationSweepInterval(0))
+	if err != nil {
+		dataStore.Close()
+		return err
+	}
+
+	err = cmd.run(&env{db: db, out: out}, cmdFlags, cmdArgs)
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// parseInterspersed parses args, allowing flags to follow positional arguments, which are returned.
+// Arguments following "--" are always treated as positional.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	positional := make([]string, 0)
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+
+		rest := fs.Args()
+		if len(rest) == 0 {
+			return positional, nil
+		}
+
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func printUsage(fs *flag.FlagSet, w io.Writer) {
+	fmt.Fprintln(w, "usage: clover [flags] <dir> <command> [command flags] [args]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+
+	names := make([]string, 0, len(commands))
+	for _, cmd := range commands {
+		names = append(names, cmd.name)
+	}
+	sort.Strings(names)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, name := range names {
+		cmd := findCommand(name)
+		fmt.Fprintf(tw, "  %s\t%s\n", strings.TrimSpace(cmd.name+" "+cmd.args), cmd.help)
+	}
+	tw.Flush()
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "flags:")
+	fs.PrintDefaults()
+}
+
+const (
+	engineBbolt  = "bbolt"
+	engineBadger = "badger"
+)
+
+// detectEngine returns the engine of the database stored in dir, or an empty string if dir does not contain a database.
+func detectEngine(dir string) string {
+	if _, err := os.Stat(filepath.Join(dir, "data.db")); err == nil {
+		return engineBbolt
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "MANIFEST")); err == nil {
+		return engineBadger
+	}
+	return ""
+}
+
+func openStore(dir string, engine string, readOnly bool) (store.Store, error) {
+	if engine == "" {
+		engine = detectEngine(dir)
+	}
+
+	if engine == "" {
+		if readOnly {
+			return nil, fmt.Errorf("no database found in %s", dir)
+		}
+		engine = engineBbolt
+	}
+
+	switch engine {
+	case engineBbolt:
+		if !readOnly {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return nil, err
+			}
+		}
+		return bboltstore.OpenWithOptions(dir, &bbolt.Options{ReadOnly: readOnly, Timeout: time.Second})
+	case engineBadger:
+		return badgerstore.OpenWithOptions(badger.DefaultOptions(dir).WithReadOnly(readOnly).WithLogger(nil))
+	}
+	return nil, fmt.Errorf("unknown engine %q", engine)
+}
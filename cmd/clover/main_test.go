@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const todosPath = "../../test/data/todos.json"
+
+func runCommand(t *testing.T, args ...string) (string, error) {
+	var out, errOut bytes.Buffer
+	err := run(args, &out, &errOut)
+	return out.String(), err
+}
+
+func TestCommands(t *testing.T) {
+	for _, engine := range []string{engineBbolt, engineBadger} {
+		dir, err := os.MkdirTemp("", "clover-cli")
+		require.NoError(t, err)
+
+		dbDir := filepath.Join(dir, "db")
+
+		// inspection commands do not create databases
+		_, err = runCommand(t, dbDir, "collections")
+		require.Error(t, err)
+
+		out, err := runCommand(t, "-engine", engine, dbDir, "import", "todos", todosPath)
+		require.NoError(t, err)
+		require.Equal(t, "200 documents imported\n", out)
+
+		require.Equal(t, engine, detectEngine(dbDir))
+
+		out, err = runCommand(t, dbDir, "collections")
+		require.NoError(t, err)
+		require.Equal(t, "COLLECTION  DOCUMENTS  INDEXES\ntodos       200        0\n", out)
+
+		_, err = runCommand(t, dbDir, "create-index", "todos", "-unique", "id")
+		require.NoError(t, err)
+
+		_, err = runCommand(t, dbDir, "create-index", "todos", "--", "completed", "-userId")
+		require.NoError(t, err)
+
+		out, err = runCommand(t, dbDir, "indexes", "todos")
+		require.NoError(t, err)
+		require.Contains(t, out, "completed_1_userId_-1  completed,-userId  false")
+		require.Contains(t, out, "id                     id                 true")
+
+		out, err = runCommand(t, dbDir, "count", "todos", "-filter", `{"completed": true, "userId": {"$in": [1, 2]}}`)
+		require.NoError(t, err)
+		require.Equal(t, "19\n", out)
+
+		out, err = runCommand(t, dbDir, "find", "todos", "-filter", `{"userId": 1}`, "-sort", "-id", "-limit", "2", "-select", "id", "-format", "ndjson")
+		require.NoError(t, err)
+		found := out
+
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		require.Len(t, lines, 2)
+
+		ids := make([]float64, 0)
+		for _, line := range lines {
+			m := make(map[string]interface{})
+			require.NoError(t, json.Unmarshal([]byte(line), &m))
+			ids = append(ids, m["id"].(float64))
+		}
+		require.Equal(t, []float64{20, 19}, ids)
+
+		exportPath := filepath.Join(dir, "todos.csv")
+		_, err = runCommand(t, dbDir, "export", "todos", exportPath, "-format", "csv", "-filter", `{"completed": false}`)
+		require.NoError(t, err)
+
+		nPending, err := runCommand(t, dbDir, "count", "todos", "-filter", `{"completed": false}`)
+		require.NoError(t, err)
+
+		out, err = runCommand(t, dbDir, "import", "pending", exportPath, "-format", "csv")
+		require.NoError(t, err)
+		require.Equal(t, strings.TrimSpace(nPending)+" documents imported\n", out)
+
+		_, err = runCommand(t, dbDir, "drop-index", "todos", "id")
+		require.NoError(t, err)
+
+		out, err = runCommand(t, dbDir, "check")
+		require.NoError(t, err)
+		require.Equal(t, "no issues found\n", out)
+
+		_, err = runCommand(t, dbDir, "count", "todos", "-filter", `{"id": {"$foo": 1}}`)
+		require.EqualError(t, err, "invalid filter: unknown operator $foo")
+
+		out, err = runCommand(t, dbDir, "count", "todos", "-where", "completed = true AND userId IN (1, 2)")
+		require.NoError(t, err)
+		require.Equal(t, "19\n", out)
+
+		out, err = runCommand(t, dbDir, "sql", "-format", "ndjson", "SELECT id FROM todos WHERE userId = 1 ORDER BY id DESC LIMIT 2")
+		require.NoError(t, err)
+		require.Equal(t, found, out)
+
+		_, err = runCommand(t, dbDir, "create-index", "-text", "todos", "title")
+		require.NoError(t, err)
+
+		out, err = runCommand(t, dbDir, "indexes", "todos")
+		require.NoError(t, err)
+		require.Contains(t, out, "title_text")
+
+		nMatches, err := runCommand(t, dbDir, "count", "todos", "-where", "title MATCH 'qui'")
+		require.NoError(t, err)
+
+		out, err = runCommand(t, dbDir, "count", "todos", "-filter", `{"title": {"$search": "QUI"}}`)
+		require.NoError(t, err)
+		require.Equal(t, nMatches, out)
+		require.NotEqual(t, "0\n", out)
+
+		_, err = runCommand(t, dbDir, "create-index", "-geo", "todos", "location")
+		require.NoError(t, err)
+
+		out, err = runCommand(t, dbDir, "indexes", "todos")
+		require.NoError(t, err)
+		require.Contains(t, out, "location_geo")
+
+		_, err = runCommand(t, dbDir, "sql", "SELECT * FROM todos WHERE")
+		require.EqualError(t, err, "syntax error at position 26: expected field name, found end of input")
+
+		_, err = runCommand(t, dbDir, "unknown")
+		require.Equal(t, errUsage, err)
+
+		require.NoError(t, os.RemoveAll(dir))
+	}
+}
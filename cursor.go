@@ -0,0 +1,433 @@
+package clover
+
+import (
+	"encoding/base64"
+	"errors"
+	"sync"
+
+	d "github.com/ostafen/clover/v2/document"
+	"github.com/ostafen/clover/v2/index"
+	"github.com/ostafen/clover/v2/internal"
+	"github.com/ostafen/clover/v2/query"
+)
+
+// ErrInvalidToken is returned when a continuation token is malformed, or it was produced by a query with different sort options.
+var ErrInvalidToken = errors.New("invalid continuation token")
+
+var errPseudoFieldSort = errors.New("cursors cannot sort documents by relevance score or distance")
+
+// Cursor iterates the documents selected by a query, as soon as they are produced by the query plan.
+// A cursor holds a read transaction until it is exhausted or closed, so it must always be closed by calling Close.
+//
+// Documents are returned in the order requested by the query, with ties broken by the "_id" field,
+// so that the position of each document is uniquely identified by a continuation token.
+// If the query has no sort options, documents are returned in the order in which they are read:
+// by the values of the index serving the query, if any, and by "_id" otherwise. In both cases, they are not buffered in memory.
+type Cursor struct {
+	db   *DB
+	opts []query.SortOption
+
+	results   chan cursorResult
+	quit      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	planErr   error
+
+	doc *d.Document
+	key []interface{}
+	err error
+}
+
+type cursorResult struct {
+	doc *d.Document
+	key []interface{}
+}
+
+// Find returns a cursor over the documents satisfying q.
+func (db *DB) Find(q *query.Query) (*Cursor, error) {
+	return db.FindAfter(q, "")
+}
+
+// FindAfter returns a cursor over the documents satisfying q, which follow the position identified by token.
+// The token must have been returned by the Token method of a cursor created by a query with the same sort options.
+// If q has no sort options, the order of the cursor which returned the token is preserved.
+// Skip and limit options are applied to the documents following the position.
+// If token is empty, FindAfter is equivalent to Find.
+func (db *DB) FindAfter(q *query.Query, token string) (*Cursor, error) {
+	q, err := normalizeCriteria(q)
+	if err != nil {
+		return nil, err
+	}
+
+	if sortsByPseudoField(q) {
+		return nil, errPseudoFieldSort
+	}
+
+	tx, err := db.store.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := db.getCollectionMeta(q.Collection(), tx)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	indexes := db.getIndexes(tx, q.Collection(), meta)
+
+	q, after, err := prepareCursorQuery(q, indexes, token)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	cursor := &Cursor{
+		db:      db,
+		opts:    q.SortOptions(),
+		results: make(chan cursorResult),
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+		key:     after,
+	}
+
+	keyNode := &sortKeyNode{opts: q.SortOptions()}
+	output := &consumerNode{consumer: func(doc *d.Document) error {
+		select {
+		case cursor.results <- cursorResult{doc: doc, key: keyNode.key}:
+			return nil
+		case <-cursor.quit:
+			return internal.ErrStopIteration
+		}
+	}}
+
+	nd := buildCursorPlan(q, indexes, after, keyNode, output)
+
+	db.addCursor(cursor)
+	go func() {
+		defer db.removeCursor(cursor)
+		defer close(cursor.done)
+
+		err := execPlan(nd, tx)
+		tx.Rollback()
+
+		if !errors.Is(err, internal.ErrStopIteration) {
+			cursor.planErr = err
+		}
+		close(cursor.results)
+	}()
+	return cursor, nil
+}
+
+// Next advances the cursor to the next document, which is then returned by Doc.
+// It returns false when no more documents are available, or an error occurs. In the latter case, the error is returned by Err.
+func (c *Cursor) Next() bool {
+	res, ok := <-c.results
+	if !ok {
+		c.doc = nil
+		c.err = c.planErr
+		return false
+	}
+
+	c.doc, c.key = res.doc, res.key
+	return true
+}
+
+// Doc returns the current document of the cursor.
+func (c *Cursor) Doc() *d.Document {
+	return c.doc
+}
+
+// Err returns the error, if any, which stopped the iteration.
+func (c *Cursor) Err() error {
+	return c.err
+}
+
+// Token returns a continuation token identifying the position of the current document.
+// It can be passed to FindAfter to resume the iteration from the next document, even after the cursor has been closed.
+// If Next has never returned true, the token of the starting position is returned (an empty string, for cursors created by Find).
+func (c *Cursor) Token() (string, error) {
+	if c.key == nil {
+		return "", nil
+	}
+	return encodeToken(c.key, c.opts)
+}
+
+// Close releases the read transaction held by the cursor. It is safe to call Close multiple times.
+func (c *Cursor) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.quit)
+	})
+	<-c.done
+	return nil
+}
+
+func (db *DB) addCursor(c *Cursor) {
+	db.cursorsMu.Lock()
+	defer db.cursorsMu.Unlock()
+
+	if db.cursors == nil {
+		db.cursors = make(map[*Cursor]bool)
+	}
+	db.cursors[c] = true
+}
+
+func (db *DB) removeCursor(c *Cursor) {
+	db.cursorsMu.Lock()
+	defer db.cursorsMu.Unlock()
+
+	delete(db.cursors, c)
+}
+
+// closeAllCursors closes the cursors which are still running, so that their transactions do not prevent the store from being closed.
+func (db *DB) closeAllCursors() {
+	db.cursorsMu.Lock()
+	cursors := make([]*Cursor, 0, len(db.cursors))
+	for c := range db.cursors {
+		cursors = append(cursors, c)
+	}
+	db.cursorsMu.Unlock()
+
+	for _, c := range cursors {
+		c.Close()
+	}
+}
+
+// prepareCursorQuery adds to q the sort options of the cursor, and decodes the position identified by token, if any.
+func prepareCursorQuery(q *query.Query, indexes []index.Index, token string) (*query.Query, []interface{}, error) {
+	if len(q.SortOptions()) > 0 {
+		q = q.Sort(cursorSortOptions(q.SortOptions())...)
+	} else {
+		q = q.Sort(defaultCursorSortOptions(q, indexes, token)...)
+	}
+
+	if token == "" {
+		return q, nil, nil
+	}
+
+	after, err := decodeToken(token, q.SortOptions())
+	return q, after, err
+}
+
+// cursorSortOptions returns opts, followed by the "_id" field, if not already present.
+// The "_id" field has the same direction of the first option, since this is the order in which index records sharing the same value are read.
+func cursorSortOptions(opts []query.SortOption) []query.SortOption {
+	for _, opt := range opts {
+		if opt.Field == d.ObjectIdField {
+			return opts
+		}
+	}
+
+	direction := 1
+	if len(opts) > 0 {
+		direction = opts[0].Direction
+	}
+	return append(opts[:len(opts):len(opts)], query.SortOption{Field: d.ObjectIdField, Direction: direction})
+}
+
+// defaultCursorSortOptions returns the sort options of a cursor over a query without sort options.
+// When resuming from a token, the order of the cursor which produced it is preserved. Otherwise, the order in which
+// documents are read is chosen, so that they do not need to be sorted: the order of the fields of the index selected
+// for the query, if any, or else the order of document ids, in which the collection is scanned.
+func defaultCursorSortOptions(q *query.Query, indexes []index.Index, token string) []query.SortOption {
+	if opts, ok := getTokenSortOptions(token); ok {
+		return opts
+	}
+
+	itNode, _ := selectIterNode(q, indexes)
+	switch idxQuery := itNode.idxQuery.(type) {
+	case *index.RangeIndexQuery:
+		return cursorSortOptions([]query.SortOption{{Field: idxQuery.Idx.Field(), Direction: 1}})
+	case *index.CompoundIndexQuery:
+		// compound index records sharing the same values are sorted by ascending id, whatever the direction of the first field
+		fields := idxQuery.Idx.Fields()
+		return append(fields[:len(fields):len(fields)], query.SortOption{Field: d.ObjectIdField, Direction: 1})
+	}
+	return cursorSortOptions(nil)
+}
+
+func buildCursorPlan(q *query.Query, indexes []index.Index, after []interface{}, keyNode *sortKeyNode, outputNode planNode) inputNode {
+	itNode, isOutputSorted := selectIterNode(q, indexes)
+	opts := q.SortOptions()
+
+	var prevNode planNode = itNode
+	if after != nil {
+		seekPosition(itNode, opts, after)
+
+		nd := &startAfterNode{opts: opts, key: after}
+		prevNode.SetNext(nd)
+		prevNode = nd
+	}
+
+	if !isOutputSorted {
+		nd := &sortNode{opts: opts, compare: compareSortValues}
+		prevNode.SetNext(nd)
+		prevNode = nd
+	}
+
+	prevNode = appendSkipLimit(prevNode, q)
+	prevNode.SetNext(keyNode)
+
+	prevNode = appendProjection(keyNode, q)
+	prevNode.SetNext(outputNode)
+	return itNode
+}
+
+// seekPosition restricts the iteration to the documents whose value of the first sort field does not precede the supplied position,
+// when the collection is scanned by id, or an index over such field is iterated in ascending order.
+// The range is passed to the index as it is, so that values are never interpreted as field references.
+// Since null values and missing fields come first, nothing is skipped when the position holds a null value.
+func seekPosition(itNode *iterNode, opts []query.SortOption, after []interface{}) {
+	if opts[0].Direction < 0 || after[0] == nil {
+		return
+	}
+
+	if itNode.idxQuery == nil {
+		if id, isString := after[0].(string); isString && opts[0].Field == d.ObjectIdField {
+			itNode.seekId = id
+		}
+		return
+	}
+
+	if idxQuery, ok := itNode.idxQuery.(*index.RangeIndexQuery); ok && !idxQuery.Reverse && idxQuery.Idx.Field() == opts[0].Field {
+		seekRange := &index.Range{Start: after[0], StartIncluded: true}
+		if idxQuery.Range != nil {
+			seekRange = seekRange.Intersect(idxQuery.Range)
+		}
+		idxQuery.Range = seekRange
+	}
+}
+
+// compareSortValues compares documents according to sortOpts, treating missing fields as null values, as indexes do.
+func compareSortValues(first *d.Document, second *d.Document, sortOpts []query.SortOption) int {
+	for _, opt := range sortOpts {
+		if res := internal.Compare(first.Get(opt.Field), second.Get(opt.Field)); res != 0 {
+			return res * opt.Direction
+		}
+	}
+	return 0
+}
+
+func getSortKey(doc *d.Document, sortOpts []query.SortOption) []interface{} {
+	key := make([]interface{}, 0, len(sortOpts))
+	for _, opt := range sortOpts {
+		key = append(key, doc.Get(opt.Field))
+	}
+	return key
+}
+
+// startAfterNode discards the documents which do not follow the position identified by key.
+type startAfterNode struct {
+	planNodeBase
+	opts []query.SortOption
+	key  []interface{}
+}
+
+func (nd *startAfterNode) Callback(doc *d.Document) error {
+	for i, opt := range nd.opts {
+		res := internal.Compare(doc.Get(opt.Field), nd.key[i]) * opt.Direction
+		if res > 0 {
+			return nd.CallNext(doc)
+		}
+
+		if res < 0 {
+			return nil
+		}
+	}
+	return nil
+}
+
+// sortKeyNode records the sort key of the last document, before a projection can remove the sort fields.
+type sortKeyNode struct {
+	planNodeBase
+	opts []query.SortOption
+	key  []interface{}
+}
+
+func (nd *sortKeyNode) Callback(doc *d.Document) error {
+	nd.key = getSortKey(doc, nd.opts)
+	return nd.CallNext(doc)
+}
+
+const (
+	tokenSortField  = "s"
+	tokenValueField = "v"
+)
+
+// getTokenSortOptions returns the sort options recorded in token, if it is well formed.
+func getTokenSortOptions(token string) ([]query.SortOption, bool) {
+	if token == "" {
+		return nil, false
+	}
+
+	m, err := decodeTokenMap(token)
+	if err != nil {
+		return nil, false
+	}
+
+	fields, _ := m[tokenSortField].([]interface{})
+	opts := make([]query.SortOption, 0, len(fields))
+	for _, field := range fields {
+		s, _ := field.(string)
+		if len(s) < 2 || (s[0] != '+' && s[0] != '-') {
+			return nil, false
+		}
+
+		direction := 1
+		if s[0] == '-' {
+			direction = -1
+		}
+		opts = append(opts, query.SortOption{Field: s[1:], Direction: direction})
+	}
+	return opts, len(opts) > 0
+}
+
+func getTokenSortFields(opts []query.SortOption) []interface{} {
+	fields := make([]interface{}, 0, len(opts))
+	for _, opt := range opts {
+		if opt.Direction < 0 {
+			fields = append(fields, "-"+opt.Field)
+		} else {
+			fields = append(fields, "+"+opt.Field)
+		}
+	}
+	return fields
+}
+
+func encodeToken(key []interface{}, opts []query.SortOption) (string, error) {
+	data, err := internal.Encode(map[string]interface{}{
+		tokenSortField:  getTokenSortFields(opts),
+		tokenValueField: key,
+	})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeTokenMap(token string) (map[string]interface{}, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	m := make(map[string]interface{})
+	if err := internal.Decode(data, &m); err != nil {
+		return nil, ErrInvalidToken
+	}
+	return m, nil
+}
+
+func decodeToken(token string, opts []query.SortOption) ([]interface{}, error) {
+	m, err := decodeTokenMap(token)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, _ := m[tokenSortField].([]interface{})
+	key, _ := m[tokenValueField].([]interface{})
+	if internal.Compare(fields, getTokenSortFields(opts)) != 0 || len(key) != len(opts) {
+		return nil, ErrInvalidToken
+	}
+	return key, nil
+}
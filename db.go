@@ -5,13 +5,17 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/gofrs/uuid/v5"
 	d "github.com/ostafen/clover/v2/document"
 	"github.com/ostafen/clover/v2/index"
 	"github.com/ostafen/clover/v2/internal"
 	"github.com/ostafen/clover/v2/query"
+	"github.com/ostafen/clover/v2/schema"
 	"github.com/ostafen/clover/v2/store"
 	"github.com/ostafen/clover/v2/store/bbolt"
 )
@@ -26,6 +30,7 @@ var (
 
 	ErrDocumentNotExist = errors.New("no such document")
 	ErrDuplicateKey     = errors.New("duplicate key")
+	ErrVersionConflict  = errors.New("document version conflict")
 )
 
 type docConsumer func(doc *d.Document) error
@@ -34,21 +39,112 @@ type docConsumer func(doc *d.Document) error
 type DB struct {
 	store  store.Store
 	closed uint32
+
+	chWg   sync.WaitGroup
+	chQuit chan struct{}
+
+	watchMu  sync.Mutex
+	commitMu sync.Mutex // serializes the commit and publication of transactions producing change events
+	streams  map[*ChangeStream]bool
+	seq      uint64
+
+	cursorsMu sync.Mutex
+	cursors   map[*Cursor]bool
+
+	schemaWarningHandler SchemaWarningHandler
+}
+
+// Config contains the parameters which can be used to customize the behaviour of a database instance.
+type Config struct {
+	// ExpirationSweepInterval is the interval between two consecutive runs of the expired documents reaper.
+	// A non positive value disables the reaper, so that expired documents are only removed by calling PurgeExpired.
+	ExpirationSweepInterval time.Duration
+
+	// SchemaWarningHandler is called for each document violating the schema of a collection created with the ValidationWarn mode.
+	SchemaWarningHandler SchemaWarningHandler
+}
+
+// Option is a function used to set a configuration parameter.
+type Option func(c *Config) error
+
+// ExpirationSweepInterval sets the interval between two consecutive runs of the expired documents reaper.
+func ExpirationSweepInterval(interval time.Duration) Option {
+	return func(c *Config) error {
+		c.ExpirationSweepInterval = interval
+		return nil
+	}
+}
+
+// WithSchemaWarningHandler sets the function which is called for each document violating the schema of a collection created with the ValidationWarn mode.
+func WithSchemaWarningHandler(handler SchemaWarningHandler) Option {
+	return func(c *Config) error {
+		c.SchemaWarningHandler = handler
+		return nil
+	}
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		ExpirationSweepInterval: DefaultExpirationSweepInterval,
+		SchemaWarningHandler:    logSchemaWarning,
+	}
 }
 
 type collectionMetadata struct {
-	Size    int
-	Indexes []index.Info
+	Size           int
+	Indexes        []index.Info
+	Versioned      bool
+	Schema         *schema.Schema `json:",omitempty"`
+	ValidationMode ValidationMode `json:",omitempty"`
+}
+
+// CollectionOptions contains the options which can be supplied when creating a collection.
+type CollectionOptions struct {
+	// Versioned enables optimistic concurrency control. Each document of the collection carries a "_version" field,
+	// which is set to 1 on insertion and incremented by each update. Updates of documents carrying a version
+	// which is different from the stored one fail with ErrVersionConflict, as well as replacements of documents carrying no version.
+	Versioned bool
+
+	// Schema, if not nil, is used to validate each document which is inserted into the collection or updated.
+	Schema *schema.Schema
+
+	// ValidationMode tells what happens when a document violates Schema.
+	ValidationMode ValidationMode
 }
 
 // CreateCollection creates a new empty collection with the given name.
 func (db *DB) CreateCollection(name string) error {
-	tx, err := db.store.Begin(true)
+	tx, err := db.beginUpdate()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
+	if err := db.createCollection(tx, name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *DB) createCollection(tx store.Tx, name string) error {
+	return db.createCollectionWithOptions(tx, name, CollectionOptions{})
+}
+
+// CreateCollectionWithOptions creates a new empty collection with the given name and options.
+func (db *DB) CreateCollectionWithOptions(name string, opts CollectionOptions) error {
+	tx, err := db.beginUpdate()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := db.createCollectionWithOptions(tx, name, opts); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *DB) createCollectionWithOptions(tx store.Tx, name string, opts CollectionOptions) error {
 	ok, err := db.hasCollection(name, tx)
 	if err != nil {
 		return err
@@ -58,27 +154,48 @@ func (db *DB) CreateCollection(name string) error {
 		return ErrCollectionExist
 	}
 
-	meta := &collectionMetadata{Size: 0}
-	if err := db.saveCollectionMetadata(name, meta, tx); err != nil {
-		return err
+	if opts.Schema != nil {
+		if err := opts.Schema.Check(); err != nil {
+			return err
+		}
 	}
-	return tx.Commit()
+
+	meta := &collectionMetadata{
+		Size:           0,
+		Versioned:      opts.Versioned,
+		Schema:         opts.Schema,
+		ValidationMode: opts.ValidationMode,
+	}
+	return db.saveCollectionMetadata(name, meta, tx)
 }
 
 func (db *DB) CreateCollectionByQuery(name string, q *query.Query) error {
-	err := db.CreateCollection(name)
+	tx, err := db.beginUpdate()
 	if err != nil {
 		return err
 	}
-	docs, err := db.FindAll(q)
+	defer tx.Rollback()
+
+	if err := db.createCollectionByQuery(tx, name, q); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *DB) createCollectionByQuery(tx store.Tx, name string, q *query.Query) error {
+	if err := db.createCollection(tx, name); err != nil {
+		return err
+	}
+
+	docs, err := db.findAll(tx, q)
 	if err != nil {
 		return err
 	}
+
 	if len(docs) == 0 { // just an empty collection
 		return nil
-	} else {
-		return db.Insert(name, docs...)
 	}
+	return db.insert(tx, name, docs...)
 }
 
 func (db *DB) saveCollectionMetadata(collection string, meta *collectionMetadata, tx store.Tx) error {
@@ -104,12 +221,28 @@ func getCollectionKeyPrefix() string {
 
 // DropCollection removes the collection with the given name, deleting any content on disk.
 func (db *DB) DropCollection(name string) error {
-	tx, err := db.store.Begin(true)
+	tx, err := db.beginUpdate()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
+	if err := db.dropCollection(tx, name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *DB) dropCollection(tx store.Tx, name string) error {
+	ok, err := db.hasCollection(name, tx)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return ErrCollectionNotExist
+	}
+
 	if err := db.deleteAll(tx, name); err != nil {
 		return err
 	}
@@ -118,13 +251,28 @@ func (db *DB) DropCollection(name string) error {
 		return err
 	}
 
-	return tx.Commit()
+	recordChange(tx, ChangeDrop, name, nil, nil)
+	return nil
 }
 
+// deleteAll removes every key belonging to the collection (documents, index records and expiration entries),
+// including expired documents which have not been purged yet.
 func (db *DB) deleteAll(tx store.Tx, collName string) error {
-	return db.replaceDocs(tx, query.NewQuery(collName), func(_ *d.Document) *d.Document {
+	keys := make([][]byte, 0)
+	err := iteratePrefix([]byte("c:"+collName+";"), tx, func(item store.Item) error {
+		keys = append(keys, append([]byte{}, item.Key...))
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err := tx.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // HasCollection returns true if and only if the database contains a collection with the given name.
@@ -144,6 +292,19 @@ func NewObjectId() string {
 
 // Insert adds the supplied documents to a collection.
 func (db *DB) Insert(collectionName string, docs ...*d.Document) error {
+	tx, err := db.beginUpdate()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := db.insert(tx, collectionName, docs...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *DB) insert(tx store.Tx, collectionName string, docs ...*d.Document) error {
 	for _, doc := range docs {
 		if !doc.Has(d.ObjectIdField) || doc.Get(d.ObjectIdField) == "" {
 			objectId := NewObjectId()
@@ -151,12 +312,6 @@ func (db *DB) Insert(collectionName string, docs ...*d.Document) error {
 		}
 	}
 
-	tx, err := db.store.Begin(true)
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
-
 	meta, err := db.getCollectionMeta(collectionName, tx)
 	if err != nil {
 		return err
@@ -164,48 +319,68 @@ func (db *DB) Insert(collectionName string, docs ...*d.Document) error {
 
 	indexes := db.getIndexes(tx, collectionName, meta)
 
+	now := time.Now()
 	for _, doc := range docs {
-		if err := db.addDocToIndexes(tx, indexes, doc); err != nil {
-			return err
+		if meta.Versioned {
+			doc.Set(d.VersionField, 1)
 		}
 
 		key := []byte(getDocumentKey(collectionName, doc.ObjectId()))
-		value, err := tx.Get(key)
+		oldDoc, err := getDocumentById(collectionName, doc.ObjectId(), tx)
 		if err != nil {
 			return err
 		}
 
-		if value != nil {
-			return ErrDuplicateKey
+		if oldDoc != nil {
+			if !oldDoc.IsExpired(now) {
+				return ErrDuplicateKey
+			}
+
+			// an expired document which has not been purged yet is simply replaced
+			if err := db.deleteDocFromIndexes(indexes, oldDoc); err != nil {
+				return err
+			}
+		} else {
+			meta.Size++
 		}
 
-		if err := saveDocument(doc, key, tx); err != nil {
+		if err := db.addDocToIndexes(tx, indexes, doc); err != nil {
 			return err
 		}
-	}
 
-	meta.Size += len(docs)
-	if err := db.saveCollectionMetadata(collectionName, meta, tx); err != nil {
-		return err
+		if err := updateExpiration(tx, collectionName, oldDoc, doc); err != nil {
+			return err
+		}
+
+		if err := db.saveDocument(collectionName, meta, doc, key, tx); err != nil {
+			return err
+		}
+
+		// an expired document is no longer visible, so that replacing it is reported as an insertion
+		recordChange(tx, ChangeInsert, collectionName, nil, doc)
 	}
 
-	return tx.Commit()
+	return db.saveCollectionMetadata(collectionName, meta, tx)
 }
 
 func (db *DB) getIndexes(tx store.Tx, collection string, meta *collectionMetadata) []index.Index {
 	indexes := make([]index.Index, 0)
 
 	for _, info := range meta.Indexes {
-		indexes = append(indexes, index.CreateIndex(collection, info.Field, info.Type, tx))
+		indexes = append(indexes, index.NewIndex(collection, info, tx))
 	}
 	return indexes
 }
 
-func saveDocument(doc *d.Document, key []byte, tx store.Tx) error {
+func (db *DB) saveDocument(collection string, meta *collectionMetadata, doc *d.Document, key []byte, tx store.Tx) error {
 	if err := d.Validate(doc); err != nil {
 		return err
 	}
 
+	if err := db.validateSchema(tx, collection, meta, doc); err != nil {
+		return err
+	}
+
 	data, err := d.Encode(doc)
 	if err != nil {
 		return err
@@ -216,7 +391,13 @@ func saveDocument(doc *d.Document, key []byte, tx store.Tx) error {
 func (db *DB) addDocToIndexes(tx store.Tx, indexes []index.Index, doc *d.Document) error {
 	// update indexes
 	for _, idx := range indexes {
-		fieldVal := doc.Get(idx.Field()) // missing fields are treated as null
+		fieldVal := getIndexValue(idx, doc) // missing fields are treated as null
+
+		if idx.Unique() {
+			if err := checkUniqueness(tx, idx, doc.ObjectId(), fieldVal); err != nil {
+				return err
+			}
+		}
 
 		err := idx.Add(doc.ObjectId(), fieldVal, doc.TTL())
 		if err != nil {
@@ -226,6 +407,52 @@ func (db *DB) addDocToIndexes(tx store.Tx, indexes []index.Index, doc *d.Documen
 	return nil
 }
 
+// getIndexValue returns the value under which doc is stored in idx.
+// For compound indexes, this is the slice of the values of all the indexed fields.
+func getIndexValue(idx index.Index, doc *d.Document) interface{} {
+	switch idx := idx.(type) {
+	case index.CompoundIndex:
+		values := make([]interface{}, 0, len(idx.Fields()))
+		for _, field := range idx.Fields() {
+			values = append(values, doc.Get(field.Field))
+		}
+		return values
+	case index.FullTextIndex:
+		return doc.Get(idx.TextField())
+	case index.GeoIndex:
+		return doc.Get(idx.GeoField())
+	}
+	return doc.Get(idx.Field())
+}
+
+// checkUniqueness returns ErrDuplicateKey if a document other than the one with the given id is indexed under value.
+// Null values (and thus missing fields) are not subject to the constraint.
+func checkUniqueness(tx store.Tx, idx index.Index, docId string, value interface{}) error {
+	rangeIdx, ok := idx.(index.RangeIndex)
+	if !ok || value == nil {
+		return nil
+	}
+
+	now := time.Now()
+	vRange := &index.Range{Start: value, End: value, StartIncluded: true, EndIncluded: true}
+	return rangeIdx.IterateRange(vRange, false, func(otherId string) error {
+		if otherId == docId {
+			return nil
+		}
+
+		doc, err := getDocumentById(idx.Collection(), otherId, tx)
+		if err != nil {
+			return err
+		}
+
+		// records of expired documents are removed only when documents are purged
+		if doc != nil && !doc.IsExpired(now) {
+			return ErrDuplicateKey
+		}
+		return nil
+	})
+}
+
 func getDocumentKey(collection string, id string) string {
 	return getDocumentKeyPrefix(collection) + id
 }
@@ -266,22 +493,41 @@ func (db *DB) InsertOne(collectionName string, doc *d.Document) (string, error)
 }
 
 // Open opens a new clover database on the supplied path. If such a folder doesn't exist, it is automatically created.
-func Open(dir string) (*DB, error) {
+func Open(dir string, opts ...Option) (*DB, error) {
 	dataStore, err := bbolt.Open(dir)
 	if err != nil {
 		return nil, err
 	}
-	return OpenWithStore(dataStore)
+	return OpenWithStore(dataStore, opts...)
 }
 
 // OpenWithStore opens a new clover database using the provided store.
-func OpenWithStore(store store.Store) (*DB, error) {
-	return &DB{store: store}, nil
+func OpenWithStore(store store.Store, opts ...Option) (*DB, error) {
+	conf := defaultConfig()
+	for _, opt := range opts {
+		if err := opt(conf); err != nil {
+			return nil, err
+		}
+	}
+
+	db := &DB{
+		store:                store,
+		chQuit:               make(chan struct{}),
+		schemaWarningHandler: conf.SchemaWarningHandler,
+	}
+
+	if conf.ExpirationSweepInterval > 0 {
+		db.startExpirationReaper(conf.ExpirationSweepInterval)
+	}
+	return db, nil
 }
 
 // Close releases all the resources and closes the database. After the call, the instance will no more be usable.
 func (db *DB) Close() error {
 	if atomic.CompareAndSwapUint32(&db.closed, 0, 1) {
+		db.stopExpirationReaper()
+		db.closeAllStreams()
+		db.closeAllCursors()
 		return db.store.Close()
 	}
 	return nil
@@ -289,13 +535,22 @@ func (db *DB) Close() error {
 
 // FindAll selects all the documents satisfying q.
 func (db *DB) FindAll(q *query.Query) ([]*d.Document, error) {
+	tx, err := db.store.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	return db.findAll(tx, q)
+}
+
+func (db *DB) findAll(tx store.Tx, q *query.Query) ([]*d.Document, error) {
 	q, err := normalizeCriteria(q)
 	if err != nil {
 		return nil, err
 	}
 
 	docs := make([]*d.Document, 0)
-	err = db.IterateDocs(q, func(doc *d.Document) error {
+	err = db.iterateDocs(tx, q, func(doc *d.Document) error {
 		docs = append(docs, doc)
 		return nil
 	})
@@ -339,26 +594,41 @@ func (db *DB) ForEach(q *query.Query, consumer func(_ *d.Document) bool) error {
 }
 
 // Count returns the number of documents which satisfy the query (i.e. len(q.FindAll()) == q.Count()).
+// When the query has no criteria, Count reads the size of the collection instead of scanning its documents. However, since expired
+// documents are still accounted in the size until they are purged, it must also scan the expiration entries of the documents
+// which have expired but have not been purged yet, so its cost grows with their number (see PurgeExpired and ExpirationSweepInterval).
 func (db *DB) Count(q *query.Query) (int, error) {
+	tx, err := db.store.Begin(false)
+	if err != nil {
+		return -1, err
+	}
+	defer tx.Rollback()
+	return db.count(tx, q)
+}
+
+func (db *DB) count(tx store.Tx, q *query.Query) (int, error) {
 	q, err := normalizeCriteria(q)
 	if err != nil {
 		return -1, err
 	}
 
 	if q.Criteria() == nil { // simply return the size of the collection in this case
-		return db.countCollection(q)
+		return db.countCollection(tx, q)
 	}
 
 	num := 0
-	err = db.IterateDocs(q, func(doc *d.Document) error {
+	err = db.iterateDocs(tx, q, func(doc *d.Document) error {
 		num++
 		return nil
 	})
 	return num, err
 }
 
-func (db *DB) countCollection(q *query.Query) (int, error) {
-	size, err := db.getCollectionSize(q.Collection())
+func (db *DB) countCollection(tx store.Tx, q *query.Query) (int, error) {
+	size, err := db.getCollectionSize(tx, q.Collection())
+	if err != nil {
+		return -1, err
+	}
 	size -= q.GetSkip()
 
 	if size < 0 {
@@ -372,18 +642,19 @@ func (db *DB) countCollection(q *query.Query) (int, error) {
 	return size, err
 }
 
-func (db *DB) getCollectionSize(collection string) (int, error) {
-	tx, err := db.store.Begin(false)
+func (db *DB) getCollectionSize(tx store.Tx, collection string) (int, error) {
+	meta, err := db.getCollectionMeta(collection, tx)
 	if err != nil {
 		return -1, err
 	}
-	defer tx.Rollback()
 
-	meta, err := db.getCollectionMeta(collection, tx)
+	// expired documents are still accounted in the collection size until they are purged.
+	// Expiration entries are sorted by time, so that only the ones of the expired documents are scanned.
+	expired, err := countExpired(tx, collection, time.Now())
 	if err != nil {
 		return -1, err
 	}
-	return meta.Size, nil
+	return meta.Size - expired, nil
 }
 
 // Exists returns true if and only if the query result set is not empty.
@@ -399,7 +670,10 @@ func (db *DB) FindById(collection string, id string) (*d.Document, error) {
 		return nil, err
 	}
 	defer tx.Rollback()
+	return db.findById(tx, collection, id)
+}
 
+func (db *DB) findById(tx store.Tx, collection string, id string) (*d.Document, error) {
 	ok, err := db.hasCollection(collection, tx)
 	if err != nil {
 		return nil, err
@@ -409,7 +683,11 @@ func (db *DB) FindById(collection string, id string) (*d.Document, error) {
 		return nil, ErrCollectionNotExist
 	}
 
-	return getDocumentById(collection, id, tx)
+	doc, err := getDocumentById(collection, id, tx)
+	if doc != nil && doc.IsExpired(time.Now()) {
+		return nil, err
+	}
+	return doc, err
 }
 
 func getDocumentById(collectionName string, id string, tx store.Tx) (*d.Document, error) {
@@ -422,12 +700,19 @@ func getDocumentById(collectionName string, id string, tx store.Tx) (*d.Document
 
 // DeleteById removes the document with the given id from the underlying collection, provided that such a document exists and satisfies the underlying query.
 func (db *DB) DeleteById(collection string, id string) error {
-	tx, err := db.store.Begin(true)
+	tx, err := db.beginUpdate()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
+	if err := db.deleteById(tx, collection, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *DB) deleteById(tx store.Tx, collection string, id string) error {
 	meta, err := db.getCollectionMeta(collection, tx)
 	if err != nil {
 		return err
@@ -435,53 +720,47 @@ func (db *DB) DeleteById(collection string, id string) error {
 
 	indexes := db.getIndexes(tx, collection, meta)
 
-	if err := db.getDocAndDeleteFromIndexes(tx, indexes, collection, id); err != nil {
+	doc, err := getDocumentById(collection, id, tx)
+	if err != nil || doc == nil {
 		return err
 	}
 
-	if err := tx.Delete([]byte(getDocumentKey(collection, id))); err != nil {
+	if err := db.deleteDocFromIndexes(indexes, doc); err != nil {
 		return err
 	}
 
-	meta.Size--
-	if err := db.saveCollectionMetadata(collection, meta, tx); err != nil {
+	if err := updateExpiration(tx, collection, doc, nil); err != nil {
 		return err
 	}
-	return tx.Commit()
-}
 
-func (db *DB) getDocAndDeleteFromIndexes(tx store.Tx, indexes []index.Index, collection string, docId string) error {
-	if len(indexes) == 0 {
-		return nil
-	}
-
-	doc, err := getDocumentById(collection, docId, tx)
-	if err != nil {
+	if err := tx.Delete([]byte(getDocumentKey(collection, id))); err != nil {
 		return err
 	}
 
-	if doc == nil {
-		return nil
-	}
+	recordChange(tx, ChangeDelete, collection, doc, nil)
 
-	for _, idx := range indexes {
-		value := doc.Get(idx.Field())
-		if err := idx.Remove(doc.ObjectId(), value); err != nil {
-			return err
-		}
-	}
-	return nil
+	meta.Size--
+	return db.saveCollectionMetadata(collection, meta, tx)
 }
 
 // UpdateById updates the document with the specified id using the supplied update map.
-// If no document with the specified id exists, an ErrDocumentNotExist is returned.
-func (db *DB) UpdateById(collectionName string, docId string, updater func(doc *d.Document) *d.Document) error {
-	tx, err := db.store.Begin(true)
+// If no document with the specified id exists, an ErrDocumentNotExist is returned, unless the Upsert option is set.
+// In the latter case, updater is applied to an empty document with the supplied id, which is then inserted.
+func (db *DB) UpdateById(collectionName string, docId string, updater func(doc *d.Document) *d.Document, opts ...UpdateOptions) error {
+	tx, err := db.beginUpdate()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
+	if err := db.updateById(tx, collectionName, docId, funcUpdater(updater), ChangeUpdate, getUpdateOptions(opts)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// updateById applies updater to the document with the given id. changeType tells whether the change is reported as an update or a replacement.
+func (db *DB) updateById(tx store.Tx, collectionName string, docId string, updater docUpdater, changeType ChangeType, opts UpdateOptions) error {
 	meta, err := db.getCollectionMeta(collectionName, tx)
 	if err != nil {
 		return err
@@ -496,7 +775,7 @@ func (db *DB) UpdateById(collectionName string, docId string, updater func(doc *
 	}
 
 	if value == nil {
-		return ErrDocumentNotExist
+		return db.upsertById(tx, collectionName, docId, updater, opts)
 	}
 
 	doc, err := d.Decode(value)
@@ -504,15 +783,61 @@ func (db *DB) UpdateById(collectionName string, docId string, updater func(doc *
 		return err
 	}
 
-	updatedDoc := updater(doc)
+	if doc.IsExpired(time.Now()) {
+		return db.upsertById(tx, collectionName, docId, updater, opts)
+	}
+
+	updatedDoc, err := updater(doc)
+	if err != nil {
+		return err
+	}
+
+	if updatedDoc, err = nextVersion(meta, doc, updatedDoc); err != nil {
+		return err
+	}
+
 	if err := db.updateIndexesOnDocUpdate(tx, indexes, doc, updatedDoc); err != nil {
 		return err
 	}
 
-	if err := saveDocument(updatedDoc, []byte(docKey), tx); err != nil {
+	if err := updateExpiration(tx, collectionName, doc, updatedDoc); err != nil {
 		return err
 	}
-	return tx.Commit()
+
+	if err := db.saveDocument(collectionName, meta, updatedDoc, []byte(docKey), tx); err != nil {
+		return err
+	}
+
+	recordChange(tx, changeType, collectionName, doc, updatedDoc)
+	return nil
+}
+
+// nextVersion increments the version of a document updated in a versioned collection, and returns the resulting document.
+// If newDoc carries a version which is different from the one of oldDoc, an ErrVersionConflict is returned.
+func nextVersion(meta *collectionMetadata, oldDoc, newDoc *d.Document) (*d.Document, error) {
+	if !meta.Versioned || newDoc == nil {
+		return newDoc, nil
+	}
+
+	if newDoc.Has(d.VersionField) && internal.Compare(newDoc.Get(d.VersionField), oldDoc.Get(d.VersionField)) != 0 {
+		return nil, ErrVersionConflict
+	}
+
+	// the updater could have modified the original document in place
+	newDoc = newDoc.Copy()
+	newDoc.Set(d.VersionField, oldDoc.Version()+1)
+	return newDoc, nil
+}
+
+// isModified reports whether the content of newDoc differs from the one of oldDoc.
+// In versioned collections, the version field is ignored, since it is incremented by every update.
+func isModified(meta *collectionMetadata, oldDoc, newDoc *d.Document) bool {
+	if meta.Versioned {
+		oldDoc, newDoc = oldDoc.Copy(), newDoc.Copy()
+		oldDoc.Unset(d.VersionField)
+		newDoc.Unset(d.VersionField)
+	}
+	return internal.Compare(oldDoc.AsMap(), newDoc.AsMap()) != 0
 }
 
 func (db *DB) updateIndexesOnDocUpdate(tx store.Tx, indexes []index.Index, oldDoc, newDoc *d.Document) error {
@@ -531,7 +856,7 @@ func (db *DB) updateIndexesOnDocUpdate(tx store.Tx, indexes []index.Index, oldDo
 
 func (db *DB) deleteDocFromIndexes(indexes []index.Index, doc *d.Document) error {
 	for _, idx := range indexes {
-		value := doc.Get(idx.Field())
+		value := getIndexValue(idx, doc)
 		if err := idx.Remove(doc.ObjectId(), value); err != nil {
 			return err
 		}
@@ -541,86 +866,246 @@ func (db *DB) deleteDocFromIndexes(indexes []index.Index, doc *d.Document) error
 
 // ReplaceById replaces the document with the specified id with the one provided.
 // If no document exists, an ErrDocumentNotExist is returned.
+// In versioned collections, the document must carry the version of the stored one, otherwise an ErrVersionConflict is returned.
 func (db *DB) ReplaceById(collection, docId string, doc *d.Document) error {
+	tx, err := db.beginUpdate()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := db.replaceById(tx, collection, docId, doc); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *DB) replaceById(tx store.Tx, collection, docId string, doc *d.Document) error {
 	if doc.ObjectId() != docId {
 		return fmt.Errorf("the id of the document must match the one supplied")
 	}
-	return db.UpdateById(collection, docId, func(_ *d.Document) *d.Document {
-		return doc
-	})
+
+	meta, err := db.getCollectionMeta(collection, tx)
+	if err != nil {
+		return err
+	}
+
+	// a replacement without a version cannot be checked against the stored document, so it could overwrite newer changes
+	if meta.Versioned && !doc.Has(d.VersionField) {
+		return ErrVersionConflict
+	}
+
+	return db.updateById(tx, collection, docId, func(_ *d.Document) (*d.Document, error) {
+		return doc, nil
+	}, ChangeReplace, UpdateOptions{})
+}
+
+// UpdateOptions contains the options of update operations.
+type UpdateOptions struct {
+	// Upsert makes the update insert a new document when no document is selected.
+	// The new document is built from the equality conditions of the query criteria, and then updated.
+	Upsert bool
+}
+
+func getUpdateOptions(opts []UpdateOptions) UpdateOptions {
+	if len(opts) > 0 {
+		return opts[0]
+	}
+	return UpdateOptions{}
+}
+
+// UpdateResult reports the outcome of an update.
+type UpdateResult struct {
+	// MatchedCount is the number of documents selected by the query.
+	MatchedCount int
+	// ModifiedCount is the number of selected documents whose content has been changed. The version of the documents of versioned collections is ignored.
+	ModifiedCount int
+	// DeletedCount is the number of selected documents which have been deleted, since the update function returned nil.
+	DeletedCount int
+	// UpsertedId is the id of the inserted document, if no document was selected and the Upsert option was set.
+	UpsertedId string
+}
+
+// DeleteResult reports the outcome of a deletion.
+type DeleteResult struct {
+	// DeletedCount is the number of deleted documents.
+	DeletedCount int
 }
 
 // Update updates all the document selected by q using the provided updateMap.
 // Each update is specified by a mapping fieldName -> newValue.
-func (db *DB) Update(q *query.Query, updateMap map[string]interface{}) error {
-	q, err := normalizeCriteria(q)
+func (db *DB) Update(q *query.Query, updateMap map[string]interface{}, opts ...UpdateOptions) (*UpdateResult, error) {
+	return db.runUpdate(q, updateMapFunc(updateMap), getUpdateOptions(opts))
+}
+
+// runUpdate applies updater to the documents selected by q in a new transaction.
+func (db *DB) runUpdate(q *query.Query, updater docUpdater, opts UpdateOptions) (*UpdateResult, error) {
+	txn, err := db.beginUpdate()
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer txn.Rollback()
 
-	return db.UpdateFunc(q, func(doc *d.Document) *d.Document {
+	res, err := db.updateFunc(txn, q, updater, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := txn.Commit(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func updateMapFunc(updateMap map[string]interface{}) docUpdater {
+	return func(doc *d.Document) (*d.Document, error) {
 		newDoc := doc.Copy()
 		newDoc.SetAll(updateMap)
-		return newDoc
-	})
+		return newDoc, nil
+	}
 }
 
 // UpdateFunc updates all the document selected by q using the provided function.
-func (db *DB) UpdateFunc(q *query.Query, updateFunc func(doc *d.Document) *d.Document) error {
-	txn, err := db.store.Begin(true)
+// Documents for which the function returns nil are deleted.
+func (db *DB) UpdateFunc(q *query.Query, updateFunc func(doc *d.Document) *d.Document, opts ...UpdateOptions) (*UpdateResult, error) {
+	return db.runUpdate(q, funcUpdater(updateFunc), getUpdateOptions(opts))
+}
+
+func (db *DB) updateFunc(tx store.Tx, q *query.Query, updateFunc docUpdater, opts UpdateOptions) (*UpdateResult, error) {
+	q, err := normalizeCriteria(q)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer txn.Rollback()
 
-	q, err = normalizeCriteria(q)
-	if err != nil {
-		return err
+	res, err := db.replaceDocs(tx, q, updateFunc)
+	if err != nil || res.MatchedCount > 0 || !opts.Upsert {
+		return res, err
 	}
-	if err := db.replaceDocs(txn, q, updateFunc); err != nil {
+
+	res.UpsertedId, err = db.upsert(tx, q, updateFunc)
+	return res, err
+}
+
+// upsert inserts the document obtained by applying updater to a document built from the equality conditions of the criteria of q.
+// It returns the id of the inserted document, if any.
+func (db *DB) upsert(tx store.Tx, q *query.Query, updater docUpdater) (string, error) {
+	doc := d.NewDocument()
+	if q.Criteria() != nil {
+		c := q.Criteria().Accept(&NotFlattenVisitor{}).(query.Criteria)
+		ranges := c.Accept(&ConjunctiveRangeVisitor{}).(map[string]*index.Range)
+
+		fields := make([]string, 0, len(ranges))
+		for field, r := range ranges {
+			if isEqualityRange(r) {
+				fields = append(fields, field)
+			}
+		}
+
+		sort.Strings(fields) // so that parent fields are set before nested ones
+		for _, field := range fields {
+			doc.Set(field, ranges[field].Start)
+		}
+	}
+
+	newDoc, err := updater(doc)
+	if err != nil || newDoc == nil {
+		return "", err
+	}
+
+	if err := db.insert(tx, q.Collection(), newDoc); err != nil {
+		return "", err
+	}
+	return newDoc.ObjectId(), nil
+}
+
+func (db *DB) upsertById(tx store.Tx, collectionName string, docId string, updater docUpdater, opts UpdateOptions) error {
+	if !opts.Upsert {
+		return ErrDocumentNotExist
+	}
+
+	doc := d.NewDocument()
+	doc.Set(d.ObjectIdField, docId)
+
+	newDoc, err := updater(doc)
+	if err != nil || newDoc == nil {
 		return err
 	}
-	return txn.Commit()
+
+	if newDoc.ObjectId() != docId {
+		return fmt.Errorf("the id of the document must match the one supplied")
+	}
+	return db.insert(tx, collectionName, newDoc)
 }
 
-type docUpdater func(doc *d.Document) *d.Document
+// docUpdater returns the updated version of doc, or nil if doc must be deleted.
+type docUpdater func(doc *d.Document) (*d.Document, error)
+
+// funcUpdater wraps a user supplied update function. The function receives a copy of the stored document,
+// so that documents modified in place can still be compared with their previous version.
+func funcUpdater(updateFunc func(doc *d.Document) *d.Document) docUpdater {
+	return func(doc *d.Document) (*d.Document, error) {
+		return updateFunc(doc.Copy()), nil
+	}
+}
 
-func (db *DB) replaceDocs(tx store.Tx, q *query.Query, updater docUpdater) error {
+// replaceDocs applies updater to the documents selected by q.
+func (db *DB) replaceDocs(tx store.Tx, q *query.Query, updater docUpdater) (*UpdateResult, error) {
 	meta, err := db.getCollectionMeta(q.Collection(), tx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	indexes := db.getIndexes(tx, q.Collection(), meta)
 
-	deletedDocs := 0
+	// updates must be applied to whole documents
+	q = q.Select().Exclude()
+
+	res := &UpdateResult{}
 	err = db.iterateDocs(tx, q, func(doc *d.Document) error {
+		res.MatchedCount++
 		docKey := []byte(getDocumentKey(q.Collection(), doc.ObjectId()))
-		newDoc := updater(doc)
+		newDoc, err := updater(doc)
+		if err != nil {
+			return err
+		}
+
+		if newDoc, err = nextVersion(meta, doc, newDoc); err != nil {
+			return err
+		}
 
 		if err := db.updateIndexesOnDocUpdate(tx, indexes, doc, newDoc); err != nil {
 			return err
 		}
 
+		if err := updateExpiration(tx, q.Collection(), doc, newDoc); err != nil {
+			return err
+		}
+
 		if newDoc == nil {
-			deletedDocs++
+			res.DeletedCount++
+			recordChange(tx, ChangeDelete, q.Collection(), doc, nil)
 			return tx.Delete(docKey)
 		}
 
-		return saveDocument(newDoc, docKey, tx)
+		if isModified(meta, doc, newDoc) {
+			res.ModifiedCount++
+		}
+
+		recordChange(tx, ChangeUpdate, q.Collection(), doc, newDoc)
+		return db.saveDocument(q.Collection(), meta, newDoc, docKey, tx)
 	})
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if deletedDocs > 0 {
-		meta.Size -= deletedDocs
+	if res.DeletedCount > 0 {
+		meta.Size -= res.DeletedCount
 		if err := db.saveCollectionMetadata(q.Collection(), meta, tx); err != nil {
-			return err
+			return nil, err
 		}
 	}
-	return nil
+	return res, nil
 }
 
 func (db *DB) iterateDocs(tx store.Tx, q *query.Query, consumer docConsumer) error {
@@ -633,27 +1118,142 @@ func (db *DB) iterateDocs(tx store.Tx, q *query.Query, consumer docConsumer) err
 }
 
 // Delete removes all the documents selected by q from the underlying collection.
-func (db *DB) Delete(q *query.Query) error {
-	q, err := normalizeCriteria(q)
+func (db *DB) Delete(q *query.Query) (*DeleteResult, error) {
+	tx, err := db.beginUpdate()
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer tx.Rollback()
 
-	tx, err := db.store.Begin(true)
+	res, err := db.delete(tx, q)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (db *DB) delete(tx store.Tx, q *query.Query) (*DeleteResult, error) {
+	res, err := db.updateFunc(tx, q, func(_ *d.Document) (*d.Document, error) { return nil, nil }, UpdateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &DeleteResult{DeletedCount: res.DeletedCount}, nil
+}
+
+// FindOneAndUpdateOptions contains the options of FindOneAndUpdate.
+type FindOneAndUpdateOptions struct {
+	UpdateOptions
+	// ReturnNew makes FindOneAndUpdate return the updated document, in place of the original one.
+	ReturnNew bool
+}
+
+func getFindOneAndUpdateOptions(opts []FindOneAndUpdateOptions) FindOneAndUpdateOptions {
+	if len(opts) > 0 {
+		return opts[0]
+	}
+	return FindOneAndUpdateOptions{}
+}
+
+// FindOneAndUpdate applies u to the first document selected by q, and returns the original document (or the updated one, if the ReturnNew option is set).
+// The returned document only contains the fields selected by q. If no document is selected, nil is returned,
+// unless both the Upsert and ReturnNew options are set. In the latter case, the inserted document is returned.
+func (db *DB) FindOneAndUpdate(q *query.Query, u *query.Update, opts ...FindOneAndUpdateOptions) (*d.Document, error) {
+	tx, err := db.beginUpdate()
+	if err != nil {
+		return nil, err
 	}
 	defer tx.Rollback()
 
-	if err := db.replaceDocs(tx, q, func(_ *d.Document) *d.Document { return nil }); err != nil {
-		return err
+	doc, err := db.findOneAndUpdate(tx, q, updateSpecFunc(u), getFindOneAndUpdateOptions(opts))
+	if err != nil {
+		return nil, err
 	}
-	return tx.Commit()
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func (db *DB) findOneAndUpdate(tx store.Tx, q *query.Query, updater docUpdater, opts FindOneAndUpdateOptions) (*d.Document, error) {
+	doc, err := db.findFirstDocument(tx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	var docId string
+	if doc != nil {
+		docId = doc.ObjectId()
+		err = db.updateById(tx, q.Collection(), docId, updater, ChangeUpdate, UpdateOptions{})
+	} else if opts.Upsert {
+		q, err = normalizeCriteria(q)
+		if err == nil {
+			docId, err = db.upsert(tx, q, updater)
+		}
+	}
+
+	if err != nil || docId == "" {
+		return nil, err
+	}
+
+	if opts.ReturnNew {
+		doc, err = db.findById(tx, q.Collection(), docId)
+	}
+
+	if err != nil || doc == nil {
+		return nil, err
+	}
+	return projectDocument(doc, q.SelectedFields(), q.ExcludedFields()), nil
+}
+
+// FindOneAndDelete deletes the first document selected by q, and returns it. If no document is selected, nil is returned.
+// The returned document only contains the fields selected by q.
+func (db *DB) FindOneAndDelete(q *query.Query) (*d.Document, error) {
+	tx, err := db.beginUpdate()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	doc, err := db.findOneAndDelete(tx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func (db *DB) findOneAndDelete(tx store.Tx, q *query.Query) (*d.Document, error) {
+	doc, err := db.findFirstDocument(tx, q)
+	if err != nil || doc == nil {
+		return nil, err
+	}
+
+	if err := db.deleteById(tx, q.Collection(), doc.ObjectId()); err != nil {
+		return nil, err
+	}
+	return projectDocument(doc, q.SelectedFields(), q.ExcludedFields()), nil
+}
+
+// findFirstDocument returns the whole first document selected by q, if any.
+func (db *DB) findFirstDocument(tx store.Tx, q *query.Query) (*d.Document, error) {
+	docs, err := db.findAll(tx, q.Select().Exclude().Limit(1))
+	if err != nil || len(docs) == 0 {
+		return nil, err
+	}
+	return docs[0], nil
 }
 
 // ListCollections returns a slice of strings containing the name of each collection stored in the db.
 func (db *DB) ListCollections() ([]string, error) {
-	tx, err := db.store.Begin(true)
+	tx, err := db.store.Begin(false)
 	if err != nil {
 		return nil, err
 	}
@@ -671,13 +1271,18 @@ func (db *DB) ListCollections() ([]string, error) {
 }
 
 func iteratePrefix(prefix []byte, tx store.Tx, itemConsumer func(item store.Item) error) error {
+	return iteratePrefixFrom(prefix, prefix, tx, itemConsumer)
+}
+
+// iteratePrefixFrom iterates the keys with the given prefix, starting from seekKey.
+func iteratePrefixFrom(prefix []byte, seekKey []byte, tx store.Tx, itemConsumer func(item store.Item) error) error {
 	cursor, err := tx.Cursor(true)
 	if err != nil {
 		return err
 	}
 	defer cursor.Close()
 
-	if err := cursor.Seek(prefix); err != nil {
+	if err := cursor.Seek(seekKey); err != nil {
 		return err
 	}
 
@@ -706,11 +1311,47 @@ func iteratePrefix(prefix []byte, tx store.Tx, itemConsumer func(item store.Item
 
 // CreateIndex creates an index for the specified for the specified (index, collection) pair.
 func (db *DB) CreateIndex(collection, field string) error {
-	return db.createIndex(collection, field, index.SingleField)
+	return db.createIndex(collection, index.Info{Field: field, Type: index.SingleField})
+}
+
+// CreateUniqueIndex creates an index for the specified (index, collection) pair, which rejects any write
+// that would result in two documents having the same value for the field with an ErrDuplicateKey error.
+// Documents where the field is missing or null are not subject to the constraint.
+// If the collection already contains duplicate values, the index is not created and ErrDuplicateKey is returned.
+func (db *DB) CreateUniqueIndex(collection, field string) error {
+	return db.createIndex(collection, index.Info{Field: field, Type: index.SingleField, Unique: true})
+}
+
+// CreateCompoundIndex creates an index over multiple fields of a collection, each sorted according to its direction.
+// Queries which select an equality prefix of the indexed fields, optionally followed by a range on the next field,
+// are served by the index, which is also used to return results in sorted order whenever possible.
+// The index is named after its fields (see index.CompoundIndexName), and such name must be used to drop it.
+func (db *DB) CreateCompoundIndex(collection string, fields ...query.SortOption) error {
+	if len(fields) < 2 {
+		return fmt.Errorf("a compound index requires at least two fields")
+	}
+
+	return db.createIndex(collection, index.CompoundInfo(fields))
+}
+
+// CreateFullTextIndex creates an inverted index over the words of a string field (or of the strings of an array field),
+// which is used to answer Search criteria and to sort their results by relevance (see query.ScoreField).
+// The index is named after its field (see index.FullTextIndexName), and such name must be used to drop it.
+func (db *DB) CreateFullTextIndex(collection, field string) error {
+	return db.createIndex(collection, index.FullTextInfo(field))
 }
 
-func (db *DB) createIndex(collection, field string, indexType index.Type) error {
-	tx, err := db.store.Begin(true)
+// CreateGeoIndex creates an index over a field holding points (GeoJSON Point objects, or arrays of coordinates [lon, lat]),
+// which is used to answer Near, WithinBox and WithinPolygon criteria. Documents whose field is not a point are never selected by such criteria.
+// The index is named after its field (see index.GeoIndexName), and such name must be used to drop it.
+func (db *DB) CreateGeoIndex(collection, field string) error {
+	return db.createIndex(collection, index.GeoInfo(field))
+}
+
+func (db *DB) createIndex(collection string, info index.Info) error {
+	field := info.Field
+
+	tx, err := db.beginUpdate()
 	if err != nil {
 		return err
 	}
@@ -730,13 +1371,12 @@ func (db *DB) createIndex(collection, field string, indexType index.Type) error
 	if meta.Indexes == nil {
 		meta.Indexes = make([]index.Info, 0)
 	}
-	meta.Indexes = append(meta.Indexes, index.Info{Field: field, Type: indexType})
+	meta.Indexes = append(meta.Indexes, info)
 
-	idx := index.CreateIndex(collection, field, indexType, tx)
+	idx := index.NewIndex(collection, info, tx)
 
 	err = db.iterateDocs(tx, query.NewQuery(collection), func(doc *d.Document) error {
-		value := doc.Get(field)
-		return idx.Add(doc.ObjectId(), value, doc.TTL())
+		return db.addDocToIndexes(tx, []index.Index{idx}, doc)
 	})
 
 	if err != nil {
@@ -775,7 +1415,7 @@ func (db *DB) hasIndex(tx store.Tx, collection, field string) (bool, error) {
 
 // DropIndex deletes the index, is such index exists for the specified (index, collection) pair.
 func (db *DB) DropIndex(collection, field string) error {
-	txn, err := db.store.Begin(true)
+	txn, err := db.beginUpdate()
 	if err != nil {
 		return err
 	}
@@ -797,12 +1437,12 @@ func (db *DB) DropIndex(collection, field string) error {
 		return ErrIndexNotExist
 	}
 
-	idxType := meta.Indexes[j].Type
+	info := meta.Indexes[j]
 
 	meta.Indexes[j] = meta.Indexes[0]
 	meta.Indexes = meta.Indexes[1:]
 
-	idx := index.CreateIndex(collection, field, idxType, txn)
+	idx := index.NewIndex(collection, info, txn)
 
 	if err := idx.Drop(); err != nil {
 		return err
@@ -1,15 +1,19 @@
 package clover_test
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"math"
 	"math/rand"
 	"os"
 	"path/filepath"
 	"reflect"
 	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -20,8 +24,11 @@ import (
 	d "github.com/ostafen/clover/v2/document"
 	"github.com/ostafen/clover/v2/index"
 	q "github.com/ostafen/clover/v2/query"
+	"github.com/ostafen/clover/v2/schema"
+	"github.com/ostafen/clover/v2/store"
 	badgerstore "github.com/ostafen/clover/v2/store/badger"
 	"github.com/ostafen/clover/v2/store/bbolt"
+	"github.com/ostafen/clover/v2/util"
 )
 
 const (
@@ -88,10 +95,10 @@ func TestErrCollectionNotExist(t *testing.T) {
 		_, err = db.FindAll(query)
 		require.Equal(t, c.ErrCollectionNotExist, err)
 
-		err = db.Update(query, nil)
+		_, err = db.Update(query, nil)
 		require.Equal(t, c.ErrCollectionNotExist, err)
 
-		err = db.Delete(query)
+		_, err = db.Delete(query)
 		require.Equal(t, c.ErrCollectionNotExist, err)
 
 		err = db.DeleteById("myCollection", "objectId")
@@ -315,7 +322,7 @@ func TestUpdateCollection(t *testing.T) {
 		docs, err := db.FindAll(q.NewQuery("todos").Where(criteria))
 		require.NoError(t, err)
 
-		err = db.Update(q.NewQuery("todos").Where(criteria), updates)
+		_, err = db.Update(q.NewQuery("todos").Where(criteria), updates)
 		require.NoError(t, err)
 
 		n, err := db.Count(q.NewQuery("todos").Where(criteria))
@@ -360,6 +367,449 @@ func TestUpdateById(t *testing.T) {
 	})
 }
 
+func TestUpdateWith(t *testing.T) {
+	runCloverTest(t, func(t *testing.T, db *c.DB) {
+		require.NoError(t, db.CreateCollection("posts"))
+		require.NoError(t, db.CreateIndex("posts", "stats.views"))
+
+		doc := d.NewDocumentOf(map[string]interface{}{
+			"title": "hello",
+			"tags":  []interface{}{"a", "b", "a"},
+			"stats": map[string]interface{}{"views": 10, "score": 1.5},
+			"tmp":   true,
+			"best":  5,
+		})
+		id, err := db.InsertOne("posts", doc)
+		require.NoError(t, err)
+
+		u := q.Inc("stats.views", 1).
+			Inc("stats.score", 1).
+			Inc("likes", 2).
+			Push("tags", "c", "d").
+			Pull("tags", "a").
+			Unset("tmp").
+			Min("best", 3).
+			Max("best", 4).
+			Rename("title", "meta.title")
+
+		_, err = db.UpdateWith(q.NewQuery("posts").Where(q.Field("stats.views").Eq(10)), u)
+		require.NoError(t, err)
+
+		doc, err = db.FindById("posts", id)
+		require.NoError(t, err)
+
+		require.Equal(t, int64(11), util.ToInt64(doc.Get("stats.views")))
+		require.Equal(t, 2.5, doc.Get("stats.score"))
+		require.Equal(t, int64(2), util.ToInt64(doc.Get("likes")))
+		require.Equal(t, []interface{}{"b", "c", "d"}, doc.Get("tags"))
+		require.False(t, doc.Has("tmp"))
+		require.Equal(t, int64(4), util.ToInt64(doc.Get("best")))
+		require.False(t, doc.Has("title"))
+		require.Equal(t, "hello", doc.Get("meta.title"))
+
+		// the index has been updated
+		n, err := db.Count(q.NewQuery("posts").Where(q.Field("stats.views").Eq(11)))
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+
+		n, err = db.Count(q.NewQuery("posts").Where(q.Field("stats.views").Eq(10)))
+		require.NoError(t, err)
+		require.Equal(t, 0, n)
+
+		require.NoError(t, db.UpdateByIdWith("posts", id, q.Set("meta.title", "bye").Pull("missing", 1)))
+		doc, err = db.FindById("posts", id)
+		require.NoError(t, err)
+		require.Equal(t, "bye", doc.Get("meta.title"))
+		require.False(t, doc.Has("missing"))
+
+		require.Equal(t, c.ErrDocumentNotExist, db.UpdateByIdWith("posts", "invalid-id", q.Set("a", 1)))
+
+		// invalid updates leave the documents untouched
+		_, err = db.UpdateWith(q.NewQuery("posts"), q.Inc("stats.views", 1).Inc("meta.title", 1))
+		require.True(t, errors.Is(err, c.ErrInvalidUpdate))
+
+		err = db.UpdateByIdWith("posts", id, q.Push("meta.title", "x"))
+		require.True(t, errors.Is(err, c.ErrInvalidUpdate))
+
+		err = db.UpdateByIdWith("posts", id, q.Unset("_id"))
+		require.True(t, errors.Is(err, c.ErrInvalidUpdate))
+
+		doc, err = db.FindById("posts", id)
+		require.NoError(t, err)
+		require.Equal(t, int64(11), util.ToInt64(doc.Get("stats.views")))
+	})
+}
+
+func TestUpsert(t *testing.T) {
+	runCloverTest(t, func(t *testing.T, db *c.DB) {
+		require.NoError(t, db.CreateCollection("counters"))
+
+		upsert := c.UpdateOptions{Upsert: true}
+		query := q.NewQuery("counters").Where(q.Field("name").Eq("visits").And(q.Field("meta.source").Eq("web")).And(q.Field("hits").Gt(-1)))
+
+		for i := 0; i < 3; i++ {
+			res, err := db.UpdateWith(query, q.Inc("hits", 1), upsert)
+			require.NoError(t, err)
+
+			if i == 0 {
+				require.Equal(t, 0, res.MatchedCount)
+				require.NotEmpty(t, res.UpsertedId)
+			} else {
+				require.Equal(t, 1, res.MatchedCount)
+				require.Empty(t, res.UpsertedId)
+			}
+		}
+
+		docs, err := db.FindAll(q.NewQuery("counters"))
+		require.NoError(t, err)
+		require.Len(t, docs, 1)
+		require.Equal(t, "visits", docs[0].Get("name"))
+		require.Equal(t, "web", docs[0].Get("meta.source"))
+		require.Equal(t, int64(3), util.ToInt64(docs[0].Get("hits")))
+
+		// without the Upsert option, nothing is inserted
+		_, err = db.Update(q.NewQuery("counters").Where(q.Field("name").Eq("clicks")), map[string]interface{}{"hits": 1})
+		require.NoError(t, err)
+
+		n, err := db.Count(q.NewQuery("counters"))
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+
+		// conditions which are not equalities do not contribute to the new document
+		_, err = db.Update(q.NewQuery("counters").Where(q.Field("name").Eq("clicks").Or(q.Field("hits").Eq(5))), map[string]interface{}{"hits": 1}, upsert)
+		require.NoError(t, err)
+		_, err = db.UpdateFunc(q.NewQuery("counters").Where(q.Field("name").Eq("clicks")), func(doc *d.Document) *d.Document {
+			require.False(t, doc.Has("hits"))
+			doc.Set("hits", 1)
+			return doc
+		}, upsert)
+		require.NoError(t, err)
+
+		docs, err = db.FindAll(q.NewQuery("counters").Where(q.Field("hits").Eq(1)))
+		require.NoError(t, err)
+		require.Len(t, docs, 2)
+		require.False(t, docs[0].Has("name") && docs[1].Has("name"))
+
+		id := c.NewObjectId()
+		require.Equal(t, c.ErrDocumentNotExist, db.UpdateByIdWith("counters", id, q.Inc("hits", 1)))
+		require.NoError(t, db.UpdateByIdWith("counters", id, q.Inc("hits", 1), upsert))
+		require.NoError(t, db.UpdateById("counters", id, func(doc *d.Document) *d.Document {
+			doc.Set("hits", util.ToInt64(doc.Get("hits"))+1)
+			return doc
+		}, upsert))
+
+		doc, err := db.FindById("counters", id)
+		require.NoError(t, err)
+		require.Equal(t, int64(2), util.ToInt64(doc.Get("hits")))
+
+		n, err = db.Count(q.NewQuery("counters"))
+		require.NoError(t, err)
+		require.Equal(t, 4, n)
+	})
+}
+
+func TestMutationResults(t *testing.T) {
+	runCloverTest(t, func(t *testing.T, db *c.DB) {
+		require.NoError(t, db.CreateCollection("tasks"))
+
+		for i := 0; i < 10; i++ {
+			require.NoError(t, db.Insert("tasks", d.NewDocumentOf(map[string]interface{}{"priority": i, "done": i < 3})))
+		}
+
+		res, err := db.Update(q.NewQuery("tasks").Where(q.Field("priority").Lt(5)), map[string]interface{}{"done": true})
+		require.NoError(t, err)
+		require.Equal(t, &c.UpdateResult{MatchedCount: 5, ModifiedCount: 2}, res)
+
+		// updaters may modify documents in place: only actual changes are counted as modifications
+		res, err = db.UpdateFunc(q.NewQuery("tasks").Where(q.Field("priority").GtEq(5)), func(doc *d.Document) *d.Document {
+			if doc.Get("priority").(int64) < 7 {
+				doc.Set("done", false)
+			}
+			return doc
+		})
+		require.NoError(t, err)
+		require.Equal(t, &c.UpdateResult{MatchedCount: 5, ModifiedCount: 0}, res)
+
+		res, err = db.UpdateFunc(q.NewQuery("tasks").Where(q.Field("priority").GtEq(5)), func(doc *d.Document) *d.Document {
+			doc.Set("priority", doc.Get("priority").(int64)+10)
+			return doc
+		})
+		require.NoError(t, err)
+		require.Equal(t, &c.UpdateResult{MatchedCount: 5, ModifiedCount: 5}, res)
+
+		res, err = db.UpdateFunc(q.NewQuery("tasks").Where(q.Field("priority").GtEq(15)), func(doc *d.Document) *d.Document {
+			doc.Set("priority", doc.Get("priority").(int64)-10)
+			return doc
+		})
+		require.NoError(t, err)
+		require.Equal(t, &c.UpdateResult{MatchedCount: 5, ModifiedCount: 5}, res)
+
+		res, err = db.UpdateFunc(q.NewQuery("tasks").Where(q.Field("priority").Gt(7)), func(doc *d.Document) *d.Document {
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, &c.UpdateResult{MatchedCount: 2, DeletedCount: 2}, res)
+
+		delRes, err := db.Delete(q.NewQuery("tasks").Where(q.Field("done").IsTrue()))
+		require.NoError(t, err)
+		require.Equal(t, 5, delRes.DeletedCount)
+
+		n, err := db.Count(q.NewQuery("tasks"))
+		require.NoError(t, err)
+		require.Equal(t, 3, n)
+
+		query := q.NewQuery("tasks").Sort(q.SortOption{Field: "priority", Direction: -1})
+
+		doc, err := db.FindOneAndUpdate(query, q.Set("done", true))
+		require.NoError(t, err)
+		require.Equal(t, int64(7), util.ToInt64(doc.Get("priority")))
+		require.False(t, doc.Get("done").(bool))
+
+		doc, err = db.FindOneAndUpdate(query.Where(q.Field("done").IsFalse()).Select("priority"), q.Inc("priority", 10), c.FindOneAndUpdateOptions{ReturnNew: true})
+		require.NoError(t, err)
+		require.Equal(t, int64(16), util.ToInt64(doc.Get("priority")))
+		require.False(t, doc.Has("done"))
+
+		doc, err = db.FindOneAndUpdate(query.Where(q.Field("priority").Eq(100)), q.Set("done", false))
+		require.NoError(t, err)
+		require.Nil(t, doc)
+
+		opts := c.FindOneAndUpdateOptions{ReturnNew: true}
+		opts.Upsert = true
+		doc, err = db.FindOneAndUpdate(query.Where(q.Field("priority").Eq(100)), q.Set("done", false), opts)
+		require.NoError(t, err)
+		require.NotNil(t, doc)
+		require.Equal(t, int64(100), util.ToInt64(doc.Get("priority")))
+
+		doc, err = db.FindOneAndDelete(query)
+		require.NoError(t, err)
+		require.Equal(t, int64(100), util.ToInt64(doc.Get("priority")))
+
+		doc, err = db.FindById("tasks", doc.ObjectId())
+		require.NoError(t, err)
+		require.Nil(t, doc)
+
+		doc, err = db.FindOneAndDelete(query.Where(q.Field("priority").Eq(100)))
+		require.NoError(t, err)
+		require.Nil(t, doc)
+	})
+}
+
+func TestVersionedCollection(t *testing.T) {
+	runCloverTest(t, func(t *testing.T, db *c.DB) {
+		require.NoError(t, db.CreateCollectionWithOptions("notes", c.CollectionOptions{Versioned: true}))
+		require.NoError(t, db.CreateCollection("plain"))
+
+		doc := d.NewDocument()
+		doc.Set("text", "hello")
+		doc.Set(d.VersionField, 10)
+		id, err := db.InsertOne("notes", doc)
+		require.NoError(t, err)
+
+		plainId, err := db.InsertOne("plain", d.NewDocumentOf(map[string]interface{}{"text": "hello"}))
+		require.NoError(t, err)
+
+		stale, err := db.FindById("notes", id)
+		require.NoError(t, err)
+		require.Equal(t, int64(1), stale.Version())
+
+		require.NoError(t, db.UpdateById("notes", id, func(doc *d.Document) *d.Document {
+			doc.Set("text", "hello, world")
+			return doc
+		}))
+
+		current, err := db.FindById("notes", id)
+		require.NoError(t, err)
+		require.Equal(t, int64(2), current.Version())
+
+		// a client editing an old copy of the document cannot overwrite newer changes
+		stale.Set("text", "hi")
+		require.Equal(t, c.ErrVersionConflict, db.ReplaceById("notes", id, stale))
+		require.Equal(t, c.ErrVersionConflict, db.Save("notes", stale))
+
+		// a replacement without a version cannot be checked, so it is rejected as well
+		unversioned := current.Copy()
+		unversioned.Unset(d.VersionField)
+		unversioned.Set("text", "hi")
+		require.Equal(t, c.ErrVersionConflict, db.ReplaceById("notes", id, unversioned))
+		require.Equal(t, c.ErrVersionConflict, db.Save("notes", unversioned))
+
+		current.Set("text", "hi")
+		require.NoError(t, db.ReplaceById("notes", id, current))
+
+		_, err = db.UpdateWith(q.NewQuery("notes").Where(q.Field("_id").Eq(id)), q.Set(d.VersionField, 2).Set("text", "bye"))
+		require.Equal(t, c.ErrVersionConflict, err)
+
+		res, err := db.UpdateWith(q.NewQuery("notes").Where(q.Field("_id").Eq(id)), q.Set(d.VersionField, 3).Set("text", "bye"))
+		require.NoError(t, err)
+		require.Equal(t, 1, res.ModifiedCount)
+
+		doc, err = db.FindById("notes", id)
+		require.NoError(t, err)
+		require.Equal(t, int64(4), doc.Version())
+		require.Equal(t, "bye", doc.Get("text"))
+
+		// updates leaving the content unchanged are not counted as modifications, even if they increment the version
+		res, err = db.Update(q.NewQuery("notes").Where(q.Field("_id").Eq(id)), map[string]interface{}{"text": "bye"})
+		require.NoError(t, err)
+		require.Equal(t, &c.UpdateResult{MatchedCount: 1, ModifiedCount: 0}, res)
+
+		res, err = db.UpdateFunc(q.NewQuery("notes").Where(q.Field("_id").Eq(id)), func(doc *d.Document) *d.Document {
+			return doc
+		})
+		require.NoError(t, err)
+		require.Equal(t, &c.UpdateResult{MatchedCount: 1, ModifiedCount: 0}, res)
+
+		_, err = db.Update(q.NewQuery("plain"), map[string]interface{}{"text": "bye"})
+		require.NoError(t, err)
+
+		doc, err = db.FindById("plain", plainId)
+		require.NoError(t, err)
+		require.False(t, doc.Has(d.VersionField))
+	})
+}
+
+func TestSchemaValidation(t *testing.T) {
+	personSchema, err := schema.Parse([]byte(`{
+		"type": "object",
+		"required": ["name", "age"],
+		"additionalProperties": false,
+		"properties": {
+			"name": {"type": "string", "minLength": 1},
+			"age": {"type": "integer", "minimum": 0},
+			"address": {
+				"type": "object",
+				"properties": {"zip": {"type": "string", "pattern": "^[0-9]{5}$"}}
+			},
+			"tags": {"type": "array", "items": {"type": "string"}}
+		}
+	}`))
+	require.NoError(t, err)
+
+	runCloverTest(t, func(t *testing.T, db *c.DB) {
+		require.NoError(t, db.CreateCollectionWithOptions("people", c.CollectionOptions{Schema: personSchema, Versioned: true}))
+
+		id, err := db.InsertOne("people", d.NewDocumentOf(map[string]interface{}{
+			"name":    "John",
+			"age":     30,
+			"address": map[string]interface{}{"zip": "12345"},
+			"tags":    []interface{}{"a", "b"},
+		}))
+		require.NoError(t, err)
+
+		requireViolation := func(err error, path string) {
+			var validationErr *schema.ValidationError
+			require.True(t, errors.As(err, &validationErr), "unexpected error: %v", err)
+			require.Equal(t, path, validationErr.Path)
+		}
+
+		err = db.Insert("people", d.NewDocumentOf(map[string]interface{}{"name": "Jane"}))
+		requireViolation(err, "age")
+
+		err = db.Insert("people", d.NewDocumentOf(map[string]interface{}{"name": "Jane", "age": 20.5}))
+		requireViolation(err, "age")
+
+		err = db.Insert("people", d.NewDocumentOf(map[string]interface{}{"name": "Jane", "age": 20, "address": map[string]interface{}{"zip": "abc"}}))
+		requireViolation(err, "address.zip")
+
+		err = db.Insert("people", d.NewDocumentOf(map[string]interface{}{"name": "Jane", "age": 20, "nickname": "J"}))
+		requireViolation(err, "nickname")
+
+		_, err = db.UpdateWith(q.NewQuery("people"), q.Push("tags", 10))
+		requireViolation(err, "tags[2]")
+
+		err = db.UpdateByIdWith("people", id, q.Unset("name"))
+		requireViolation(err, "name")
+
+		n, err := db.Count(q.NewQuery("people"))
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+
+		doc, err := db.FindById("people", id)
+		require.NoError(t, err)
+		require.Equal(t, "John", doc.Get("name"))
+		require.Len(t, doc.Get("tags"), 2)
+
+		_, err = db.UpdateWith(q.NewQuery("people"), q.Inc("age", 1))
+		require.NoError(t, err)
+
+		invalidDocs, err := db.ValidateCollection("people")
+		require.NoError(t, err)
+		require.Empty(t, invalidDocs)
+
+		_, err = db.ValidateCollection("missing")
+		require.Equal(t, c.ErrCollectionNotExist, err)
+	})
+}
+
+func TestSchemaValidationWarnMode(t *testing.T) {
+	personSchema := &schema.Schema{
+		Type:     schema.Types{schema.ObjectType},
+		Required: []string{"name"},
+	}
+
+	for _, openStore := range []func(string) (store.Store, error){badgerstore.Open, bbolt.Open} {
+		dir, err := os.MkdirTemp("", "clover-test")
+		require.NoError(t, err)
+
+		dataStore, err := openStore(dir)
+		require.NoError(t, err)
+
+		warnings := make(map[string]error)
+		db, err := c.OpenWithStore(dataStore, c.WithSchemaWarningHandler(func(collection, docId string, err error) {
+			require.Equal(t, "people", collection)
+			warnings[docId] = err
+		}))
+		require.NoError(t, err)
+
+		require.NoError(t, db.CreateCollectionWithOptions("people", c.CollectionOptions{Schema: personSchema, ValidationMode: c.ValidationWarn}))
+
+		validId, err := db.InsertOne("people", d.NewDocumentOf(map[string]interface{}{"name": "John"}))
+		require.NoError(t, err)
+
+		invalidId, err := db.InsertOne("people", d.NewDocumentOf(map[string]interface{}{"age": 20}))
+		require.NoError(t, err)
+
+		require.Len(t, warnings, 1)
+		require.Contains(t, warnings, invalidId)
+
+		require.NoError(t, db.UpdateByIdWith("people", validId, q.Unset("name")))
+		require.Len(t, warnings, 2)
+		require.Contains(t, warnings, validId)
+
+		require.NoError(t, db.UpdateByIdWith("people", invalidId, q.Set("name", "Jane")))
+
+		// warnings are reported only once the transaction commits
+		tx, err := db.Begin()
+		require.NoError(t, err)
+		pendingId, err := tx.InsertOne("people", d.NewDocumentOf(map[string]interface{}{"age": 30}))
+		require.NoError(t, err)
+		require.NotContains(t, warnings, pendingId)
+		require.NoError(t, tx.Commit())
+		require.Contains(t, warnings, pendingId)
+		require.NoError(t, db.DeleteById("people", pendingId))
+
+		var rolledBackId string
+		err = db.RunInTransaction(func(tx *c.Tx) error {
+			rolledBackId, err = tx.InsertOne("people", d.NewDocumentOf(map[string]interface{}{"age": 40}))
+			require.NoError(t, err)
+			return errors.New("abort")
+		})
+		require.Error(t, err)
+		require.NotContains(t, warnings, rolledBackId)
+		require.Len(t, warnings, 3)
+
+		invalidDocs, err := db.ValidateCollection("people")
+		require.NoError(t, err)
+		require.Len(t, invalidDocs, 1)
+		require.Equal(t, validId, invalidDocs[0].DocumentId)
+		require.EqualError(t, invalidDocs[0].Err, "schema validation failed at name: required field is missing")
+
+		require.NoError(t, db.Close())
+		require.NoError(t, os.RemoveAll(dir))
+	}
+}
+
 func TestReplaceById(t *testing.T) {
 	runCloverTest(t, func(t *testing.T, db *c.DB) {
 		require.NoError(t, loadFromJson(db, todosPath, &TodoModel{}))
@@ -392,7 +842,7 @@ func TestInsertAndDelete(t *testing.T) {
 		require.NoError(t, loadFromJson(db, todosPath, &TodoModel{}))
 
 		criteria := q.Field("completed").Eq(true)
-		err := db.Delete(q.NewQuery("todos").Where(criteria))
+		_, err := db.Delete(q.NewQuery("todos").Where(criteria))
 		require.NoError(t, err)
 
 		n, err := db.Count(q.NewQuery("todos"))
@@ -1223,62 +1673,433 @@ func TestExportAndImportCollection(t *testing.T) {
 	})
 }
 
-func TestSliceCompare(t *testing.T) {
+func TestStreamingExportAndImport(t *testing.T) {
 	runCloverTest(t, func(t *testing.T, db *c.DB) {
-		require.NoError(t, loadFromJson(db, todosPath, nil))
+		require.NoError(t, loadFromJson(db, todosPath, &TodoModel{}))
 
-		allDocs, err := db.FindAll(q.NewQuery("todos"))
+		completedQuery := q.NewQuery("todos").Where(q.Field("completed").IsTrue())
+		nCompleted, err := db.Count(completedQuery)
 		require.NoError(t, err)
 
-		require.NoError(t, db.CreateCollection("todos.copy"))
+		for _, format := range []c.Format{c.FormatJSON, c.FormatNDJSON} {
+			var buf bytes.Buffer
+			exported := 0
+			n, err := db.Export(&buf, completedQuery, c.ExportOptions{Format: format, Progress: func(n int) { exported = n }})
+			require.NoError(t, err)
+			require.Equal(t, nCompleted, n)
+			require.Equal(t, nCompleted, exported)
 
-		for _, doc := range allDocs {
-			title, _ := doc.Get("title").(string)
-			if title != "" {
-				s := make([]int, len(title))
-				for i := 0; i < len(title); i++ {
-					s[i] = int(title[i])
+			if format == c.FormatNDJSON {
+				require.Equal(t, nCompleted, strings.Count(buf.String(), "\n"))
+			} else {
+				// one element per line, with separators at the end of the lines
+				lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+				require.Len(t, lines, nCompleted+2)
+				require.Equal(t, "[", lines[0])
+				require.Equal(t, "]", lines[len(lines)-1])
+				for i, line := range lines[1 : len(lines)-1] {
+					require.Equal(t, i < nCompleted-1, strings.HasSuffix(line, ","))
 				}
-				doc.Set("title", s)
+				require.True(t, json.Valid(buf.Bytes()))
 			}
-		}
-		err = db.Insert("todos.copy", allDocs...)
-		require.NoError(t, err)
 
-		sort1, err := db.FindAll(q.NewQuery("todos").Sort(q.SortOption{Field: "title"}))
-		require.NoError(t, err)
+			progress := make([]int, 0)
+			n, err = db.Import(bytes.NewReader(buf.Bytes()), "completed", c.ImportOptions{
+				Format:    format,
+				BatchSize: 10,
+				Progress:  func(n int) { progress = append(progress, n) },
+			})
+			require.NoError(t, err)
+			require.Equal(t, nCompleted, n)
+			require.Len(t, progress, (nCompleted+9)/10)
+			require.Equal(t, nCompleted, progress[len(progress)-1])
 
-		sort2, err := db.FindAll(q.NewQuery("todos.copy").Sort(q.SortOption{Field: "title"}))
-		require.NoError(t, err)
+			// documents carry their ids, so they cannot be appended twice
+			_, err = db.Import(bytes.NewReader(buf.Bytes()), "completed", c.ImportOptions{Format: format})
+			require.ErrorIs(t, err, c.ErrDuplicateKey)
 
-		require.Equal(t, len(sort1), len(sort2))
+			_, err = db.Import(bytes.NewReader(buf.Bytes()), "completed", c.ImportOptions{Format: format, Mode: c.ImportUpsert})
+			require.NoError(t, err)
 
-		for i := 0; i < len(sort1); i++ {
-			doc1 := sort1[i]
-			doc2 := sort2[i]
+			count, err := db.Count(q.NewQuery("completed"))
+			require.NoError(t, err)
+			require.Equal(t, nCompleted, count)
 
-			title := ""
-			sTitle := doc2.Get("title").([]interface{})
-			for j := 0; j < len(sTitle); j++ {
-				title += string(byte(sTitle[j].(int64)))
+			require.NoError(t, db.Insert("completed", d.NewDocumentOf(map[string]interface{}{"title": "extra"})))
+
+			_, err = db.Import(bytes.NewReader(buf.Bytes()), "completed", c.ImportOptions{Format: format, Mode: c.ImportReplace})
+			require.NoError(t, err)
+
+			count, err = db.Count(q.NewQuery("completed"))
+			require.NoError(t, err)
+			require.Equal(t, nCompleted, count)
+
+			docs, err := db.FindAll(completedQuery.Sort())
+			require.NoError(t, err)
+
+			importedDocs, err := db.FindAll(q.NewQuery("completed").Sort())
+			require.NoError(t, err)
+			require.Equal(t, len(docs), len(importedDocs))
+
+			for i := range docs {
+				require.Equal(t, docs[i].Get("title"), importedDocs[i].Get("title"))
 			}
-			require.Equal(t, title, doc1.Get("title"))
+
+			require.NoError(t, db.DropCollection("completed"))
 		}
-	})
-}
 
-func TestCompareDocumentFields(t *testing.T) {
-	runCloverTest(t, func(t *testing.T, db *c.DB) {
-		require.NoError(t, loadFromJson(db, airlinesPath, nil))
+		_, err = db.Import(strings.NewReader(`{"title": "not an array"}`), "invalid")
+		require.ErrorIs(t, err, c.ErrInvalidImport)
 
-		criteria := q.Field("Statistics.Flights.Diverted").Gt(q.Field("Statistics.Flights.Cancelled"))
-		docs, err := db.FindAll(q.NewQuery("airlines").Where(criteria))
+		_, err = db.Import(strings.NewReader("{\"title\": \"a\"}\n{\"title\""), "invalid", c.ImportOptions{Format: c.FormatNDJSON})
+		require.ErrorIs(t, err, c.ErrInvalidImport)
+
+		n, err := db.Import(strings.NewReader("[]"), "empty")
 		require.NoError(t, err)
+		require.Equal(t, 0, n)
 
-		require.Greater(t, len(docs), 0)
-		for _, doc := range docs {
-			diverted := doc.Get("Statistics.Flights.Diverted").(float64)
-			cancelled := doc.Get("Statistics.Flights.Cancelled").(float64)
+		var buf bytes.Buffer
+		n, err = db.Export(&buf, q.NewQuery("empty"))
+		require.NoError(t, err)
+		require.Equal(t, 0, n)
+		require.Equal(t, "[\n]\n", buf.String())
+
+		has, err := db.HasCollection("empty")
+		require.NoError(t, err)
+		require.True(t, has)
+	})
+}
+
+func TestCSVExportAndImport(t *testing.T) {
+	runCloverTest(t, func(t *testing.T, db *c.DB) {
+		require.NoError(t, db.CreateCollection("people"))
+
+		born := time.Date(1990, 5, 17, 10, 30, 0, 0, time.UTC)
+		john := d.NewDocumentOf(map[string]interface{}{
+			"name":    "John",
+			"age":     30,
+			"score":   7.5,
+			"active":  true,
+			"nick":    nil,
+			"born":    born,
+			"tags":    []interface{}{"a", "b"},
+			"address": map[string]interface{}{"city": "Rome", "zip": "00100"},
+		})
+		jane := d.NewDocumentOf(map[string]interface{}{"name": "Jane, Jr.", "age": 25})
+		require.NoError(t, db.Insert("people", john, jane))
+
+		var buf bytes.Buffer
+		n, err := db.ExportCSV(&buf, q.NewQuery("people").Sort(q.SortOption{Field: "age"}), c.CSVExportOptions{
+			CSVOptions: c.CSVOptions{Headers: map[string]string{"address.city": "City"}},
+		})
+		require.NoError(t, err)
+		require.Equal(t, 2, n)
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		require.Len(t, lines, 3)
+		require.Equal(t, "_id,active,City,address.zip,age,born,name,nick,score,tags", lines[0])
+		require.Equal(t, jane.ObjectId()+",,,,25,,\"Jane, Jr.\",,,", lines[1])
+
+		n, err = db.ImportCSV(bytes.NewReader(buf.Bytes()), "people-copy", c.CSVImportOptions{
+			CSVOptions: c.CSVOptions{Headers: map[string]string{"address.city": "City"}},
+		})
+		require.NoError(t, err)
+		require.Equal(t, 2, n)
+
+		doc, err := db.FindById("people-copy", john.ObjectId())
+		require.NoError(t, err)
+		require.Equal(t, "John", doc.Get("name"))
+		require.Equal(t, int64(30), util.ToInt64(doc.Get("age")))
+		require.Equal(t, 7.5, doc.Get("score"))
+		require.Equal(t, true, doc.Get("active"))
+		require.True(t, doc.Has("nick"))
+		require.Nil(t, doc.Get("nick"))
+		require.True(t, born.Equal(doc.Get("born").(time.Time)))
+		require.Equal(t, []interface{}{"a", "b"}, doc.Get("tags"))
+		require.Equal(t, "Rome", doc.Get("address.city"))
+		require.Equal(t, "00100", doc.Get("address.zip"))
+
+		doc, err = db.FindById("people-copy", jane.ObjectId())
+		require.NoError(t, err)
+		require.Equal(t, []string{"_id", "age", "name"}, doc.Fields(true))
+
+		buf.Reset()
+		_, err = db.ExportCSV(&buf, q.NewQuery("people").Sort(q.SortOption{Field: "age"}), c.CSVExportOptions{
+			CSVOptions: c.CSVOptions{Delimiter: ';', Columns: []string{"name", "address.city"}},
+		})
+		require.NoError(t, err)
+		require.Equal(t, "name;address.city\nJane, Jr.;\nJohn;Rome\n", buf.String())
+
+		n, err = db.ImportCSV(strings.NewReader("name;age;city\nMark;40;Paris\n"), "people-copy", c.CSVImportOptions{
+			CSVOptions: c.CSVOptions{Delimiter: ';', Columns: []string{"name", "address.city"}, Headers: map[string]string{"address.city": "city"}},
+		})
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+
+		doc, err = db.FindFirst(q.NewQuery("people-copy").Where(q.Field("name").Eq("Mark")))
+		require.NoError(t, err)
+		require.Equal(t, "Paris", doc.Get("address.city"))
+		require.False(t, doc.Has("age"))
+
+		_, err = db.ImportCSV(strings.NewReader(""), "people-copy")
+		require.ErrorIs(t, err, c.ErrInvalidImport)
+	})
+}
+
+func TestCSVLossyRoundTrip(t *testing.T) {
+	runCloverTest(t, func(t *testing.T, db *c.DB) {
+		require.NoError(t, db.CreateCollection("values"))
+
+		now := time.Date(2020, 1, 29, 22, 54, 41, 0, time.UTC)
+		doc := d.NewDocumentOf(map[string]interface{}{
+			"number":  "42",
+			"bool":    "true",
+			"null":    "null",
+			"empty":   "",
+			"time":    "2020-01-29T22:54:41Z",
+			"array":   "[1]",
+			"float":   2.0,
+			"big":     uint64(math.MaxUint64),
+			"object":  map[string]interface{}{},
+			"nested":  []interface{}{int64(1), now},
+			"kept":    "plain text",
+			"integer": 7,
+		})
+		require.NoError(t, db.Insert("values", doc))
+
+		var buf bytes.Buffer
+		_, err := db.ExportCSV(&buf, q.NewQuery("values"))
+		require.NoError(t, err)
+
+		_, err = db.ImportCSV(&buf, "values-copy")
+		require.NoError(t, err)
+
+		imported, err := db.FindById("values-copy", doc.ObjectId())
+		require.NoError(t, err)
+
+		require.Equal(t, int64(42), imported.Get("number"))
+		require.Equal(t, true, imported.Get("bool"))
+		require.True(t, imported.Has("null"))
+		require.Nil(t, imported.Get("null"))
+		require.False(t, imported.Has("empty"))
+		require.Equal(t, now, imported.Get("time"))
+		require.Equal(t, []interface{}{float64(1)}, imported.Get("array"))
+		require.Equal(t, int64(2), imported.Get("float"))
+		require.Equal(t, float64(math.MaxUint64), imported.Get("big"))
+		require.False(t, imported.Has("object"))
+		require.Equal(t, []interface{}{float64(1), now.Format(time.RFC3339Nano)}, imported.Get("nested"))
+
+		require.Equal(t, "plain text", imported.Get("kept"))
+		require.Equal(t, int64(7), imported.Get("integer"))
+	})
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	runCloverTest(t, func(t *testing.T, db *c.DB) {
+		require.NoError(t, loadFromJson(db, todosPath, &TodoModel{}))
+		require.NoError(t, db.CreateIndex("todos", "userId"))
+		require.NoError(t, db.CreateCollectionWithOptions("notes", c.CollectionOptions{Versioned: true}))
+
+		noteId, err := db.InsertOne("notes", d.NewDocumentOf(map[string]interface{}{"text": "hello"}))
+		require.NoError(t, err)
+		require.NoError(t, db.UpdateByIdWith("notes", noteId, q.Set("text", "hello, world")))
+
+		expired := d.NewDocumentOf(map[string]interface{}{"text": "expired"})
+		expired.SetExpiresAt(time.Now().Add(-time.Second))
+		require.NoError(t, db.Insert("notes", expired))
+
+		var buf bytes.Buffer
+		require.NoError(t, db.Backup(&buf))
+
+		for _, openStore := range []func(string) (store.Store, error){badgerstore.Open, bbolt.Open} {
+			dir, err := os.MkdirTemp("", "clover-restore")
+			require.NoError(t, err)
+
+			dataStore, err := openStore(dir)
+			require.NoError(t, err)
+
+			require.NoError(t, c.Restore(bytes.NewReader(buf.Bytes()), dataStore))
+			require.ErrorIs(t, c.Restore(bytes.NewReader(buf.Bytes()), dataStore), c.ErrCollectionExist)
+
+			restoredDB, err := c.OpenWithStore(dataStore)
+			require.NoError(t, err)
+
+			collections, err := restoredDB.ListCollections()
+			require.NoError(t, err)
+			require.ElementsMatch(t, []string{"todos", "notes"}, collections)
+
+			indexes, err := restoredDB.ListIndexes("todos")
+			require.NoError(t, err)
+			require.Len(t, indexes, 1)
+			require.Equal(t, "userId", indexes[0].Field)
+
+			userQuery := q.NewQuery("todos").Where(q.Field("userId").Eq(5))
+			docs, err := db.FindAll(userQuery)
+			require.NoError(t, err)
+
+			restoredDocs, err := restoredDB.FindAll(userQuery)
+			require.NoError(t, err)
+			require.Equal(t, len(docs), len(restoredDocs))
+
+			n, err := restoredDB.Count(q.NewQuery("todos"))
+			require.NoError(t, err)
+			m, err := db.Count(q.NewQuery("todos"))
+			require.NoError(t, err)
+			require.Equal(t, m, n)
+
+			n, err = restoredDB.Count(q.NewQuery("notes"))
+			require.NoError(t, err)
+			require.Equal(t, 1, n)
+
+			note, err := restoredDB.FindById("notes", noteId)
+			require.NoError(t, err)
+			require.Equal(t, int64(2), note.Version())
+
+			// the restored collection keeps its options
+			note.Set(d.VersionField, 1)
+			require.Equal(t, c.ErrVersionConflict, restoredDB.ReplaceById("notes", noteId, note))
+
+			require.NoError(t, restoredDB.Close())
+			require.NoError(t, os.RemoveAll(dir))
+		}
+
+		corrupted := append([]byte{}, buf.Bytes()...)
+		corrupted[len(corrupted)/2] ^= 0xff
+
+		for _, data := range [][]byte{corrupted, buf.Bytes()[:buf.Len()-1], []byte("not a backup")} {
+			dir, err := os.MkdirTemp("", "clover-restore")
+			require.NoError(t, err)
+
+			dataStore, err := bbolt.Open(dir)
+			require.NoError(t, err)
+
+			require.ErrorIs(t, c.Restore(bytes.NewReader(data), dataStore), c.ErrInvalidBackup)
+
+			restoredDB, err := c.OpenWithStore(dataStore)
+			require.NoError(t, err)
+
+			collections, err := restoredDB.ListCollections()
+			require.NoError(t, err)
+			require.Empty(t, collections)
+
+			require.NoError(t, restoredDB.Close())
+			require.NoError(t, os.RemoveAll(dir))
+		}
+	})
+}
+
+func TestRestoreLargeBackup(t *testing.T) {
+	dir, err := os.MkdirTemp("", "clover-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "source"), os.ModePerm))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "restored"), os.ModePerm))
+
+	dataStore, err := bbolt.Open(filepath.Join(dir, "source"))
+	require.NoError(t, err)
+
+	db, err := c.OpenWithStore(dataStore)
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.CreateCollection("events"))
+	require.NoError(t, db.CreateIndex("events", "kind"))
+
+	// about 15 MB of documents, which do not fit into a single badger transaction
+	const nDocs = 60000
+	payload := strings.Repeat("x", 200)
+	for i := 0; i < nDocs; i += 10000 {
+		docs := make([]*d.Document, 0, 10000)
+		for j := i; j < i+10000; j++ {
+			docs = append(docs, d.NewDocumentOf(map[string]interface{}{"seq": j, "kind": j % 10, "payload": payload}))
+		}
+		require.NoError(t, db.Insert("events", docs...))
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, db.Backup(&buf))
+	require.Greater(t, buf.Len(), 15<<20)
+
+	restoredStore, err := badgerstore.Open(filepath.Join(dir, "restored"))
+	require.NoError(t, err)
+
+	// readers which cannot seek are staged before being restored
+	require.NoError(t, c.Restore(struct{ io.Reader }{&buf}, restoredStore))
+
+	restoredDB, err := c.OpenWithStore(restoredStore)
+	require.NoError(t, err)
+	defer restoredDB.Close()
+
+	n, err := restoredDB.Count(q.NewQuery("events"))
+	require.NoError(t, err)
+	require.Equal(t, nDocs, n)
+
+	n, err = restoredDB.Count(q.NewQuery("events").Where(q.Field("kind").Eq(3)))
+	require.NoError(t, err)
+	require.Equal(t, nDocs/10, n)
+
+	issues, err := restoredDB.CheckIntegrity()
+	require.NoError(t, err)
+	require.Empty(t, issues)
+}
+
+func TestSliceCompare(t *testing.T) {
+	runCloverTest(t, func(t *testing.T, db *c.DB) {
+		require.NoError(t, loadFromJson(db, todosPath, nil))
+
+		allDocs, err := db.FindAll(q.NewQuery("todos"))
+		require.NoError(t, err)
+
+		require.NoError(t, db.CreateCollection("todos.copy"))
+
+		for _, doc := range allDocs {
+			title, _ := doc.Get("title").(string)
+			if title != "" {
+				s := make([]int, len(title))
+				for i := 0; i < len(title); i++ {
+					s[i] = int(title[i])
+				}
+				doc.Set("title", s)
+			}
+		}
+		err = db.Insert("todos.copy", allDocs...)
+		require.NoError(t, err)
+
+		sort1, err := db.FindAll(q.NewQuery("todos").Sort(q.SortOption{Field: "title"}))
+		require.NoError(t, err)
+
+		sort2, err := db.FindAll(q.NewQuery("todos.copy").Sort(q.SortOption{Field: "title"}))
+		require.NoError(t, err)
+
+		require.Equal(t, len(sort1), len(sort2))
+
+		for i := 0; i < len(sort1); i++ {
+			doc1 := sort1[i]
+			doc2 := sort2[i]
+
+			title := ""
+			sTitle := doc2.Get("title").([]interface{})
+			for j := 0; j < len(sTitle); j++ {
+				title += string(byte(sTitle[j].(int64)))
+			}
+			require.Equal(t, title, doc1.Get("title"))
+		}
+	})
+}
+
+func TestCompareDocumentFields(t *testing.T) {
+	runCloverTest(t, func(t *testing.T, db *c.DB) {
+		require.NoError(t, loadFromJson(db, airlinesPath, nil))
+
+		criteria := q.Field("Statistics.Flights.Diverted").Gt(q.Field("Statistics.Flights.Cancelled"))
+		docs, err := db.FindAll(q.NewQuery("airlines").Where(criteria))
+		require.NoError(t, err)
+
+		require.Greater(t, len(docs), 0)
+		for _, doc := range docs {
+			diverted := doc.Get("Statistics.Flights.Diverted").(float64)
+			cancelled := doc.Get("Statistics.Flights.Cancelled").(float64)
 			require.Greater(t, diverted, cancelled)
 		}
 
@@ -1433,7 +2254,7 @@ func TestIndexUpdate(t *testing.T) {
 		n, err := db.Count(q.NewQuery("airlines").Where(criteria))
 		require.NoError(t, err)
 
-		err = db.Update(q.NewQuery("airlines").Where(criteria), map[string]interface{}{
+		_, err = db.Update(q.NewQuery("airlines").Where(criteria), map[string]interface{}{
 			"Statistics.Flights.Cancelled": 99999999,
 		})
 		require.NoError(t, err)
@@ -1459,7 +2280,7 @@ func TestIndexDelete(t *testing.T) {
 		err = db.CreateIndex("airlines", "Statistics.Flights.Cancelled")
 		require.NoError(t, err)
 
-		err = db.Delete(q.NewQuery("airlines").Where(criteria))
+		_, err = db.Delete(q.NewQuery("airlines").Where(criteria))
 		require.NoError(t, err)
 
 		n, err = db.Count(q.NewQuery("airlines").Where(criteria))
@@ -1610,99 +2431,1325 @@ func TestListIndexes(t *testing.T) {
 	})
 }
 
-func TestCreateCollectionByQuery(t *testing.T) {
+func TestUniqueIndex(t *testing.T) {
 	runCloverTest(t, func(t *testing.T, db *c.DB) {
-		require.NoError(t, loadFromJson(db, todosPath, &TodoModel{}))
-
-		newCollection := "todos_copy"
-		criteria := q.Field("completed").Eq(true)
-		err := db.CreateCollectionByQuery(newCollection, q.NewQuery("todos").Where(criteria))
-		require.NoError(t, err)
-
-		hasNewCollection, err := db.HasCollection(newCollection)
-		require.True(t, hasNewCollection)
-		require.NoError(t, err)
+		require.NoError(t, db.CreateCollection("users"))
+		require.NoError(t, db.CreateUniqueIndex("users", "email"))
+		require.Equal(t, c.ErrIndexExist, db.CreateUniqueIndex("users", "email"))
 
-		docs, err := db.FindAll(q.NewQuery(newCollection))
+		indexes, err := db.ListIndexes("users")
 		require.NoError(t, err)
+		require.Equal(t, []index.Info{{Field: "email", Type: index.SingleField, Unique: true}}, indexes)
 
-		for _, doc := range docs {
-			completed, _ := doc.Get("completed").(bool)
-			require.True(t, completed)
+		newUser := func(email interface{}) *d.Document {
+			doc := d.NewDocument()
+			if email != nil {
+				doc.Set("email", email)
+			}
+			return doc
 		}
-	})
-}
-
-/*
-func TestInMemoryMode(t *testing.T) {
-	db, err := c.Open("clover-db", c.InMemoryMode(true))
-	require.NoError(t, err)
 
-	require.NoError(t, db.CreateCollection("test"))
-	has, err := db.HasCollection("test")
-	require.NoError(t, err)
-	require.True(t, has)
+		alice := newUser("alice@clover.com")
+		require.NoError(t, db.Insert("users", alice))
+		require.Equal(t, c.ErrDuplicateKey, db.Insert("users", newUser("alice@clover.com")))
 
-	require.NoError(t, db.Close())
+		// duplicates within the same batch are detected too, and the whole batch is rejected
+		require.Equal(t, c.ErrDuplicateKey, db.Insert("users", newUser("bob@clover.com"), newUser("bob@clover.com")))
 
-	db, err = c.Open("clover-db", c.InMemoryMode(true))
-	require.NoError(t, err)
+		n, err := db.Count(q.NewQuery("users"))
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
 
-	has, err = db.HasCollection("test")
-	require.NoError(t, err)
-	require.False(t, has)
-}*/
+		// documents without the field are not subject to the constraint
+		require.NoError(t, db.Insert("users", newUser(nil), newUser(nil)))
 
-/*
-func TestExpiration(t *testing.T) {
-	runCloverTest(t, func(t *testing.T, db *c.DB) {
-		require.NoError(t, db.CreateCollection("test"))
+		bob := newUser("bob@clover.com")
+		require.NoError(t, db.Insert("users", bob))
 
-		require.NoError(t, db.CreateIndex("test", "HasExpiration"))
+		_, err = db.Update(q.NewQuery("users").Where(q.Field("email").Eq("bob@clover.com")), map[string]interface{}{"email": "alice@clover.com"})
+		require.Equal(t, c.ErrDuplicateKey, err)
 
-		nInserts := 1000
+		err = db.UpdateById("users", bob.ObjectId(), func(doc *d.Document) *d.Document {
+			newDoc := doc.Copy()
+			newDoc.Set("email", "alice@clover.com")
+			return newDoc
+		})
+		require.Equal(t, c.ErrDuplicateKey, err)
 
-		expiredDocuments := 0
+		replacement := bob.Copy()
+		replacement.Set("email", "alice@clover.com")
+		require.Equal(t, c.ErrDuplicateKey, db.ReplaceById("users", bob.ObjectId(), replacement))
+		require.Equal(t, c.ErrDuplicateKey, db.Save("users", replacement))
 
-		docs := make([]*d.Document, 0)
-		expiresAt := time.Now().Add(time.Second * 5)
-		for i := 0; i < nInserts; i++ {
-			doc := d.NewDocument()
-			if rand.Intn(2) == 0 {
-				doc.SetExpiresAt(expiresAt)
-				expiredDocuments++
-				doc.Set("HasExpiration", true)
-			} else {
-				doc.Set("HasExpiration", false)
-			}
+		// rewriting a document with its own value is allowed
+		require.NoError(t, db.Save("users", bob))
 
-			docs = append(docs, doc)
-		}
+		_, err = db.Update(q.NewQuery("users").Where(q.Field("email").Eq("bob@clover.com")), map[string]interface{}{"email": "robert@clover.com"})
+		require.NoError(t, err)
+		require.NoError(t, db.Insert("users", newUser("bob@clover.com")))
 
-		require.NoError(t, db.Insert("test", docs...))
+		// the value of a deleted document can be reused
+		require.NoError(t, db.DeleteById("users", alice.ObjectId()))
+		require.NoError(t, db.Insert("users", newUser("alice@clover.com")))
 
-		time.Sleep(time.Second * 2)
+		// expired documents do not take part in the constraint
+		expiring := newUser("carl@clover.com")
+		expiring.SetExpiresAt(time.Now().Add(-time.Second))
+		require.NoError(t, db.Insert("users", expiring))
+		require.NoError(t, db.Insert("users", newUser("carl@clover.com")))
 
-		n, err := db.Count(q.NewQuery("test"))
+		n, err = db.Count(q.NewQuery("users").Where(q.Field("email").Eq("carl@clover.com")))
 		require.NoError(t, err)
+		require.Equal(t, 1, n)
+	})
+}
 
-		require.Equal(t, nInserts, n)
+func TestCreateUniqueIndexWithDuplicates(t *testing.T) {
+	runCloverTest(t, func(t *testing.T, db *c.DB) {
+		require.NoError(t, loadFromJson(db, todosPath, &TodoModel{}))
 
-		time.Sleep(time.Second * 3)
+		require.Equal(t, c.ErrDuplicateKey, db.CreateUniqueIndex("todos", "userId"))
 
-		n, err = db.Count(q.NewQuery("test").Where(q.Field("HasExpiration").Eq(true)))
+		has, err := db.HasIndex("todos", "userId")
 		require.NoError(t, err)
+		require.False(t, has)
 
-		require.Equal(t, 0, n)
+		require.NoError(t, db.CreateUniqueIndex("todos", "id"))
 
-		// run an insert with already expired documents
-		expired := make([]*d.Document, 0)
-		for _, doc := range docs {
-			if doc.Get("HasExpiration").(bool) {
-				expired = append(expired, doc)
-			}
-		}
-		require.NoError(t, db.Insert("test", expired...))
+		doc := d.NewDocument()
+		doc.Set("id", 1)
+		require.Equal(t, c.ErrDuplicateKey, db.Insert("todos", doc))
+	})
+}
+
+func TestCompoundIndex(t *testing.T) {
+	runCloverTest(t, func(t *testing.T, db *c.DB) {
+		require.NoError(t, loadFromJson(db, todosPath, &TodoModel{}))
+
+		require.Error(t, db.CreateCompoundIndex("todos", q.SortOption{Field: "userId"}))
+
+		queries := []*q.Query{
+			q.NewQuery("todos").Where(q.Field("userId").Eq(3).And(q.Field("id").Gt(45))).Sort(q.SortOption{Field: "id", Direction: -1}),
+			q.NewQuery("todos").Where(q.Field("userId").Eq(3).And(q.Field("id").Gt(45))).Sort(q.SortOption{Field: "id", Direction: 1}),
+			q.NewQuery("todos").Where(q.Field("userId").Eq(3).And(q.Field("id").LtEq(50))).Sort(q.SortOption{Field: "id", Direction: -1}).Limit(5),
+			q.NewQuery("todos").Where(q.Field("userId").Eq(3)).Sort(q.SortOption{Field: "id", Direction: -1}),
+			q.NewQuery("todos").Where(q.Field("userId").Eq(3).And(q.Field("id").Eq(50))),
+			q.NewQuery("todos").Where(q.Field("userId").GtEq(3).And(q.Field("userId").Lt(5))).Sort(q.SortOption{Field: "userId"}, q.SortOption{Field: "id"}),
+			q.NewQuery("todos").Where(q.Field("userId").Gt(3).And(q.Field("userId").LtEq(5))).Sort(q.SortOption{Field: "userId", Direction: -1}, q.SortOption{Field: "id", Direction: -1}),
+			q.NewQuery("todos").Sort(q.SortOption{Field: "userId", Direction: -1}, q.SortOption{Field: "id", Direction: -1}).Skip(10).Limit(30),
+			q.NewQuery("todos").Where(q.Field("userId").Eq(3).Or(q.Field("id").Lt(10))).Sort(q.SortOption{Field: "id"}),
+			q.NewQuery("todos").Where(q.Field("completed").IsTrue().And(q.Field("userId").Eq(2))).Sort(q.SortOption{Field: "id"}),
+		}
+
+		expected := make([][]*d.Document, 0, len(queries))
+		for _, query := range queries {
+			docs, err := db.FindAll(query)
+			require.NoError(t, err)
+			expected = append(expected, docs)
+		}
+
+		indexes := [][]q.SortOption{
+			{{Field: "userId", Direction: 1}, {Field: "id", Direction: 1}},
+			{{Field: "userId", Direction: 1}, {Field: "id", Direction: -1}},
+			{{Field: "userId", Direction: -1}, {Field: "id", Direction: -1}},
+			{{Field: "userId", Direction: -1}, {Field: "completed", Direction: 1}, {Field: "id", Direction: 1}},
+		}
+
+		for _, fields := range indexes {
+			require.NoError(t, db.CreateCompoundIndex("todos", fields...))
+			require.Equal(t, c.ErrIndexExist, db.CreateCompoundIndex("todos", fields...))
+
+			for i, query := range queries {
+				docs, err := db.FindAll(query)
+				require.NoError(t, err)
+				require.Equal(t, expected[i], docs)
+			}
+			require.NoError(t, db.DropIndex("todos", index.CompoundIndexName(fields)))
+		}
+	})
+}
+
+func TestCompoundIndexUpdate(t *testing.T) {
+	runCloverTest(t, func(t *testing.T, db *c.DB) {
+		require.NoError(t, loadFromJson(db, todosPath, &TodoModel{}))
+		require.NoError(t, db.CreateIndex("todos", "userId"))
+		require.NoError(t, db.CreateCompoundIndex("todos", q.SortOption{Field: "userId"}, q.SortOption{Field: "id", Direction: -1}))
+
+		has, err := db.HasIndex("todos", "userId_1_id_-1")
+		require.NoError(t, err)
+		require.True(t, has)
+
+		indexes, err := db.ListIndexes("todos")
+		require.NoError(t, err)
+		require.Equal(t, []index.Info{
+			{Field: "userId", Type: index.SingleField},
+			{Field: "userId_1_id_-1", Type: index.Compound, Fields: []q.SortOption{{Field: "userId", Direction: 1}, {Field: "id", Direction: -1}}},
+		}, indexes)
+
+		criteria := q.Field("userId").Eq(1).And(q.Field("id").Lt(5))
+		_, err = db.Update(q.NewQuery("todos").Where(criteria), map[string]interface{}{"userId": 100})
+		require.NoError(t, err)
+
+		docs, err := db.FindAll(q.NewQuery("todos").Where(q.Field("userId").Eq(100).And(q.Field("id").Gt(0))).Sort(q.SortOption{Field: "id", Direction: -1}))
+		require.NoError(t, err)
+		require.Len(t, docs, 4)
+		require.Equal(t, uint64(4), docs[0].Get("id"))
+
+		_, err = db.Delete(q.NewQuery("todos").Where(q.Field("userId").Eq(100)))
+		require.NoError(t, err)
+
+		n, err := db.Count(q.NewQuery("todos").Where(q.Field("userId").Eq(100).And(q.Field("id").Gt(0))))
+		require.NoError(t, err)
+		require.Zero(t, n)
+
+		// dropping the single field index must not affect the compound index sharing the same prefix
+		require.NoError(t, db.DropIndex("todos", "userId"))
+
+		n, err = db.Count(q.NewQuery("todos").Where(q.Field("userId").Eq(1).And(q.Field("id").Gt(0))))
+		require.NoError(t, err)
+		require.Equal(t, 16, n)
+	})
+}
+
+func TestFullTextIndex(t *testing.T) {
+	runCloverTest(t, func(t *testing.T, db *c.DB) {
+		require.NoError(t, loadFromJson(db, todosPath, &TodoModel{}))
+
+		queries := []*q.Query{
+			q.NewQuery("todos").Where(q.Field("title").Search("Quis")).Sort(q.SortOption{Field: "id"}),
+			q.NewQuery("todos").Where(q.Field("title").Search("et QUI")).Sort(q.SortOption{Field: "id"}),
+			q.NewQuery("todos").Where(q.Field("title").Search("et").And(q.Field("completed").IsTrue())).Sort(q.SortOption{Field: "id"}),
+			q.NewQuery("todos").Where(q.Field("title").Search("et").Or(q.Field("userId").Eq(1))).Sort(q.SortOption{Field: "id"}),
+			q.NewQuery("todos").Where(q.Field("title").Search("et").Not()).Sort(q.SortOption{Field: "id"}),
+			q.NewQuery("todos").Where(q.Field("title").Search("unknown")),
+		}
+
+		expected := make([][]*d.Document, 0, len(queries))
+		for _, query := range queries {
+			docs, err := db.FindAll(query)
+			require.NoError(t, err)
+			expected = append(expected, docs)
+		}
+		require.NotEmpty(t, expected[1])
+		require.Empty(t, expected[5])
+
+		require.NoError(t, db.CreateFullTextIndex("todos", "title"))
+		require.Equal(t, c.ErrIndexExist, db.CreateFullTextIndex("todos", "title"))
+
+		for i, query := range queries {
+			docs, err := db.FindAll(query)
+			require.NoError(t, err)
+			require.Equal(t, expected[i], docs)
+		}
+
+		plan, err := db.Explain(q.NewQuery("todos").Where(q.Field("title").Search("et QUI")).Sort(q.SortOption{Field: q.ScoreField, Direction: -1}))
+		require.NoError(t, err)
+		require.True(t, plan.IndexSorted)
+		require.Equal(t, c.FullTextScan, plan.Nodes[0].Index.Type)
+		require.Equal(t, "title_text", plan.Nodes[0].Index.Index)
+		require.Contains(t, plan.String(), "terms=[et qui]")
+
+		issues, err := db.CheckIntegrity()
+		require.NoError(t, err)
+		require.Empty(t, issues)
+
+		require.NoError(t, db.DropIndex("todos", index.FullTextIndexName("title")))
+	})
+}
+
+func TestFullTextRanking(t *testing.T) {
+	runCloverTest(t, func(t *testing.T, db *c.DB) {
+		require.NoError(t, db.CreateCollection("articles"))
+		require.NoError(t, db.CreateFullTextIndex("articles", "title"))
+
+		titles := []string{
+			"The quick brown fox",
+			"Fox, foxes and more foxes",
+			"The lazy dog",
+			"Quick foxes are quicker than the other animals living in the very same forest",
+		}
+
+		ids := make([]string, 0, len(titles))
+		for i, title := range titles {
+			id, err := db.InsertOne("articles", d.NewDocumentOf(map[string]interface{}{"n": i, "title": title}))
+			require.NoError(t, err)
+			ids = append(ids, id)
+		}
+
+		byScore := q.SortOption{Field: q.ScoreField, Direction: -1}
+
+		findTitles := func(query *q.Query) []string {
+			docs, err := db.FindAll(query)
+			require.NoError(t, err)
+
+			res := make([]string, 0, len(docs))
+			for _, doc := range docs {
+				res = append(res, doc.Get("title").(string))
+			}
+			return res
+		}
+
+		require.Equal(t, []string{titles[1], titles[0], titles[3]}, findTitles(q.NewQuery("articles").Where(q.Field("title").Search("fox")).Sort(byScore)))
+		require.Equal(t, []string{titles[3], titles[0], titles[1]}, findTitles(q.NewQuery("articles").Where(q.Field("title").Search("fox")).Sort(q.SortOption{Field: q.ScoreField, Direction: 1})))
+		require.Equal(t, []string{titles[0], titles[3]}, findTitles(q.NewQuery("articles").Where(q.Field("title").Search("quick FOX")).Sort(byScore)))
+		require.Equal(t, []string{titles[0]}, findTitles(q.NewQuery("articles").Where(q.Field("title").Search("fox")).Sort(byScore).Skip(1).Limit(1)))
+		require.Equal(t, []string{titles[0], titles[3]}, findTitles(q.NewQuery("articles").Where(q.Field("title").Search("fox").And(q.Field("n").Neq(1))).Sort(byScore, q.SortOption{Field: "n"})))
+
+		_, err := db.FindAfter(q.NewQuery("articles").Where(q.Field("title").Search("fox")).Sort(byScore), "")
+		require.Error(t, err)
+
+		require.NoError(t, db.UpdateById("articles", ids[1], func(doc *d.Document) *d.Document {
+			doc.Set("title", "A dog")
+			return doc
+		}))
+		require.NoError(t, db.DeleteById("articles", ids[0]))
+
+		require.Equal(t, []string{titles[3]}, findTitles(q.NewQuery("articles").Where(q.Field("title").Search("fox")).Sort(byScore)))
+		require.Equal(t, []string{"A dog", titles[2]}, findTitles(q.NewQuery("articles").Where(q.Field("title").Search("dogs")).Sort(byScore)))
+
+		issues, err := db.CheckIntegrity()
+		require.NoError(t, err)
+		require.Empty(t, issues)
+	})
+}
+
+func TestGeoIndex(t *testing.T) {
+	runCloverTest(t, func(t *testing.T, db *c.DB) {
+		require.NoError(t, loadFromJson(db, earthquakes, nil))
+
+		byDistance := q.SortOption{Field: q.DistanceField}
+		california := q.Field("geometry").Near(-117, 35, 400000)
+
+		queries := []*q.Query{
+			q.NewQuery("earthquakes").Where(california).Sort(byDistance),
+			q.NewQuery("earthquakes").Where(california.And(q.Field("properties.mag").Gt(0.5))).Sort(byDistance),
+			q.NewQuery("earthquakes").Where(california.Not()).Sort(q.SortOption{Field: "id"}),
+			q.NewQuery("earthquakes").Where(q.Field("geometry").WithinBox(-160, 55, -140, 70)).Sort(q.SortOption{Field: "id"}),
+			q.NewQuery("earthquakes").Where(q.Field("geometry").WithinBox(120, -10, -150, 70)).Sort(q.SortOption{Field: "id"}),
+			q.NewQuery("earthquakes").Where(q.Field("geometry").WithinPolygon([2]float64{-120, 32}, [2]float64{-114, 32}, [2]float64{-120, 40})).Sort(q.SortOption{Field: "id"}),
+			q.NewQuery("earthquakes").Where(q.Field("geometry").Near(-150, 64, 1e9)).Sort(byDistance, q.SortOption{Field: "id"}),
+		}
+
+		expected := make([][]*d.Document, 0, len(queries))
+		for _, query := range queries {
+			docs, err := db.FindAll(query)
+			require.NoError(t, err)
+			expected = append(expected, docs)
+		}
+
+		ids := func(docs []*d.Document) []string {
+			res := make([]string, 0, len(docs))
+			for _, doc := range docs {
+				res = append(res, doc.Get("id").(string))
+			}
+			return res
+		}
+
+		require.Equal(t, []string{"ci40252680", "ci40252688", "nn00838379"}, ids(expected[0]))
+		require.Equal(t, []string{"ci40252680", "nn00838379"}, ids(expected[1]))
+		require.Equal(t, []string{"ak0225qv6c3y", "ak0225qv6iue", "ak0225qv8ko3"}, ids(expected[3]))
+		require.Equal(t, []string{"ak0225qv6c3y", "ak0225qv6iue", "hv73003457", "us7000h781"}, ids(expected[4]))
+		require.Equal(t, []string{"ci40252680", "ci40252688"}, ids(expected[5]))
+		require.Len(t, expected[6], 8)
+		require.Equal(t, "us7000h781", expected[6][7].Get("id"))
+
+		require.NoError(t, db.CreateGeoIndex("earthquakes", "geometry"))
+		require.Equal(t, c.ErrIndexExist, db.CreateGeoIndex("earthquakes", "geometry"))
+
+		for i, query := range queries {
+			docs, err := db.FindAll(query)
+			require.NoError(t, err)
+			require.Equal(t, expected[i], docs)
+		}
+
+		plan, err := db.Explain(queries[0])
+		require.NoError(t, err)
+		require.True(t, plan.IndexSorted)
+		require.Equal(t, c.GeoScan, plan.Nodes[0].Index.Type)
+		require.Equal(t, "geometry_geo", plan.Nodes[0].Index.Index)
+		require.Contains(t, plan.String(), "region=near(-117, 35, 400000m)")
+
+		plan, err = db.ExplainAnalyze(queries[3])
+		require.NoError(t, err)
+		require.Equal(t, 3, plan.Nodes[0].DocsExamined)
+
+		_, err = db.FindAfter(queries[0], "")
+		require.Error(t, err)
+
+		// documents whose field is not a point are indexed, but never selected
+		_, err = db.InsertOne("earthquakes", d.NewDocumentOf(map[string]interface{}{"id": "unknown", "geometry": "somewhere"}))
+		require.NoError(t, err)
+
+		_, err = db.Update(q.NewQuery("earthquakes").Where(q.Field("id").Eq("ci40252680")), map[string]interface{}{"geometry": []interface{}{-70.0, 40.0}})
+		require.NoError(t, err)
+
+		docs, err := db.FindAll(queries[0])
+		require.NoError(t, err)
+		require.Equal(t, []string{"ci40252688", "nn00838379"}, ids(docs))
+
+		docs, err = db.FindAll(q.NewQuery("earthquakes").Where(q.Field("geometry").Near(-70, 40, 1000)))
+		require.NoError(t, err)
+		require.Equal(t, []string{"ci40252680"}, ids(docs))
+
+		issues, err := db.CheckIntegrity()
+		require.NoError(t, err)
+		require.Empty(t, issues)
+
+		require.NoError(t, db.DropIndex("earthquakes", index.GeoIndexName("geometry")))
+	})
+}
+
+func TestMultiIndexQuery(t *testing.T) {
+	runCloverTest(t, func(t *testing.T, db *c.DB) {
+		require.NoError(t, loadFromJson(db, todosPath, &TodoModel{}))
+
+		criterias := []q.Criteria{
+			q.Field("userId").Eq(3).Or(q.Field("completed").IsTrue()),
+			q.Field("userId").Eq(3).And(q.Field("completed").IsTrue()),
+			q.Field("userId").Gt(2).And(q.Field("userId").Lt(5)).And(q.Field("id").GtEq(40)),
+			q.Field("userId").Gt(5).Or(q.Field("userId").Gt(8)).Or(q.Field("id").Lt(10)),
+			q.Field("userId").In(1, 4, 7).And(q.Field("completed").IsFalse()),
+			q.Field("userId").Eq(1).Or(q.Field("userId").Eq(2)).And(q.Field("id").Lt(30).Or(q.Field("completed").IsTrue())),
+			q.Field("userId").Eq(1).Or(q.Field("title").Like("^qui")),
+			q.Field("userId").Eq(1).And(q.Field("id").Gt(100)),
+		}
+
+		expected := make([][]*d.Document, 0, len(criterias))
+		for _, criteria := range criterias {
+			docs, err := db.FindAll(q.NewQuery("todos").Where(criteria).Sort())
+			require.NoError(t, err)
+			expected = append(expected, docs)
+		}
+
+		require.NoError(t, db.CreateIndex("todos", "userId"))
+		require.NoError(t, db.CreateIndex("todos", "completed"))
+		require.NoError(t, db.CreateIndex("todos", "id"))
+
+		for i, criteria := range criterias {
+			docs, err := db.FindAll(q.NewQuery("todos").Where(criteria).Sort())
+			require.NoError(t, err)
+			require.Equal(t, expected[i], docs)
+
+			n, err := db.Count(q.NewQuery("todos").Where(criteria))
+			require.NoError(t, err)
+			require.Equal(t, len(expected[i]), n)
+
+			n, err = db.Count(q.NewQuery("todos").Where(criteria).Limit(3))
+			require.NoError(t, err)
+			if len(expected[i]) < 3 {
+				require.Equal(t, len(expected[i]), n)
+			} else {
+				require.Equal(t, 3, n)
+			}
+		}
+	})
+}
+
+func TestIndexReverseInclusiveRange(t *testing.T) {
+	runCloverTest(t, func(t *testing.T, db *c.DB) {
+		require.NoError(t, loadFromJson(db, todosPath, &TodoModel{}))
+
+		query := q.NewQuery("todos").Where(q.Field("id").GtEq(10).And(q.Field("id").LtEq(20))).Sort(q.SortOption{Field: "id", Direction: -1})
+		docs, err := db.FindAll(query)
+		require.NoError(t, err)
+		require.Len(t, docs, 11)
+
+		require.NoError(t, db.CreateIndex("todos", "id"))
+
+		indexDocs, err := db.FindAll(query)
+		require.NoError(t, err)
+		require.Equal(t, docs, indexDocs)
+	})
+}
+
+func TestExplain(t *testing.T) {
+	runCloverTest(t, func(t *testing.T, db *c.DB) {
+		require.NoError(t, loadFromJson(db, todosPath, &TodoModel{}))
+
+		query := q.NewQuery("todos").Where(q.Field("userId").Gt(2).And(q.Field("userId").Lt(5))).Sort(q.SortOption{Field: "userId", Direction: -1}).Limit(5)
+
+		plan, err := db.Explain(query)
+		require.NoError(t, err)
+		require.False(t, plan.Analyzed)
+		require.False(t, plan.IndexSorted)
+		require.Len(t, plan.Nodes, 4)
+		require.Equal(t, c.CollectionScanNode, plan.Nodes[0].Type)
+		require.Equal(t, c.SortNode, plan.Nodes[1].Type)
+		require.Equal(t, c.SkipLimitNode, plan.Nodes[2].Type)
+		require.Equal(t, 5, plan.Nodes[2].Limit)
+		require.Equal(t, c.OutputNode, plan.Nodes[3].Type)
+
+		require.NoError(t, db.CreateIndex("todos", "userId"))
+
+		plan, err = db.Explain(query)
+		require.NoError(t, err)
+		require.True(t, plan.IndexSorted)
+		require.Len(t, plan.Nodes, 3)
+		require.Equal(t, c.IndexScanNode, plan.Nodes[0].Type)
+
+		scan := plan.Nodes[0].Index
+		require.Equal(t, c.RangeScan, scan.Type)
+		require.Equal(t, "userId", scan.Index)
+		require.True(t, scan.Reverse)
+		require.Contains(t, plan.String(), "range=(2, 5)")
+
+		require.NoError(t, db.CreateIndex("todos", "completed"))
+
+		plan, err = db.Explain(q.NewQuery("todos").Where(q.Field("userId").Eq(1).Or(q.Field("completed").IsTrue())))
+		require.NoError(t, err)
+		require.Equal(t, c.UnionScan, plan.Nodes[0].Index.Type)
+		require.Len(t, plan.Nodes[0].Index.Children, 2)
+
+		_, err = db.Explain(q.NewQuery("unknown"))
+		require.Equal(t, c.ErrCollectionNotExist, err)
+	})
+}
+
+func TestExplainAnalyze(t *testing.T) {
+	runCloverTest(t, func(t *testing.T, db *c.DB) {
+		require.NoError(t, loadFromJson(db, todosPath, &TodoModel{}))
+
+		criteria := q.Field("userId").Eq(3).And(q.Field("completed").IsTrue())
+
+		n, err := db.Count(q.NewQuery("todos").Where(criteria))
+		require.NoError(t, err)
+
+		plan, err := db.ExplainAnalyze(q.NewQuery("todos").Where(criteria))
+		require.NoError(t, err)
+		require.True(t, plan.Analyzed)
+		require.Equal(t, c.CollectionScanNode, plan.Nodes[0].Type)
+		require.Equal(t, 200, plan.Nodes[0].DocsExamined)
+		require.Equal(t, n, plan.Nodes[0].DocsReturned)
+		require.Equal(t, c.OutputNode, plan.Nodes[len(plan.Nodes)-1].Type)
+		require.Equal(t, n, plan.Nodes[len(plan.Nodes)-1].DocsReturned)
+
+		require.NoError(t, db.CreateIndex("todos", "userId"))
+
+		plan, err = db.ExplainAnalyze(q.NewQuery("todos").Where(criteria))
+		require.NoError(t, err)
+		require.Equal(t, c.IndexScanNode, plan.Nodes[0].Type)
+		require.Equal(t, 20, plan.Nodes[0].Index.KeysScanned)
+		require.Equal(t, 20, plan.Nodes[0].DocsExamined)
+		require.Equal(t, n, plan.Nodes[0].DocsReturned)
+
+		plan, err = db.ExplainAnalyze(q.NewQuery("todos").Where(q.Field("userId").Eq(3)).Skip(2).Limit(5))
+		require.NoError(t, err)
+		require.Len(t, plan.Nodes, 3)
+		require.Equal(t, 5, plan.Nodes[1].DocsReturned)
+		require.Less(t, plan.Nodes[0].DocsReturned, 20)
+		require.Equal(t, 5, plan.Nodes[2].DocsReturned)
+		lines := strings.Split(strings.TrimSpace(plan.String()), "\n")
+		require.Contains(t, lines[len(lines)-2], "Output (returned=5")
+
+		var total time.Duration
+		for _, nd := range plan.Nodes {
+			require.GreaterOrEqual(t, nd.Elapsed, time.Duration(0))
+			total += nd.Elapsed
+		}
+		require.LessOrEqual(t, total, plan.Elapsed)
+	})
+}
+
+func TestRunInTransaction(t *testing.T) {
+	runCloverTest(t, func(t *testing.T, db *c.DB) {
+		require.NoError(t, db.CreateCollection("orders"))
+		require.NoError(t, db.CreateCollection("inventory"))
+		require.NoError(t, db.CreateUniqueIndex("orders", "code"))
+
+		item := d.NewDocument()
+		item.Set("name", "pen")
+		item.Set("quantity", 10)
+		itemId, err := db.InsertOne("inventory", item)
+		require.NoError(t, err)
+
+		err = db.RunInTransaction(func(tx *c.Tx) error {
+			order := d.NewDocument()
+			order.Set("code", "A1")
+			order.Set("item", itemId)
+			if err := tx.Insert("orders", order); err != nil {
+				return err
+			}
+
+			if _, err := tx.Update(q.NewQuery("inventory").Where(q.Field("_id").Eq(itemId)), map[string]interface{}{"quantity": 9}); err != nil {
+				return err
+			}
+
+			// the transaction reads its own writes
+			n, err := tx.Count(q.NewQuery("orders").Where(q.Field("code").Eq("A1")))
+			require.NoError(t, err)
+			require.Equal(t, 1, n)
+
+			doc, err := tx.FindById("inventory", itemId)
+			require.NoError(t, err)
+			require.Equal(t, int64(9), doc.Get("quantity"))
+
+			// documents inserted within the transaction are subject to unique indexes
+			duplicate := d.NewDocument()
+			duplicate.Set("code", "A1")
+			require.ErrorIs(t, tx.Insert("orders", duplicate), c.ErrDuplicateKey)
+			return nil
+		})
+		require.NoError(t, err)
+
+		n, err := db.Count(q.NewQuery("orders"))
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+
+		doc, err := db.FindById("inventory", itemId)
+		require.NoError(t, err)
+		require.Equal(t, int64(9), doc.Get("quantity"))
+	})
+}
+
+func TestTransactionRollback(t *testing.T) {
+	runCloverTest(t, func(t *testing.T, db *c.DB) {
+		require.NoError(t, db.CreateCollection("orders"))
+		require.NoError(t, db.CreateIndex("orders", "code"))
+
+		errAbort := errors.New("abort")
+		err := db.RunInTransaction(func(tx *c.Tx) error {
+			require.NoError(t, tx.CreateCollection("inventory"))
+
+			order := d.NewDocument()
+			order.Set("code", "A1")
+			require.NoError(t, tx.Insert("orders", order))
+			require.NoError(t, tx.Insert("inventory", d.NewDocument()))
+			return errAbort
+		})
+		require.ErrorIs(t, err, errAbort)
+
+		has, err := db.HasCollection("inventory")
+		require.NoError(t, err)
+		require.False(t, has)
+
+		n, err := db.Count(q.NewQuery("orders"))
+		require.NoError(t, err)
+		require.Equal(t, 0, n)
+
+		n, err = db.Count(q.NewQuery("orders").Where(q.Field("code").Eq("A1")))
+		require.NoError(t, err)
+		require.Equal(t, 0, n)
+
+		tx, err := db.Begin()
+		require.NoError(t, err)
+		require.NoError(t, tx.Insert("orders", d.NewDocument()))
+		require.NoError(t, tx.Rollback())
+
+		require.ErrorIs(t, tx.Insert("orders", d.NewDocument()), c.ErrTxDone)
+		require.ErrorIs(t, tx.Commit(), c.ErrTxDone)
+		require.NoError(t, tx.Rollback())
+
+		tx, err = db.Begin()
+		require.NoError(t, err)
+		require.NoError(t, tx.Insert("orders", d.NewDocument()))
+		require.NoError(t, tx.Commit())
+
+		n, err = db.Count(q.NewQuery("orders"))
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+	})
+}
+
+func TestTransactionAbort(t *testing.T) {
+	nameSchema, err := schema.Parse([]byte(`{"type": "object", "required": ["name"]}`))
+	require.NoError(t, err)
+
+	runCloverTest(t, func(t *testing.T, db *c.DB) {
+		require.NoError(t, db.CreateCollection("orders"))
+		require.NoError(t, db.CreateUniqueIndex("orders", "code"))
+		require.NoError(t, db.CreateCollectionWithOptions("people", c.CollectionOptions{Schema: nameSchema}))
+		require.NoError(t, db.CreateIndex("people", "name"))
+
+		newOrder := func(code string) *d.Document {
+			doc := d.NewDocument()
+			doc.Set("code", code)
+			return doc
+		}
+
+		// the first document is written before the duplicate is detected
+		tx, err := db.Begin()
+		require.NoError(t, err)
+		require.ErrorIs(t, tx.Insert("orders", newOrder("A1"), newOrder("A1")), c.ErrDuplicateKey)
+		require.ErrorIs(t, tx.Insert("orders", newOrder("B1")), c.ErrTxAborted)
+		require.ErrorIs(t, tx.Commit(), c.ErrTxAborted)
+		require.ErrorIs(t, tx.Commit(), c.ErrTxDone)
+
+		err = db.RunInTransaction(func(tx *c.Tx) error {
+			alice := d.NewDocument()
+			alice.Set("name", "alice")
+			alice.SetExpiresAt(time.Now().Add(time.Hour))
+			return tx.Insert("people", alice, d.NewDocument())
+		})
+		var validationErr *schema.ValidationError
+		require.ErrorAs(t, err, &validationErr)
+
+		// the error of the failed operation is ignored, but the transaction cannot be committed
+		err = db.RunInTransaction(func(tx *c.Tx) error {
+			require.NoError(t, tx.Insert("orders", newOrder("C1")))
+			require.ErrorIs(t, tx.Insert("orders", newOrder("C2"), newOrder("C1")), c.ErrDuplicateKey)
+			return nil
+		})
+		require.ErrorIs(t, err, c.ErrTxAborted)
+
+		// a failure which leaves no partial effect does not abort the transaction
+		err = db.RunInTransaction(func(tx *c.Tx) error {
+			require.NoError(t, tx.Insert("orders", newOrder("D1")))
+			require.ErrorIs(t, tx.Insert("orders", newOrder("D1")), c.ErrDuplicateKey)
+			return tx.Insert("orders", newOrder("D2"))
+		})
+		require.NoError(t, err)
+
+		for _, collection := range []string{"orders", "people"} {
+			n, err := db.Count(q.NewQuery(collection))
+			require.NoError(t, err)
+
+			docs, err := db.FindAll(q.NewQuery(collection))
+			require.NoError(t, err)
+			require.Len(t, docs, n)
+		}
+
+		docs, err := db.FindAll(q.NewQuery("orders").Sort(q.SortOption{Field: "code", Direction: 1}))
+		require.NoError(t, err)
+		require.Len(t, docs, 2)
+		require.Equal(t, "D1", docs[0].Get("code"))
+		require.Equal(t, "D2", docs[1].Get("code"))
+
+		n, err := db.Count(q.NewQuery("orders").Where(q.Field("code").Eq("A1")))
+		require.NoError(t, err)
+		require.Equal(t, 0, n)
+
+		n, err = db.Count(q.NewQuery("people").Where(q.Field("name").Eq("alice")))
+		require.NoError(t, err)
+		require.Equal(t, 0, n)
+	})
+}
+
+func TestAggregate(t *testing.T) {
+	runCloverTest(t, func(t *testing.T, db *c.DB) {
+		require.NoError(t, loadFromJson(db, todosPath, &TodoModel{}))
+
+		docs, err := db.FindAll(q.NewQuery("todos"))
+		require.NoError(t, err)
+
+		type groupStats struct {
+			count    int
+			sum      int64
+			min, max interface{}
+		}
+
+		expected := make(map[int64]*groupStats)
+		for _, doc := range docs {
+			userId := util.ToInt64(doc.Get("userId"))
+			stats := expected[userId]
+			if stats == nil {
+				stats = &groupStats{min: doc.Get("title"), max: doc.Get("title")}
+				expected[userId] = stats
+			}
+
+			stats.count++
+			stats.sum += util.ToInt64(doc.Get("id"))
+			if doc.Get("title").(string) < stats.min.(string) {
+				stats.min = doc.Get("title")
+			}
+			if doc.Get("title").(string) > stats.max.(string) {
+				stats.max = doc.Get("title")
+			}
+		}
+
+		agg := q.GroupBy("userId").Count().Sum("id").Avg("id").Min("title").Max("title").As("lastTitle")
+		results, err := db.Aggregate(q.NewQuery("todos"), agg)
+		require.NoError(t, err)
+		require.Len(t, results, len(expected))
+
+		for i, res := range results {
+			userId := util.ToInt64(res.Get("userId"))
+			if i > 0 {
+				require.Greater(t, userId, util.ToInt64(results[i-1].Get("userId")))
+			}
+
+			stats := expected[userId]
+			require.Equal(t, int64(stats.count), res.Get("count"))
+			require.Equal(t, stats.sum, res.Get("sum_id"))
+			require.Equal(t, float64(stats.sum)/float64(stats.count), res.Get("avg_id"))
+			require.Equal(t, stats.min, res.Get("min_title"))
+			require.Equal(t, stats.max, res.Get("lastTitle"))
+		}
+
+		results, err = db.Aggregate(q.NewQuery("todos").Where(q.Field("completed").IsTrue()).Sort(q.SortOption{Field: "count", Direction: -1}).Limit(3), q.GroupBy("userId").Count())
+		require.NoError(t, err)
+		require.Len(t, results, 3)
+
+		for _, res := range results {
+			n, err := db.Count(q.NewQuery("todos").Where(q.Field("completed").IsTrue().And(q.Field("userId").Eq(res.Get("userId")))))
+			require.NoError(t, err)
+			require.Equal(t, int64(n), res.Get("count"))
+		}
+		require.GreaterOrEqual(t, results[0].Get("count"), results[1].Get("count"))
+		require.GreaterOrEqual(t, results[1].Get("count"), results[2].Get("count"))
+	})
+}
+
+func TestAggregateNestedAndMixedFields(t *testing.T) {
+	runCloverTest(t, func(t *testing.T, db *c.DB) {
+		require.NoError(t, db.CreateCollection("quakes"))
+
+		values := []map[string]interface{}{
+			{"place": map[string]interface{}{"country": "IT"}, "mag": 2.5, "depth": 10},
+			{"place": map[string]interface{}{"country": "IT"}, "mag": 4, "depth": "unknown"},
+			{"place": map[string]interface{}{"country": "JP"}, "mag": 6.5},
+			{"mag": 1},
+		}
+
+		for _, value := range values {
+			doc := d.NewDocument()
+			doc.SetAll(value)
+			require.NoError(t, db.Insert("quakes", doc))
+		}
+
+		results, err := db.Aggregate(q.NewQuery("quakes"), q.GroupBy("place.country").Avg("mag").Sum("depth").Max("depth").Min("depth"))
+		require.NoError(t, err)
+		require.Len(t, results, 3)
+
+		// missing values are grouped together and sorted before any other value
+		require.Nil(t, results[0].Get("place.country"))
+		require.True(t, results[0].Has("place.country"))
+		require.Equal(t, float64(1), results[0].Get("avg_mag"))
+		require.Equal(t, int64(0), results[0].Get("sum_depth"))
+		require.Nil(t, results[0].Get("max_depth"))
+
+		require.Equal(t, "IT", results[1].Get("place.country"))
+		require.Equal(t, 3.25, results[1].Get("avg_mag"))
+		require.Equal(t, int64(10), results[1].Get("sum_depth"))
+		require.Equal(t, int64(10), results[1].Get("min_depth"))
+		require.Equal(t, "unknown", results[1].Get("max_depth")) // strings are sorted after numbers
+
+		require.Equal(t, "JP", results[2].Get("place.country"))
+		require.Equal(t, 6.5, results[2].Get("avg_mag"))
+
+		results, err = db.Aggregate(q.NewQuery("quakes").Where(q.Field("mag").Gt(2)), q.GroupBy().Count().Max("mag"))
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		require.Equal(t, int64(3), results[0].Get("count"))
+		require.Equal(t, 6.5, results[0].Get("max_mag"))
+
+		_, err = db.Aggregate(q.NewQuery("unknown"), q.GroupBy().Count())
+		require.Equal(t, c.ErrCollectionNotExist, err)
+	})
+}
+
+func TestSelectAndExclude(t *testing.T) {
+	runCloverTest(t, func(t *testing.T, db *c.DB) {
+		require.NoError(t, db.CreateCollection("items"))
+
+		doc := d.NewDocument()
+		doc.Set("name", "pen")
+		doc.Set("price.amount", 10)
+		doc.Set("price.currency", "EUR")
+		doc.Set("description", "a long description")
+		id, err := db.InsertOne("items", doc)
+		require.NoError(t, err)
+
+		selected, err := db.FindFirst(q.NewQuery("items").Select("name", "price.amount", "missing"))
+		require.NoError(t, err)
+		require.Equal(t, map[string]interface{}{
+			"_id":   id,
+			"name":  "pen",
+			"price": map[string]interface{}{"amount": int64(10)},
+		}, selected.ToMap())
+
+		excluded, err := db.FindFirst(q.NewQuery("items").Exclude("description", "price.currency", "_id"))
+		require.NoError(t, err)
+		require.Equal(t, map[string]interface{}{
+			"name":  "pen",
+			"price": map[string]interface{}{"amount": int64(10)},
+		}, excluded.ToMap())
+
+		selected, err = db.FindFirst(q.NewQuery("items").Select("name").Exclude("_id"))
+		require.NoError(t, err)
+		require.Equal(t, map[string]interface{}{"name": "pen"}, selected.ToMap())
+
+		// projections do not affect updates
+		_, err = db.Update(q.NewQuery("items").Select("name"), map[string]interface{}{"name": "pencil"})
+		require.NoError(t, err)
+
+		doc, err = db.FindById("items", id)
+		require.NoError(t, err)
+		require.Equal(t, "pencil", doc.Get("name"))
+		require.Equal(t, "a long description", doc.Get("description"))
+	})
+}
+
+func TestIndexOnlyProjection(t *testing.T) {
+	runCloverTest(t, func(t *testing.T, db *c.DB) {
+		require.NoError(t, loadFromJson(db, todosPath, &TodoModel{}))
+
+		nullDoc := d.NewDocument()
+		nullDoc.Set("title", nil)
+		nullDoc.Set("userId", 3)
+		require.NoError(t, db.Insert("todos", nullDoc))
+
+		queries := []*q.Query{
+			q.NewQuery("todos").Where(q.Field("userId").Eq(3)).Select("title"),
+			q.NewQuery("todos").Select("title").Sort(q.SortOption{Field: "title", Direction: -1}),
+			q.NewQuery("todos").Where(q.Field("userId").Gt(8)).Select("userId", "title").Sort(q.SortOption{Field: "userId"}, q.SortOption{Field: "title"}),
+		}
+
+		expected := make([][]*d.Document, 0, len(queries))
+		for _, query := range queries {
+			docs, err := db.FindAll(query.Sort())
+			require.NoError(t, err)
+			expected = append(expected, docs)
+		}
+
+		require.NoError(t, db.CreateIndex("todos", "title"))
+		require.NoError(t, db.CreateCompoundIndex("todos", q.SortOption{Field: "userId", Direction: 1}, q.SortOption{Field: "title", Direction: 1}))
+
+		for i, query := range queries {
+			plan, err := db.ExplainAnalyze(query)
+			require.NoError(t, err)
+			require.True(t, plan.Nodes[0].IndexOnly)
+			require.LessOrEqual(t, plan.Nodes[0].DocsExamined, 1) // only the document with a null title is read
+
+			docs, err := db.FindAll(query.Sort())
+			require.NoError(t, err)
+			require.Equal(t, expected[i], docs)
+		}
+
+		expiredDoc := d.NewDocument()
+		expiredDoc.Set("title", "expired")
+		expiredDoc.SetExpiresAt(time.Now().Add(-time.Second))
+		require.NoError(t, db.Insert("todos", expiredDoc))
+
+		docs, err := db.FindAll(q.NewQuery("todos").Where(q.Field("title").Eq("expired")).Select("title"))
+		require.NoError(t, err)
+		require.Empty(t, docs)
+
+		// fields which are not indexed require reading the documents
+		plan, err := db.Explain(q.NewQuery("todos").Where(q.Field("userId").Eq(3)).Select("title", "completed"))
+		require.NoError(t, err)
+		require.False(t, plan.Nodes[0].IndexOnly)
+	})
+}
+
+func readPage(t *testing.T, db *c.DB, query *q.Query, token string) ([]*d.Document, string) {
+	cursor, err := db.FindAfter(query, token)
+	require.NoError(t, err)
+	defer cursor.Close()
+
+	docs := make([]*d.Document, 0)
+	for cursor.Next() {
+		docs = append(docs, cursor.Doc())
+	}
+	require.NoError(t, cursor.Err())
+
+	token, err = cursor.Token()
+	require.NoError(t, err)
+	return docs, token
+}
+
+func TestCursor(t *testing.T) {
+	runCloverTest(t, func(t *testing.T, db *c.DB) {
+		require.NoError(t, db.CreateCollection("items"))
+
+		docs := make([]*d.Document, 0)
+		for i := 0; i < 50; i++ {
+			doc := d.NewDocument()
+			switch i % 5 {
+			case 0: // missing field
+			case 1:
+				doc.Set("rank", nil)
+			default:
+				doc.Set("rank", i%7)
+			}
+			doc.Set("name", fmt.Sprintf("item-%d", i))
+			// strings starting with "$" must not be taken for field references when resuming
+			doc.Set("plan", []string{"$5 plan", "$name", "$rank", "$plan"}[i%4])
+			docs = append(docs, doc)
+		}
+		require.NoError(t, db.Insert("items", docs...))
+
+		cursor, err := db.Find(q.NewQuery("items"))
+		require.NoError(t, err)
+
+		n := 0
+		var lastId string
+		for cursor.Next() {
+			require.Greater(t, cursor.Doc().ObjectId(), lastId)
+			lastId = cursor.Doc().ObjectId()
+			n++
+		}
+		require.NoError(t, cursor.Err())
+		require.Equal(t, 50, n)
+		require.NoError(t, cursor.Close())
+
+		// a cursor can be abandoned before being exhausted
+		cursor, err = db.Find(q.NewQuery("items"))
+		require.NoError(t, err)
+		require.True(t, cursor.Next())
+		require.NoError(t, cursor.Close())
+		require.False(t, cursor.Next())
+
+		require.NoError(t, db.CreateIndex("items", "rank"))
+		require.NoError(t, db.CreateIndex("items", "plan"))
+
+		queries := []*q.Query{
+			q.NewQuery("items"),
+			q.NewQuery("items").Sort(q.SortOption{Field: "rank", Direction: 1}),
+			q.NewQuery("items").Sort(q.SortOption{Field: "rank", Direction: -1}),
+			q.NewQuery("items").Sort(q.SortOption{Field: "name", Direction: -1}),
+			q.NewQuery("items").Where(q.Field("rank").Gt(2)).Sort(q.SortOption{Field: "rank", Direction: 1}),
+			q.NewQuery("items").Sort(q.SortOption{Field: "rank", Direction: 1}).Select("name"),
+			q.NewQuery("items").Where(q.Field("rank").GtEq(2)),
+			q.NewQuery("items").Sort(q.SortOption{Field: "plan", Direction: 1}),
+			q.NewQuery("items").Where(q.Field("rank").Lt(4)).Sort(q.SortOption{Field: "plan", Direction: 1}),
+		}
+
+		for _, query := range queries {
+			all, _ := readPage(t, db, query, "")
+
+			n, err := db.Count(query)
+			require.NoError(t, err)
+			require.Len(t, all, n)
+
+			paged := make([]*d.Document, 0)
+			token := ""
+			for {
+				var page []*d.Document
+				page, token = readPage(t, db, query.Limit(7), token)
+				if len(page) == 0 {
+					break
+				}
+				paged = append(paged, page...)
+			}
+			require.Equal(t, all, paged)
+		}
+
+		_, token := readPage(t, db, q.NewQuery("items").Limit(1), "")
+		_, err = db.FindAfter(q.NewQuery("items").Sort(q.SortOption{Field: "rank"}), token)
+		require.Equal(t, c.ErrInvalidToken, err)
+
+		_, err = db.FindAfter(q.NewQuery("items"), "not a token")
+		require.Equal(t, c.ErrInvalidToken, err)
+
+		// cursors still open are closed with the database
+		cursor, err = db.Find(q.NewQuery("items"))
+		require.NoError(t, err)
+		require.True(t, cursor.Next())
+	})
+}
+
+func nextEvent(t *testing.T, cs *c.ChangeStream) *c.ChangeEvent {
+	select {
+	case event := <-cs.Events():
+		require.NotNil(t, event)
+		return event
+	default:
+		require.Fail(t, "no event available")
+	}
+	return nil
+}
+
+func TestWatch(t *testing.T) {
+	runCloverTest(t, func(t *testing.T, db *c.DB) {
+		require.NoError(t, db.CreateCollection("items"))
+
+		cs, err := db.Watch("items", nil)
+		require.NoError(t, err)
+		defer cs.Close()
+
+		doc := d.NewDocument()
+		doc.Set("name", "pen")
+		id, err := db.InsertOne("items", doc)
+		require.NoError(t, err)
+
+		event := nextEvent(t, cs)
+		require.Equal(t, c.ChangeInsert, event.Type)
+		require.Equal(t, id, event.DocumentId)
+		require.Nil(t, event.OldDoc)
+		require.Equal(t, "pen", event.NewDoc.Get("name"))
+		lastSeq := event.Seq
+
+		require.NoError(t, db.UpdateById("items", id, func(doc *d.Document) *d.Document {
+			newDoc := doc.Copy()
+			newDoc.Set("name", "pencil")
+			return newDoc
+		}))
+
+		event = nextEvent(t, cs)
+		require.Equal(t, c.ChangeUpdate, event.Type)
+		require.Equal(t, "pen", event.OldDoc.Get("name"))
+		require.Equal(t, "pencil", event.NewDoc.Get("name"))
+		require.Greater(t, event.Seq, lastSeq)
+		lastSeq = event.Seq
+
+		replacement := d.NewDocument()
+		replacement.Set(d.ObjectIdField, id)
+		replacement.Set("name", "marker")
+		require.NoError(t, db.ReplaceById("items", id, replacement))
+		require.Equal(t, c.ChangeReplace, nextEvent(t, cs).Type)
+
+		_, err = db.Update(q.NewQuery("items"), map[string]interface{}{"color": "red"})
+		require.NoError(t, err)
+		event = nextEvent(t, cs)
+		require.Equal(t, c.ChangeUpdate, event.Type)
+		require.Equal(t, "red", event.NewDoc.Get("color"))
+
+		require.NoError(t, db.DeleteById("items", id))
+		event = nextEvent(t, cs)
+		require.Equal(t, c.ChangeDelete, event.Type)
+		require.Equal(t, id, event.DocumentId)
+		require.Nil(t, event.NewDoc)
+		require.Greater(t, event.Seq, lastSeq)
+
+		require.NoError(t, db.Insert("items", d.NewDocument(), d.NewDocument()))
+		nextEvent(t, cs)
+		nextEvent(t, cs)
+
+		_, err = db.Delete(q.NewQuery("items"))
+		require.NoError(t, err)
+		require.Equal(t, c.ChangeDelete, nextEvent(t, cs).Type)
+		require.Equal(t, c.ChangeDelete, nextEvent(t, cs).Type)
+
+		// changes of rolled back transactions are never delivered
+		err = db.RunInTransaction(func(tx *c.Tx) error {
+			require.NoError(t, tx.Insert("items", d.NewDocument()))
+			return errors.New("abort")
+		})
+		require.Error(t, err)
+		require.Len(t, cs.Events(), 0)
+
+		require.NoError(t, db.DropCollection("items"))
+		event = nextEvent(t, cs)
+		require.Equal(t, c.ChangeDrop, event.Type)
+		require.Equal(t, "items", event.Collection)
+
+		require.NoError(t, cs.Close())
+		_, ok := <-cs.Events()
+		require.False(t, ok)
+		require.NoError(t, cs.Err())
+	})
+}
+
+func TestWatchOpenedBeforeCommit(t *testing.T) {
+	runCloverTest(t, func(t *testing.T, db *c.DB) {
+		require.NoError(t, db.CreateCollection("items"))
+
+		tx, err := db.Begin()
+		require.NoError(t, err)
+
+		id, err := tx.InsertOne("items", d.NewDocumentOf(map[string]interface{}{"name": "pen"}))
+		require.NoError(t, err)
+
+		// the stream is opened after the write, but before the transaction commits
+		cs, err := db.Watch("items", nil)
+		require.NoError(t, err)
+		defer cs.Close()
+
+		require.Len(t, cs.Events(), 0)
+		require.NoError(t, tx.Commit())
+
+		event := nextEvent(t, cs)
+		require.Equal(t, c.ChangeInsert, event.Type)
+		require.Equal(t, id, event.DocumentId)
+	})
+}
+
+func TestWatchWithFilter(t *testing.T) {
+	runCloverTest(t, func(t *testing.T, db *c.DB) {
+		require.NoError(t, db.CreateCollection("items"))
+		require.NoError(t, db.CreateCollection("other"))
+
+		cs, err := db.Watch("items", &c.WatchOptions{Filter: q.Field("price").Gt(10)})
+		require.NoError(t, err)
+		defer cs.Close()
+
+		cheap := d.NewDocument()
+		cheap.Set("price", 5)
+		expensive := d.NewDocument()
+		expensive.Set("price", 20)
+
+		require.NoError(t, db.Insert("items", cheap, expensive))
+		require.NoError(t, db.Insert("other", d.NewDocumentOf(map[string]interface{}{"price": 30})))
+
+		event := nextEvent(t, cs)
+		require.Equal(t, expensive.ObjectId(), event.DocumentId)
+		require.Len(t, cs.Events(), 0)
+
+		// an update is delivered if either the old or the new document satisfies the filter
+		_, err = db.Update(q.NewQuery("items").Where(q.Field("_id").Eq(expensive.ObjectId())), map[string]interface{}{"price": 1})
+		require.NoError(t, err)
+		event = nextEvent(t, cs)
+		require.Equal(t, int64(20), event.OldDoc.Get("price"))
+		require.Equal(t, int64(1), event.NewDoc.Get("price"))
+
+		small, err := db.Watch("items", &c.WatchOptions{BufferSize: 1})
+		require.NoError(t, err)
+
+		require.NoError(t, db.Insert("items", d.NewDocument(), d.NewDocument()))
+
+		<-small.Events()
+		_, ok := <-small.Events()
+		require.False(t, ok)
+		require.Equal(t, c.ErrChangeStreamOverflow, small.Err())
+	})
+}
+
+func TestWatchUpdateInPlace(t *testing.T) {
+	runCloverTest(t, func(t *testing.T, db *c.DB) {
+		require.NoError(t, db.CreateCollection("counters"))
+
+		id, err := db.InsertOne("counters", d.NewDocumentOf(map[string]interface{}{"n": 1}))
+		require.NoError(t, err)
+
+		cs, err := db.Watch("counters", &c.WatchOptions{BufferSize: 1000})
+		require.NoError(t, err)
+		defer cs.Close()
+
+		increment := func(doc *d.Document) *d.Document {
+			doc.Set("n", doc.Get("n").(int64)+1)
+			return doc
+		}
+
+		require.NoError(t, db.UpdateById("counters", id, increment))
+		event := nextEvent(t, cs)
+		require.Equal(t, int64(1), event.OldDoc.Get("n"))
+		require.Equal(t, int64(2), event.NewDoc.Get("n"))
+
+		_, err = db.UpdateFunc(q.NewQuery("counters"), increment)
+		require.NoError(t, err)
+		event = nextEvent(t, cs)
+		require.Equal(t, int64(2), event.OldDoc.Get("n"))
+		require.Equal(t, int64(3), event.NewDoc.Get("n"))
+
+		// events of concurrent writers are numbered in commit order
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				// conflicting transactions are retried
+				for j := 0; j < 25; {
+					if db.UpdateById("counters", id, increment) == nil {
+						j++
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		lastSeq := event.Seq
+		for n := int64(3); n < 103; n++ {
+			event = nextEvent(t, cs)
+			require.Greater(t, event.Seq, lastSeq)
+			require.Equal(t, n, event.OldDoc.Get("n"))
+			require.Equal(t, n+1, event.NewDoc.Get("n"))
+			lastSeq = event.Seq
+		}
+	})
+}
+
+func TestCreateCollectionByQuery(t *testing.T) {
+	runCloverTest(t, func(t *testing.T, db *c.DB) {
+		require.NoError(t, loadFromJson(db, todosPath, &TodoModel{}))
+
+		newCollection := "todos_copy"
+		criteria := q.Field("completed").Eq(true)
+		err := db.CreateCollectionByQuery(newCollection, q.NewQuery("todos").Where(criteria))
+		require.NoError(t, err)
+
+		hasNewCollection, err := db.HasCollection(newCollection)
+		require.True(t, hasNewCollection)
+		require.NoError(t, err)
+
+		docs, err := db.FindAll(q.NewQuery(newCollection))
+		require.NoError(t, err)
+
+		for _, doc := range docs {
+			completed, _ := doc.Get("completed").(bool)
+			require.True(t, completed)
+		}
+	})
+}
+
+/*
+func TestInMemoryMode(t *testing.T) {
+	db, err := c.Open("clover-db", c.InMemoryMode(true))
+	require.NoError(t, err)
+
+	require.NoError(t, db.CreateCollection("test"))
+	has, err := db.HasCollection("test")
+	require.NoError(t, err)
+	require.True(t, has)
+
+	require.NoError(t, db.Close())
+
+	db, err = c.Open("clover-db", c.InMemoryMode(true))
+	require.NoError(t, err)
+
+	has, err = db.HasCollection("test")
+	require.NoError(t, err)
+	require.False(t, has)
+}*/
+
+func TestExpiration(t *testing.T) {
+	runCloverTest(t, func(t *testing.T, db *c.DB) {
+		require.NoError(t, db.CreateCollection("test"))
+
+		require.NoError(t, db.CreateIndex("test", "HasExpiration"))
+
+		nInserts := 1000
+
+		expiredDocuments := 0
+
+		docs := make([]*d.Document, 0)
+		expiresAt := time.Now().Add(time.Second * 2)
+		for i := 0; i < nInserts; i++ {
+			doc := d.NewDocument()
+			if rand.Intn(2) == 0 {
+				doc.SetExpiresAt(expiresAt)
+				expiredDocuments++
+				doc.Set("HasExpiration", true)
+			} else {
+				doc.Set("HasExpiration", false)
+			}
+
+			docs = append(docs, doc)
+		}
+
+		require.NoError(t, db.Insert("test", docs...))
+
+		n, err := db.Count(q.NewQuery("test"))
+		require.NoError(t, err)
+
+		require.Equal(t, nInserts, n)
+
+		time.Sleep(time.Until(expiresAt))
+
+		n, err = db.Count(q.NewQuery("test").Where(q.Field("HasExpiration").Eq(true)))
+		require.NoError(t, err)
+
+		require.Equal(t, 0, n)
+
+		n, err = db.Count(q.NewQuery("test"))
+		require.NoError(t, err)
+		require.Equal(t, nInserts-expiredDocuments, n)
+
+		// run an insert with already expired documents
+		expired := make([]*d.Document, 0)
+		for _, doc := range docs {
+			if doc.Get("HasExpiration").(bool) {
+				expired = append(expired, doc)
+			}
+		}
+		require.NoError(t, db.Insert("test", expired...))
+
+		doc, err := db.FindById("test", expired[0].ObjectId())
+		require.NoError(t, err)
+		require.Nil(t, doc)
+
+		require.Equal(t, c.ErrDocumentNotExist, db.UpdateById("test", expired[0].ObjectId(), func(doc *d.Document) *d.Document {
+			return doc
+		}))
 
 		n, err = db.Count(q.NewQuery("test").Where(q.Field("HasExpiration").Eq(true)))
 		require.NoError(t, err)
@@ -1713,6 +3760,94 @@ func TestExpiration(t *testing.T) {
 		require.NoError(t, err)
 
 		require.Equal(t, nInserts-expiredDocuments, n)
+
+		require.NoError(t, db.PurgeExpired("test"))
+
+		n, err = db.Count(q.NewQuery("test"))
+		require.NoError(t, err)
+		require.Equal(t, nInserts-expiredDocuments, n)
+
+		docs, err = db.FindAll(q.NewQuery("test").Sort(q.SortOption{Field: "HasExpiration"}))
+		require.NoError(t, err)
+		require.Len(t, docs, nInserts-expiredDocuments)
+
+		// expired documents can be inserted again once purged
+		doc = d.NewDocument()
+		doc.Set("_id", expired[0].ObjectId())
+		doc.Set("HasExpiration", false)
+		require.NoError(t, db.Insert("test", doc))
+
+		n, err = db.Count(q.NewQuery("test").Where(q.Field("HasExpiration").Eq(false)))
+		require.NoError(t, err)
+		require.Equal(t, nInserts-expiredDocuments+1, n)
+
+		require.Equal(t, c.ErrCollectionNotExist, db.PurgeExpired("myCollection"))
+	})
+}
+
+func TestQueryJSONRoundTrip(t *testing.T) {
+	runCloverTest(t, func(t *testing.T, db *c.DB) {
+		require.NoError(t, loadFromJson(db, todosPath, &TodoModel{}))
+
+		date := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+		queries := []*q.Query{
+			q.NewQuery("todos").Where(q.Field("completed").Eq(true).And(q.Field("userId").In(1, 2))).Sort(q.SortOption{Field: "id", Direction: -1}),
+			q.NewQuery("todos").Where(q.Field("completed_date").Lt(date).Or(q.Field("title").Like("^et"))).Skip(5).Limit(10),
+			q.NewQuery("todos").Where(q.Field("notes").NotExists().And(q.Field("userId").Neq(q.Field("id")))).Sort(),
+			q.NewQuery("todos").Where(q.Field("id").GtEq(10).And(q.Field("id").Lt(20)).Not()).Select("title"),
+		}
+
+		for _, query := range queries {
+			data, err := q.MarshalQuery(query)
+			require.NoError(t, err)
+
+			parsed, err := q.ParseQuery(data)
+			require.NoError(t, err)
+
+			expected, err := db.FindAll(query)
+			require.NoError(t, err)
+			require.NotEmpty(t, expected)
+
+			docs, err := db.FindAll(parsed)
+			require.NoError(t, err)
+			require.Equal(t, expected, docs, string(data))
+		}
+	})
+}
+
+func TestSQLQuery(t *testing.T) {
+	runCloverTest(t, func(t *testing.T, db *c.DB) {
+		require.NoError(t, loadFromJson(db, todosPath, &TodoModel{}))
+
+		tests := []struct {
+			sql   string
+			query *q.Query
+		}{
+			{
+				"SELECT title, userId FROM todos WHERE completed = false AND userId IN (1,2) ORDER BY id DESC LIMIT 10",
+				q.NewQuery("todos").Where(q.Field("completed").IsFalse().And(q.Field("userId").In(1, 2))).Sort(q.SortOption{Field: "id", Direction: -1}).Limit(10).Select("title", "userId"),
+			},
+			{
+				"SELECT * FROM todos WHERE title LIKE 'qui%' AND notes IS NULL ORDER BY id OFFSET 3",
+				q.NewQuery("todos").Where(q.Field("title").Like("^qui").And(q.Field("notes").IsNilOrNotExists())).Sort(q.SortOption{Field: "id"}).Skip(3),
+			},
+			{
+				"SELECT id FROM todos WHERE completed_date EXISTS AND completed_date < TIMESTAMP '2020-06-01T00:00:00Z' OR userId = id",
+				q.NewQuery("todos").Where(q.Field("completed_date").Exists().And(q.Field("completed_date").Lt(time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC))).Or(q.Field("userId").Eq(q.Field("id")))).Select("id"),
+			},
+		}
+
+		for _, test := range tests {
+			query, err := q.ParseSQL(test.sql)
+			require.NoError(t, err)
+
+			expected, err := db.FindAll(test.query)
+			require.NoError(t, err)
+			require.NotEmpty(t, expected)
+
+			docs, err := db.FindAll(query)
+			require.NoError(t, err)
+			require.Equal(t, expected, docs, test.sql)
+		}
 	})
 }
-*/
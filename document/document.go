@@ -13,6 +13,7 @@ import (
 const (
 	ObjectIdField  = "_id"
 	ExpiresAtField = "_expiresAt"
+	VersionField   = "_version"
 )
 
 // Document represents a document as a map.
@@ -115,6 +116,14 @@ func (doc *Document) Set(name string, value interface{}) {
 	}
 }
 
+// Unset removes the field with the supplied name, if it exists. Nested fields can be accessed using dot.
+func (doc *Document) Unset(name string) {
+	fieldMap, _, fieldName := lookupField(name, doc.fields, false)
+	if fieldMap != nil {
+		delete(fieldMap, fieldName)
+	}
+}
+
 // SetAll sets each field specified in the input map to the corresponding value. Nested fields can be accessed using dot.
 func (doc *Document) SetAll(values map[string]interface{}) {
 	for updateField, updateValue := range values {
@@ -164,6 +173,21 @@ func (doc *Document) TTL() time.Duration {
 	return time.Millisecond * time.Duration(expiresAt.Sub(now).Milliseconds())
 }
 
+// IsExpired returns true if the document has an expiration instant which is not after the supplied time.
+func (doc *Document) IsExpired(now time.Time) bool {
+	expiresAt := doc.ExpiresAt()
+	return expiresAt != nil && !expiresAt.After(now)
+}
+
+// Version returns the version of the document, which is only maintained for versioned collections. If the document has no version, zero is returned.
+func (doc *Document) Version() int64 {
+	v := doc.Get(VersionField)
+	if !util.IsNumber(v) {
+		return 0
+	}
+	return util.ToInt64(v)
+}
+
 // Unmarshal stores the document in the value pointed by v.
 func (doc *Document) Unmarshal(v interface{}) error {
 	return internal.Convert(doc.fields, v)
@@ -33,6 +33,25 @@ func TestDocument(t *testing.T) {
 	}
 }
 
+func TestDocumentUnset(t *testing.T) {
+	doc := NewDocument()
+	doc.Set("a.b", 1)
+	doc.Set("a.c", 2)
+	doc.Set("d", 3)
+
+	doc.Unset("a.b")
+	require.False(t, doc.Has("a.b"))
+	require.True(t, doc.Has("a.c"))
+
+	doc.Unset("d")
+	require.False(t, doc.Has("d"))
+
+	doc.Unset("a.x.y") // missing fields are ignored
+	doc.Unset("a")
+	require.False(t, doc.Has("a"))
+	require.Empty(t, doc.Fields(true))
+}
+
 func TestDocumentSetUint(t *testing.T) {
 	doc := NewDocument()
 
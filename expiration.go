@@ -0,0 +1,201 @@
+package clover
+
+import (
+	"bytes"
+	"log"
+	"time"
+
+	d "github.com/ostafen/clover/v2/document"
+	"github.com/ostafen/clover/v2/internal"
+	"github.com/ostafen/clover/v2/store"
+)
+
+const (
+	// DefaultExpirationSweepInterval is the default interval between two consecutive runs of the expired documents reaper.
+	DefaultExpirationSweepInterval = time.Minute
+
+	// maximum number of expired documents deleted within a single transaction
+	expirationPurgeBatchSize = 1024
+)
+
+func getExpirationKeyPrefix(collection string) string {
+	return "c:" + collection + ";" + "e:"
+}
+
+func getExpirationKey(collection string, expiresAt time.Time, docId string) ([]byte, error) {
+	key, err := internal.OrderedCode([]byte(getExpirationKeyPrefix(collection)), expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return append(key, []byte(docId)...), nil
+}
+
+// updateExpiration keeps the expiration entries of a collection in sync when oldDoc is replaced by newDoc.
+// Any of the two documents can be nil, to denote an insertion or a deletion.
+func updateExpiration(tx store.Tx, collection string, oldDoc, newDoc *d.Document) error {
+	if oldDoc != nil && oldDoc.ExpiresAt() != nil {
+		key, err := getExpirationKey(collection, *oldDoc.ExpiresAt(), oldDoc.ObjectId())
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Delete(key); err != nil {
+			return err
+		}
+	}
+
+	if newDoc != nil && newDoc.ExpiresAt() != nil {
+		key, err := getExpirationKey(collection, *newDoc.ExpiresAt(), newDoc.ObjectId())
+		if err != nil {
+			return err
+		}
+		return tx.Set(key, nil)
+	}
+	return nil
+}
+
+// iterateExpired calls onExpired for each document of the collection whose expiration instant is not after now.
+func iterateExpired(tx store.Tx, collection string, now time.Time, onExpired func(key []byte, docId string) error) error {
+	prefix := []byte(getExpirationKeyPrefix(collection))
+
+	nowKey, err := internal.OrderedCode(prefix, now)
+	if err != nil {
+		return err
+	}
+
+	return iteratePrefix(prefix, tx, func(item store.Item) error {
+		p, docId := extractDocId(item.Key)
+		if bytes.Compare(p, nowKey) > 0 {
+			return internal.ErrStopIteration
+		}
+		return onExpired(item.Key, string(docId))
+	})
+}
+
+func extractDocId(key []byte) ([]byte, []byte) {
+	return key[:len(key)-36], key[len(key)-36:]
+}
+
+func countExpired(tx store.Tx, collection string, now time.Time) (int, error) {
+	n := 0
+	err := iterateExpired(tx, collection, now, func(_ []byte, _ string) error {
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// PurgeExpired physically removes all the expired documents of the given collection.
+// Expired documents are never returned by queries, but they keep occupying space until they are purged,
+// either by calling this method or by the background reaper (see ExpirationSweepInterval).
+func (db *DB) PurgeExpired(collection string) error {
+	for {
+		n, err := db.purgeExpiredBatch(collection, expirationPurgeBatchSize)
+		if err != nil || n < expirationPurgeBatchSize {
+			return err
+		}
+	}
+}
+
+func (db *DB) purgeExpiredBatch(collection string, batchSize int) (int, error) {
+	tx, err := db.beginUpdate()
+	if err != nil {
+		return -1, err
+	}
+	defer tx.Rollback()
+
+	meta, err := db.getCollectionMeta(collection, tx)
+	if err != nil {
+		return -1, err
+	}
+
+	type expiredEntry struct {
+		key   []byte
+		docId string
+	}
+
+	entries := make([]expiredEntry, 0)
+	err = iterateExpired(tx, collection, time.Now(), func(key []byte, docId string) error {
+		entries = append(entries, expiredEntry{key: append([]byte{}, key...), docId: docId})
+		if len(entries) >= batchSize {
+			return internal.ErrStopIteration
+		}
+		return nil
+	})
+
+	if err != nil || len(entries) == 0 {
+		return 0, err
+	}
+
+	indexes := db.getIndexes(tx, collection, meta)
+	for _, entry := range entries {
+		doc, err := getDocumentById(collection, entry.docId, tx)
+		if err != nil {
+			return -1, err
+		}
+
+		if doc != nil {
+			if err := db.deleteDocFromIndexes(indexes, doc); err != nil {
+				return -1, err
+			}
+
+			if err := tx.Delete([]byte(getDocumentKey(collection, entry.docId))); err != nil {
+				return -1, err
+			}
+			recordChange(tx, ChangeDelete, collection, doc, nil)
+			meta.Size--
+		}
+
+		if err := tx.Delete(entry.key); err != nil {
+			return -1, err
+		}
+	}
+
+	if err := db.saveCollectionMetadata(collection, meta, tx); err != nil {
+		return -1, err
+	}
+	return len(entries), tx.Commit()
+}
+
+func (db *DB) purgeAllExpired() error {
+	collections, err := db.ListCollections()
+	if err != nil {
+		return err
+	}
+
+	for _, collection := range collections {
+		err := db.PurgeExpired(collection)
+		if err != nil && err != ErrCollectionNotExist { // the collection could have been dropped in the meantime
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *DB) startExpirationReaper(interval time.Duration) {
+	db.chWg.Add(1)
+
+	go func() {
+		defer db.chWg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-db.chQuit:
+				return
+
+			case <-ticker.C:
+				if err := db.purgeAllExpired(); err != nil {
+					log.Printf("purgeAllExpired(): %s\n", err.Error())
+				}
+			}
+		}
+	}()
+}
+
+func (db *DB) stopExpirationReaper() {
+	close(db.chQuit)
+	db.chWg.Wait()
+}
@@ -0,0 +1,68 @@
+package clover
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	d "github.com/ostafen/clover/v2/document"
+	q "github.com/ostafen/clover/v2/query"
+	"github.com/ostafen/clover/v2/store"
+	badgerstore "github.com/ostafen/clover/v2/store/badger"
+	"github.com/ostafen/clover/v2/store/bbolt"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpirationReaper(t *testing.T) {
+	for _, openStore := range []func(string) (store.Store, error){badgerstore.Open, bbolt.Open} {
+		dir, err := os.MkdirTemp("", "clover-test")
+		require.NoError(t, err)
+
+		dataStore, err := openStore(dir)
+		require.NoError(t, err)
+
+		db, err := OpenWithStore(dataStore, ExpirationSweepInterval(time.Millisecond*100))
+		require.NoError(t, err)
+
+		require.NoError(t, db.CreateCollection("test"))
+		require.NoError(t, db.CreateIndex("test", "n"))
+
+		for i := 0; i < 100; i++ {
+			doc := d.NewDocument()
+			doc.Set("n", i)
+			if i%2 == 0 {
+				doc.SetExpiresAt(time.Now().Add(time.Millisecond * 200))
+			}
+			require.NoError(t, db.Insert("test", doc))
+		}
+
+		time.Sleep(time.Second)
+
+		tx, err := dataStore.Begin(false)
+		require.NoError(t, err)
+
+		meta, err := db.getCollectionMeta("test", tx)
+		require.NoError(t, err)
+		require.Equal(t, 50, meta.Size)
+
+		n, err := countExpired(tx, "test", time.Now())
+		require.NoError(t, err)
+		require.Zero(t, n)
+
+		indexedDocs := 0
+		idx := db.getIndexes(tx, "test", meta)[0]
+		require.NoError(t, idx.Iterate(false, func(docId string) error {
+			indexedDocs++
+			return nil
+		}))
+		require.Equal(t, 50, indexedDocs)
+		require.NoError(t, tx.Rollback())
+
+		docs, err := db.FindAll(q.NewQuery("test").Where(q.Field("n").GtEq(0)))
+		require.NoError(t, err)
+		require.Len(t, docs, 50)
+
+		require.NoError(t, db.Close())
+		require.NoError(t, os.RemoveAll(dir))
+	}
+}
@@ -0,0 +1,500 @@
+package clover
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	d "github.com/ostafen/clover/v2/document"
+	"github.com/ostafen/clover/v2/index"
+	"github.com/ostafen/clover/v2/query"
+	"github.com/ostafen/clover/v2/store"
+)
+
+// Types of the nodes of a query plan.
+const (
+	CollectionScanNode = "CollectionScan"
+	IndexScanNode      = "IndexScan"
+	SortNode           = "Sort"
+	SkipLimitNode      = "SkipLimit"
+	ProjectNode        = "Project"
+	OutputNode         = "Output"
+)
+
+// Types of the index scans.
+const (
+	RangeScan        = "Range"
+	CompoundScan     = "Compound"
+	UnionScan        = "Union"
+	IntersectionScan = "Intersection"
+	FullTextScan     = "FullText"
+	GeoScan          = "Geo"
+)
+
+// QueryPlan describes how a query is executed. It is returned by Explain and ExplainAnalyze.
+type QueryPlan struct {
+	Collection string
+	// Nodes lists the nodes of the plan, from the one reading the documents to the one returning them.
+	Nodes []*PlanNode
+	// IndexSorted is true if documents are read in the requested order (from an index, or from the collection when sorting by "_id"), so that no sort is performed.
+	IndexSorted bool
+	// Analyzed is true if the plan has been executed to collect runtime statistics.
+	Analyzed bool
+	// Elapsed is the overall execution time of the plan. It is only set by ExplainAnalyze.
+	Elapsed time.Duration
+}
+
+// PlanNode describes a single node of a query plan.
+type PlanNode struct {
+	Type string
+
+	// Filter is the criteria evaluated by a scan node.
+	Filter query.Criteria
+	// Index describes the index scan performed by an IndexScan node.
+	Index *IndexScan
+	// IndexOnly is true if an IndexScan node builds documents from the values stored in the index, without reading them.
+	IndexOnly bool
+	// SortOptions holds the sort options of a Sort node.
+	SortOptions []query.SortOption
+	// Skip and Limit hold the parameters of a SkipLimit node.
+	Skip, Limit int
+	// Select and Exclude hold the fields selected and excluded by a Project node.
+	Select, Exclude []string
+
+	// The following fields are only set by ExplainAnalyze.
+
+	// DocsExamined is the number of documents read from the collection by a scan node.
+	DocsExamined int
+	// DocsReturned is the number of documents passed by the node to the next one.
+	// For the Output node, it is the number of documents returned by the query.
+	DocsReturned int
+	// Elapsed is the time spent inside the node, excluding the time spent by the following nodes.
+	Elapsed time.Duration
+}
+
+// IndexScan describes the scan of an index. Union and intersection scans combine the results of their children.
+type IndexScan struct {
+	Type     string
+	Index    string
+	Prefix   []interface{}
+	Range    *index.Range
+	Reverse  bool
+	Children []*IndexScan
+	// Terms holds the terms looked up by a full-text scan.
+	Terms []string
+	// Region is the region selected by a geospatial scan.
+	Region *query.GeoRegion
+
+	// KeysScanned is the number of document ids produced by the scan. It is only set by ExplainAnalyze.
+	KeysScanned int
+}
+
+// Explain returns the plan which would be used to execute the supplied query, without running it.
+func (db *DB) Explain(q *query.Query) (*QueryPlan, error) {
+	return db.explain(q, false)
+}
+
+// ExplainAnalyze executes the supplied query and returns its plan, together with the number of documents
+// processed by each node, the number of index keys scanned and the elapsed time.
+func (db *DB) ExplainAnalyze(q *query.Query) (*QueryPlan, error) {
+	return db.explain(q, true)
+}
+
+func (db *DB) explain(q *query.Query, analyze bool) (*QueryPlan, error) {
+	q, err := normalizeCriteria(q)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.store.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	meta, err := db.getCollectionMeta(q.Collection(), tx)
+	if err != nil {
+		return nil, err
+	}
+
+	output := &consumerNode{consumer: func(_ *d.Document) error { return nil }}
+	inputNode := buildQueryPlan(q, db.getIndexes(tx, q.Collection(), meta), output)
+
+	plan, nodes := describePlan(q, inputNode)
+	if analyze {
+		if err := analyzePlan(tx, plan, nodes); err != nil {
+			return nil, err
+		}
+	}
+	return plan, nil
+}
+
+func describePlan(q *query.Query, inputNode inputNode) (*QueryPlan, []planNode) {
+	plan := &QueryPlan{Collection: q.Collection()}
+
+	nodes := make([]planNode, 0)
+	hasSortNode := false
+	for curr := inputNode.(planNode); curr != nil; curr = curr.NextNode() {
+		nodes = append(nodes, curr)
+
+		info := describeNode(curr)
+		hasSortNode = hasSortNode || info.Type == SortNode
+		plan.Nodes = append(plan.Nodes, info)
+	}
+	plan.IndexSorted = len(q.SortOptions()) > 0 && !hasSortNode
+	return plan, nodes
+}
+
+func describeNode(nd planNode) *PlanNode {
+	switch nd := nd.(type) {
+	case *iterNode:
+		if nd.idxQuery == nil {
+			return &PlanNode{Type: CollectionScanNode, Filter: nd.filter}
+		}
+		return &PlanNode{Type: IndexScanNode, Filter: nd.filter, Index: describeIndexQuery(nd.idxQuery), IndexOnly: nd.indexOnly}
+	case *sortNode:
+		return &PlanNode{Type: SortNode, SortOptions: nd.opts}
+	case *skipLimitNode:
+		return &PlanNode{Type: SkipLimitNode, Skip: nd.skip, Limit: nd.limit}
+	case *projectNode:
+		return &PlanNode{Type: ProjectNode, Select: nd.fields, Exclude: nd.exclude}
+	}
+	return &PlanNode{Type: OutputNode}
+}
+
+func describeIndexQuery(q index.Query) *IndexScan {
+	switch q := q.(type) {
+	case *index.RangeIndexQuery:
+		return &IndexScan{Type: RangeScan, Index: q.Idx.Field(), Range: q.Range, Reverse: q.Reverse}
+	case *index.CompoundIndexQuery:
+		return &IndexScan{Type: CompoundScan, Index: q.Idx.Field(), Prefix: q.Prefix, Range: q.Range, Reverse: q.Reverse}
+	case *index.FullTextQuery:
+		return &IndexScan{Type: FullTextScan, Index: q.Idx.Field(), Terms: q.Terms}
+	case *index.GeoQuery:
+		return &IndexScan{Type: GeoScan, Index: q.Idx.Field(), Region: q.Region}
+	case *index.UnionQuery:
+		return &IndexScan{Type: UnionScan, Children: describeIndexQueries(q.Queries)}
+	case *index.IntersectionQuery:
+		return &IndexScan{Type: IntersectionScan, Children: describeIndexQueries(q.Queries)}
+	}
+	return &IndexScan{Type: fmt.Sprintf("%T", q)}
+}
+
+func describeIndexQueries(queries []index.Query) []*IndexScan {
+	scans := make([]*IndexScan, 0, len(queries))
+	for _, q := range queries {
+		scans = append(scans, describeIndexQuery(q))
+	}
+	return scans
+}
+
+// countingIndexQuery counts the document ids produced by the wrapped query.
+type countingIndexQuery struct {
+	q    index.Query
+	scan *IndexScan
+}
+
+func (q *countingIndexQuery) Run(onValue func(docId string) error) error {
+	return q.q.Run(func(docId string) error {
+		q.scan.KeysScanned++
+		return onValue(docId)
+	})
+}
+
+// Fields and RunCovered must only be called if the wrapped query is an index.CoveringQuery.
+func (q *countingIndexQuery) Fields() []string {
+	return q.q.(index.CoveringQuery).Fields()
+}
+
+func (q *countingIndexQuery) RunCovered(onValue func(docId string, doc *d.Document) error) error {
+	return q.q.(index.CoveringQuery).RunCovered(func(docId string, doc *d.Document) error {
+		q.scan.KeysScanned++
+		return onValue(docId, doc)
+	})
+}
+
+// instrumentIndexQuery wraps each query of the tree rooted at q, so that the produced ids are counted into the corresponding scan.
+func instrumentIndexQuery(q index.Query, scan *IndexScan) index.Query {
+	switch setQuery := q.(type) {
+	case *index.UnionQuery:
+		q = &index.UnionQuery{Queries: instrumentIndexQueries(setQuery.Queries, scan.Children)}
+	case *index.IntersectionQuery:
+		q = &index.IntersectionQuery{Queries: instrumentIndexQueries(setQuery.Queries, scan.Children)}
+	}
+	return &countingIndexQuery{q: q, scan: scan}
+}
+
+func instrumentIndexQueries(queries []index.Query, scans []*IndexScan) []index.Query {
+	instrumented := make([]index.Query, 0, len(queries))
+	for i, q := range queries {
+		instrumented = append(instrumented, instrumentIndexQuery(q, scans[i]))
+	}
+	return instrumented
+}
+
+// statsNode is placed after each node of an analyzed plan.
+// It counts the documents returned by the previous node and measures the time spent by the following ones.
+type statsNode struct {
+	planNodeBase
+	info       *PlanNode
+	downstream time.Duration
+}
+
+func (nd *statsNode) Callback(doc *d.Document) error {
+	nd.info.DocsReturned++
+
+	start := time.Now()
+	err := nd.CallNext(doc)
+	nd.downstream += time.Since(start)
+	return err
+}
+
+func analyzePlan(tx store.Tx, plan *QueryPlan, nodes []planNode) error {
+	itNode := nodes[0].(*iterNode)
+	if itNode.idxQuery != nil {
+		itNode.idxQuery = instrumentIndexQuery(itNode.idxQuery, plan.Nodes[0].Index)
+	}
+
+	stats := make([]*statsNode, len(nodes))
+	for i, nd := range nodes[:len(nodes)-1] {
+		stats[i] = &statsNode{info: plan.Nodes[i]}
+		stats[i].SetNext(nd.NextNode())
+		nd.SetNext(stats[i])
+	}
+
+	// inclusive holds the time spent by each node, including the time spent by the following ones
+	inclusive := make([]time.Duration, len(nodes))
+
+	start := time.Now()
+	if err := itNode.Run(tx); err != nil {
+		return err
+	}
+	inclusive[0] = time.Since(start)
+
+	for i, nd := range nodes {
+		finishStart := time.Now()
+		if err := nd.Finish(); err != nil {
+			return err
+		}
+		inclusive[i] += time.Since(finishStart)
+
+		if i > 0 {
+			inclusive[i] += stats[i-1].downstream
+		}
+	}
+	plan.Elapsed = time.Since(start)
+
+	for i, info := range plan.Nodes {
+		info.Elapsed = inclusive[i]
+		if stats[i] != nil {
+			info.Elapsed -= stats[i].downstream
+		}
+	}
+	plan.Nodes[0].DocsExamined = itNode.examined
+
+	// the output node returns all the documents it receives
+	plan.Nodes[len(plan.Nodes)-1].DocsReturned = plan.Nodes[len(plan.Nodes)-2].DocsReturned
+	plan.Analyzed = true
+	return nil
+}
+
+// String returns a human readable representation of the plan, one node per line.
+func (p *QueryPlan) String() string {
+	var sb strings.Builder
+	for i, nd := range p.Nodes {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(nd.describe(p.Analyzed))
+
+		if nd.Index != nil {
+			writeIndexScan(&sb, nd.Index, 1, p.Analyzed)
+		}
+	}
+
+	if p.Analyzed {
+		fmt.Fprintf(&sb, "\nTotal elapsed: %s", p.Elapsed)
+	}
+	return sb.String()
+}
+
+func (nd *PlanNode) describe(analyzed bool) string {
+	s := nd.Type
+	switch nd.Type {
+	case CollectionScanNode, IndexScanNode:
+		if nd.IndexOnly {
+			s += " index-only"
+		}
+
+		if nd.Filter != nil {
+			s += " filter=" + formatCriteria(nd.Filter)
+		}
+	case SortNode:
+		opts := make([]string, 0, len(nd.SortOptions))
+		for _, opt := range nd.SortOptions {
+			direction := "asc"
+			if opt.Direction < 0 {
+				direction = "desc"
+			}
+			opts = append(opts, opt.Field+" "+direction)
+		}
+		s += " by=" + strings.Join(opts, ", ")
+	case SkipLimitNode:
+		s += fmt.Sprintf(" skip=%d limit=%d", nd.Skip, nd.Limit)
+	case ProjectNode:
+		if len(nd.Select) > 0 {
+			s += " select=" + strings.Join(nd.Select, ", ")
+		}
+
+		if len(nd.Exclude) > 0 {
+			s += " exclude=" + strings.Join(nd.Exclude, ", ")
+		}
+	}
+
+	if analyzed {
+		if nd.Type == CollectionScanNode || nd.Type == IndexScanNode {
+			s += fmt.Sprintf(" (examined=%d returned=%d elapsed=%s)", nd.DocsExamined, nd.DocsReturned, nd.Elapsed)
+		} else {
+			s += fmt.Sprintf(" (returned=%d elapsed=%s)", nd.DocsReturned, nd.Elapsed)
+		}
+	}
+	return s
+}
+
+func writeIndexScan(sb *strings.Builder, scan *IndexScan, depth int, analyzed bool) {
+	sb.WriteString("\n" + strings.Repeat("  ", depth) + scan.Type)
+
+	if scan.Index != "" {
+		sb.WriteString(" index=" + scan.Index)
+	}
+
+	if len(scan.Prefix) > 0 {
+		sb.WriteString(fmt.Sprintf(" prefix=%v", scan.Prefix))
+	}
+
+	if scan.Range != nil {
+		sb.WriteString(" range=" + formatRange(scan.Range))
+	}
+
+	if len(scan.Terms) > 0 {
+		sb.WriteString(fmt.Sprintf(" terms=%v", scan.Terms))
+	}
+
+	if scan.Region != nil {
+		sb.WriteString(" region=" + formatRegion(scan.Region))
+	}
+
+	if scan.Reverse {
+		sb.WriteString(" reverse")
+	}
+
+	if analyzed {
+		sb.WriteString(fmt.Sprintf(" (keys=%d)", scan.KeysScanned))
+	}
+
+	for _, child := range scan.Children {
+		writeIndexScan(sb, child, depth+1, analyzed)
+	}
+}
+
+func formatRegion(r *query.GeoRegion) string {
+	switch r.Op {
+	case query.NearOp:
+		return fmt.Sprintf("near(%v, %v, %vm)", r.Center[0], r.Center[1], r.Radius)
+	case query.WithinBoxOp:
+		return fmt.Sprintf("box(%v, %v, %v, %v)", r.Box.MinLon, r.Box.MinLat, r.Box.MaxLon, r.Box.MaxLat)
+	}
+	return fmt.Sprintf("polygon%v", r.Polygon)
+}
+
+func formatRange(r *index.Range) string {
+	if r.IsNil() {
+		return "[null]"
+	}
+
+	start, end := "(", ")"
+	if r.StartIncluded {
+		start = "["
+	}
+	if r.EndIncluded {
+		end = "]"
+	}
+
+	startValue, endValue := "-inf", "+inf"
+	if r.Start != nil || r.StartIncluded {
+		startValue = formatValue(r.Start)
+	}
+	if r.End != nil || r.EndIncluded {
+		endValue = formatValue(r.End)
+	}
+	return start + startValue + ", " + endValue + end
+}
+
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return fmt.Sprintf("%q", v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	}
+
+	if query.IsField(v) { // a reference to another field
+		return "$" + getReferencedField(v)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+var opSymbols = map[int]string{
+	query.ExistsOp:        "exists",
+	query.EqOp:            "=",
+	query.NeqOp:           "!=",
+	query.GtOp:            ">",
+	query.GtEqOp:          ">=",
+	query.LtOp:            "<",
+	query.LtEqOp:          "<=",
+	query.LikeOp:          "like",
+	query.InOp:            "in",
+	query.ContainsOp:      "contains",
+	query.SearchOp:        "search",
+	query.NearOp:          "near",
+	query.WithinBoxOp:     "within box",
+	query.WithinPolygonOp: "within polygon",
+}
+
+// formatCriteria returns a human readable representation of the supplied criteria.
+func formatCriteria(c query.Criteria) string {
+	return c.Accept(&criteriaFormatVisitor{}).(string)
+}
+
+type criteriaFormatVisitor struct{}
+
+func (v *criteriaFormatVisitor) VisitUnaryCriteria(c *query.UnaryCriteria) interface{} {
+	switch c.OpType {
+	case query.ExistsOp:
+		return c.Field + " exists"
+	case query.FunctionOp:
+		return "<func>"
+	case query.InOp, query.ContainsOp:
+		values, _ := c.Value.([]interface{})
+		formatted := make([]string, 0, len(values))
+		for _, value := range values {
+			formatted = append(formatted, formatValue(value))
+		}
+		return c.Field + " " + opSymbols[c.OpType] + " [" + strings.Join(formatted, ", ") + "]"
+	}
+	return c.Field + " " + opSymbols[c.OpType] + " " + formatValue(c.Value)
+}
+
+func (v *criteriaFormatVisitor) VisitNotCriteria(c *query.NotCriteria) interface{} {
+	return "NOT (" + c.C.Accept(v).(string) + ")"
+}
+
+func (v *criteriaFormatVisitor) VisitBinaryCriteria(c *query.BinaryCriteria) interface{} {
+	op := " AND "
+	if c.OpType == query.LogicalOr {
+		op = " OR "
+	}
+	return "(" + c.C1.Accept(v).(string) + op + c.C2.Accept(v).(string) + ")"
+}
@@ -0,0 +1,332 @@
+package index
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/orderedcode"
+	"github.com/ostafen/clover/v2/internal"
+	"github.com/ostafen/clover/v2/query"
+	"github.com/ostafen/clover/v2/store"
+)
+
+// CompoundIndex is an index built over multiple fields. Records are sorted by the value of the first field,
+// then by the value of the second one, and so on, each field following its own direction.
+type CompoundIndex interface {
+	Index
+	Fields() []query.SortOption
+	IteratePrefixRange(prefix []interface{}, vRange *Range, reverse bool, onValue func(docId string) error) error
+	IteratePrefixRangeValues(prefix []interface{}, vRange *Range, reverse bool, onValue func(docId string, values []interface{}, ok bool) error) error
+}
+
+// CompoundIndexQuery selects the records whose first len(Prefix) fields are equal to Prefix
+// and, if Range is not nil, whose next field falls within Range.
+type CompoundIndexQuery struct {
+	Prefix  []interface{}
+	Range   *Range
+	Reverse bool
+	Idx     CompoundIndex
+}
+
+func (q *CompoundIndexQuery) Run(onValue func(docId string) error) error {
+	return q.Idx.IteratePrefixRange(q.Prefix, q.Range, q.Reverse, onValue)
+}
+
+// CompoundIndexName returns the name of the compound index built over the supplied fields (for example, "userId_1_createdAt_-1").
+func CompoundIndexName(fields []query.SortOption) string {
+	parts := make([]string, 0, len(fields))
+	for _, opt := range normalizeDirections(fields) {
+		parts = append(parts, fmt.Sprintf("%s_%d", opt.Field, opt.Direction))
+	}
+	return strings.Join(parts, "_")
+}
+
+// CompoundInfo returns the description of the compound index built over the supplied fields.
+func CompoundInfo(fields []query.SortOption) Info {
+	return Info{
+		Field:  CompoundIndexName(fields),
+		Type:   Compound,
+		Fields: normalizeDirections(fields),
+	}
+}
+
+func normalizeDirections(fields []query.SortOption) []query.SortOption {
+	normFields := make([]query.SortOption, 0, len(fields))
+	for _, opt := range fields {
+		direction := 1
+		if opt.Direction < 0 {
+			direction = -1
+		}
+		normFields = append(normFields, query.SortOption{Field: opt.Field, Direction: direction})
+	}
+	return normFields
+}
+
+type compoundIndex struct {
+	indexBase
+	fields []query.SortOption
+	tx     store.Tx
+}
+
+func (idx *compoundIndex) Fields() []query.SortOption {
+	return idx.fields
+}
+
+func (idx *compoundIndex) Type() Type {
+	return Compound
+}
+
+func (idx *compoundIndex) getKeyPrefix() []byte {
+	return getIndexKeyPrefix(idx.collection, idx.field)
+}
+
+// encodeComponent appends the encoding of a single field value to buf.
+// Each value is wrapped into an orderedcode string, so that components are self-delimiting and
+// tuples sharing a prefix are compared field by field. Descending fields use the inverted encoding.
+func encodeComponent(buf []byte, v interface{}, desc bool) ([]byte, error) {
+	encoded, err := orderedcode.Append(nil, uint64(internal.TypeId(v)))
+	if err != nil {
+		return nil, err
+	}
+
+	encoded, err = internal.OrderedCode(encoded, v)
+	if err != nil {
+		return nil, err
+	}
+
+	if desc {
+		return orderedcode.Append(buf, orderedcode.Decr(string(encoded)))
+	}
+	return orderedcode.Append(buf, string(encoded))
+}
+
+func (idx *compoundIndex) encodeValues(values []interface{}) ([]byte, error) {
+	if len(values) > len(idx.fields) {
+		return nil, fmt.Errorf("too many values for compound index %s", idx.field)
+	}
+
+	key := idx.getKeyPrefix()
+	for i, v := range values {
+		var err error
+		key, err = encodeComponent(key, v, idx.fields[i].Direction < 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+func (idx *compoundIndex) encodeValueAndId(v interface{}, docId string) ([]byte, error) {
+	values, ok := v.([]interface{})
+	if !ok || len(values) != len(idx.fields) {
+		return nil, fmt.Errorf("compound index %s expects exactly %d values", idx.field, len(idx.fields))
+	}
+
+	key, err := idx.encodeValues(values)
+	if err != nil {
+		return nil, err
+	}
+	return append(key, []byte(docId)...), nil
+}
+
+func (idx *compoundIndex) Add(docId string, v interface{}, ttl time.Duration) error {
+	key, err := idx.encodeValueAndId(v, docId)
+	if err != nil {
+		return err
+	}
+
+	// values are not stored if any of them is null, since missing fields would be indistinguishable from null ones
+	var value []byte
+	if values := v.([]interface{}); !hasNilValue(values) {
+		value, err = encodeRecordValue(values)
+		if err != nil {
+			return err
+		}
+	}
+	return idx.tx.Set(key, value)
+}
+
+func hasNilValue(values []interface{}) bool {
+	for _, v := range values {
+		if v == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (idx *compoundIndex) Remove(docId string, v interface{}) error {
+	key, err := idx.encodeValueAndId(v, docId)
+	if err != nil {
+		return err
+	}
+	return idx.tx.Delete(key)
+}
+
+func (idx *compoundIndex) Drop() error {
+	return deletePrefix(idx.tx, idx.getKeyPrefix())
+}
+
+func (idx *compoundIndex) Iterate(reverse bool, onValue func(docId string) error) error {
+	return idx.IteratePrefixRange(nil, nil, reverse, onValue)
+}
+
+type keyBound struct {
+	key      []byte
+	included bool
+}
+
+// satisfiedBy reports whether key lies on the right side of the bound.
+// A key having the bound as prefix holds the bound value itself, followed by other components.
+func (b *keyBound) satisfiedBy(key []byte, lower bool) bool {
+	if b == nil {
+		return true
+	}
+
+	if bytes.HasPrefix(key, b.key) {
+		return b.included
+	}
+
+	if lower {
+		return bytes.Compare(key, b.key) > 0
+	}
+	return bytes.Compare(key, b.key) < 0
+}
+
+func (idx *compoundIndex) encodeBound(prefixKey []byte, v interface{}, included bool, desc bool) (*keyBound, error) {
+	key, err := encodeComponent(append([]byte{}, prefixKey...), v, desc)
+	if err != nil {
+		return nil, err
+	}
+	return &keyBound{key: key, included: included}, nil
+}
+
+// encodeBounds converts a value range over the field following the prefix into a pair of key bounds.
+// For descending fields, the start of the range maps to the upper key bound and vice versa.
+func (idx *compoundIndex) encodeBounds(prefixKey []byte, field query.SortOption, vRange *Range) (*keyBound, *keyBound, error) {
+	var err error
+	var startBound, endBound *keyBound
+
+	desc := field.Direction < 0
+	if vRange.IsNil() || vRange.Start != nil {
+		startBound, err = idx.encodeBound(prefixKey, vRange.Start, vRange.StartIncluded, desc)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if vRange.IsNil() || vRange.End != nil {
+		endBound, err = idx.encodeBound(prefixKey, vRange.End, vRange.EndIncluded, desc)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if desc {
+		return endBound, startBound, nil
+	}
+	return startBound, endBound, nil
+}
+
+func (idx *compoundIndex) IteratePrefixRange(prefix []interface{}, vRange *Range, reverse bool, onValue func(docId string) error) error {
+	return idx.iteratePrefixRange(prefix, vRange, reverse, func(docId string, _ []byte) error {
+		return onValue(docId)
+	})
+}
+
+// IteratePrefixRangeValues is like IteratePrefixRange, but it also supplies the values of the indexed fields stored in each record.
+func (idx *compoundIndex) IteratePrefixRangeValues(prefix []interface{}, vRange *Range, reverse bool, onValue func(docId string, values []interface{}, ok bool) error) error {
+	return idx.iteratePrefixRange(prefix, vRange, reverse, func(docId string, data []byte) error {
+		value, ok, err := decodeRecordValue(data)
+		if err != nil {
+			return err
+		}
+
+		values, _ := value.([]interface{})
+		return onValue(docId, values, ok && len(values) == len(idx.fields))
+	})
+}
+
+func (idx *compoundIndex) iteratePrefixRange(prefix []interface{}, vRange *Range, reverse bool, onRecord func(docId string, data []byte) error) error {
+	if vRange != nil && vRange.IsEmpty() {
+		return nil
+	}
+
+	prefixKey, err := idx.encodeValues(prefix)
+	if err != nil {
+		return err
+	}
+
+	var lowerBound, upperBound *keyBound
+	if vRange != nil && len(prefix) < len(idx.fields) {
+		lowerBound, upperBound, err = idx.encodeBounds(prefixKey, idx.fields[len(prefix)], vRange)
+		if err != nil {
+			return err
+		}
+	}
+
+	var seekKey []byte
+	if !reverse {
+		seekKey = prefixKey
+		if lowerBound != nil {
+			seekKey = lowerBound.key
+		}
+	} else {
+		seekKey = prefixKey
+		if upperBound != nil {
+			seekKey = upperBound.key
+		}
+		// no component starts with the infinity encoding, so this positions the cursor after all the keys sharing seekKey
+		seekKey, err = orderedcode.Append(append([]byte{}, seekKey...), orderedcode.Infinity)
+		if err != nil {
+			return err
+		}
+	}
+
+	cursor, err := idx.tx.Cursor(!reverse)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+
+	if err := cursor.Seek(seekKey); err != nil {
+		return err
+	}
+
+	for ; cursor.Valid(); cursor.Next() {
+		item, err := cursor.Item()
+		if err != nil {
+			return err
+		}
+
+		if !bytes.HasPrefix(item.Key, prefixKey) {
+			return nil
+		}
+
+		key, docId := extractDocId(item.Key)
+
+		startBound, endBound := lowerBound, upperBound
+		if reverse {
+			startBound, endBound = upperBound, lowerBound
+		}
+
+		if startBound != nil && !startBound.included && bytes.HasPrefix(key, startBound.key) {
+			continue
+		}
+
+		if !endBound.satisfiedBy(key, reverse) {
+			return nil
+		}
+
+		if err := onRecord(string(docId), item.Value); err != nil {
+			if errors.Is(err, internal.ErrStopIteration) {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
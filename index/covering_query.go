@@ -0,0 +1,84 @@
+package index
+
+import (
+	d "github.com/ostafen/clover/v2/document"
+	"github.com/ostafen/clover/v2/internal"
+)
+
+// recordValueField is the key under which the indexed value is encoded in the value of an index record.
+const recordValueField = "v"
+
+// encodeRecordValue encodes the indexed value, so that it can be returned without reading the document.
+// Null values are not stored, since a missing field would be indistinguishable from a null one.
+func encodeRecordValue(v interface{}) ([]byte, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return internal.Encode(map[string]interface{}{recordValueField: v})
+}
+
+// decodeRecordValue returns the value stored in an index record.
+// The returned flag is false if the record holds no value, as it happens for null values or for records written by older versions.
+func decodeRecordValue(data []byte) (interface{}, bool, error) {
+	if len(data) == 0 {
+		return nil, false, nil
+	}
+
+	m := make(map[string]interface{})
+	if err := internal.Decode(data, &m); err != nil {
+		return nil, false, err
+	}
+
+	v, ok := m[recordValueField]
+	return v, ok, nil
+}
+
+// CoveringQuery is implemented by the queries which can return the values of the indexed fields without reading documents.
+type CoveringQuery interface {
+	Query
+	// Fields returns the fields whose values are stored in the index.
+	Fields() []string
+	// RunCovered calls onValue with a partial document, holding the id of each selected document and the values of the indexed fields.
+	// If such values are not available from the index, doc is nil, and the document must be read to know them.
+	RunCovered(onValue func(docId string, doc *d.Document) error) error
+}
+
+func newPartialDocument(docId string, fields []string, values []interface{}) *d.Document {
+	doc := d.NewDocument()
+	doc.Set(d.ObjectIdField, docId)
+	for i, field := range fields {
+		doc.Set(field, values[i])
+	}
+	return doc
+}
+
+func (q *RangeIndexQuery) Fields() []string {
+	return []string{q.Idx.Field()}
+}
+
+func (q *RangeIndexQuery) RunCovered(onValue func(docId string, doc *d.Document) error) error {
+	return q.Idx.IterateRangeValues(q.Range, q.Reverse, func(docId string, value interface{}, ok bool) error {
+		if !ok {
+			return onValue(docId, nil)
+		}
+		return onValue(docId, newPartialDocument(docId, q.Fields(), []interface{}{value}))
+	})
+}
+
+func (q *CompoundIndexQuery) Fields() []string {
+	fields := make([]string, 0, len(q.Idx.Fields()))
+	for _, opt := range q.Idx.Fields() {
+		fields = append(fields, opt.Field)
+	}
+	return fields
+}
+
+func (q *CompoundIndexQuery) RunCovered(onValue func(docId string, doc *d.Document) error) error {
+	fields := q.Fields()
+	return q.Idx.IteratePrefixRangeValues(q.Prefix, q.Range, q.Reverse, func(docId string, values []interface{}, ok bool) error {
+		if !ok {
+			return onValue(docId, nil)
+		}
+		return onValue(docId, newPartialDocument(docId, fields, values))
+	})
+}
@@ -0,0 +1,361 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/ostafen/clover/v2/internal"
+	"github.com/ostafen/clover/v2/query"
+	"github.com/ostafen/clover/v2/store"
+)
+
+// BM25 parameters: k1 controls term frequency saturation, while b controls document length normalization.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// FullTextIndex is an inverted index mapping the terms of a string field to the documents containing them.
+type FullTextIndex interface {
+	Index
+	TextField() string
+	// Search calls onMatch for each document containing all the supplied terms, in descending order of BM25 score.
+	Search(terms []string, onMatch func(docId string, score float64) error) error
+}
+
+// FullTextQuery selects the documents containing all the supplied terms, sorted by descending relevance.
+// If Scores is not nil, the score of each selected document is stored into it.
+type FullTextQuery struct {
+	Terms  []string
+	Idx    FullTextIndex
+	Scores map[string]float64
+}
+
+func (q *FullTextQuery) Run(onValue func(docId string) error) error {
+	return q.Idx.Search(q.Terms, func(docId string, score float64) error {
+		if q.Scores != nil {
+			q.Scores[docId] += score
+		}
+		return onValue(docId)
+	})
+}
+
+// FullTextIndexName returns the name of the full-text index built over the supplied field (for example, "title_text").
+func FullTextIndexName(field string) string {
+	return field + "_text"
+}
+
+// FullTextInfo returns the description of the full-text index built over the supplied field.
+func FullTextInfo(field string) Info {
+	return Info{
+		Field:  FullTextIndexName(field),
+		Type:   FullText,
+		Fields: []query.SortOption{{Field: field, Direction: 1}},
+	}
+}
+
+// fullTextIndex stores the following records under the index prefix:
+//
+//	t:<term>;<docId> -> frequency of the term in the document
+//	f:<term>         -> number of documents containing the term
+//	d:<docId>        -> number of terms of the document
+//	s                -> number of indexed documents and total number of terms
+type fullTextIndex struct {
+	indexBase
+	textField string
+	tx        store.Tx
+}
+
+func (idx *fullTextIndex) Type() Type {
+	return FullText
+}
+
+func (idx *fullTextIndex) TextField() string {
+	return idx.textField
+}
+
+func (idx *fullTextIndex) getKey(parts ...string) []byte {
+	key := getIndexKeyPrefix(idx.collection, idx.field)
+	for _, part := range parts {
+		key = append(key, part...)
+	}
+	return key
+}
+
+func (idx *fullTextIndex) postingKey(term, docId string) []byte {
+	return idx.getKey("t:", term, ";", docId)
+}
+
+func encodeCounters(counters ...uint64) []byte {
+	buf := make([]byte, len(counters)*binary.MaxVarintLen64)
+	n := 0
+	for _, c := range counters {
+		n += binary.PutUvarint(buf[n:], c)
+	}
+	return buf[:n]
+}
+
+func decodeCounters(data []byte, counters ...*uint64) error {
+	for _, c := range counters {
+		if len(data) == 0 { // missing records hold zero counters
+			*c = 0
+			continue
+		}
+
+		v, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errors.New("invalid full-text index record")
+		}
+		*c, data = v, data[n:]
+	}
+	return nil
+}
+
+func (idx *fullTextIndex) getCounters(key []byte, counters ...*uint64) error {
+	data, err := idx.tx.Get(key)
+	if err != nil {
+		return err
+	}
+	return decodeCounters(data, counters...)
+}
+
+// addCounter adds delta to the counter stored under key. The record is removed when the counter drops to zero.
+func (idx *fullTextIndex) addCounter(key []byte, delta int) error {
+	var c uint64
+	if err := idx.getCounters(key, &c); err != nil {
+		return err
+	}
+
+	c = uint64(int(c) + delta)
+	if c == 0 {
+		return idx.tx.Delete(key)
+	}
+	return idx.tx.Set(key, encodeCounters(c))
+}
+
+func (idx *fullTextIndex) updateStats(docsDelta, lenDelta int) error {
+	key := idx.getKey("s")
+
+	var nDocs, totalLen uint64
+	if err := idx.getCounters(key, &nDocs, &totalLen); err != nil {
+		return err
+	}
+	return idx.tx.Set(key, encodeCounters(uint64(int(nDocs)+docsDelta), uint64(int(totalLen)+lenDelta)))
+}
+
+// termFrequencies returns the number of occurrences of each term of the value, and the total number of terms.
+func termFrequencies(v interface{}) (map[string]int, int) {
+	terms := internal.Tokenize(query.SearchableText(v))
+
+	freqs := make(map[string]int)
+	for _, term := range terms {
+		freqs[term]++
+	}
+	return freqs, len(terms)
+}
+
+// Add indexes the terms of v. Every document gets a record, even if it contains no terms,
+// so that the number of indexed documents used for scoring matches the size of the collection.
+func (idx *fullTextIndex) Add(docId string, v interface{}, ttl time.Duration) error {
+	freqs, length := termFrequencies(v)
+
+	for term, freq := range freqs {
+		if err := idx.tx.Set(idx.postingKey(term, docId), encodeCounters(uint64(freq))); err != nil {
+			return err
+		}
+
+		if err := idx.addCounter(idx.getKey("f:", term), 1); err != nil {
+			return err
+		}
+	}
+
+	if err := idx.tx.Set(idx.getKey("d:", docId), encodeCounters(uint64(length))); err != nil {
+		return err
+	}
+	return idx.updateStats(1, length)
+}
+
+func (idx *fullTextIndex) Remove(docId string, v interface{}) error {
+	docKey := idx.getKey("d:", docId)
+
+	data, err := idx.tx.Get(docKey)
+	if err != nil || data == nil {
+		return err
+	}
+
+	var length uint64
+	if err := decodeCounters(data, &length); err != nil {
+		return err
+	}
+
+	freqs, _ := termFrequencies(v)
+	for term := range freqs {
+		if err := idx.tx.Delete(idx.postingKey(term, docId)); err != nil {
+			return err
+		}
+
+		if err := idx.addCounter(idx.getKey("f:", term), -1); err != nil {
+			return err
+		}
+	}
+
+	if err := idx.tx.Delete(docKey); err != nil {
+		return err
+	}
+	return idx.updateStats(-1, -int(length))
+}
+
+// iteratePrefix calls onRecord for each record whose key starts with prefix, passing the remaining part of the key.
+func (idx *fullTextIndex) iteratePrefix(prefix []byte, reverse bool, onRecord func(suffix string, value []byte) error) error {
+	cursor, err := idx.tx.Cursor(!reverse)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+
+	seekPrefix := prefix
+	if reverse {
+		seekPrefix = append(append([]byte{}, prefix...), 255)
+	}
+
+	for cursor.Seek(seekPrefix); cursor.Valid(); cursor.Next() {
+		item, err := cursor.Item()
+		if err != nil {
+			return err
+		}
+
+		if !bytes.HasPrefix(item.Key, prefix) {
+			return nil
+		}
+
+		if err := onRecord(string(item.Key[len(prefix):]), item.Value); err != nil {
+			if errors.Is(err, internal.ErrStopIteration) {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Iterate returns the ids of all the indexed documents, sorted by id.
+func (idx *fullTextIndex) Iterate(reverse bool, onValue func(docId string) error) error {
+	return idx.iteratePrefix(idx.getKey("d:"), reverse, func(docId string, _ []byte) error {
+		return onValue(docId)
+	})
+}
+
+func (idx *fullTextIndex) Drop() error {
+	return deletePrefix(idx.tx, idx.getKey())
+}
+
+type termStats struct {
+	term string
+	docs uint64
+}
+
+type searchResult struct {
+	docId string
+	score float64
+}
+
+func (idx *fullTextIndex) Search(terms []string, onMatch func(docId string, score float64) error) error {
+	var nDocs, totalLen uint64
+	if err := idx.getCounters(idx.getKey("s"), &nDocs, &totalLen); err != nil {
+		return err
+	}
+
+	stats := make([]termStats, 0, len(terms))
+	seen := make(map[string]bool)
+	for _, term := range terms {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+
+		var docs uint64
+		if err := idx.getCounters(idx.getKey("f:", term), &docs); err != nil {
+			return err
+		}
+
+		if docs == 0 { // all the terms are required
+			return nil
+		}
+		stats = append(stats, termStats{term: term, docs: docs})
+	}
+
+	if len(stats) == 0 || nDocs == 0 {
+		return nil
+	}
+
+	// candidates are taken from the rarest term, and then looked up in the postings of the others
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].docs < stats[j].docs
+	})
+
+	avgLen := float64(totalLen) / float64(nDocs)
+
+	results := make([]searchResult, 0, stats[0].docs)
+	err := idx.iteratePrefix(idx.getKey("t:", stats[0].term, ";"), false, func(docId string, value []byte) error {
+		var length uint64
+		if err := idx.getCounters(idx.getKey("d:", docId), &length); err != nil {
+			return err
+		}
+
+		score := 0.0
+		for i, st := range stats {
+			if i > 0 {
+				var err error
+				value, err = idx.tx.Get(idx.postingKey(st.term, docId))
+				if err != nil || value == nil {
+					return err
+				}
+			}
+
+			var freq uint64
+			if err := decodeCounters(value, &freq); err != nil {
+				return err
+			}
+
+			score += bm25(float64(freq), float64(length), avgLen, float64(st.docs), float64(nDocs))
+		}
+		results = append(results, searchResult{docId: docId, score: score})
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].score != results[j].score {
+			return results[i].score > results[j].score
+		}
+		return results[i].docId < results[j].docId
+	})
+
+	for _, res := range results {
+		if err := onMatch(res.docId, res.score); err != nil {
+			if errors.Is(err, internal.ErrStopIteration) {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// bm25 returns the contribution of a term, occurring freq times in a document of length docLen, to the score of the document.
+func bm25(freq, docLen, avgLen, docsWithTerm, nDocs float64) float64 {
+	idf := math.Log(1 + (nDocs-docsWithTerm+0.5)/(docsWithTerm+0.5))
+
+	norm := 1 - bm25B
+	if avgLen > 0 {
+		norm += bm25B * docLen / avgLen
+	}
+	return idf * freq * (bm25K1 + 1) / (freq + bm25K1*norm)
+}
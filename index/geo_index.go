@@ -0,0 +1,340 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/google/orderedcode"
+	"github.com/ostafen/clover/v2/internal"
+	"github.com/ostafen/clover/v2/query"
+	"github.com/ostafen/clover/v2/store"
+)
+
+// maxCellLevel is the level of the cells in which points are stored.
+// At level l, the surface is divided into 2^l x 2^l cells, each identified by the interleaved bits of its longitude and latitude indexes.
+const maxCellLevel = 32
+
+// GeoIndex is an index over a field holding points, which supports the selection of the points falling within a region.
+type GeoIndex interface {
+	Index
+	GeoField() string
+	// IterateRegion calls onPoint for each indexed point contained in the region.
+	IterateRegion(region *query.GeoRegion, onPoint func(docId string, lon, lat float64) error) error
+}
+
+// GeoQuery selects the documents whose point falls within Region.
+// Documents selected by a Near region are returned by increasing distance from its center.
+type GeoQuery struct {
+	Region *query.GeoRegion
+	Idx    GeoIndex
+}
+
+type geoMatch struct {
+	docId    string
+	distance float64
+}
+
+func (q *GeoQuery) Run(onValue func(docId string) error) error {
+	matches := make([]geoMatch, 0)
+	err := q.Idx.IterateRegion(q.Region, func(docId string, lon, lat float64) error {
+		m := geoMatch{docId: docId}
+		if q.Region.Op == query.NearOp {
+			m.distance = q.Region.Distance(lon, lat)
+		}
+		matches = append(matches, m)
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].distance != matches[j].distance {
+			return matches[i].distance < matches[j].distance
+		}
+		return matches[i].docId < matches[j].docId
+	})
+
+	for _, m := range matches {
+		if err := onValue(m.docId); err != nil {
+			if errors.Is(err, internal.ErrStopIteration) {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// GeoIndexName returns the name of the geospatial index built over the supplied field (for example, "geometry_geo").
+func GeoIndexName(field string) string {
+	return field + "_geo"
+}
+
+// GeoInfo returns the description of the geospatial index built over the supplied field.
+func GeoInfo(field string) Info {
+	return Info{
+		Field:  GeoIndexName(field),
+		Type:   Geospatial,
+		Fields: []query.SortOption{{Field: field, Direction: 1}},
+	}
+}
+
+// geoIndex stores the following records under the index prefix:
+//
+//	p:<cell><docId> -> longitude and latitude of the point
+//	n:<docId>       -> empty, for documents whose field is not a point
+//
+// Cells are encoded using orderedcode, so that the points of each cell (and of its sub-cells) are stored contiguously.
+type geoIndex struct {
+	indexBase
+	geoField string
+	tx       store.Tx
+}
+
+func (idx *geoIndex) Type() Type {
+	return Geospatial
+}
+
+func (idx *geoIndex) GeoField() string {
+	return idx.geoField
+}
+
+func (idx *geoIndex) getKeyPrefix() []byte {
+	return getIndexKeyPrefix(idx.collection, idx.field)
+}
+
+func (idx *geoIndex) pointsPrefix() []byte {
+	return append(idx.getKeyPrefix(), "p:"...)
+}
+
+func (idx *geoIndex) cellKey(cell uint64) ([]byte, error) {
+	return orderedcode.Append(idx.pointsPrefix(), cell)
+}
+
+func (idx *geoIndex) getKey(docId string, v interface{}) ([]byte, []byte, error) {
+	lon, lat, isPoint := internal.GeoPoint(v)
+	if !isPoint {
+		return append(append(idx.getKeyPrefix(), "n:"...), docId...), nil, nil
+	}
+
+	key, err := idx.cellKey(pointCell(lon, lat))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	value := make([]byte, 16)
+	binary.BigEndian.PutUint64(value, math.Float64bits(lon))
+	binary.BigEndian.PutUint64(value[8:], math.Float64bits(lat))
+	return append(key, docId...), value, nil
+}
+
+func (idx *geoIndex) Add(docId string, v interface{}, ttl time.Duration) error {
+	key, value, err := idx.getKey(docId, v)
+	if err != nil {
+		return err
+	}
+	return idx.tx.Set(key, value)
+}
+
+func (idx *geoIndex) Remove(docId string, v interface{}) error {
+	key, _, err := idx.getKey(docId, v)
+	if err != nil {
+		return err
+	}
+	return idx.tx.Delete(key)
+}
+
+func (idx *geoIndex) Drop() error {
+	return deletePrefix(idx.tx, idx.getKeyPrefix())
+}
+
+// Iterate returns the ids of all the indexed documents. Points are returned in cell order, followed by the other documents.
+func (idx *geoIndex) Iterate(reverse bool, onValue func(docId string) error) error {
+	cursor, err := idx.tx.Cursor(!reverse)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+
+	prefix := idx.getKeyPrefix()
+
+	seekPrefix := prefix
+	if reverse {
+		seekPrefix = append(seekPrefix, 255)
+	}
+
+	for cursor.Seek(seekPrefix); cursor.Valid(); cursor.Next() {
+		item, err := cursor.Item()
+		if err != nil {
+			return err
+		}
+
+		if !bytes.HasPrefix(item.Key, prefix) {
+			return nil
+		}
+
+		_, docId := extractDocId(item.Key)
+		if err := onValue(string(docId)); err != nil {
+			if errors.Is(err, internal.ErrStopIteration) {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (idx *geoIndex) IterateRegion(region *query.GeoRegion, onPoint func(docId string, lon, lat float64) error) error {
+	ranges := make([]cellRange, 0)
+	for _, box := range region.BoundingBox().Split() {
+		ranges = coverBox(ranges, box, 0, 0, 0, coverLevel(box))
+	}
+
+	for _, r := range mergeCellRanges(ranges) {
+		if err := idx.iterateCellRange(r, region, onPoint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (idx *geoIndex) iterateCellRange(r cellRange, region *query.GeoRegion, onPoint func(docId string, lon, lat float64) error) error {
+	startKey, err := idx.cellKey(r.start)
+	if err != nil {
+		return err
+	}
+
+	cursor, err := idx.tx.Cursor(true)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+
+	prefix := idx.pointsPrefix()
+	for cursor.Seek(startKey); cursor.Valid(); cursor.Next() {
+		item, err := cursor.Item()
+		if err != nil {
+			return err
+		}
+
+		if !bytes.HasPrefix(item.Key, prefix) {
+			return nil
+		}
+
+		var cell uint64
+		docId, err := orderedcode.Parse(string(item.Key[len(prefix):]), &cell)
+		if err != nil {
+			return err
+		}
+
+		if cell > r.end {
+			return nil
+		}
+
+		if len(item.Value) != 16 {
+			return errors.New("invalid geospatial index record")
+		}
+
+		lon := math.Float64frombits(binary.BigEndian.Uint64(item.Value))
+		lat := math.Float64frombits(binary.BigEndian.Uint64(item.Value[8:]))
+		if !region.Contains(lon, lat) {
+			continue
+		}
+
+		if err := onPoint(docId, lon, lat); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cellIndex maps a coordinate in [min, max] to the index of its cell at the maximum level.
+func cellIndex(v, min, max float64) uint64 {
+	i := math.Floor((v - min) / (max - min) * (1 << maxCellLevel))
+	return uint64(math.Max(0, math.Min(i, (1<<maxCellLevel)-1)))
+}
+
+// interleave spreads the 32 bits of x over the even bits of the result.
+func interleave(x uint64) uint64 {
+	x = (x | x<<16) & 0x0000FFFF0000FFFF
+	x = (x | x<<8) & 0x00FF00FF00FF00FF
+	x = (x | x<<4) & 0x0F0F0F0F0F0F0F0F
+	x = (x | x<<2) & 0x3333333333333333
+	x = (x | x<<1) & 0x5555555555555555
+	return x
+}
+
+// pointCell returns the cell of the maximum level containing the point.
+func pointCell(lon, lat float64) uint64 {
+	return interleave(cellIndex(lon, -180, 180))<<1 | interleave(cellIndex(lat, -90, 90))
+}
+
+// cellRange is an interval of cells of the maximum level, bounds included.
+type cellRange struct {
+	start, end uint64
+}
+
+// coverLevel returns the level at which the decomposition of the box stops,
+// chosen so that the box spans a few cells in its largest dimension.
+func coverLevel(box internal.GeoBox) int {
+	ratio := math.Min(360/math.Max(box.MaxLon-box.MinLon, 1e-9), 180/math.Max(box.MaxLat-box.MinLat, 1e-9))
+
+	level := int(math.Log2(ratio)) + 2
+	if level > maxCellLevel {
+		return maxCellLevel
+	}
+	return level
+}
+
+// coverBox appends to ranges the cells intersecting the box, by recursively splitting the cell (x, y) of the supplied level.
+// Cells fully contained in the box, as well as cells of the maximum level, are not split further.
+func coverBox(ranges []cellRange, box internal.GeoBox, x, y uint64, level, maxLevel int) []cellRange {
+	n := float64(uint64(1) << uint(level))
+	cellBox := internal.GeoBox{
+		MinLon: -180 + float64(x)*360/n,
+		MaxLon: -180 + float64(x+1)*360/n,
+		MinLat: -90 + float64(y)*180/n,
+		MaxLat: -90 + float64(y+1)*180/n,
+	}
+
+	if cellBox.MinLon > box.MaxLon || cellBox.MaxLon < box.MinLon || cellBox.MinLat > box.MaxLat || cellBox.MaxLat < box.MinLat {
+		return ranges
+	}
+
+	inside := cellBox.MinLon >= box.MinLon && cellBox.MaxLon <= box.MaxLon && cellBox.MinLat >= box.MinLat && cellBox.MaxLat <= box.MaxLat
+	if inside || level >= maxLevel {
+		shift := uint(2 * (maxCellLevel - level))
+		start := (interleave(x)<<1 | interleave(y)) << shift
+		return append(ranges, cellRange{start: start, end: start + (uint64(1)<<shift - 1)})
+	}
+
+	for _, child := range [][2]uint64{{0, 0}, {0, 1}, {1, 0}, {1, 1}} {
+		ranges = coverBox(ranges, box, 2*x+child[0], 2*y+child[1], level+1, maxLevel)
+	}
+	return ranges
+}
+
+// mergeCellRanges sorts ranges, merging the overlapping and adjacent ones.
+func mergeCellRanges(ranges []cellRange) []cellRange {
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].start < ranges[j].start
+	})
+
+	merged := make([]cellRange, 0, len(ranges))
+	for _, r := range ranges {
+		if last := len(merged) - 1; last >= 0 && (merged[last].end == math.MaxUint64 || r.start <= merged[last].end+1) {
+			if r.end > merged[last].end {
+				merged[last].end = r.end
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
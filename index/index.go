@@ -1,8 +1,11 @@
 package index
 
 import (
+	"bytes"
+	"fmt"
 	"time"
 
+	"github.com/ostafen/clover/v2/query"
 	"github.com/ostafen/clover/v2/store"
 )
 
@@ -10,11 +13,17 @@ type Type int
 
 const (
 	SingleField Type = iota
+	Compound
+	FullText
+	Geospatial
 )
 
+// Info describes an index. For compound, full-text and geospatial indexes, Field holds the index name, while Fields lists the indexed fields.
 type Info struct {
-	Field string
-	Type  Type
+	Field  string
+	Type   Type
+	Unique bool
+	Fields []query.SortOption
 }
 
 type Index interface {
@@ -25,10 +34,12 @@ type Index interface {
 	Type() Type
 	Collection() string
 	Field() string
+	Unique() bool
 }
 
 type indexBase struct {
 	collection, field string
+	unique            bool
 }
 
 func (idx *indexBase) Collection() string {
@@ -39,18 +50,84 @@ func (idx *indexBase) Field() string {
 	return idx.field
 }
 
+// Unique returns true if the index does not allow two documents to share the same (non null) value.
+func (idx *indexBase) Unique() bool {
+	return idx.unique
+}
+
+// getIndexKeyPrefix returns the prefix shared by all the records of an index.
+// The trailing separator prevents the records of an index from matching the prefix of another index whose name starts with the same characters.
+func getIndexKeyPrefix(collection, name string) []byte {
+	return []byte(fmt.Sprintf("c:%s;i:%s;", collection, name))
+}
+
+// deletePrefix removes all the keys starting with the given prefix.
+// Keys are collected before being deleted, since some stores do not support deleting keys while iterating.
+func deletePrefix(tx store.Tx, prefix []byte) error {
+	cursor, err := tx.Cursor(true)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+
+	keys := make([][]byte, 0)
+	for cursor.Seek(prefix); cursor.Valid(); cursor.Next() {
+		item, err := cursor.Item()
+		if err != nil {
+			return err
+		}
+
+		if !bytes.HasPrefix(item.Key, prefix) {
+			break
+		}
+		keys = append(keys, append([]byte{}, item.Key...))
+	}
+
+	for _, key := range keys {
+		if err := tx.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 type Query interface {
 	Run(onValue func(docId string) error) error
 }
 
+// CreateIndex returns the index of the supplied type built over a single field.
+// Indexes requiring a description of their fields, such as compound indexes, must be created through NewIndex.
 func CreateIndex(collection, field string, idxType Type, tx store.Tx) Index {
-	indexBase := indexBase{collection: collection, field: field}
-	switch idxType {
+	return NewIndex(collection, Info{Field: field, Type: idxType, Fields: []query.SortOption{{Field: field, Direction: 1}}}, tx)
+}
+
+// NewIndex returns the index described by info.
+func NewIndex(collection string, info Info, tx store.Tx) Index {
+	indexBase := indexBase{collection: collection, field: info.Field, unique: info.Unique}
+	switch info.Type {
 	case SingleField:
 		return &rangeIndex{
 			indexBase: indexBase,
 			tx:        tx,
 		}
+	case Compound:
+		return &compoundIndex{
+			indexBase: indexBase,
+			fields:    normalizeDirections(info.Fields),
+			tx:        tx,
+		}
+	case FullText:
+		return &fullTextIndex{
+			indexBase: indexBase,
+			textField: info.Fields[0].Field,
+			tx:        tx,
+		}
+	case Geospatial:
+		return &geoIndex{
+			indexBase: indexBase,
+			geoField:  info.Fields[0].Field,
+			tx:        tx,
+		}
 	}
 	return nil
 }
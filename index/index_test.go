@@ -0,0 +1,22 @@
+package index
+
+import (
+	"testing"
+
+	"github.com/ostafen/clover/v2/query"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateIndex(t *testing.T) {
+	idx := CreateIndex("todos", "userId", SingleField, nil)
+	require.Equal(t, NewIndex("todos", Info{Field: "userId", Type: SingleField}, nil), idx)
+	require.Equal(t, "userId", idx.Field())
+	require.False(t, idx.Unique())
+
+	idx = CreateIndex("todos", "title", FullText, nil)
+	require.Equal(t, "title", idx.(FullTextIndex).TextField())
+
+	idx = NewIndex("todos", Info{Field: "a_1_b_-1", Type: Compound, Fields: []query.SortOption{{Field: "a", Direction: 1}, {Field: "b", Direction: -1}}}, nil)
+	require.Equal(t, Compound, idx.Type())
+	require.Equal(t, "a_1_b_-1", idx.Field())
+}
@@ -14,6 +14,7 @@ import (
 type RangeIndex interface {
 	Index
 	IterateRange(vRange *Range, reverse bool, onValue func(docId string) error) error
+	IterateRangeValues(vRange *Range, reverse bool, onValue func(docId string, value interface{}, ok bool) error) error
 }
 
 type RangeIndexQuery struct {
@@ -42,11 +43,11 @@ func extractDocId(key []byte) ([]byte, []byte) {
 }
 
 func (idx *rangeIndex) getKeyPrefix() []byte {
-	return []byte(fmt.Sprintf("c:%s;i:%s", idx.collection, idx.field))
+	return getIndexKeyPrefix(idx.collection, idx.field)
 }
 
 func (idx *rangeIndex) getKeyPrefixForType(typeId int) []byte {
-	return []byte(fmt.Sprintf("%s;t:%d;v:", idx.getKeyPrefix(), typeId))
+	return []byte(fmt.Sprintf("%st:%d;v:", idx.getKeyPrefix(), typeId))
 }
 
 func (idx *rangeIndex) getKey(v interface{}) ([]byte, error) {
@@ -68,7 +69,12 @@ func (idx *rangeIndex) Add(docId string, v interface{}, ttl time.Duration) error
 	if err != nil {
 		return err
 	}
-	return idx.tx.Set(encodedKey, nil)
+
+	value, err := encodeRecordValue(v)
+	if err != nil {
+		return err
+	}
+	return idx.tx.Set(encodedKey, value)
 }
 
 func (idx *rangeIndex) Remove(docId string, value interface{}) error {
@@ -80,29 +86,7 @@ func (idx *rangeIndex) Remove(docId string, value interface{}) error {
 }
 
 func (idx *rangeIndex) Drop() error {
-	cursor, err := idx.tx.Cursor(true)
-	if err != nil {
-		return err
-	}
-	defer cursor.Close()
-
-	prefix := idx.getKeyPrefix()
-	cursor.Seek(prefix)
-	for ; cursor.Valid(); cursor.Next() {
-		item, err := cursor.Item()
-		if err != nil {
-			return err
-		}
-
-		if !bytes.HasPrefix(item.Key, prefix) {
-			return nil
-		}
-
-		if err := idx.tx.Delete(item.Key); err != nil {
-			return err
-		}
-	}
-	return nil
+	return deletePrefix(idx.tx, idx.getKeyPrefix())
 }
 
 func (idx *rangeIndex) encodeRange(vRange *Range) ([]byte, []byte, error) {
@@ -127,6 +111,29 @@ func (idx *rangeIndex) encodeRange(vRange *Range) ([]byte, []byte, error) {
 }
 
 func (idx *rangeIndex) IterateRange(vRange *Range, reverse bool, onValue func(docId string) error) error {
+	return idx.iterateRange(vRange, reverse, func(docId string, _ []byte) error {
+		return onValue(docId)
+	})
+}
+
+// IterateRangeValues is like IterateRange, but it also supplies the value stored in each record.
+// A nil range selects all the records of the index.
+func (idx *rangeIndex) IterateRangeValues(vRange *Range, reverse bool, onValue func(docId string, value interface{}, ok bool) error) error {
+	onRecord := func(docId string, data []byte) error {
+		value, ok, err := decodeRecordValue(data)
+		if err != nil {
+			return err
+		}
+		return onValue(docId, value, ok)
+	}
+
+	if vRange == nil {
+		return idx.iterate(reverse, onRecord)
+	}
+	return idx.iterateRange(vRange, reverse, onRecord)
+}
+
+func (idx *rangeIndex) iterateRange(vRange *Range, reverse bool, onRecord func(docId string, data []byte) error) error {
 	if vRange.IsEmpty() {
 		return nil
 	}
@@ -137,8 +144,11 @@ func (idx *rangeIndex) IterateRange(vRange *Range, reverse bool, onValue func(do
 	}
 
 	seekPrefix := startKey
-	if reverse {
-		seekPrefix = endKey
+	if reverse && endKey != nil {
+		// position the cursor after all the records whose value is equal to range.end
+		seekPrefix = append(append([]byte{}, endKey...), 255)
+	} else if reverse {
+		seekPrefix = nil
 	}
 
 	if seekPrefix == nil {
@@ -210,7 +220,7 @@ func (idx *rangeIndex) IterateRange(vRange *Range, reverse bool, onValue func(do
 			}
 		}
 
-		if err := onValue(string(docId)); err != nil {
+		if err := onRecord(string(docId), item.Value); err != nil {
 			if errors.Is(err, internal.ErrStopIteration) {
 				return nil
 			}
@@ -221,6 +231,12 @@ func (idx *rangeIndex) IterateRange(vRange *Range, reverse bool, onValue func(do
 }
 
 func (idx *rangeIndex) Iterate(reverse bool, onValue func(docId string) error) error {
+	return idx.iterate(reverse, func(docId string, _ []byte) error {
+		return onValue(docId)
+	})
+}
+
+func (idx *rangeIndex) iterate(reverse bool, onRecord func(docId string, data []byte) error) error {
 	opts := badger.DefaultIteratorOptions
 	opts.Reverse = reverse
 
@@ -251,7 +267,7 @@ func (idx *rangeIndex) Iterate(reverse bool, onValue func(docId string) error) e
 		}
 
 		_, docId := extractDocId(key)
-		if err := onValue(string(docId)); err != nil {
+		if err := onRecord(string(docId), item.Value); err != nil {
 			if errors.Is(err, internal.ErrStopIteration) {
 				return nil
 			}
@@ -0,0 +1,79 @@
+package index
+
+import (
+	"errors"
+
+	"github.com/ostafen/clover/v2/internal"
+)
+
+// UnionQuery selects the documents returned by at least one of its queries.
+// Each document id is emitted only once, in order of first appearance.
+type UnionQuery struct {
+	Queries []Query
+}
+
+func (q *UnionQuery) Run(onValue func(docId string) error) error {
+	seen := make(map[string]bool)
+	stopped := false
+
+	onNewValue := func(docId string) error {
+		if seen[docId] {
+			return nil
+		}
+		seen[docId] = true
+
+		err := onValue(docId)
+		if errors.Is(err, internal.ErrStopIteration) {
+			stopped = true
+		}
+		return err
+	}
+
+	for _, subQuery := range q.Queries {
+		if err := subQuery.Run(onNewValue); err != nil || stopped {
+			return err
+		}
+	}
+	return nil
+}
+
+// IntersectionQuery selects the documents returned by all of its queries.
+// The ids selected by all the queries but the last one are kept in memory, while the last query is streamed.
+type IntersectionQuery struct {
+	Queries []Query
+}
+
+func (q *IntersectionQuery) Run(onValue func(docId string) error) error {
+	if len(q.Queries) == 0 {
+		return nil
+	}
+
+	var candidates map[string]bool
+	for _, subQuery := range q.Queries[:len(q.Queries)-1] {
+		selected := make(map[string]bool)
+		err := subQuery.Run(func(docId string) error {
+			if candidates == nil || candidates[docId] {
+				selected[docId] = true
+			}
+			return nil
+		})
+
+		if err != nil {
+			return err
+		}
+
+		candidates = selected
+		if len(candidates) == 0 {
+			return nil
+		}
+	}
+
+	emitted := make(map[string]bool)
+	return q.Queries[len(q.Queries)-1].Run(func(docId string) error {
+		if (candidates != nil && !candidates[docId]) || emitted[docId] {
+			return nil
+		}
+		emitted[docId] = true
+		return onValue(docId)
+	})
+}
@@ -0,0 +1,119 @@
+package clover
+
+import (
+	"bytes"
+	"fmt"
+
+	d "github.com/ostafen/clover/v2/document"
+	"github.com/ostafen/clover/v2/store"
+)
+
+// IntegrityIssue describes an inconsistency found by CheckIntegrity.
+type IntegrityIssue struct {
+	Collection string
+	// Index is the name of the index the issue refers to, if any.
+	Index string
+	// DocumentId is the id of the document the issue refers to, if any.
+	DocumentId string
+	Message    string
+}
+
+func (issue IntegrityIssue) String() string {
+	s := "collection " + issue.Collection
+	if issue.Index != "" {
+		s += ", index " + issue.Index
+	}
+
+	if issue.DocumentId != "" {
+		s += ", document " + issue.DocumentId
+	}
+	return s + ": " + issue.Message
+}
+
+// CheckIntegrity checks that the stored documents are well formed, that the size recorded for each collection is correct,
+// and that each index contains exactly one record for each document of its collection. It returns the inconsistencies which have been found.
+func (db *DB) CheckIntegrity() ([]IntegrityIssue, error) {
+	tx, err := db.store.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	collections, err := listCollections(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	issues := make([]IntegrityIssue, 0)
+	for _, coll := range collections {
+		collIssues, err := db.checkCollectionIntegrity(tx, coll.Name, coll.Meta)
+		if err != nil {
+			return nil, err
+		}
+		issues = append(issues, collIssues...)
+	}
+	return issues, nil
+}
+
+func (db *DB) checkCollectionIntegrity(tx store.Tx, collection string, meta *collectionMetadata) ([]IntegrityIssue, error) {
+	issues := make([]IntegrityIssue, 0)
+	report := func(index, docId, format string, args ...interface{}) {
+		issues = append(issues, IntegrityIssue{Collection: collection, Index: index, DocumentId: docId, Message: fmt.Sprintf(format, args...)})
+	}
+
+	// expired documents which have not been purged yet are still part of the collection, as well as their index records
+	docIds := make(map[string]bool)
+	prefix := []byte(getDocumentKeyPrefix(collection))
+	err := iteratePrefix(prefix, tx, func(item store.Item) error {
+		keyId := string(bytes.TrimPrefix(item.Key, prefix))
+		docIds[keyId] = true
+
+		doc, err := d.Decode(item.Value)
+		if err != nil {
+			report("", keyId, "cannot decode document: %s", err.Error())
+			return nil
+		}
+
+		if err := d.Validate(doc); err != nil {
+			report("", keyId, "invalid document: %s", err.Error())
+		} else if doc.ObjectId() != keyId {
+			report("", keyId, "document is stored with the id %s", doc.ObjectId())
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(docIds) != meta.Size {
+		report("", "", "the recorded size is %d, but %d documents are stored", meta.Size, len(docIds))
+	}
+
+	for _, idx := range db.getIndexes(tx, collection, meta) {
+		indexed := make(map[string]int)
+		err := idx.Iterate(false, func(docId string) error {
+			indexed[docId]++
+			return nil
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		for docId, n := range indexed {
+			if !docIds[docId] {
+				report(idx.Field(), docId, "index record refers to a missing document")
+			} else if n > 1 {
+				report(idx.Field(), docId, "document has %d index records", n)
+			}
+		}
+
+		for docId := range docIds {
+			if indexed[docId] == 0 {
+				report(idx.Field(), docId, "document is not indexed")
+			}
+		}
+	}
+	return issues, nil
+}
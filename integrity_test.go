@@ -0,0 +1,74 @@
+package clover
+
+import (
+	"os"
+	"testing"
+
+	d "github.com/ostafen/clover/v2/document"
+	"github.com/ostafen/clover/v2/query"
+	"github.com/ostafen/clover/v2/store"
+	badgerstore "github.com/ostafen/clover/v2/store/badger"
+	"github.com/ostafen/clover/v2/store/bbolt"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckIntegrity(t *testing.T) {
+	for _, openStore := range []func(string) (store.Store, error){badgerstore.Open, bbolt.Open} {
+		dir, err := os.MkdirTemp("", "clover-test")
+		require.NoError(t, err)
+
+		dataStore, err := openStore(dir)
+		require.NoError(t, err)
+
+		db, err := OpenWithStore(dataStore)
+		require.NoError(t, err)
+
+		require.NoError(t, db.CreateCollection("test"))
+		require.NoError(t, db.CreateIndex("test", "n"))
+		require.NoError(t, db.CreateCompoundIndex("test", query.SortOption{Field: "n", Direction: 1}, query.SortOption{Field: "m", Direction: -1}))
+
+		ids := make([]string, 0)
+		for i := 0; i < 10; i++ {
+			id, err := db.InsertOne("test", d.NewDocumentOf(map[string]interface{}{"n": i, "m": i % 3}))
+			require.NoError(t, err)
+			ids = append(ids, id)
+		}
+
+		issues, err := db.CheckIntegrity()
+		require.NoError(t, err)
+		require.Empty(t, issues)
+
+		tx, err := dataStore.Begin(true)
+		require.NoError(t, err)
+
+		// remove a document, without updating indexes and metadata
+		require.NoError(t, tx.Delete([]byte(getDocumentKey("test", ids[0]))))
+
+		// store a document under a wrong key
+		doc, err := getDocumentById("test", ids[1], tx)
+		require.NoError(t, err)
+
+		data, err := d.Encode(doc)
+		require.NoError(t, err)
+		require.NoError(t, tx.Set([]byte(getDocumentKey("test", ids[2])), data))
+		require.NoError(t, tx.Commit())
+
+		issues, err = db.CheckIntegrity()
+		require.NoError(t, err)
+
+		messages := make([]string, 0)
+		for _, issue := range issues {
+			messages = append(messages, issue.String())
+		}
+
+		require.ElementsMatch(t, []string{
+			"collection test, document " + ids[2] + ": document is stored with the id " + ids[1],
+			"collection test: the recorded size is 10, but 9 documents are stored",
+			"collection test, index n, document " + ids[0] + ": index record refers to a missing document",
+			"collection test, index n_1_m_-1, document " + ids[0] + ": index record refers to a missing document",
+		}, messages)
+
+		require.NoError(t, db.Close())
+		require.NoError(t, os.RemoveAll(dir))
+	}
+}
@@ -0,0 +1,140 @@
+package internal
+
+import "math"
+
+// EarthRadius is the mean radius of the Earth, in meters.
+const EarthRadius = 6371008.8
+
+// GeoPoint returns the longitude and latitude of a point, which is represented either as a GeoJSON Point object,
+// such as {"type": "Point", "coordinates": [lon, lat]}, or as an array of coordinates. Additional coordinates (such as the altitude) are ignored.
+func GeoPoint(v interface{}) (float64, float64, bool) {
+	if obj, isObject := v.(map[string]interface{}); isObject {
+		if obj["type"] != "Point" {
+			return 0, 0, false
+		}
+		v = obj["coordinates"]
+	}
+
+	coords, isSlice := v.([]interface{})
+	if !isSlice || len(coords) < 2 {
+		return 0, 0, false
+	}
+
+	lon, lonOk := toFloat64(coords[0])
+	lat, latOk := toFloat64(coords[1])
+	if !lonOk || !latOk || lon < -180 || lon > 180 || lat < -90 || lat > 90 {
+		return 0, 0, false
+	}
+	return lon, lat, true
+}
+
+func toFloat64(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+func toRadians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+func toDegrees(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
+
+// GeoDistance returns the great-circle distance between two points, in meters, computed using the haversine formula.
+func GeoDistance(lon1, lat1, lon2, lat2 float64) float64 {
+	dLat := toRadians(lat2 - lat1)
+	dLon := toRadians(lon2 - lon1)
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(toRadians(lat1))*math.Cos(toRadians(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * EarthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// GeoBox is a rectangle delimited by two meridians and two parallels, described by its south-west and north-east corners.
+// A box whose minimum longitude is greater than the maximum one crosses the antimeridian.
+type GeoBox struct {
+	MinLon, MinLat, MaxLon, MaxLat float64
+}
+
+// Contains reports whether the box contains the point, borders included.
+func (b GeoBox) Contains(lon, lat float64) bool {
+	if lat < b.MinLat || lat > b.MaxLat {
+		return false
+	}
+
+	if b.MinLon <= b.MaxLon {
+		return lon >= b.MinLon && lon <= b.MaxLon
+	}
+	return lon >= b.MinLon || lon <= b.MaxLon
+}
+
+// Split returns the boxes obtained by splitting the box at the antimeridian, so that each of them has MinLon <= MaxLon.
+func (b GeoBox) Split() []GeoBox {
+	if b.MinLon <= b.MaxLon {
+		return []GeoBox{b}
+	}
+	return []GeoBox{
+		{MinLon: b.MinLon, MinLat: b.MinLat, MaxLon: 180, MaxLat: b.MaxLat},
+		{MinLon: -180, MinLat: b.MinLat, MaxLon: b.MaxLon, MaxLat: b.MaxLat},
+	}
+}
+
+// GeoCircleBox returns the smallest box containing all the points whose distance from the center is at most radius meters.
+func GeoCircleBox(lon, lat, radius float64) GeoBox {
+	angle := radius / EarthRadius
+	dLat := toDegrees(angle)
+
+	box := GeoBox{MinLon: -180, MinLat: math.Max(lat-dLat, -90), MaxLon: 180, MaxLat: math.Min(lat+dLat, 90)}
+
+	// circles containing a pole span all meridians
+	if box.MinLat == -90 || box.MaxLat == 90 || angle >= math.Pi/2 {
+		return box
+	}
+
+	ratio := math.Sin(angle) / math.Cos(toRadians(lat))
+	if ratio >= 1 {
+		return box
+	}
+
+	dLon := toDegrees(math.Asin(ratio))
+	box.MinLon, box.MaxLon = lon-dLon, lon+dLon
+	if box.MinLon < -180 {
+		box.MinLon += 360
+	}
+	if box.MaxLon > 180 {
+		box.MaxLon -= 360
+	}
+	return box
+}
+
+// GeoPolygonBox returns the smallest box (not crossing the antimeridian) containing the supplied vertices.
+func GeoPolygonBox(vertices [][2]float64) GeoBox {
+	box := GeoBox{MinLon: 180, MinLat: 90, MaxLon: -180, MaxLat: -90}
+	for _, v := range vertices {
+		box.MinLon, box.MaxLon = math.Min(box.MinLon, v[0]), math.Max(box.MaxLon, v[0])
+		box.MinLat, box.MaxLat = math.Min(box.MinLat, v[1]), math.Max(box.MaxLat, v[1])
+	}
+	return box
+}
+
+// InGeoPolygon reports whether the point lies inside the polygon, whose edges are treated as straight lines in the longitude/latitude plane.
+// The polygon is implicitly closed, and points lying on its border may be considered either inside or outside.
+func InGeoPolygon(lon, lat float64, vertices [][2]float64) bool {
+	inside := false
+	for i, j := 0, len(vertices)-1; i < len(vertices); j, i = i, i+1 {
+		xi, yi := vertices[i][0], vertices[i][1]
+		xj, yj := vertices[j][0], vertices[j][1]
+
+		if (yi > lat) != (yj > lat) && lon < (xj-xi)*(lat-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
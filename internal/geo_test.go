@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeoPoint(t *testing.T) {
+	lon, lat, ok := GeoPoint(map[string]interface{}{"type": "Point", "coordinates": []interface{}{-116.7, 33.4, 12.2}})
+	require.True(t, ok)
+	require.Equal(t, -116.7, lon)
+	require.Equal(t, 33.4, lat)
+
+	_, _, ok = GeoPoint([]interface{}{int64(10), uint64(20)})
+	require.True(t, ok)
+
+	_, _, ok = GeoPoint(map[string]interface{}{"type": "LineString", "coordinates": []interface{}{1.0, 2.0}})
+	require.False(t, ok)
+
+	_, _, ok = GeoPoint([]interface{}{10.0, 95.0})
+	require.False(t, ok)
+
+	_, _, ok = GeoPoint("10, 20")
+	require.False(t, ok)
+}
+
+func TestGeoDistance(t *testing.T) {
+	// Rome - Paris
+	require.InDelta(t, 1105000, GeoDistance(12.4964, 41.9028, 2.3522, 48.8566), 5000)
+	require.InDelta(t, 0, GeoDistance(10, 10, 10, 10), 1e-9)
+	require.InDelta(t, 2*EarthRadius*1.5707963, GeoDistance(179.5, 0, -0.5, 0), 1)
+}
+
+func TestGeoCircleBox(t *testing.T) {
+	box := GeoCircleBox(179, 0, 300000)
+	require.Greater(t, box.MinLon, box.MaxLon)
+	require.True(t, box.Contains(-179, 0))
+	require.True(t, box.Contains(178, 1))
+	require.False(t, box.Contains(0, 0))
+
+	require.Len(t, box.Split(), 2)
+
+	box = GeoCircleBox(0, 89, 300000)
+	require.Equal(t, GeoBox{MinLon: -180, MinLat: box.MinLat, MaxLon: 180, MaxLat: 90}, box)
+}
+
+func TestInGeoPolygon(t *testing.T) {
+	triangle := [][2]float64{{0, 0}, {10, 0}, {0, 10}}
+	require.True(t, InGeoPolygon(2, 2, triangle))
+	require.False(t, InGeoPolygon(6, 6, triangle))
+	require.False(t, InGeoPolygon(-1, 2, triangle))
+}
@@ -0,0 +1,242 @@
+package internal
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Tokenize splits text into words, which are lowercased and reduced to their stem,
+// so that different inflections of the same word (such as "connected" and "connection") produce the same term.
+// Words are maximal sequences of letters and digits.
+func Tokenize(text string) []string {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	terms := make([]string, 0, len(words))
+	for _, word := range words {
+		terms = append(terms, Stem(strings.ToLower(word)))
+	}
+	return terms
+}
+
+// Stem reduces a lowercase English word to its stem, according to the Porter stemming algorithm.
+// Words containing characters other than ASCII letters are returned unchanged.
+func Stem(word string) string {
+	if len(word) <= 2 {
+		return word
+	}
+
+	for i := 0; i < len(word); i++ {
+		if word[i] < 'a' || word[i] > 'z' {
+			return word
+		}
+	}
+
+	s := &stemmer{b: []byte(word)}
+	s.step1a()
+	s.step1b()
+	s.step1c()
+	s.replaceSuffix(step2Suffixes, 0)
+	s.replaceSuffix(step3Suffixes, 0)
+	s.step4()
+	s.step5()
+	return string(s.b)
+}
+
+type stemmer struct {
+	b []byte
+}
+
+// isConsonant reports whether the i-th letter is a consonant. The letter y is a consonant unless it follows a consonant.
+func (s *stemmer) isConsonant(i int) bool {
+	switch s.b[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		return i == 0 || !s.isConsonant(i-1)
+	}
+	return true
+}
+
+// measure returns the number of vowel-consonant sequences in the first n letters.
+func (s *stemmer) measure(n int) int {
+	m, i := 0, 0
+	for i < n && s.isConsonant(i) {
+		i++
+	}
+
+	for i < n {
+		for i < n && !s.isConsonant(i) {
+			i++
+		}
+
+		if i == n {
+			break
+		}
+
+		for i < n && s.isConsonant(i) {
+			i++
+		}
+		m++
+	}
+	return m
+}
+
+func (s *stemmer) hasVowel(n int) bool {
+	for i := 0; i < n; i++ {
+		if !s.isConsonant(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// endsWithDoubleConsonant reports whether the first n letters end with two equal consonants.
+func (s *stemmer) endsWithDoubleConsonant(n int) bool {
+	return n >= 2 && s.b[n-1] == s.b[n-2] && s.isConsonant(n-1)
+}
+
+// endsWithCVC reports whether the first n letters end with a consonant, a vowel and a consonant other than w, x or y.
+func (s *stemmer) endsWithCVC(n int) bool {
+	if n < 3 || !s.isConsonant(n-3) || s.isConsonant(n-2) || !s.isConsonant(n-1) {
+		return false
+	}
+
+	switch s.b[n-1] {
+	case 'w', 'x', 'y':
+		return false
+	}
+	return true
+}
+
+func (s *stemmer) hasSuffix(suffix string) bool {
+	return strings.HasSuffix(string(s.b), suffix)
+}
+
+// stemLen returns the length of the word without the supplied suffix.
+func (s *stemmer) stemLen(suffix string) int {
+	return len(s.b) - len(suffix)
+}
+
+func (s *stemmer) setSuffix(suffix, replacement string) {
+	s.b = append(s.b[:s.stemLen(suffix)], replacement...)
+}
+
+func (s *stemmer) step1a() {
+	switch {
+	case s.hasSuffix("sses"):
+		s.setSuffix("sses", "ss")
+	case s.hasSuffix("ies"):
+		s.setSuffix("ies", "i")
+	case s.hasSuffix("ss"):
+	case s.hasSuffix("s"):
+		s.setSuffix("s", "")
+	}
+}
+
+func (s *stemmer) step1b() {
+	if s.hasSuffix("eed") {
+		if s.measure(s.stemLen("eed")) > 0 {
+			s.setSuffix("eed", "ee")
+		}
+		return
+	}
+
+	removed := false
+	for _, suffix := range []string{"ed", "ing"} {
+		if s.hasSuffix(suffix) && s.hasVowel(s.stemLen(suffix)) {
+			s.setSuffix(suffix, "")
+			removed = true
+			break
+		}
+	}
+
+	if !removed {
+		return
+	}
+
+	n := len(s.b)
+	switch {
+	case s.hasSuffix("at"), s.hasSuffix("bl"), s.hasSuffix("iz"):
+		s.b = append(s.b, 'e')
+	case s.endsWithDoubleConsonant(n) && s.b[n-1] != 'l' && s.b[n-1] != 's' && s.b[n-1] != 'z':
+		s.b = s.b[:n-1]
+	case s.measure(n) == 1 && s.endsWithCVC(n):
+		s.b = append(s.b, 'e')
+	}
+}
+
+func (s *stemmer) step1c() {
+	if s.hasSuffix("y") && s.hasVowel(s.stemLen("y")) {
+		s.setSuffix("y", "i")
+	}
+}
+
+var step2Suffixes = [][2]string{
+	{"ational", "ate"}, {"tional", "tion"}, {"enci", "ence"}, {"anci", "ance"}, {"izer", "ize"}, {"abli", "able"},
+	{"alli", "al"}, {"entli", "ent"}, {"eli", "e"}, {"ousli", "ous"}, {"ization", "ize"}, {"ation", "ate"},
+	{"ator", "ate"}, {"alism", "al"}, {"iveness", "ive"}, {"fulness", "ful"}, {"ousness", "ous"}, {"aliti", "al"},
+	{"iviti", "ive"}, {"biliti", "ble"},
+}
+
+var step3Suffixes = [][2]string{
+	{"icate", "ic"}, {"ative", ""}, {"alize", "al"}, {"iciti", "ic"}, {"ical", "ic"}, {"ful", ""}, {"ness", ""},
+}
+
+// longestSuffix returns the index of the longest suffix of the word among the supplied ones, or -1.
+func (s *stemmer) longestSuffix(suffixes [][2]string) int {
+	longest := -1
+	for i, suffix := range suffixes {
+		if s.hasSuffix(suffix[0]) && (longest < 0 || len(suffix[0]) > len(suffixes[longest][0])) {
+			longest = i
+		}
+	}
+	return longest
+}
+
+// replaceSuffix replaces the longest matching suffix, provided that the measure of the remaining stem is greater than minMeasure.
+func (s *stemmer) replaceSuffix(suffixes [][2]string, minMeasure int) {
+	if i := s.longestSuffix(suffixes); i >= 0 && s.measure(s.stemLen(suffixes[i][0])) > minMeasure {
+		s.setSuffix(suffixes[i][0], suffixes[i][1])
+	}
+}
+
+var step4Suffixes = [][2]string{
+	{"al", ""}, {"ance", ""}, {"ence", ""}, {"er", ""}, {"ic", ""}, {"able", ""}, {"ible", ""}, {"ant", ""},
+	{"ement", ""}, {"ment", ""}, {"ent", ""}, {"ion", ""}, {"ou", ""}, {"ism", ""}, {"ate", ""}, {"iti", ""},
+	{"ous", ""}, {"ive", ""}, {"ize", ""},
+}
+
+func (s *stemmer) step4() {
+	i := s.longestSuffix(step4Suffixes)
+	if i < 0 {
+		return
+	}
+
+	suffix := step4Suffixes[i][0]
+	n := s.stemLen(suffix)
+
+	// "ion" is only removed after s or t
+	if suffix == "ion" && (n == 0 || (s.b[n-1] != 's' && s.b[n-1] != 't')) {
+		return
+	}
+
+	if s.measure(n) > 1 {
+		s.setSuffix(suffix, "")
+	}
+}
+
+func (s *stemmer) step5() {
+	if s.hasSuffix("e") {
+		n := s.stemLen("e")
+		if m := s.measure(n); m > 1 || (m == 1 && !s.endsWithCVC(n)) {
+			s.b = s.b[:n]
+		}
+	}
+
+	n := len(s.b)
+	if s.measure(n) > 1 && s.endsWithDoubleConsonant(n) && s.b[n-1] == 'l' {
+		s.b = s.b[:n-1]
+	}
+}
@@ -2,13 +2,322 @@ package clover
 
 import (
 	"bufio"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	d "github.com/ostafen/clover/v2/document"
 	"github.com/ostafen/clover/v2/query"
+	"github.com/ostafen/clover/v2/store"
+	"github.com/ostafen/clover/v2/util"
 )
 
+// ErrInvalidImport is returned when the input of an import is not in the expected format.
+var ErrInvalidImport = errors.New("invalid import data")
+
+// DefaultImportBatchSize is the default number of documents inserted by each transaction of an import.
+const DefaultImportBatchSize = 1000
+
+// Format is the encoding of exported and imported documents.
+type Format int
+
+const (
+	// FormatJSON encodes documents as a single JSON array.
+	FormatJSON Format = iota
+	// FormatNDJSON encodes documents as a sequence of JSON objects, one per line (newline delimited JSON).
+	FormatNDJSON
+)
+
+// ImportMode tells how imported documents are merged with the ones already stored in the collection.
+type ImportMode int
+
+const (
+	// ImportAppend inserts imported documents into the collection. The import fails with ErrDuplicateKey if a document with the same id already exists.
+	ImportAppend ImportMode = iota
+	// ImportReplace removes all the documents of the collection before importing. Indexes and collection options are preserved.
+	ImportReplace
+	// ImportUpsert replaces the documents having the same id of an imported one, and inserts the others.
+	ImportUpsert
+)
+
+// ExportOptions contains the options of Export.
+type ExportOptions struct {
+	Format Format
+
+	// Progress, if not nil, is called after each document is written, with the number of documents exported so far.
+	Progress func(exported int)
+}
+
+// ImportOptions contains the options of Import.
+type ImportOptions struct {
+	Format Format
+	Mode   ImportMode
+
+	// BatchSize is the number of documents inserted by each transaction. A non positive value means DefaultImportBatchSize.
+	BatchSize int
+
+	// Progress, if not nil, is called after each batch is committed, with the number of documents imported so far.
+	Progress func(imported int)
+}
+
+func getExportOptions(opts []ExportOptions) ExportOptions {
+	if len(opts) > 0 {
+		return opts[0]
+	}
+	return ExportOptions{}
+}
+
+func getImportOptions(opts []ImportOptions) ImportOptions {
+	var o ImportOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+
+	if o.BatchSize <= 0 {
+		o.BatchSize = DefaultImportBatchSize
+	}
+	return o
+}
+
+// Export writes the documents selected by q to w, without loading them all in memory.
+// Documents are read from a single snapshot of the database. It returns the number of exported documents.
+func (db *DB) Export(w io.Writer, q *query.Query, opts ...ExportOptions) (int, error) {
+	o := getExportOptions(opts)
+
+	tx, err := db.store.Begin(false)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	bw := bufio.NewWriter(w)
+
+	if o.Format == FormatJSON {
+		if _, err := bw.WriteString("[\n"); err != nil {
+			return 0, err
+		}
+	}
+
+	n := 0
+	err = db.iterateDocs(tx, q, func(doc *d.Document) error {
+		if n > 0 && o.Format == FormatJSON {
+			if _, err := bw.WriteString(",\n"); err != nil {
+				return err
+			}
+		}
+
+		data, err := json.Marshal(doc.AsMap())
+		if err != nil {
+			return err
+		}
+
+		if _, err := bw.Write(data); err != nil {
+			return err
+		}
+
+		if o.Format == FormatNDJSON {
+			if err := bw.WriteByte('\n'); err != nil {
+				return err
+			}
+		}
+
+		n++
+		if o.Progress != nil {
+			o.Progress(n)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return n, err
+	}
+
+	if o.Format == FormatJSON {
+		end := "]\n"
+		if n > 0 {
+			end = "\n]\n"
+		}
+
+		if _, err := bw.WriteString(end); err != nil {
+			return n, err
+		}
+	}
+	return n, bw.Flush()
+}
+
+// Import reads documents from r, and stores them into a collection, which is created if it does not exist.
+// Documents are inserted in batches, each one committed by a separate transaction, so that a failed import
+// could leave the documents of the previous batches in the collection. It returns the number of imported documents.
+func (db *DB) Import(r io.Reader, collectionName string, opts ...ImportOptions) (int, error) {
+	o := getImportOptions(opts)
+
+	decoder := json.NewDecoder(r)
+	if o.Format == FormatJSON {
+		if err := expectDelim(decoder, '['); err != nil {
+			return 0, err
+		}
+	}
+	return db.importDocs(collectionName, jsonDocReader(decoder, o.Format), o)
+}
+
+// docReader returns the next document to import, or io.EOF when no more documents are available.
+type docReader func() (*d.Document, error)
+
+func jsonDocReader(decoder *json.Decoder, format Format) docReader {
+	return func() (*d.Document, error) {
+		if format == FormatJSON && !decoder.More() {
+			if err := expectDelim(decoder, ']'); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+
+		fields := make(map[string]interface{})
+		if err := decoder.Decode(&fields); err != nil {
+			if err == io.EOF && format == FormatNDJSON {
+				return nil, io.EOF
+			}
+			return nil, fmt.Errorf("%w: %s", ErrInvalidImport, err.Error())
+		}
+		return d.NewDocumentOf(fields), nil
+	}
+}
+
+func (db *DB) importDocs(collectionName string, next docReader, o ImportOptions) (int, error) {
+	n := 0
+	first := true
+	for {
+		batch, err := readBatch(next, o.BatchSize)
+		if err != nil {
+			return n, err
+		}
+
+		// the first batch is always committed, so that the collection is created (or emptied) even by empty imports
+		if len(batch) == 0 && !first {
+			break
+		}
+
+		if err := db.importBatch(collectionName, batch, o.Mode, first); err != nil {
+			return n, err
+		}
+
+		first = false
+		n += len(batch)
+		if o.Progress != nil && len(batch) > 0 {
+			o.Progress(n)
+		}
+
+		if len(batch) < o.BatchSize {
+			break
+		}
+	}
+	return n, nil
+}
+
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	tok, err := decoder.Token()
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidImport, err.Error())
+	}
+
+	if tok != delim {
+		return fmt.Errorf("%w: expected %s, found %v", ErrInvalidImport, delim, tok)
+	}
+	return nil
+}
+
+// readBatch reads at most size documents. It returns less than size documents only when the input is exhausted.
+func readBatch(next docReader, size int) ([]*d.Document, error) {
+	batch := make([]*d.Document, 0, size)
+	for len(batch) < size {
+		doc, err := next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+		batch = append(batch, doc)
+	}
+	return batch, nil
+}
+
+func (db *DB) importBatch(collectionName string, docs []*d.Document, mode ImportMode, first bool) error {
+	tx, err := db.beginUpdate()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if first {
+		if err := db.prepareImport(tx, collectionName, mode); err != nil {
+			return err
+		}
+	}
+
+	if mode != ImportUpsert {
+		if err := db.insert(tx, collectionName, docs...); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	meta, err := db.getCollectionMeta(collectionName, tx)
+	if err != nil {
+		return err
+	}
+
+	for _, doc := range docs {
+		if err := db.upsertImported(tx, collectionName, meta, doc); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// prepareImport creates the collection, if it does not exist, and empties it when mode is ImportReplace.
+func (db *DB) prepareImport(tx store.Tx, collectionName string, mode ImportMode) error {
+	meta, err := db.getCollectionMeta(collectionName, tx)
+	if errors.Is(err, ErrCollectionNotExist) {
+		return db.createCollection(tx, collectionName)
+	}
+
+	if err != nil || mode != ImportReplace {
+		return err
+	}
+
+	if err := db.dropCollection(tx, collectionName); err != nil {
+		return err
+	}
+
+	meta.Size = 0
+	return db.saveCollectionMetadata(collectionName, meta, tx)
+}
+
+func (db *DB) upsertImported(tx store.Tx, collectionName string, meta *collectionMetadata, doc *d.Document) error {
+	if !doc.Has(d.ObjectIdField) || doc.ObjectId() == "" {
+		return db.insert(tx, collectionName, doc)
+	}
+
+	// the version of the imported document refers to another database, so it must not be checked against the stored one
+	if meta.Versioned {
+		doc.Unset(d.VersionField)
+	}
+
+	return db.updateById(tx, collectionName, doc.ObjectId(), func(_ *d.Document) (*d.Document, error) {
+		return doc, nil
+	}, ChangeReplace, UpdateOptions{Upsert: true})
+}
+
 // ExportCollection exports an existing collection to a JSON file.
 func (db *DB) ExportCollection(collectionName string, exportPath string) error {
 	exists, err := db.HasCollection(collectionName)
@@ -19,45 +328,312 @@ func (db *DB) ExportCollection(collectionName string, exportPath string) error {
 		return ErrCollectionNotExist
 	}
 
-	result, err := db.FindAll(query.NewQuery(collectionName))
+	file, err := os.OpenFile(exportPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.ModePerm)
 	if err != nil {
 		return err
 	}
 
-	docs := make([]map[string]interface{}, 0)
-	for _, doc := range result {
-		docs = append(docs, doc.AsMap())
+	if _, err := db.Export(file, query.NewQuery(collectionName)); err != nil {
+		file.Close()
+		return err
 	}
+	return file.Close()
+}
 
-	jsonString, err := json.Marshal(docs)
+// ImportCollection imports documents from a JSON file into a collection, which is created if it does not exist.
+func (db *DB) ImportCollection(collectionName string, importPath string) error {
+	file, err := os.Open(importPath)
 	if err != nil {
 		return err
 	}
+	defer file.Close()
 
-	return os.WriteFile(exportPath, jsonString, os.ModePerm)
+	_, err = db.Import(file, collectionName)
+	return err
 }
 
-// ImportCollection imports a collection from a JSON file.
-func (db *DB) ImportCollection(collectionName string, importPath string) error {
-	file, err := os.Open(importPath)
+// CSVOptions contains the options shared by CSV imports and exports.
+type CSVOptions struct {
+	// Delimiter is the field delimiter. If zero, a comma is used.
+	Delimiter rune
+
+	// Columns, if not empty, restricts the exported or imported fields to the listed ones.
+	// Nested fields are identified by their dot separated path. On export, it also sets the order of the columns.
+	Columns []string
+
+	// Headers maps field paths to the names of the corresponding CSV columns. Unmapped fields use their path as column name.
+	Headers map[string]string
+}
+
+// CSVExportOptions contains the options of ExportCSV.
+type CSVExportOptions struct {
+	CSVOptions
+
+	// Progress, if not nil, is called after each document is written, with the number of documents exported so far.
+	Progress func(exported int)
+}
+
+// CSVImportOptions contains the options of ImportCSV.
+type CSVImportOptions struct {
+	CSVOptions
+
+	Mode ImportMode
+
+	// BatchSize is the number of documents inserted by each transaction. A non positive value means DefaultImportBatchSize.
+	BatchSize int
+
+	// Progress, if not nil, is called after each batch is committed, with the number of documents imported so far.
+	Progress func(imported int)
+}
+
+func (o *CSVOptions) delimiter() rune {
+	if o.Delimiter == 0 {
+		return ','
+	}
+	return o.Delimiter
+}
+
+// ExportCSV writes the documents selected by q to w in CSV format, with a column for each field.
+// Nested fields are flattened into columns named after their dot separated path, while arrays are encoded as JSON.
+// Missing fields are written as empty cells, and null values as "null".
+// If no column is specified, the documents are read twice, in order to collect the fields appearing in any of them.
+//
+// Since CSV cells carry no type, exporting documents and importing them back with ImportCSV is lossy:
+//   - strings which look like values of another type (such as "42", "true", "null", RFC3339 timestamps and JSON arrays) are imported as such values,
+//     and empty strings are imported as missing fields;
+//   - floating point numbers without a fractional part are imported as integers, and unsigned integers beyond the int64 range as floating point numbers;
+//   - empty objects are lost, since they produce no column;
+//   - array elements are imported as decoded by encoding/json: numbers become floating point numbers, and times become strings.
+//
+// Use Export and Import when documents must be preserved exactly.
+func (db *DB) ExportCSV(w io.Writer, q *query.Query, opts ...CSVExportOptions) (int, error) {
+	var o CSVExportOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+
+	tx, err := db.store.Begin(false)
 	if err != nil {
-		return err
+		return 0, err
 	}
+	defer tx.Rollback()
 
-	if err := db.CreateCollection(collectionName); err != nil {
-		return err
+	columns := o.Columns
+	if len(columns) == 0 {
+		if columns, err = db.collectColumns(tx, q); err != nil {
+			return 0, err
+		}
 	}
 
-	reader := bufio.NewReader(file)
-	jsonObjects := make([]*map[string]interface{}, 0)
-	err = json.NewDecoder(reader).Decode(&jsonObjects)
+	writer := csv.NewWriter(w)
+	writer.Comma = o.delimiter()
+
+	header := make([]string, 0, len(columns))
+	for _, column := range columns {
+		header = append(header, getCSVHeader(o.Headers, column))
+	}
+
+	if err := writer.Write(header); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	record := make([]string, len(columns))
+	err = db.iterateDocs(tx, q, func(doc *d.Document) error {
+		for i, column := range columns {
+			cell, err := formatCSVCell(doc, column)
+			if err != nil {
+				return err
+			}
+			record[i] = cell
+		}
+
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+
+		n++
+		if o.Progress != nil {
+			o.Progress(n)
+		}
+		return nil
+	})
+
 	if err != nil {
-		return err
+		return n, err
+	}
+
+	writer.Flush()
+	return n, writer.Error()
+}
+
+// collectColumns returns the fields appearing in any of the documents selected by q, sorted by name, with the "_id" field first.
+func (db *DB) collectColumns(tx store.Tx, q *query.Query) ([]string, error) {
+	fieldSet := make(map[string]bool)
+	err := db.iterateDocs(tx, q, func(doc *d.Document) error {
+		for _, field := range doc.Fields(true) {
+			fieldSet[field] = true
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make([]string, 0, len(fieldSet))
+	for field := range fieldSet {
+		if field != d.ObjectIdField {
+			columns = append(columns, field)
+		}
+	}
+	sort.Strings(columns)
+
+	if fieldSet[d.ObjectIdField] {
+		columns = append([]string{d.ObjectIdField}, columns...)
+	}
+	return columns, nil
+}
+
+func getCSVHeader(headers map[string]string, field string) string {
+	if header, ok := headers[field]; ok {
+		return header
+	}
+	return field
+}
+
+func formatCSVCell(doc *d.Document, field string) (string, error) {
+	if !doc.Has(field) {
+		return "", nil
+	}
+
+	switch v := doc.Get(field).(type) {
+	case nil:
+		return "null", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	default:
+		data, err := json.Marshal(v)
+		return string(data), err
+	}
+}
+
+// ImportCSV reads documents from r in CSV format, and stores them into a collection, which is created if it does not exist.
+// The first record must contain the column names. Columns named after a dot separated path are stored as nested fields.
+// Except for the "_id" column, cell types are inferred: empty cells are skipped, "null" is imported as a null value, "true" and "false" as booleans,
+// numbers as integers or floating point numbers, RFC3339 timestamps as times and JSON arrays as arrays.
+// Any other cell is imported as a string. Documents are inserted in batches, as by Import.
+// See ExportCSV for the values which do not survive an export and import round trip.
+func (db *DB) ImportCSV(r io.Reader, collectionName string, opts ...CSVImportOptions) (int, error) {
+	var o CSVImportOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+
+	reader := csv.NewReader(r)
+	reader.Comma = o.delimiter()
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidImport, err.Error())
+	}
+
+	fields := getCSVFields(header, o.CSVOptions)
+
+	importOpts := getImportOptions([]ImportOptions{{Mode: o.Mode, BatchSize: o.BatchSize, Progress: o.Progress}})
+	return db.importDocs(collectionName, func() (*d.Document, error) {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil, err
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidImport, err.Error())
+		}
+
+		doc := d.NewDocument()
+		for i, cell := range record {
+			if fields[i] == "" || cell == "" {
+				continue
+			}
+
+			// ids are always strings
+			if fields[i] == d.ObjectIdField {
+				doc.Set(fields[i], cell)
+			} else {
+				doc.Set(fields[i], parseCSVCell(cell))
+			}
+		}
+		return doc, nil
+	}, importOpts)
+}
+
+// getCSVFields returns the field paths corresponding to each column of header. Columns which must not be imported are mapped to an empty string.
+func getCSVFields(header []string, o CSVOptions) []string {
+	headerToField := make(map[string]string, len(o.Headers))
+	for field, header := range o.Headers {
+		headerToField[header] = field
+	}
+
+	whitelist := util.StringSliceToSet(o.Columns)
+
+	fields := make([]string, len(header))
+	for i, column := range header {
+		field, ok := headerToField[column]
+		if !ok {
+			field = column
+		}
+
+		if len(whitelist) == 0 || whitelist[field] {
+			fields[i] = field
+		}
+	}
+	return fields
+}
+
+var csvNumberRegexp = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+func parseCSVCell(cell string) interface{} {
+	switch cell {
+	case "null":
+		return nil
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+
+	// numbers with leading zeros, such as zip codes, are kept as strings
+	if csvNumberRegexp.MatchString(cell) {
+		if n, err := strconv.ParseInt(cell, 10, 64); err == nil {
+			return n
+		}
+
+		if f, err := strconv.ParseFloat(cell, 64); err == nil {
+			return f
+		}
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, cell); err == nil {
+		return t
 	}
 
-	docs := make([]*d.Document, 0)
-	for _, doc := range jsonObjects {
-		docs = append(docs, d.NewDocumentOf(*doc))
+	if strings.HasPrefix(cell, "[") {
+		var arr []interface{}
+		if err := json.Unmarshal([]byte(cell), &arr); err == nil {
+			return arr
+		}
 	}
-	return db.Insert(collectionName, docs...)
+	return cell
 }
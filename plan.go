@@ -1,7 +1,10 @@
 package clover
 
 import (
+	"math"
 	"sort"
+	"strings"
+	"time"
 
 	d "github.com/ostafen/clover/v2/document"
 	"github.com/ostafen/clover/v2/index"
@@ -59,15 +62,36 @@ type iterNode struct {
 
 	idxQuery index.Query
 	//iterIndexReverse bool
+
+	// indexOnly is set when the fields needed by the query are stored in the index, so that documents are not read
+	indexOnly bool
+
+	// seekId, if set, makes the collection scan start from the document with such id
+	seekId string
+
+	examined int // number of documents read from the collection
+
+	// scores holds the relevance of the documents selected by full-text queries, when results are sorted by score
+	scores map[string]float64
 }
 
-func (nd *iterNode) iterateFullCollection(tx store.Tx) error {
+func (nd *iterNode) iterateFullCollection(tx store.Tx, now time.Time) error {
 	prefix := []byte(getDocumentKeyPrefix(nd.collection))
-	return iteratePrefix(prefix, tx, func(item store.Item) error {
+	seekKey := prefix
+	if nd.seekId != "" {
+		seekKey = []byte(getDocumentKey(nd.collection, nd.seekId))
+	}
+
+	return iteratePrefixFrom(prefix, seekKey, tx, func(item store.Item) error {
 		doc, err := d.Decode(item.Value)
 		if err != nil {
 			return err
 		}
+		nd.examined++
+
+		if doc.IsExpired(now) {
+			return nil
+		}
 
 		if nd.filter == nil || nd.filter.Satisfy(doc) {
 			return nd.CallNext(doc)
@@ -77,83 +101,270 @@ func (nd *iterNode) iterateFullCollection(tx store.Tx) error {
 	})
 }
 
-func (nd *iterNode) iterateIndex(tx store.Tx) error {
+func (nd *iterNode) iterateIndex(tx store.Tx, now time.Time) error {
 	iterFunc := func(docId string) error {
-		doc, err := getDocumentById(nd.collection, docId, tx)
+		return nd.processDocument(tx, now, docId)
+	}
 
-		if err != nil || doc == nil {
-			// doc == nil when index record expires after document record
-			return err
+	err := nd.idxQuery.Run(iterFunc)
+	return err
+}
+
+func (nd *iterNode) processDocument(tx store.Tx, now time.Time, docId string) error {
+	doc, err := getDocumentById(nd.collection, docId, tx)
+
+	if err != nil || doc == nil {
+		return err
+	}
+	nd.examined++
+
+	// index records of expired documents are removed only when documents are purged
+	if doc.IsExpired(now) {
+		return nil
+	}
+
+	if nd.filter == nil || nd.filter.Satisfy(doc) {
+		return nd.CallNext(doc)
+	}
+	return nil
+}
+
+// iterateIndexOnly builds the documents from the values stored in the index.
+// Documents are only read when such values are not available (for example, for null values).
+func (nd *iterNode) iterateIndexOnly(tx store.Tx, now time.Time) error {
+	expired := make(map[string]bool)
+	err := iterateExpired(tx, nd.collection, now, func(_ []byte, docId string) error {
+		expired[docId] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return nd.idxQuery.(index.CoveringQuery).RunCovered(func(docId string, doc *d.Document) error {
+		if expired[docId] {
+			return nil
+		}
+
+		if doc == nil {
+			return nd.processDocument(tx, now, docId)
 		}
 
 		if nd.filter == nil || nd.filter.Satisfy(doc) {
 			return nd.CallNext(doc)
 		}
 		return nil
-	}
-
-	err := nd.idxQuery.Run(iterFunc)
-	return err
+	})
 }
 
 func (nd *iterNode) Run(tx store.Tx) error {
+	now := time.Now()
+	if nd.idxQuery != nil && nd.indexOnly {
+		return nd.iterateIndexOnly(tx, now)
+	}
+
 	if nd.idxQuery != nil {
-		return nd.iterateIndex(tx)
+		return nd.iterateIndex(tx, now)
 	}
-	return nd.iterateFullCollection(tx)
+	return nd.iterateFullCollection(tx, now)
 }
 
-func getIndexQueries(q *query.Query, indexes []index.Index) []index.Query {
+// getIndexQuery returns the query over single field indexes which selects the documents satisfying q, if any.
+func getIndexQuery(q *query.Query, indexes []index.Index) index.Query {
 	if q.Criteria() == nil || len(indexes) == 0 {
 		return nil
 	}
 
-	info := make(map[string]*index.Info)
+	rangeIndexes := make(map[string]index.RangeIndex)
+	textIndexes := make(map[string]index.FullTextIndex)
+	geoIndexes := make(map[string]index.GeoIndex)
 	for _, idx := range indexes {
-		info[idx.Field()] = &index.Info{
-			Field: idx.Field(),
-			Type:  idx.Type(),
+		switch idx.Type() {
+		case index.SingleField:
+			rangeIndexes[idx.Field()] = idx.(index.RangeIndex)
+		case index.FullText:
+			textIndex := idx.(index.FullTextIndex)
+			textIndexes[textIndex.TextField()] = textIndex
+		case index.Geospatial:
+			geoIndex := idx.(index.GeoIndex)
+			geoIndexes[geoIndex.GeoField()] = geoIndex
 		}
 	}
 
 	c := q.Criteria().Accept(&NotFlattenVisitor{}).(query.Criteria)
-	selectedFields := c.Accept(&IndexSelectVisitor{
-		Fields: info,
-	}).([]*index.Info)
+	idxQuery, _ := c.Accept(&IndexQueryVisitor{Indexes: rangeIndexes, TextIndexes: textIndexes, GeoIndexes: geoIndexes}).(index.Query)
+	return idxQuery
+}
 
-	if len(selectedFields) == 0 {
-		return nil
+func isEqualityRange(r *index.Range) bool {
+	return r.StartIncluded && r.EndIncluded && internal.Compare(r.Start, r.End) == 0
+}
+
+// compoundIndexSortsOutput checks whether iterating a compound index, whose first nPrefix fields are fixed,
+// returns documents sorted according to sortOpts. Sort options over fields constrained by an equality are ignored,
+// since all the selected documents share the same value for such fields.
+// If so, it also reports whether the index must be iterated in reverse order.
+func compoundIndexSortsOutput(fields []query.SortOption, nPrefix int, sortOpts []query.SortOption, ranges map[string]*index.Range) (bool, bool) {
+	// records sharing the same values are sorted by document id
+	fields = append(fields[:len(fields):len(fields)], query.SortOption{Field: d.ObjectIdField, Direction: 1})
+
+	opts := make([]query.SortOption, 0, len(sortOpts))
+	for _, opt := range sortOpts {
+		if r := ranges[opt.Field]; r == nil || !isEqualityRange(r) {
+			opts = append(opts, opt)
+		}
 	}
 
-	indexesMap := make(map[string]index.Index)
-	for _, idx := range indexes {
-		indexesMap[idx.Field()] = idx
+	if len(opts) > len(fields)-nPrefix {
+		return false, false
+	}
+
+	reverse := false
+	for i, opt := range opts {
+		field := fields[nPrefix+i]
+		if field.Field != opt.Field {
+			return false, false
+		}
+
+		sameDirection := field.Direction == opt.Direction
+		if i == 0 {
+			reverse = !sameDirection
+		} else if sameDirection == reverse {
+			return false, false
+		}
 	}
+	return true, reverse
+}
 
-	fieldRanges := c.Accept(NewFieldRangeVisitor([]string{selectedFields[0].Field})).(map[string]*index.Range)
+// tryToSelectCompoundIndex selects the compound index which serves the highest number of fields of the query,
+// that is an equality prefix of its fields, optionally followed by a range on the next field.
+// Ties are broken in favour of the indexes which also return documents in sorted order.
+// It returns the iteration node, the number of fields served by the index, and whether the output is sorted.
+func tryToSelectCompoundIndex(q *query.Query, indexes []index.Index) (*iterNode, int, bool) {
+	ranges := map[string]*index.Range{}
+	if q.Criteria() != nil {
+		c := q.Criteria().Accept(&NotFlattenVisitor{}).(query.Criteria)
+		ranges = c.Accept(&ConjunctiveRangeVisitor{}).(map[string]*index.Range)
+	}
 
-	queries := make([]index.Query, 0)
-	for field, vRange := range fieldRanges {
-		queries = append(queries, &index.RangeIndexQuery{
-			Range: vRange,
-			Idx:   indexesMap[field].(index.RangeIndex),
-		})
+	var bestQuery *index.CompoundIndexQuery
+	bestUsedFields, bestSorted := 0, false
+
+	for _, idx := range indexes {
+		compoundIdx, ok := idx.(index.CompoundIndex)
+		if !ok {
+			continue
+		}
+
+		fields := compoundIdx.Fields()
+
+		prefix := make([]interface{}, 0)
+		for len(prefix) < len(fields) {
+			r := ranges[fields[len(prefix)].Field]
+			if r == nil || !isEqualityRange(r) {
+				break
+			}
+			prefix = append(prefix, r.Start)
+		}
+
+		usedFields := len(prefix)
+
+		var vRange *index.Range
+		if len(prefix) < len(fields) {
+			vRange = ranges[fields[len(prefix)].Field]
+			if vRange != nil {
+				usedFields++
+			}
+		}
+
+		sorted, reverse := compoundIndexSortsOutput(fields, len(prefix), q.SortOptions(), ranges)
+		sorted = sorted && len(q.SortOptions()) > 0
+
+		if usedFields == 0 && !sorted {
+			continue
+		}
+
+		if usedFields > bestUsedFields || (usedFields == bestUsedFields && sorted && !bestSorted) || bestQuery == nil {
+			bestQuery = &index.CompoundIndexQuery{
+				Prefix:  prefix,
+				Range:   vRange,
+				Reverse: reverse,
+				Idx:     compoundIdx,
+			}
+			bestUsedFields, bestSorted = usedFields, sorted
+		}
 	}
-	return queries
+
+	if bestQuery == nil {
+		return nil, 0, false
+	}
+
+	return &iterNode{
+		idxQuery:   bestQuery,
+		filter:     q.Criteria(),
+		collection: q.Collection(),
+	}, bestUsedFields, bestSorted
 }
 
 func tryToSelectIndex(q *query.Query, indexes []index.Index) (*iterNode, bool) {
-	indexQueries := getIndexQueries(q, indexes)
-	if len(indexQueries) == 1 {
-		outputSorted := false
+	// scores are only known to full-text queries, which are never selected in place of a compound index
+	if sortsByScore(q) {
+		if nd, sorted := tryToSelectSingleFieldIndex(q, indexes); nd != nil && len(fullTextQueries(nd.idxQuery)) > 0 {
+			return nd, sorted
+		}
+	}
+
+	compoundNode, usedFields, compoundSorted := tryToSelectCompoundIndex(q, indexes)
+
+	// a compound index is preferred when it serves more than one field, or when it also avoids sorting
+	if compoundNode != nil && (usedFields > 1 || (usedFields == 1 && compoundSorted)) {
+		return compoundNode, compoundSorted
+	}
+
+	nd, sorted := tryToSelectSingleFieldIndex(q, indexes)
+	if nd == nil && compoundNode != nil {
+		return compoundNode, compoundSorted
+	}
+	return nd, sorted
+}
+
+// singleFieldSortOption returns the sort option of q, if documents are sorted by a single field.
+// Since index records sharing the same value are sorted by document id, a trailing "_id" option is allowed,
+// provided that it has the same direction of the first one.
+func singleFieldSortOption(q *query.Query) (query.SortOption, bool) {
+	opts := q.SortOptions()
+	if len(opts) == 2 && opts[1].Field == d.ObjectIdField && opts[1].Direction == opts[0].Direction {
+		return opts[0], true
+	}
+	if len(opts) == 1 {
+		return opts[0], true
+	}
+	return query.SortOption{}, false
+}
 
-		idxQuery := indexQueries[0]
+func tryToSelectSingleFieldIndex(q *query.Query, indexes []index.Index) (*iterNode, bool) {
+	sortOpt, sortedByField := singleFieldSortOption(q)
 
-		if rangeQuery, ok := idxQuery.(*index.RangeIndexQuery); ok {
-			if len(q.SortOptions()) == 1 && q.SortOptions()[0].Field == rangeQuery.Idx.Field() {
-				rangeQuery.Reverse = q.SortOptions()[0].Direction < 0
+	idxQuery := getIndexQuery(q, indexes)
+	if idxQuery != nil {
+		outputSorted := false
+
+		switch idxQuery := idxQuery.(type) {
+		case *index.RangeIndexQuery:
+			if sortedByField && sortOpt.Field == idxQuery.Idx.Field() {
+				idxQuery.Reverse = sortOpt.Direction < 0
 				outputSorted = true
 			}
+		case *index.FullTextQuery:
+			// full-text queries return documents by descending score
+			opts := q.SortOptions()
+			outputSorted = len(opts) == 1 && opts[0].Field == query.ScoreField && opts[0].Direction < 0
+		case *index.GeoQuery:
+			// near queries return documents by increasing distance
+			opts := q.SortOptions()
+			near := nearCriteria(q.Criteria())
+			outputSorted = len(opts) == 1 && opts[0].Field == query.DistanceField && opts[0].Direction > 0 &&
+				near != nil && near.Field == idxQuery.Idx.GeoField() && idxQuery.Region.Op == query.NearOp
 		}
 
 		return &iterNode{
@@ -163,16 +374,16 @@ func tryToSelectIndex(q *query.Query, indexes []index.Index) (*iterNode, bool) {
 		}, outputSorted
 	}
 
-	if len(q.SortOptions()) == 1 {
+	if sortedByField {
 		for _, idx := range indexes {
-			if idx.Type() == index.SingleField && idx.Field() == q.SortOptions()[0].Field {
+			if idx.Type() == index.SingleField && idx.Field() == sortOpt.Field {
 				return &iterNode{
 					filter:     q.Criteria(),
 					collection: q.Collection(),
 					idxQuery: &index.RangeIndexQuery{
 						Range:   nil,
 						Idx:     idx.(index.RangeIndex),
-						Reverse: q.SortOptions()[0].Direction < 0,
+						Reverse: sortOpt.Direction < 0,
 					},
 				}, true
 			}
@@ -181,6 +392,129 @@ func tryToSelectIndex(q *query.Query, indexes []index.Index) (*iterNode, bool) {
 	return nil, false
 }
 
+// sortsByScore reports whether q sorts documents by relevance.
+func sortsByScore(q *query.Query) bool {
+	for _, opt := range q.SortOptions() {
+		if opt.Field == query.ScoreField {
+			return true
+		}
+	}
+	return false
+}
+
+// sortsByPseudoField reports whether q sorts documents by relevance or distance, which are not stored in documents.
+func sortsByPseudoField(q *query.Query) bool {
+	for _, opt := range q.SortOptions() {
+		if opt.Field == query.ScoreField || opt.Field == query.DistanceField {
+			return true
+		}
+	}
+	return false
+}
+
+// nearCriteria returns the first Near criteria found in c, which determines the distance of documents, or nil if there is no such criteria.
+// Negated criteria are not considered.
+func nearCriteria(c query.Criteria) *query.UnaryCriteria {
+	switch c := c.(type) {
+	case *query.UnaryCriteria:
+		if c.OpType == query.NearOp {
+			return c
+		}
+	case *query.BinaryCriteria:
+		if near := nearCriteria(c.C1); near != nil {
+			return near
+		}
+		return nearCriteria(c.C2)
+	}
+	return nil
+}
+
+// fullTextQueries returns the full-text queries contained in idxQuery.
+func fullTextQueries(idxQuery index.Query) []*index.FullTextQuery {
+	switch idxQuery := idxQuery.(type) {
+	case *index.FullTextQuery:
+		return []*index.FullTextQuery{idxQuery}
+	case *index.UnionQuery:
+		return fullTextQueriesOf(idxQuery.Queries)
+	case *index.IntersectionQuery:
+		return fullTextQueriesOf(idxQuery.Queries)
+	}
+	return nil
+}
+
+func fullTextQueriesOf(queries []index.Query) []*index.FullTextQuery {
+	res := make([]*index.FullTextQuery, 0)
+	for _, q := range queries {
+		res = append(res, fullTextQueries(q)...)
+	}
+	return res
+}
+
+// getRequiredFields returns the fields which must be known to execute q, provided that the query selects a subset of fields.
+// Otherwise, or if such fields cannot be determined, it returns nil.
+func getRequiredFields(q *query.Query) []string {
+	if len(q.SelectedFields()) == 0 {
+		return nil
+	}
+
+	fields := append([]string{}, q.SelectedFields()...)
+	for _, opt := range q.SortOptions() {
+		fields = append(fields, opt.Field)
+	}
+
+	if q.Criteria() != nil {
+		criteriaFields, _ := q.Criteria().Accept(&CriteriaFieldsVisitor{}).([]string)
+		if criteriaFields == nil {
+			return nil
+		}
+		fields = append(fields, criteriaFields...)
+	}
+	return fields
+}
+
+// isCoveringQuery reports whether the index query returns the values of all the supplied fields.
+func isCoveringQuery(idxQuery index.Query, fields []string) bool {
+	coveringQuery, ok := idxQuery.(index.CoveringQuery)
+	if !ok {
+		return false
+	}
+
+	for _, field := range fields {
+		covered := field == d.ObjectIdField
+		for _, indexedField := range coveringQuery.Fields() {
+			covered = covered || field == indexedField || strings.HasPrefix(field, indexedField+".")
+		}
+
+		if !covered {
+			return false
+		}
+	}
+	return true
+}
+
+// tryToSelectCoveringIndex selects an index storing the values of all the supplied fields, which is entirely scanned in place of the collection.
+func tryToSelectCoveringIndex(q *query.Query, indexes []index.Index, fields []string) *iterNode {
+	for _, idx := range indexes {
+		var idxQuery index.Query
+		switch idx := idx.(type) {
+		case index.CompoundIndex:
+			idxQuery = &index.CompoundIndexQuery{Idx: idx}
+		case index.RangeIndex:
+			idxQuery = &index.RangeIndexQuery{Idx: idx}
+		}
+
+		if isCoveringQuery(idxQuery, fields) {
+			return &iterNode{
+				idxQuery:   idxQuery,
+				indexOnly:  true,
+				filter:     q.Criteria(),
+				collection: q.Collection(),
+			}
+		}
+	}
+	return nil
+}
+
 type skipLimitNode struct {
 	planNodeBase
 	skipped  int
@@ -206,6 +540,9 @@ type sortNode struct {
 	planNodeBase
 	opts []query.SortOption
 	docs []*d.Document
+
+	// compare is used in place of compareDocuments, if set
+	compare func(first *d.Document, second *d.Document, sortOpts []query.SortOption) int
 }
 
 func (nd *sortNode) Callback(doc *d.Document) error {
@@ -218,8 +555,13 @@ func (nd *sortNode) Callback(doc *d.Document) error {
 
 func (nd *sortNode) Finish() error {
 	if nd.docs != nil {
+		compare := nd.compare
+		if compare == nil {
+			compare = compareDocuments
+		}
+
 		sort.Slice(nd.docs, func(i, j int) bool {
-			return compareDocuments(nd.docs[i], nd.docs[j], nd.opts) < 0
+			return compare(nd.docs[i], nd.docs[j], nd.opts) < 0
 		})
 
 		for _, doc := range nd.docs {
@@ -230,37 +572,84 @@ func (nd *sortNode) Finish() error {
 }
 
 func buildQueryPlan(q *query.Query, indexes []index.Index, outputNode planNode) inputNode {
-	var inputNode inputNode
-	var prevNode planNode
+	itNode, isOutputSorted := selectIterNode(q, indexes)
 
+	prevNode := appendSortAndSkipLimit(itNode, q, isOutputSorted)
+	prevNode = appendProjection(prevNode, q)
+	prevNode.SetNext(outputNode)
+
+	return itNode
+}
+
+// selectIterNode returns the node which reads the documents selected by q, and whether they are returned in the order requested by q.
+func selectIterNode(q *query.Query, indexes []index.Index) (*iterNode, bool) {
 	itNode, isOutputSorted := tryToSelectIndex(q, indexes)
+
+	if fields := getRequiredFields(q); fields != nil {
+		if itNode == nil {
+			itNode = tryToSelectCoveringIndex(q, indexes, fields)
+		} else {
+			itNode.indexOnly = isCoveringQuery(itNode.idxQuery, fields)
+		}
+	}
+
 	if itNode == nil {
 		itNode = &iterNode{
 			filter:     q.Criteria(),
 			collection: q.Collection(),
 		}
 	}
-	inputNode = itNode
-	prevNode = itNode
 
-	//isOutputSorted := (len(q.sortOpts) == 1 && itNode.index != nil && itNode.index.Field() == q.sortOpts[0].Field)
+	// the collection is scanned in ascending order of document id
+	if itNode.idxQuery == nil {
+		opts := q.SortOptions()
+		isOutputSorted = len(opts) == 1 && opts[0].Field == d.ObjectIdField && opts[0].Direction > 0
+	}
+
+	if sortsByScore(q) {
+		itNode.scores = make(map[string]float64)
+		for _, textQuery := range fullTextQueries(itNode.idxQuery) {
+			textQuery.Scores = itNode.scores
+		}
+	}
+	return itNode, isOutputSorted
+}
+
+// appendProjection appends to prevNode the node selecting the fields requested by q, if any. It returns the last node of the chain.
+func appendProjection(prevNode planNode, q *query.Query) planNode {
+	if len(q.SelectedFields()) > 0 || len(q.ExcludedFields()) > 0 {
+		nd := &projectNode{fields: q.SelectedFields(), exclude: q.ExcludedFields()}
+		prevNode.SetNext(nd)
+		prevNode = nd
+	}
+	return prevNode
+}
+
+// appendSortAndSkipLimit appends to prevNode the nodes needed to sort (unless the output is already sorted), skip and limit documents according to q.
+// It returns the last node of the chain.
+func appendSortAndSkipLimit(prevNode planNode, q *query.Query, isOutputSorted bool) planNode {
 	if len(q.SortOptions()) > 0 && !isOutputSorted {
 		nd := &sortNode{opts: q.SortOptions()}
+		if itNode, ok := prevNode.(*iterNode); ok {
+			nd.compare = pseudoFieldsComparator(itNode.scores, nearCriteria(q.Criteria()))
+		}
 		prevNode.SetNext(nd)
 		prevNode = nd
 	}
 
 	//log.Println("output sorted: ", len(q.SortOptions()) > 0 && !isOutputSorted)
 
+	return appendSkipLimit(prevNode, q)
+}
+
+// appendSkipLimit appends to prevNode the node needed to skip and limit documents according to q, if any. It returns the last node of the chain.
+func appendSkipLimit(prevNode planNode, q *query.Query) planNode {
 	if q.GetSkip() > 0 || q.GetLimit() >= 0 {
 		nd := &skipLimitNode{skipped: 0, consumed: 0, skip: q.GetSkip(), limit: q.GetLimit()}
 		prevNode.SetNext(nd)
 		prevNode = nd
 	}
-
-	prevNode.SetNext(outputNode)
-
-	return inputNode
+	return prevNode
 }
 
 func execPlan(nd inputNode, tx store.Tx) error {
@@ -276,6 +665,39 @@ func execPlan(nd inputNode, tx store.Tx) error {
 	return nil
 }
 
+type projectNode struct {
+	planNodeBase
+	fields  []string
+	exclude []string
+}
+
+func (nd *projectNode) Callback(doc *d.Document) error {
+	return nd.CallNext(projectDocument(doc, nd.fields, nd.exclude))
+}
+
+// projectDocument returns a document containing the "_id" field and the supplied fields of doc (or all of them, if fields is empty), except for the excluded ones.
+func projectDocument(doc *d.Document, fields []string, exclude []string) *d.Document {
+	if len(fields) > 0 {
+		projectedDoc := d.NewDocument()
+		projectedDoc.Set(d.ObjectIdField, doc.ObjectId())
+
+		for _, field := range fields {
+			if doc.Has(field) {
+				projectedDoc.Set(field, doc.Get(field))
+			}
+		}
+		doc = projectedDoc
+	}
+
+	if len(exclude) > 0 {
+		doc = doc.Copy()
+		for _, field := range exclude {
+			doc.Unset(field)
+		}
+	}
+	return doc
+}
+
 type consumerNode struct {
 	planNodeBase
 	consumer docConsumer
@@ -285,6 +707,54 @@ func (nd *consumerNode) Callback(doc *d.Document) error {
 	return nd.consumer(doc)
 }
 
+// pseudoFieldsComparator returns a function comparing documents as compareDocuments does, except for the ScoreField and DistanceField options,
+// which compare the relevance scores of documents and their distance from the point of the near criteria, respectively.
+// Documents not selected by a full-text query have a score of zero, while documents which are not points have an infinite distance.
+// It returns nil if scores and near are both nil.
+func pseudoFieldsComparator(scores map[string]float64, near *query.UnaryCriteria) func(first *d.Document, second *d.Document, sortOpts []query.SortOption) int {
+	var region *query.GeoRegion
+	if near != nil {
+		region, _ = query.GeoRegionOf(near)
+	}
+
+	if scores == nil && region == nil {
+		return nil
+	}
+
+	distance := func(doc *d.Document) float64 {
+		lon, lat, isPoint := internal.GeoPoint(doc.Get(near.Field))
+		if !isPoint {
+			return math.Inf(1)
+		}
+		return region.Distance(lon, lat)
+	}
+
+	return func(first *d.Document, second *d.Document, sortOpts []query.SortOption) int {
+		for _, opt := range sortOpts {
+			var firstValue, secondValue float64
+			switch {
+			case opt.Field == query.ScoreField && scores != nil:
+				firstValue, secondValue = scores[first.ObjectId()], scores[second.ObjectId()]
+			case opt.Field == query.DistanceField && region != nil:
+				firstValue, secondValue = distance(first), distance(second)
+			default:
+				if res := compareDocuments(first, second, []query.SortOption{opt}); res != 0 {
+					return res
+				}
+				continue
+			}
+
+			if firstValue < secondValue {
+				return -opt.Direction
+			}
+			if firstValue > secondValue {
+				return opt.Direction
+			}
+		}
+		return 0
+	}
+}
+
 func compareDocuments(first *d.Document, second *d.Document, sortOpts []query.SortOption) int {
 	for _, opt := range sortOpts {
 		field := opt.Field
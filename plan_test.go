@@ -0,0 +1,174 @@
+package clover
+
+import (
+	"os"
+	"testing"
+
+	"github.com/ostafen/clover/v2/index"
+	q "github.com/ostafen/clover/v2/query"
+	"github.com/stretchr/testify/require"
+)
+
+func getPlanNodes(t *testing.T, db *DB, query *q.Query) []planNode {
+	query, err := normalizeCriteria(query)
+	require.NoError(t, err)
+
+	tx, err := db.store.Begin(false)
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	meta, err := db.getCollectionMeta(query.Collection(), tx)
+	require.NoError(t, err)
+
+	nodes := make([]planNode, 0)
+	nd := buildQueryPlan(query, db.getIndexes(tx, query.Collection(), meta), &consumerNode{})
+	for curr := nd.(planNode); curr != nil; curr = curr.NextNode() {
+		nodes = append(nodes, curr)
+	}
+	return nodes
+}
+
+func openTestDB(t *testing.T) (*DB, func()) {
+	dir, err := os.MkdirTemp("", "clover-test")
+	require.NoError(t, err)
+
+	db, err := Open(dir)
+	require.NoError(t, err)
+
+	return db, func() {
+		require.NoError(t, db.Close())
+		require.NoError(t, os.RemoveAll(dir))
+	}
+}
+
+func TestCompoundIndexPlan(t *testing.T) {
+	db, closeDB := openTestDB(t)
+	defer closeDB()
+
+	require.NoError(t, db.CreateCollection("items"))
+	require.NoError(t, db.CreateIndex("items", "createdAt"))
+	require.NoError(t, db.CreateCompoundIndex("items", q.SortOption{Field: "userId"}, q.SortOption{Field: "createdAt", Direction: -1}))
+
+	query := q.NewQuery("items").
+		Where(q.Field("userId").Eq("u1").And(q.Field("createdAt").Gt(100))).
+		Sort(q.SortOption{Field: "createdAt", Direction: -1}).
+		Limit(10)
+
+	nodes := getPlanNodes(t, db, query)
+	require.Len(t, nodes, 3)
+	require.IsType(t, &iterNode{}, nodes[0])
+	require.IsType(t, &skipLimitNode{}, nodes[1])
+
+	idxQuery, ok := nodes[0].(*iterNode).idxQuery.(*index.CompoundIndexQuery)
+	require.True(t, ok)
+	require.Equal(t, []interface{}{"u1"}, idxQuery.Prefix)
+	require.False(t, idxQuery.Reverse)
+
+	// ascending order is obtained by iterating the index in reverse
+	nodes = getPlanNodes(t, db, query.Sort(q.SortOption{Field: "userId"}, q.SortOption{Field: "createdAt"}))
+	require.Len(t, nodes, 3)
+	require.True(t, nodes[0].(*iterNode).idxQuery.(*index.CompoundIndexQuery).Reverse)
+
+	// sorting on a field which does not follow the equality prefix requires an explicit sort
+	nodes = getPlanNodes(t, db, query.Sort(q.SortOption{Field: "title"}))
+	require.Len(t, nodes, 4)
+	require.IsType(t, &sortNode{}, nodes[1])
+
+	// a range on the first field is served by the single field index
+	nodes = getPlanNodes(t, db, q.NewQuery("items").Where(q.Field("createdAt").Gt(100)))
+	require.IsType(t, &index.RangeIndexQuery{}, nodes[0].(*iterNode).idxQuery)
+}
+
+func TestMultiIndexPlan(t *testing.T) {
+	db, closeDB := openTestDB(t)
+	defer closeDB()
+
+	require.NoError(t, db.CreateCollection("issues"))
+	require.NoError(t, db.CreateIndex("issues", "status"))
+	require.NoError(t, db.CreateIndex("issues", "assignee"))
+
+	nodes := getPlanNodes(t, db, q.NewQuery("issues").Where(q.Field("status").Eq("open").Or(q.Field("assignee").Eq("me"))))
+	union, ok := nodes[0].(*iterNode).idxQuery.(*index.UnionQuery)
+	require.True(t, ok)
+	require.Len(t, union.Queries, 2)
+
+	nodes = getPlanNodes(t, db, q.NewQuery("issues").Where(q.Field("status").Eq("open").And(q.Field("assignee").Eq("me"))))
+	intersection, ok := nodes[0].(*iterNode).idxQuery.(*index.IntersectionQuery)
+	require.True(t, ok)
+	require.Len(t, intersection.Queries, 2)
+
+	// ranges over the same field are merged instead of being intersected
+	nodes = getPlanNodes(t, db, q.NewQuery("issues").Where(q.Field("status").Gt("a").And(q.Field("status").Lt("z")).And(q.Field("title").Eq("x"))))
+	rangeQuery, ok := nodes[0].(*iterNode).idxQuery.(*index.RangeIndexQuery)
+	require.True(t, ok)
+	require.Equal(t, &index.Range{Start: "a", End: "z"}, rangeQuery.Range)
+
+	nodes = getPlanNodes(t, db, q.NewQuery("issues").Where(q.Field("status").In("open", "closed")))
+	union, ok = nodes[0].(*iterNode).idxQuery.(*index.UnionQuery)
+	require.True(t, ok)
+	require.Len(t, union.Queries, 2)
+
+	// an or with a non indexed operand requires a full scan
+	nodes = getPlanNodes(t, db, q.NewQuery("issues").Where(q.Field("status").Eq("open").Or(q.Field("title").Eq("x"))))
+	require.Nil(t, nodes[0].(*iterNode).idxQuery)
+}
+
+func getCursorPlanNodes(t *testing.T, db *DB, query *q.Query, token string) []planNode {
+	query, err := normalizeCriteria(query)
+	require.NoError(t, err)
+
+	tx, err := db.store.Begin(false)
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	meta, err := db.getCollectionMeta(query.Collection(), tx)
+	require.NoError(t, err)
+
+	indexes := db.getIndexes(tx, query.Collection(), meta)
+
+	query, after, err := prepareCursorQuery(query, indexes, token)
+	require.NoError(t, err)
+
+	nodes := make([]planNode, 0)
+	nd := buildCursorPlan(query, indexes, after, &sortKeyNode{opts: query.SortOptions()}, &consumerNode{})
+	for curr := nd.(planNode); curr != nil; curr = curr.NextNode() {
+		nodes = append(nodes, curr)
+	}
+	return nodes
+}
+
+func TestCursorPlan(t *testing.T) {
+	db, closeDB := openTestDB(t)
+	defer closeDB()
+
+	require.NoError(t, db.CreateCollection("items"))
+	require.NoError(t, db.CreateIndex("items", "price"))
+	require.NoError(t, db.CreateCompoundIndex("items", q.SortOption{Field: "shop"}, q.SortOption{Field: "price", Direction: -1}))
+
+	// without sort options, documents are returned in the order of the selected index, and never buffered
+	for _, c := range []q.Criteria{nil, q.Field("price").Gt(10), q.Field("shop").Eq("s1").And(q.Field("price").Lt(10))} {
+		nodes := getCursorPlanNodes(t, db, q.NewQuery("items").Where(c), "")
+		require.Len(t, nodes, 3)
+		require.IsType(t, &sortKeyNode{}, nodes[1])
+	}
+
+	nodes := getCursorPlanNodes(t, db, q.NewQuery("items").Where(q.Field("price").Gt(10)), "")
+	require.Equal(t, []q.SortOption{{Field: "price", Direction: 1}, {Field: "_id", Direction: 1}}, nodes[1].(*sortKeyNode).opts)
+
+	token, err := encodeToken([]interface{}{"$5", "id"}, []q.SortOption{{Field: "price", Direction: 1}, {Field: "_id", Direction: 1}})
+	require.NoError(t, err)
+
+	// the position is sought within the index range, without interpreting its value
+	nodes = getCursorPlanNodes(t, db, q.NewQuery("items").Where(q.Field("price").Gt(1)), token)
+	require.Len(t, nodes, 4)
+	require.IsType(t, &startAfterNode{}, nodes[1])
+
+	rangeQuery := nodes[0].(*iterNode).idxQuery.(*index.RangeIndexQuery)
+	require.Equal(t, &index.Range{Start: "$5", StartIncluded: true}, rangeQuery.Range)
+
+	// documents selected by more indexes must be sorted by id
+	require.NoError(t, db.CreateIndex("items", "shop"))
+	nodes = getCursorPlanNodes(t, db, q.NewQuery("items").Where(q.Field("price").Gt(10).Or(q.Field("shop").Eq("s1"))), "")
+	require.Len(t, nodes, 4)
+	require.IsType(t, &sortNode{}, nodes[1])
+}
@@ -0,0 +1,106 @@
+package query
+
+import "strings"
+
+// Accumulator operators.
+const (
+	CountOp = iota
+	SumOp
+	AvgOp
+	MinOp
+	MaxOp
+)
+
+var accumulatorPrefixes = map[int]string{
+	CountOp: "count",
+	SumOp:   "sum",
+	AvgOp:   "avg",
+	MinOp:   "min",
+	MaxOp:   "max",
+}
+
+// Accumulator computes a value summarizing the documents of a group.
+// The result is stored in the output field Name.
+type Accumulator struct {
+	OpType int
+	Field  string
+	Name   string
+}
+
+// Aggregation groups the documents selected by a query according to the values of one or more fields,
+// and computes a set of accumulators for each group.
+type Aggregation struct {
+	groupBy      []string
+	accumulators []Accumulator
+}
+
+// GroupBy returns a new Aggregation which groups documents according to the values of the supplied fields.
+// Nested fields can be accessed using dot. If no field is supplied, all the documents belong to a single group.
+func GroupBy(fields ...string) *Aggregation {
+	return &Aggregation{
+		groupBy:      fields,
+		accumulators: nil,
+	}
+}
+
+func (a *Aggregation) copy() *Aggregation {
+	return &Aggregation{
+		groupBy:      a.groupBy,
+		accumulators: append([]Accumulator{}, a.accumulators...),
+	}
+}
+
+func (a *Aggregation) withAccumulator(opType int, field string) *Aggregation {
+	name := accumulatorPrefixes[opType]
+	if field != "" {
+		name += "_" + strings.ReplaceAll(field, ".", "_")
+	}
+
+	newAgg := a.copy()
+	newAgg.accumulators = append(newAgg.accumulators, Accumulator{OpType: opType, Field: field, Name: name})
+	return newAgg
+}
+
+// Count computes the number of documents of each group. The result is stored in the "count" field.
+func (a *Aggregation) Count() *Aggregation {
+	return a.withAccumulator(CountOp, "")
+}
+
+// Sum computes the sum of the numeric values of the supplied field. The result is stored in the "sum_<field>" field.
+func (a *Aggregation) Sum(field string) *Aggregation {
+	return a.withAccumulator(SumOp, field)
+}
+
+// Avg computes the average of the numeric values of the supplied field. The result is stored in the "avg_<field>" field.
+func (a *Aggregation) Avg(field string) *Aggregation {
+	return a.withAccumulator(AvgOp, field)
+}
+
+// Min computes the minimum value of the supplied field. The result is stored in the "min_<field>" field.
+func (a *Aggregation) Min(field string) *Aggregation {
+	return a.withAccumulator(MinOp, field)
+}
+
+// Max computes the maximum value of the supplied field. The result is stored in the "max_<field>" field.
+func (a *Aggregation) Max(field string) *Aggregation {
+	return a.withAccumulator(MaxOp, field)
+}
+
+// As sets the name of the output field of the last accumulator.
+func (a *Aggregation) As(name string) *Aggregation {
+	if len(a.accumulators) == 0 {
+		return a
+	}
+
+	newAgg := a.copy()
+	newAgg.accumulators[len(newAgg.accumulators)-1].Name = name
+	return newAgg
+}
+
+func (a *Aggregation) GroupFields() []string {
+	return a.groupBy
+}
+
+func (a *Aggregation) Accumulators() []Accumulator {
+	return a.accumulators
+}
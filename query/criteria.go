@@ -20,8 +20,16 @@ const (
 	InOp
 	ContainsOp
 	FunctionOp
+	SearchOp
+	NearOp
+	WithinBoxOp
+	WithinPolygonOp
 )
 
+// ScoreField is the name of the pseudo-field which can be used to sort the results of a full-text search by relevance.
+// Documents matching a Search criteria are scored using the BM25 ranking function.
+const ScoreField = "_score"
+
 const (
 	LogicalAnd = iota
 	LogicalOr
@@ -123,6 +131,10 @@ func (c *UnaryCriteria) Satisfy(doc *d.Document) bool {
 		return c.contains(doc)
 	case FunctionOp:
 		return c.Value.(func(*d.Document) bool)(doc)
+	case SearchOp:
+		return c.search(doc)
+	case NearOp, WithinBoxOp, WithinPolygonOp:
+		return c.within(doc)
 	}
 	return false
 }
@@ -173,6 +185,11 @@ func Field(name string) *field {
 	return &field{name: name}
 }
 
+// Name returns the name of the field.
+func (f *field) Name() string {
+	return f.name
+}
+
 func (f *field) Exists() Criteria {
 	return newCriteria(ExistsOp, f.name, nil)
 }
@@ -233,6 +250,13 @@ func (f *field) Contains(elems ...interface{}) Criteria {
 	return newCriteria(ContainsOp, f.name, elems)
 }
 
+// Search selects documents whose field contains all the words of the supplied text.
+// Both the field and the text are tokenized, lowercased and stemmed, so that "Connecting" matches "connection".
+// If the field is an array, its string elements are searched.
+func (f *field) Search(text string) Criteria {
+	return newCriteria(SearchOp, f.name, text)
+}
+
 // getFieldOrValue returns dereferenced value if value denotes another document field,
 // otherwise returns the value itself directly
 func getFieldOrValue(doc *d.Document, value interface{}) interface{} {
@@ -333,6 +357,43 @@ func (c *UnaryCriteria) like(doc *d.Document) bool {
 	return matched && err == nil
 }
 
+func (c *UnaryCriteria) search(doc *d.Document) bool {
+	terms := internal.Tokenize(c.Value.(string))
+	if len(terms) == 0 {
+		return false
+	}
+
+	docTerms := make(map[string]bool)
+	for _, term := range internal.Tokenize(SearchableText(doc.Get(c.Field))) {
+		docTerms[term] = true
+	}
+
+	for _, term := range terms {
+		if !docTerms[term] {
+			return false
+		}
+	}
+	return true
+}
+
+// SearchableText returns the text which is indexed for a field value by a full-text search.
+// Strings are returned as they are, while the string elements of an array are joined together.
+func SearchableText(v interface{}) string {
+	switch value := v.(type) {
+	case string:
+		return value
+	case []interface{}:
+		parts := make([]string, 0, len(value))
+		for _, elem := range value {
+			if s, ok := elem.(string); ok {
+				parts = append(parts, s)
+			}
+		}
+		return strings.Join(parts, " ")
+	}
+	return ""
+}
+
 type CriteriaVisitor interface {
 	VisitUnaryCriteria(c *UnaryCriteria) interface{}
 	VisitNotCriteria(c *NotCriteria) interface{}
@@ -0,0 +1,532 @@
+package query
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrInvalidFilter is returned when parsing a malformed filter or query.
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// ErrNotSerializable is returned when marshalling criteria which have no JSON representation, such as the ones created by Query.MatchFunc.
+var ErrNotSerializable = errors.New("criteria cannot be serialized")
+
+// dateKey is the key of the objects representing time values, which have no JSON counterpart, such as {"$date": "2006-01-02T15:04:05Z"}.
+const dateKey = "$date"
+
+func invalidFilter(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidFilter, fmt.Sprintf(format, args...))
+}
+
+// ParseFilter converts a JSON filter document into a criteria. Fields are matched by equality, unless their value is an object of operators,
+// such as {"age": {"$gt": 30}}. The supported operators are $eq, $ne, $gt, $gte, $lt, $lte, $in, $exists, $like, $contains, $search, $near, $withinBox, $withinPolygon and $not,
+// while criteria can be combined with $and, $or and $not. Conditions on different fields are combined with $and.
+// Time values are represented by objects such as {"$date": "2006-01-02T15:04:05Z"}, while strings starting with "$" refer to other fields.
+// An empty filter selects all the documents, and is converted to a nil criteria.
+func ParseFilter(data []byte) (Criteria, error) {
+	filter := make(map[string]interface{})
+	if err := json.Unmarshal(data, &filter); err != nil {
+		return nil, invalidFilter("%s", err)
+	}
+	return parseFilterObject(filter)
+}
+
+func parseFilterObject(filter map[string]interface{}) (Criteria, error) {
+	var c Criteria
+	for _, key := range sortedKeys(filter) {
+		keyCriteria, err := parseFilterKey(key, filter[key])
+		if err != nil {
+			return nil, err
+		}
+		c = andCriteria(c, keyCriteria)
+	}
+	return c, nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// andCriteria combines two criteria, where a nil criteria selects all the documents.
+func andCriteria(c1, c2 Criteria) Criteria {
+	if c1 == nil {
+		return c2
+	}
+
+	if c2 == nil {
+		return c1
+	}
+	return c1.And(c2)
+}
+
+func parseFilterKey(key string, value interface{}) (Criteria, error) {
+	switch key {
+	case "$and", "$or":
+		return parseLogicalFilter(key, value)
+	case "$not":
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, invalidFilter("$not requires an object")
+		}
+
+		c, err := parseFilterObject(m)
+		if err != nil {
+			return nil, err
+		}
+
+		if c == nil {
+			return nil, invalidFilter("$not requires a non empty filter")
+		}
+		return c.Not(), nil
+	}
+
+	if strings.HasPrefix(key, "$") {
+		return nil, invalidFilter("unknown operator %s", key)
+	}
+
+	ops, isMap := value.(map[string]interface{})
+	if !isMap || !isOperatorObject(ops) {
+		v, err := decodeValue(value)
+		if err != nil {
+			return nil, err
+		}
+		return Field(key).Eq(v), nil
+	}
+	return parseOperators(key, ops)
+}
+
+func parseLogicalFilter(key string, value interface{}) (Criteria, error) {
+	filters, ok := value.([]interface{})
+	if !ok || len(filters) == 0 {
+		return nil, invalidFilter("%s requires a non empty array", key)
+	}
+
+	var c Criteria
+	for _, f := range filters {
+		m, ok := f.(map[string]interface{})
+		if !ok {
+			return nil, invalidFilter("%s requires an array of objects", key)
+		}
+
+		fc, err := parseFilterObject(m)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case fc == nil && key == "$or":
+			// an empty filter selects all the documents, and so does the disjunction
+			return nil, nil
+		case fc == nil:
+			continue
+		case c == nil:
+			c = fc
+		case key == "$and":
+			c = c.And(fc)
+		default:
+			c = c.Or(fc)
+		}
+	}
+	return c, nil
+}
+
+// isOperatorObject tells whether m is an object of operators, rather than a value to match by equality.
+func isOperatorObject(m map[string]interface{}) bool {
+	if isDateObject(m) {
+		return false
+	}
+
+	for key := range m {
+		if len(key) == 0 || key[0] != '$' {
+			return false
+		}
+	}
+	return len(m) > 0
+}
+
+func isDateObject(m map[string]interface{}) bool {
+	_, has := m[dateKey]
+	return has && len(m) == 1
+}
+
+func parseOperators(field string, ops map[string]interface{}) (Criteria, error) {
+	var c Criteria
+	for _, op := range sortedKeys(ops) {
+		opCriteria, err := parseOperator(field, op, ops[op])
+		if err != nil {
+			return nil, err
+		}
+		c = andCriteria(c, opCriteria)
+	}
+	return c, nil
+}
+
+func parseOperator(field, op string, value interface{}) (Criteria, error) {
+	f := Field(field)
+	switch op {
+	case "$exists":
+		exists, ok := value.(bool)
+		if !ok {
+			return nil, invalidFilter("$exists requires a boolean")
+		}
+
+		if exists {
+			return f.Exists(), nil
+		}
+		return f.NotExists(), nil
+	case "$like":
+		pattern, ok := value.(string)
+		if !ok {
+			return nil, invalidFilter("$like requires a string")
+		}
+		return f.Like(pattern), nil
+	case "$search":
+		text, ok := value.(string)
+		if !ok {
+			return nil, invalidFilter("$search requires a string")
+		}
+		return f.Search(text), nil
+	case "$not":
+		ops, ok := value.(map[string]interface{})
+		if !ok || !isOperatorObject(ops) {
+			return nil, invalidFilter("$not requires an object of operators")
+		}
+
+		c, err := parseOperators(field, ops)
+		if err != nil {
+			return nil, err
+		}
+		return c.Not(), nil
+	}
+
+	v, err := decodeValue(value)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op {
+	case "$eq":
+		return f.Eq(v), nil
+	case "$ne":
+		return f.Neq(v), nil
+	case "$gt":
+		return f.Gt(v), nil
+	case "$gte":
+		return f.GtEq(v), nil
+	case "$lt":
+		return f.Lt(v), nil
+	case "$lte":
+		return f.LtEq(v), nil
+	case "$near", "$withinBox", "$withinPolygon":
+		c := newCriteria(geoOps[op], field, v)
+		if _, ok := GeoRegionOf(c.(*UnaryCriteria)); !ok {
+			return nil, invalidFilter("%s requires %s", op, geoOpArgs[op])
+		}
+		return c, nil
+	case "$in", "$contains":
+		values, ok := v.([]interface{})
+		if !ok {
+			return nil, invalidFilter("%s requires an array", op)
+		}
+
+		if op == "$in" {
+			return f.In(values...), nil
+		}
+		return f.Contains(values...), nil
+	}
+	return nil, invalidFilter("unknown operator %s", op)
+}
+
+var geoOps = map[string]int{"$near": NearOp, "$withinBox": WithinBoxOp, "$withinPolygon": WithinPolygonOp}
+
+var geoOpArgs = map[string]string{
+	"$near":          "an array [lon, lat, maxMeters]",
+	"$withinBox":     "an array [minLon, minLat, maxLon, maxLat]",
+	"$withinPolygon": "an array of at least three [lon, lat] vertices",
+}
+
+// decodeValue converts the date objects contained in v to time values.
+func decodeValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if isDateObject(v) {
+			s, isString := v[dateKey].(string)
+			if !isString {
+				return nil, invalidFilter("%s requires a string", dateKey)
+			}
+
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				return nil, invalidFilter("invalid date %q", s)
+			}
+			return t, nil
+		}
+
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			decoded, err := decodeValue(value)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = decoded
+		}
+		return m, nil
+	case []interface{}:
+		values := make([]interface{}, 0, len(v))
+		for _, value := range v {
+			decoded, err := decodeValue(value)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, decoded)
+		}
+		return values, nil
+	}
+	return v, nil
+}
+
+// MarshalFilter converts a criteria into a JSON filter document, which can be converted back by ParseFilter.
+// Operators are always written explicitly, such as {"age": {"$eq": 30}}, and a nil criteria is converted to an empty filter.
+// Criteria created by Query.MatchFunc cannot be represented, and cause an ErrNotSerializable to be returned.
+func MarshalFilter(c Criteria) ([]byte, error) {
+	if c == nil {
+		return []byte("{}"), nil
+	}
+
+	v := c.Accept(&filterEncoder{})
+	if err, isErr := v.(error); isErr {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// filterEncoder is a CriteriaVisitor converting criteria into JSON-encodable filter objects.
+// Errors are returned in place of the result.
+type filterEncoder struct{}
+
+var opNames = map[int]string{
+	EqOp:            "$eq",
+	GtOp:            "$gt",
+	GtEqOp:          "$gte",
+	LtOp:            "$lt",
+	LtEqOp:          "$lte",
+	LikeOp:          "$like",
+	InOp:            "$in",
+	ContainsOp:      "$contains",
+	SearchOp:        "$search",
+	NearOp:          "$near",
+	WithinBoxOp:     "$withinBox",
+	WithinPolygonOp: "$withinPolygon",
+}
+
+func (e *filterEncoder) VisitUnaryCriteria(c *UnaryCriteria) interface{} {
+	switch c.OpType {
+	case ExistsOp:
+		return fieldFilter(c.Field, "$exists", true)
+	case FunctionOp:
+		return ErrNotSerializable
+	}
+
+	name, has := opNames[c.OpType]
+	if !has {
+		return fmt.Errorf("%w: unknown operator %d", ErrNotSerializable, c.OpType)
+	}
+	return fieldFilter(c.Field, name, encodeValue(c.Value))
+}
+
+func fieldFilter(field, op string, value interface{}) map[string]interface{} {
+	return map[string]interface{}{field: map[string]interface{}{op: value}}
+}
+
+func (e *filterEncoder) VisitNotCriteria(c *NotCriteria) interface{} {
+	// negations of equality and existence have a dedicated operator
+	if uc, isUnary := c.C.(*UnaryCriteria); isUnary {
+		switch uc.OpType {
+		case EqOp:
+			return fieldFilter(uc.Field, "$ne", encodeValue(uc.Value))
+		case ExistsOp:
+			return fieldFilter(uc.Field, "$exists", false)
+		}
+	}
+
+	v := c.C.Accept(e)
+	if _, isErr := v.(error); isErr {
+		return v
+	}
+	return map[string]interface{}{"$not": v}
+}
+
+func (e *filterEncoder) VisitBinaryCriteria(c *BinaryCriteria) interface{} {
+	op := "$and"
+	if c.OpType == LogicalOr {
+		op = "$or"
+	}
+
+	filters := make([]interface{}, 0)
+	for _, child := range flattenBinaryCriteria(c, c.OpType) {
+		v := child.Accept(e)
+		if _, isErr := v.(error); isErr {
+			return v
+		}
+		filters = append(filters, v)
+	}
+	return map[string]interface{}{op: filters}
+}
+
+// flattenBinaryCriteria returns the operands of a chain of binary criteria of the same type, so that (a AND b) AND c is encoded as a single $and.
+func flattenBinaryCriteria(c Criteria, opType int) []Criteria {
+	bc, isBinary := c.(*BinaryCriteria)
+	if !isBinary || bc.OpType != opType {
+		return []Criteria{c}
+	}
+	return append(flattenBinaryCriteria(bc.C1, opType), flattenBinaryCriteria(bc.C2, opType)...)
+}
+
+// encodeValue converts the time values and the field references contained in v to their JSON representation.
+func encodeValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case time.Time:
+		return map[string]interface{}{dateKey: v.Format(time.RFC3339Nano)}
+	case *field:
+		return "$" + v.name
+	case []interface{}:
+		values := make([]interface{}, 0, len(v))
+		for _, value := range v {
+			values = append(values, encodeValue(value))
+		}
+		return values
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[key] = encodeValue(value)
+		}
+		return m
+	}
+	return v
+}
+
+// jsonQuery is the JSON representation of a query.
+type jsonQuery struct {
+	Collection string          `json:"collection"`
+	Filter     json.RawMessage `json:"filter,omitempty"`
+	Sort       []string        `json:"sort,omitempty"`
+	Skip       int             `json:"skip,omitempty"`
+	Limit      *int            `json:"limit,omitempty"`
+	Select     []string        `json:"select,omitempty"`
+	Exclude    []string        `json:"exclude,omitempty"`
+}
+
+// ParseSortOptions converts a list of fields into sort options. Fields prefixed by a minus sign are sorted in descending order,
+// while a plus sign can be optionally used for ascending order. Empty fields are ignored.
+func ParseSortOptions(fields []string) []SortOption {
+	opts := make([]SortOption, 0, len(fields))
+	for _, field := range fields {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		if strings.HasPrefix(field, "-") {
+			opts = append(opts, SortOption{Field: field[1:], Direction: -1})
+		} else {
+			opts = append(opts, SortOption{Field: strings.TrimPrefix(field, "+"), Direction: 1})
+		}
+	}
+	return opts
+}
+
+// ParseQuery converts a JSON object into a query. Besides the collection, the object can contain a filter (in the format accepted by ParseFilter),
+// a list of sort fields (prefixed by a minus sign for descending order), the skip and limit values and the lists of selected and excluded fields:
+//
+//	{"collection": "todos", "filter": {"completed": false}, "sort": ["-id"], "skip": 10, "limit": 5, "select": ["title"]}
+func ParseQuery(data []byte) (*Query, error) {
+	jq := jsonQuery{}
+
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&jq); err != nil {
+		return nil, invalidFilter("%s", err)
+	}
+
+	if jq.Collection == "" {
+		return nil, invalidFilter("missing collection")
+	}
+
+	q := NewQuery(jq.Collection)
+	if len(jq.Filter) > 0 && string(jq.Filter) != "null" {
+		c, err := ParseFilter(jq.Filter)
+		if err != nil {
+			return nil, err
+		}
+
+		if c != nil {
+			q = q.Where(c)
+		}
+	}
+
+	if len(jq.Sort) > 0 {
+		q = q.Sort(ParseSortOptions(jq.Sort)...)
+	}
+
+	if jq.Skip < 0 {
+		return nil, invalidFilter("skip must be non negative")
+	}
+	q = q.Skip(jq.Skip)
+
+	if jq.Limit != nil {
+		if *jq.Limit < 0 {
+			return nil, invalidFilter("limit must be non negative")
+		}
+		q = q.Limit(*jq.Limit)
+	}
+
+	if len(jq.Select) > 0 {
+		q = q.Select(jq.Select...)
+	}
+
+	if len(jq.Exclude) > 0 {
+		q = q.Exclude(jq.Exclude...)
+	}
+	return q, nil
+}
+
+// MarshalQuery converts a query into a JSON object, which can be converted back by ParseQuery.
+func MarshalQuery(q *Query) ([]byte, error) {
+	jq := jsonQuery{
+		Collection: q.collection,
+		Skip:       q.skip,
+		Select:     q.selectFields,
+		Exclude:    q.excludeFields,
+	}
+
+	if q.criteria != nil {
+		filter, err := MarshalFilter(q.criteria)
+		if err != nil {
+			return nil, err
+		}
+		jq.Filter = filter
+	}
+
+	for _, opt := range q.sortOpts {
+		if opt.Direction < 0 {
+			jq.Sort = append(jq.Sort, "-"+opt.Field)
+		} else {
+			jq.Sort = append(jq.Sort, opt.Field)
+		}
+	}
+
+	if q.limit >= 0 {
+		limit := q.limit
+		jq.Limit = &limit
+	}
+	return json.Marshal(jq)
+}
@@ -0,0 +1,156 @@
+package query_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ostafen/clover/v2/document"
+	"github.com/ostafen/clover/v2/query"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFilter(t *testing.T) {
+	c, err := query.ParseFilter([]byte(`{}`))
+	require.NoError(t, err)
+	require.Nil(t, c)
+
+	c, err = query.ParseFilter([]byte(`{"age": {"$gt": 30, "$lte": 40}, "name": "John"}`))
+	require.NoError(t, err)
+	require.Equal(t, query.Field("age").Gt(30.0).And(query.Field("age").LtEq(40.0)).And(query.Field("name").Eq("John")), c)
+
+	c, err = query.ParseFilter([]byte(`{"$or": [{"a": {"$exists": false}}, {"b": {"$ne": null}}], "c": {"$not": {"$in": [1, 2]}}}`))
+	require.NoError(t, err)
+	require.Equal(t, query.Field("a").NotExists().Or(query.Field("b").Neq(nil)).And(query.Field("c").In(1.0, 2.0).Not()), c)
+
+	// objects containing non operator keys are matched by equality
+	c, err = query.ParseFilter([]byte(`{"a": {"b": 1}}`))
+	require.NoError(t, err)
+	require.Equal(t, query.Field("a").Eq(map[string]interface{}{"b": 1.0}), c)
+
+	c, err = query.ParseFilter([]byte(`{"date": {"$gte": {"$date": "2020-01-29T22:54:41+01:00"}}}`))
+	require.NoError(t, err)
+
+	date, err := time.Parse(time.RFC3339, "2020-01-29T22:54:41+01:00")
+	require.NoError(t, err)
+	require.Equal(t, query.Field("date").GtEq(date), c)
+
+	c, err = query.ParseFilter([]byte(`{"title": {"$search": "quick fox"}}`))
+	require.NoError(t, err)
+	require.Equal(t, query.Field("title").Search("quick fox"), c)
+
+	data, err := query.MarshalFilter(c)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"title": {"$search": "quick fox"}}`, string(data))
+
+	c, err = query.ParseFilter([]byte(`{"geometry": {"$near": [-117, 35, 1000]}, "location": {"$withinPolygon": [[0, 0], [10, 0], [0, 10]]}}`))
+	require.NoError(t, err)
+	require.Equal(t, query.Field("geometry").Near(-117, 35, 1000).And(query.Field("location").WithinPolygon([2]float64{0, 0}, [2]float64{10, 0}, [2]float64{0, 10})), c)
+
+	c, err = query.ParseFilter([]byte(`{"geometry": {"$withinBox": [170, -10, -170, 10]}}`))
+	require.NoError(t, err)
+	require.Equal(t, query.Field("geometry").WithinBox(170, -10, -170, 10), c)
+
+	data, err = query.MarshalFilter(c)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"geometry": {"$withinBox": [170, -10, -170, 10]}}`, string(data))
+
+	// an empty filter inside $or selects all the documents
+	c, err = query.ParseFilter([]byte(`{"$or": [{"a": 1}, {}]}`))
+	require.NoError(t, err)
+	require.Nil(t, c)
+
+	for _, filter := range []string{
+		`[]`,
+		`{"$or": {}}`,
+		`{"$and": []}`,
+		`{"$not": {}}`,
+		`{"$foo": 1}`,
+		`{"a": {"$foo": 1}}`,
+		`{"a": {"$exists": 1}}`,
+		`{"a": {"$in": 1}}`,
+		`{"a": {"$like": 1}}`,
+		`{"a": {"$search": 1}}`,
+		`{"a": {"$near": [0, 0]}}`,
+		`{"a": {"$near": [0, 100, 10]}}`,
+		`{"a": {"$withinBox": [0, 10, 10, 0]}}`,
+		`{"a": {"$withinPolygon": [[0, 0], [1, 1]]}}`,
+		`{"a": {"$not": 1}}`,
+		`{"a": {"$date": "yesterday"}}`,
+	} {
+		_, err := query.ParseFilter([]byte(filter))
+		require.True(t, errors.Is(err, query.ErrInvalidFilter), filter)
+	}
+}
+
+func TestMarshalFilter(t *testing.T) {
+	data, err := query.MarshalFilter(nil)
+	require.NoError(t, err)
+	require.JSONEq(t, `{}`, string(data))
+
+	date := time.Date(2020, 1, 29, 22, 54, 41, 0, time.UTC)
+	criteria := query.Field("a").Gt(1).And(query.Field("b").Neq("x")).And(query.Field("c").Like("^h")).
+		Or(query.Field("d").NotExists()).
+		Or(query.Field("e").In(1, 2).Not()).
+		Or(query.Field("f").Lt(date)).
+		Or(query.Field("g").Eq(query.Field("h")))
+
+	data, err = query.MarshalFilter(criteria)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"$or": [
+		{"$and": [{"a": {"$gt": 1}}, {"b": {"$ne": "x"}}, {"c": {"$like": "^h"}}]},
+		{"d": {"$exists": false}},
+		{"$not": {"e": {"$in": [1, 2]}}},
+		{"f": {"$lt": {"$date": "2020-01-29T22:54:41Z"}}},
+		{"g": {"$eq": "$h"}}
+	]}`, string(data))
+
+	_, err = query.MarshalFilter(query.Field("a").Eq(1).And(query.NewQuery("test").MatchFunc(func(doc *document.Document) bool {
+		return true
+	}).Criteria()))
+	require.True(t, errors.Is(err, query.ErrNotSerializable))
+}
+
+func TestQueryRoundTrip(t *testing.T) {
+	q := query.NewQuery("todos").
+		Where(query.Field("completed").Eq(false)).
+		Sort(query.SortOption{Field: "userId", Direction: 1}, query.SortOption{Field: "id", Direction: -1}).
+		Skip(10).
+		Limit(5).
+		Select("title", "userId")
+
+	data, err := query.MarshalQuery(q)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"collection": "todos",
+		"filter": {"completed": {"$eq": false}},
+		"sort": ["userId", "-id"],
+		"skip": 10,
+		"limit": 5,
+		"select": ["title", "userId"]
+	}`, string(data))
+
+	parsed, err := query.ParseQuery(data)
+	require.NoError(t, err)
+	require.Equal(t, q, parsed)
+
+	parsed, err = query.ParseQuery([]byte(`{"collection": "todos", "exclude": ["notes"]}`))
+	require.NoError(t, err)
+	require.Equal(t, query.NewQuery("todos").Exclude("notes"), parsed)
+
+	for _, data := range []string{
+		`{}`,
+		`{"collection": "todos", "limit": -1}`,
+		`{"collection": "todos", "skip": -1}`,
+		`{"collection": "todos", "where": {}}`,
+		`{"collection": "todos", "filter": {"$foo": 1}}`,
+	} {
+		_, err := query.ParseQuery([]byte(data))
+		require.True(t, errors.Is(err, query.ErrInvalidFilter), data)
+	}
+}
+
+func TestParseSortOptions(t *testing.T) {
+	opts := query.ParseSortOptions([]string{"-a", " +b", "", "c"})
+	require.Equal(t, []query.SortOption{{Field: "a", Direction: -1}, {Field: "b", Direction: 1}, {Field: "c", Direction: 1}}, opts)
+}
@@ -0,0 +1,145 @@
+package query
+
+import (
+	d "github.com/ostafen/clover/v2/document"
+	"github.com/ostafen/clover/v2/internal"
+)
+
+// DistanceField is the name of the pseudo-field which can be used to sort documents by their distance
+// from the point of the Near criteria of the query. Documents are sorted by the great-circle distance, in meters.
+const DistanceField = "_distance"
+
+// Near selects documents whose field is a point (a GeoJSON Point object, or an array [lon, lat]) within maxMeters meters from the supplied point.
+func (f *field) Near(lon, lat, maxMeters float64) Criteria {
+	return newCriteria(NearOp, f.name, []interface{}{lon, lat, maxMeters})
+}
+
+// WithinBox selects documents whose field is a point within the box delimited by the supplied south-west and north-east corners.
+// If minLon is greater than maxLon, the box crosses the antimeridian.
+func (f *field) WithinBox(minLon, minLat, maxLon, maxLat float64) Criteria {
+	return newCriteria(WithinBoxOp, f.name, []interface{}{minLon, minLat, maxLon, maxLat})
+}
+
+// WithinPolygon selects documents whose field is a point inside the polygon having the supplied [lon, lat] vertices.
+// Edges are straight lines in the longitude/latitude plane, and the polygon cannot cross the antimeridian.
+func (f *field) WithinPolygon(vertices ...[2]float64) Criteria {
+	points := make([]interface{}, 0, len(vertices))
+	for _, v := range vertices {
+		points = append(points, []interface{}{v[0], v[1]})
+	}
+	return newCriteria(WithinPolygonOp, f.name, points)
+}
+
+// GeoRegion is the region selected by a geospatial criteria.
+type GeoRegion struct {
+	Op int
+	// Center and Radius (in meters) describe the circle selected by a Near criteria.
+	Center [2]float64
+	Radius float64
+	// Box is the box selected by a WithinBox criteria.
+	Box internal.GeoBox
+	// Polygon holds the vertices of the polygon selected by a WithinPolygon criteria.
+	Polygon [][2]float64
+}
+
+// GeoRegionOf returns the region selected by a geospatial criteria. It returns false if the criteria is not geospatial, or its value is malformed.
+func GeoRegionOf(c *UnaryCriteria) (*GeoRegion, bool) {
+	values, isSlice := c.Value.([]interface{})
+	if !isSlice {
+		return nil, false
+	}
+
+	switch c.OpType {
+	case NearOp:
+		if len(values) != 3 {
+			return nil, false
+		}
+
+		center, ok := geoCoords(values[:2], 2)
+		if !ok {
+			return nil, false
+		}
+
+		radius, ok := values[2].(float64)
+		if !ok || radius < 0 {
+			return nil, false
+		}
+		return &GeoRegion{Op: NearOp, Center: [2]float64{center[0], center[1]}, Radius: radius}, true
+	case WithinBoxOp:
+		coords, ok := geoCoords(values, 4)
+		if !ok || coords[1] > coords[3] {
+			return nil, false
+		}
+		return &GeoRegion{Op: WithinBoxOp, Box: internal.GeoBox{MinLon: coords[0], MinLat: coords[1], MaxLon: coords[2], MaxLat: coords[3]}}, true
+	case WithinPolygonOp:
+		polygon := make([][2]float64, 0, len(values))
+		for _, v := range values {
+			vertex, isSlice := v.([]interface{})
+			coords, ok := geoCoords(vertex, 2)
+			if !isSlice || !ok {
+				return nil, false
+			}
+			polygon = append(polygon, [2]float64{coords[0], coords[1]})
+		}
+
+		if len(polygon) < 3 {
+			return nil, false
+		}
+		return &GeoRegion{Op: WithinPolygonOp, Polygon: polygon}, true
+	}
+	return nil, false
+}
+
+// geoCoords converts n alternating longitudes and latitudes to floats, checking that they are valid.
+func geoCoords(values []interface{}, n int) ([]float64, bool) {
+	if len(values) != n {
+		return nil, false
+	}
+
+	coords := make([]float64, 0, n)
+	for i := 0; i < n; i += 2 {
+		lon, lat, ok := internal.GeoPoint(values[i : i+2])
+		if !ok {
+			return nil, false
+		}
+		coords = append(coords, lon, lat)
+	}
+	return coords, true
+}
+
+// Contains reports whether the region contains the point.
+func (r *GeoRegion) Contains(lon, lat float64) bool {
+	switch r.Op {
+	case NearOp:
+		return internal.GeoDistance(r.Center[0], r.Center[1], lon, lat) <= r.Radius
+	case WithinBoxOp:
+		return r.Box.Contains(lon, lat)
+	}
+	return internal.GeoPolygonBox(r.Polygon).Contains(lon, lat) && internal.InGeoPolygon(lon, lat, r.Polygon)
+}
+
+// BoundingBox returns a box containing the region.
+func (r *GeoRegion) BoundingBox() internal.GeoBox {
+	switch r.Op {
+	case NearOp:
+		return internal.GeoCircleBox(r.Center[0], r.Center[1], r.Radius)
+	case WithinBoxOp:
+		return r.Box
+	}
+	return internal.GeoPolygonBox(r.Polygon)
+}
+
+// Distance returns the distance of the point from the center of a Near region, in meters.
+func (r *GeoRegion) Distance(lon, lat float64) float64 {
+	return internal.GeoDistance(r.Center[0], r.Center[1], lon, lat)
+}
+
+func (c *UnaryCriteria) within(doc *d.Document) bool {
+	region, ok := GeoRegionOf(c)
+	if !ok {
+		return false
+	}
+
+	lon, lat, isPoint := internal.GeoPoint(doc.Get(c.Field))
+	return isPoint && region.Contains(lon, lat)
+}
@@ -9,6 +9,9 @@ type Query struct {
 	limit      int
 	skip       int
 	sortOpts   []SortOption
+
+	selectFields  []string
+	excludeFields []string
 }
 
 // NewQuery simply returns the collection with the supplied name. Use it to initialize a new query.
@@ -29,6 +32,9 @@ func (q *Query) copy() *Query {
 		limit:      q.limit,
 		skip:       q.skip,
 		sortOpts:   q.sortOpts,
+
+		selectFields:  q.selectFields,
+		excludeFields: q.excludeFields,
 	}
 }
 
@@ -104,6 +110,22 @@ func (q *Query) Sort(opts ...SortOption) *Query {
 	return newQuery
 }
 
+// Select sets the query so that the returned documents only contain the supplied fields, besides the "_id" field.
+// Nested fields can be accessed using dot. Calling Select without arguments removes any previous selection.
+func (q *Query) Select(fields ...string) *Query {
+	newQuery := q.copy()
+	newQuery.selectFields = fields
+	return newQuery
+}
+
+// Exclude sets the query so that the supplied fields are removed from the returned documents.
+// Nested fields can be accessed using dot. Calling Exclude without arguments removes any previous exclusion.
+func (q *Query) Exclude(fields ...string) *Query {
+	newQuery := q.copy()
+	newQuery.excludeFields = fields
+	return newQuery
+}
+
 func (q *Query) Collection() string {
 	return q.collection
 }
@@ -123,3 +145,11 @@ func (q *Query) GetSkip() int {
 func (q *Query) SortOptions() []SortOption {
 	return q.sortOpts
 }
+
+func (q *Query) SelectedFields() []string {
+	return q.selectFields
+}
+
+func (q *Query) ExcludedFields() []string {
+	return q.excludeFields
+}
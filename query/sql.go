@@ -0,0 +1,615 @@
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// SyntaxError reports a malformed SQL query. Pos is the position of the offending character, starting from 1.
+type SyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at position %d: %s", e.Pos, e.Msg)
+}
+
+type sqlTokenType int
+
+const (
+	sqlEOF sqlTokenType = iota
+	sqlIdent
+	sqlQuotedIdent
+	sqlNumber
+	sqlString
+	sqlPunct
+)
+
+type sqlToken struct {
+	typ sqlTokenType
+	val string
+	pos int
+}
+
+func (t sqlToken) String() string {
+	switch t.typ {
+	case sqlEOF:
+		return "end of input"
+	case sqlString:
+		return "'" + strings.Replace(t.val, "'", "''", -1) + "'"
+	}
+	return fmt.Sprintf("%q", t.val)
+}
+
+func isSQLIdentRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.'
+}
+
+func tokenizeSQL(text string) ([]sqlToken, error) {
+	runes := []rune(text)
+	tokens := make([]sqlToken, 0)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		start := i
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			continue
+		case r == '\'' || r == '"' || r == '`':
+			// quotes are escaped by doubling them, as in 'it''s'
+			var sb strings.Builder
+			for i++; i < len(runes); i++ {
+				if runes[i] == r {
+					if i+1 < len(runes) && runes[i+1] == r {
+						i++
+					} else {
+						break
+					}
+				}
+				sb.WriteRune(runes[i])
+			}
+
+			if i == len(runes) {
+				return nil, &SyntaxError{Pos: start + 1, Msg: "unterminated quoted string"}
+			}
+			i++
+
+			typ := sqlString
+			if r != '\'' {
+				typ = sqlQuotedIdent
+			}
+			tokens = append(tokens, sqlToken{typ: typ, val: sb.String(), pos: start + 1})
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			for i++; i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.' || runes[i] == 'e' || runes[i] == 'E' ||
+				((runes[i] == '+' || runes[i] == '-') && (runes[i-1] == 'e' || runes[i-1] == 'E'))); i++ {
+			}
+			tokens = append(tokens, sqlToken{typ: sqlNumber, val: string(runes[start:i]), pos: start + 1})
+		case isSQLIdentRune(r):
+			for ; i < len(runes) && isSQLIdentRune(runes[i]); i++ {
+			}
+			tokens = append(tokens, sqlToken{typ: sqlIdent, val: string(runes[start:i]), pos: start + 1})
+		default:
+			if i+1 < len(runes) && isSQLTwoCharOperator(string(runes[i:i+2])) {
+				i += 2
+			} else if strings.ContainsRune("(),=<>*;", r) {
+				i++
+			} else {
+				return nil, &SyntaxError{Pos: start + 1, Msg: fmt.Sprintf("unexpected character %q", r)}
+			}
+			tokens = append(tokens, sqlToken{typ: sqlPunct, val: string(runes[start:i]), pos: start + 1})
+		}
+	}
+	return append(tokens, sqlToken{typ: sqlEOF, pos: len(runes) + 1}), nil
+}
+
+func isSQLTwoCharOperator(s string) bool {
+	switch s {
+	case "!=", "<=", ">=", "<>":
+		return true
+	}
+	return false
+}
+
+// sqlKeywords cannot be used as unquoted field names.
+var sqlKeywords = map[string]bool{
+	"select": true, "from": true, "where": true, "order": true, "by": true, "asc": true, "desc": true, "limit": true, "offset": true,
+	"and": true, "or": true, "not": true, "is": true, "null": true, "in": true, "like": true, "regexp": true, "contains": true,
+	"match": true, "exists": true, "between": true, "true": true, "false": true, "timestamp": true,
+}
+
+type sqlParser struct {
+	tokens []sqlToken
+	pos    int
+}
+
+func newSQLParser(text string) (*sqlParser, error) {
+	tokens, err := tokenizeSQL(text)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlParser{tokens: tokens}, nil
+}
+
+func (p *sqlParser) peek() sqlToken {
+	return p.tokens[p.pos]
+}
+
+func (p *sqlParser) next() sqlToken {
+	tok := p.tokens[p.pos]
+	if tok.typ != sqlEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *sqlParser) errorf(tok sqlToken, format string, args ...interface{}) error {
+	return &SyntaxError{Pos: tok.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// isKeyword reports whether the next token is the supplied keyword. Keywords are case insensitive.
+func (p *sqlParser) isKeyword(kw string) bool {
+	tok := p.peek()
+	return tok.typ == sqlIdent && strings.EqualFold(tok.val, kw)
+}
+
+func (p *sqlParser) acceptKeyword(kw string) bool {
+	if p.isKeyword(kw) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *sqlParser) expectKeyword(kw string) error {
+	if !p.acceptKeyword(kw) {
+		return p.errorf(p.peek(), "expected %s, found %s", strings.ToUpper(kw), p.peek())
+	}
+	return nil
+}
+
+func (p *sqlParser) acceptPunct(punct string) bool {
+	tok := p.peek()
+	if tok.typ == sqlPunct && tok.val == punct {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *sqlParser) expectPunct(punct string) error {
+	if !p.acceptPunct(punct) {
+		return p.errorf(p.peek(), "expected %q, found %s", punct, p.peek())
+	}
+	return nil
+}
+
+func (p *sqlParser) isIdent() bool {
+	tok := p.peek()
+	return tok.typ == sqlQuotedIdent || (tok.typ == sqlIdent && !sqlKeywords[strings.ToLower(tok.val)])
+}
+
+func (p *sqlParser) expectIdent(what string) (string, error) {
+	if !p.isIdent() {
+		return "", p.errorf(p.peek(), "expected %s, found %s", what, p.peek())
+	}
+	return p.next().val, nil
+}
+
+func (p *sqlParser) expectEOF() error {
+	p.acceptPunct(";")
+	if tok := p.peek(); tok.typ != sqlEOF {
+		return p.errorf(tok, "unexpected %s", tok)
+	}
+	return nil
+}
+
+func (p *sqlParser) parseInt(what string) (int, error) {
+	tok := p.next()
+	n, err := strconv.Atoi(tok.val)
+	if tok.typ != sqlNumber || err != nil || n < 0 {
+		return 0, p.errorf(tok, "expected %s, found %s", what, tok)
+	}
+	return n, nil
+}
+
+// ParseSQL compiles a SQL-like SELECT statement into a query, such as:
+//
+//	SELECT title, userId FROM todos WHERE completed = false AND userId IN (1, 2) ORDER BY id DESC LIMIT 10 OFFSET 20
+//
+// The statement selects either all the fields (*) or a list of fields, which can be nested fields in dot notation.
+// Conditions in the WHERE clause are parsed as in ParseWhere. Keywords are case insensitive, while field and collection names
+// are case sensitive, and can be enclosed in double quotes or backticks when they contain other characters or clash with keywords.
+// Malformed statements are reported by a *SyntaxError.
+func ParseSQL(text string) (*Query, error) {
+	p, err := newSQLParser(text)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expectKeyword("select"); err != nil {
+		return nil, err
+	}
+
+	var fields []string
+	if !p.acceptPunct("*") {
+		if fields, err = p.parseFieldList(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.expectKeyword("from"); err != nil {
+		return nil, err
+	}
+
+	collection, err := p.expectIdent("collection name")
+	if err != nil {
+		return nil, err
+	}
+
+	q := NewQuery(collection)
+	if len(fields) > 0 {
+		q = q.Select(fields...)
+	}
+
+	if p.acceptKeyword("where") {
+		c, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		q = q.Where(c)
+	}
+
+	if p.acceptKeyword("order") {
+		if err := p.expectKeyword("by"); err != nil {
+			return nil, err
+		}
+
+		opts, err := p.parseSortOptions()
+		if err != nil {
+			return nil, err
+		}
+		q = q.Sort(opts...)
+	}
+
+	// LIMIT and OFFSET are accepted in any order
+	hasLimit, hasOffset := false, false
+	for {
+		switch {
+		case !hasLimit && p.acceptKeyword("limit"):
+			n, err := p.parseInt("maximum number of documents")
+			if err != nil {
+				return nil, err
+			}
+			q, hasLimit = q.Limit(n), true
+		case !hasOffset && p.acceptKeyword("offset"):
+			n, err := p.parseInt("number of documents to skip")
+			if err != nil {
+				return nil, err
+			}
+			q, hasOffset = q.Skip(n), true
+		default:
+			return q, p.expectEOF()
+		}
+	}
+}
+
+// ParseWhere compiles the condition of a WHERE clause into a criteria, such as:
+//
+//	completed = false AND (userId IN (1, 2) OR title LIKE 'qui%')
+//
+// Conditions compare a field with a value (a string, a number, true, false, null or a time value in RFC3339 format, such as TIMESTAMP '2020-01-01T00:00:00Z')
+// or with another field, by means of the =, != (or <>), <, <=, > and >= operators. The following conditions are also supported:
+//
+//	field [NOT] IN (value, ...)           the field is equal to one of the values
+//	field [NOT] CONTAINS (value, ...)     the field is an array containing all the values
+//	field [NOT] BETWEEN value AND value   the field is within the range, bounds included
+//	field [NOT] LIKE 'pattern'            the field matches the pattern, where % matches any sequence of characters and _ a single character
+//	field [NOT] REGEXP 'pattern'          the field matches the regular expression
+//	field [NOT] MATCH 'text'              the field contains all the words of the text (see Search)
+//	field IS [NOT] NULL                   the field is null, or missing
+//	field [NOT] EXISTS                    the field exists
+//
+// Conditions can be combined with AND, OR, NOT and parentheses.
+func ParseWhere(text string) (Criteria, error) {
+	p, err := newSQLParser(text)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	return c, p.expectEOF()
+}
+
+func (p *sqlParser) parseFieldList() ([]string, error) {
+	fields := make([]string, 0)
+	for {
+		field, err := p.expectIdent("field name")
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, field)
+
+		if !p.acceptPunct(",") {
+			return fields, nil
+		}
+	}
+}
+
+func (p *sqlParser) parseSortOptions() ([]SortOption, error) {
+	opts := make([]SortOption, 0)
+	for {
+		field, err := p.expectIdent("field name")
+		if err != nil {
+			return nil, err
+		}
+
+		direction := 1
+		if p.acceptKeyword("desc") {
+			direction = -1
+		} else {
+			p.acceptKeyword("asc")
+		}
+		opts = append(opts, SortOption{Field: field, Direction: direction})
+
+		if !p.acceptPunct(",") {
+			return opts, nil
+		}
+	}
+}
+
+func (p *sqlParser) parseOr() (Criteria, error) {
+	c, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.acceptKeyword("or") {
+		other, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		c = c.Or(other)
+	}
+	return c, nil
+}
+
+func (p *sqlParser) parseAnd() (Criteria, error) {
+	c, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.acceptKeyword("and") {
+		other, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		c = c.And(other)
+	}
+	return c, nil
+}
+
+func (p *sqlParser) parseUnary() (Criteria, error) {
+	if p.acceptKeyword("not") {
+		c, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return c.Not(), nil
+	}
+
+	if p.acceptPunct("(") {
+		c, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return c, p.expectPunct(")")
+	}
+	return p.parsePredicate()
+}
+
+func negateIf(c Criteria, negate bool) Criteria {
+	if negate {
+		return c.Not()
+	}
+	return c
+}
+
+func (p *sqlParser) parsePredicate() (Criteria, error) {
+	name, err := p.expectIdent("field name")
+	if err != nil {
+		return nil, err
+	}
+	f := Field(name)
+
+	if p.acceptKeyword("is") {
+		negate := p.acceptKeyword("not")
+		if err := p.expectKeyword("null"); err != nil {
+			return nil, err
+		}
+
+		// as in SQL, missing fields are considered null
+		return negateIf(f.IsNilOrNotExists(), negate), nil
+	}
+
+	negate := p.acceptKeyword("not")
+	switch {
+	case p.acceptKeyword("exists"):
+		return negateIf(f.Exists(), negate), nil
+	case p.acceptKeyword("in"):
+		values, err := p.parseValueList()
+		if err != nil {
+			return nil, err
+		}
+		return negateIf(f.In(values...), negate), nil
+	case p.acceptKeyword("contains"):
+		if tok := p.peek(); tok.typ != sqlPunct || tok.val != "(" {
+			v, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			return negateIf(f.Contains(v), negate), nil
+		}
+
+		values, err := p.parseValueList()
+		if err != nil {
+			return nil, err
+		}
+		return negateIf(f.Contains(values...), negate), nil
+	case p.acceptKeyword("between"):
+		low, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := p.expectKeyword("and"); err != nil {
+			return nil, err
+		}
+
+		high, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		return negateIf(f.GtEq(low).And(f.LtEq(high)), negate), nil
+	case p.isKeyword("like"), p.isKeyword("regexp"):
+		kw := p.next().val
+		tok := p.next()
+		if tok.typ != sqlString {
+			return nil, p.errorf(tok, "expected pattern, found %s", tok)
+		}
+
+		pattern := tok.val
+		if strings.EqualFold(kw, "like") {
+			pattern = likeToRegexp(tok.val)
+		} else if _, err := regexp.Compile(pattern); err != nil {
+			return nil, p.errorf(tok, "invalid regular expression: %s", err)
+		}
+		return negateIf(f.Like(pattern), negate), nil
+	case p.acceptKeyword("match"):
+		tok := p.next()
+		if tok.typ != sqlString {
+			return nil, p.errorf(tok, "expected text, found %s", tok)
+		}
+		return negateIf(f.Search(tok.val), negate), nil
+	case negate:
+		return nil, p.errorf(p.peek(), "expected EXISTS, IN, CONTAINS, BETWEEN, LIKE, REGEXP or MATCH, found %s", p.peek())
+	}
+
+	opTok := p.next()
+	if opTok.typ != sqlPunct {
+		return nil, p.errorf(opTok, "expected operator, found %s", opTok)
+	}
+
+	var value interface{}
+	if p.isIdent() {
+		value = Field(p.next().val)
+	} else if value, err = p.parseValue(); err != nil {
+		return nil, err
+	}
+
+	switch opTok.val {
+	case "=":
+		return f.Eq(value), nil
+	case "!=", "<>":
+		return f.Neq(value), nil
+	case ">":
+		return f.Gt(value), nil
+	case ">=":
+		return f.GtEq(value), nil
+	case "<":
+		return f.Lt(value), nil
+	case "<=":
+		return f.LtEq(value), nil
+	}
+	return nil, p.errorf(opTok, "expected operator, found %s", opTok)
+}
+
+// likeToRegexp converts a LIKE pattern into an equivalent regular expression.
+// A backslash escapes the following character, so that \% matches a percent sign.
+func likeToRegexp(pattern string) string {
+	var sb strings.Builder
+	sb.WriteString("(?s)^")
+
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; {
+		case r == '%':
+			sb.WriteString(".*")
+		case r == '_':
+			sb.WriteString(".")
+		case r == '\\' && i+1 < len(runes):
+			i++
+			sb.WriteString(regexp.QuoteMeta(string(runes[i])))
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return sb.String()
+}
+
+func (p *sqlParser) parseValueList() ([]interface{}, error) {
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+
+	values := make([]interface{}, 0)
+	for {
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+
+		if !p.acceptPunct(",") {
+			return values, p.expectPunct(")")
+		}
+	}
+}
+
+func (p *sqlParser) parseValue() (interface{}, error) {
+	tok := p.next()
+	switch tok.typ {
+	case sqlString:
+		return tok.val, nil
+	case sqlNumber:
+		if n, err := strconv.ParseInt(tok.val, 10, 64); err == nil {
+			return n, nil
+		}
+
+		if f, err := strconv.ParseFloat(tok.val, 64); err == nil {
+			return f, nil
+		}
+		return nil, p.errorf(tok, "invalid number %s", tok.val)
+	case sqlIdent:
+		switch strings.ToLower(tok.val) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		case "timestamp":
+			strTok := p.next()
+			if strTok.typ != sqlString {
+				return nil, p.errorf(strTok, "expected time value, found %s", strTok)
+			}
+
+			t, err := time.Parse(time.RFC3339Nano, strTok.val)
+			if err != nil {
+				return nil, p.errorf(strTok, "invalid time value %s, expected RFC3339 format", strTok)
+			}
+			return t, nil
+		}
+	}
+	return nil, p.errorf(tok, "expected value, found %s", tok)
+}
@@ -0,0 +1,98 @@
+package query_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ostafen/clover/v2/query"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSQL(t *testing.T) {
+	q, err := query.ParseSQL("SELECT title, userId FROM todos WHERE completed = false AND userId IN (1,2) ORDER BY id DESC LIMIT 10")
+	require.NoError(t, err)
+
+	expected := query.NewQuery("todos").
+		Select("title", "userId").
+		Where(query.Field("completed").Eq(false).And(query.Field("userId").In(int64(1), int64(2)))).
+		Sort(query.SortOption{Field: "id", Direction: -1}).
+		Limit(10)
+	require.Equal(t, expected, q)
+
+	q, err = query.ParseSQL(`select * from "my todos" order by userId, id asc offset 5 limit 2;`)
+	require.NoError(t, err)
+	require.Equal(t, query.NewQuery("my todos").Sort(query.SortOption{Field: "userId", Direction: 1}, query.SortOption{Field: "id", Direction: 1}).Skip(5).Limit(2), q)
+
+	q, err = query.ParseSQL("SELECT * FROM todos")
+	require.NoError(t, err)
+	require.Equal(t, query.NewQuery("todos"), q)
+}
+
+func TestParseWhere(t *testing.T) {
+	date := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		where    string
+		criteria query.Criteria
+	}{
+		{"a.b = 'it''s'", query.Field("a.b").Eq("it's")},
+		{"a <> -1.5 OR b != c", query.Field("a").Neq(-1.5).Or(query.Field("b").Neq(query.Field("c")))},
+		{"a > 1 AND b >= 2 AND c < 3 AND d <= 4", query.Field("a").Gt(int64(1)).And(query.Field("b").GtEq(int64(2))).And(query.Field("c").Lt(int64(3))).And(query.Field("d").LtEq(int64(4)))},
+		{"a = 1 OR b = 2 AND c = 3", query.Field("a").Eq(int64(1)).Or(query.Field("b").Eq(int64(2)).And(query.Field("c").Eq(int64(3))))},
+		{"(a = 1 OR b = 2) AND NOT c = 3", query.Field("a").Eq(int64(1)).Or(query.Field("b").Eq(int64(2))).And(query.Field("c").Eq(int64(3)).Not())},
+		{"a IS NULL and b is not null", query.Field("a").IsNilOrNotExists().And(query.Field("b").IsNilOrNotExists().Not())},
+		{"a EXISTS AND b NOT EXISTS", query.Field("a").Exists().And(query.Field("b").Exists().Not())},
+		{"a NOT IN ('x', true, null)", query.Field("a").In("x", true, nil).Not()},
+		{"tags CONTAINS 'a' OR tags CONTAINS ('b', 'c')", query.Field("tags").Contains("a").Or(query.Field("tags").Contains("b", "c"))},
+		{"a BETWEEN 1 AND 5 AND b = 1", query.Field("a").GtEq(int64(1)).And(query.Field("a").LtEq(int64(5))).And(query.Field("b").Eq(int64(1)))},
+		{"title LIKE 'qui%_\\%.'", query.Field("title").Like(`(?s)^qui.*.%\.$`)},
+		{"title NOT REGEXP '^qui'", query.Field("title").Like("^qui").Not()},
+		{"date >= TIMESTAMP '2020-01-01T00:00:00Z'", query.Field("date").GtEq(date)},
+		{"`order` = 1", query.Field("order").Eq(int64(1))},
+		{"title MATCH 'quick fox' AND body NOT MATCH 'dog'", query.Field("title").Search("quick fox").And(query.Field("body").Search("dog").Not())},
+	}
+
+	for _, test := range tests {
+		c, err := query.ParseWhere(test.where)
+		require.NoError(t, err, test.where)
+		require.Equal(t, test.criteria, c, test.where)
+	}
+}
+
+func TestSQLSyntaxErrors(t *testing.T) {
+	tests := []struct {
+		sql string
+		pos int
+		msg string
+	}{
+		{"", 1, "expected SELECT, found end of input"},
+		{"SELECT FROM todos", 8, `expected field name, found "FROM"`},
+		{"SELECT * todos", 10, `expected FROM, found "todos"`},
+		{"SELECT * FROM todos WHERE", 26, "expected field name, found end of input"},
+		{"SELECT * FROM todos WHERE a = ", 31, "expected value, found end of input"},
+		{"SELECT * FROM todos WHERE a == 1", 30, `expected value, found "="`},
+		{"SELECT * FROM todos WHERE a ~ 1", 29, `unexpected character '~'`},
+		{"SELECT * FROM todos WHERE a = 'x", 31, "unterminated quoted string"},
+		{"SELECT * FROM todos WHERE (a = 1", 33, `expected ")", found end of input`},
+		{"SELECT * FROM todos WHERE a IN 1", 32, `expected "(", found "1"`},
+		{"SELECT * FROM todos WHERE a NOT = 1", 33, `expected EXISTS, IN, CONTAINS, BETWEEN, LIKE, REGEXP or MATCH, found "="`},
+		{"SELECT * FROM todos WHERE a MATCH b", 35, `expected text, found "b"`},
+		{"SELECT * FROM todos WHERE a LIKE 1", 34, `expected pattern, found "1"`},
+		{"SELECT * FROM todos WHERE a REGEXP '('", 36, "invalid regular expression: error parsing regexp: missing closing ): `(`"},
+		{"SELECT * FROM todos WHERE a > TIMESTAMP 'now'", 41, "invalid time value 'now', expected RFC3339 format"},
+		{"SELECT * FROM todos ORDER id", 27, `expected BY, found "id"`},
+		{"SELECT * FROM todos LIMIT -1", 27, `expected maximum number of documents, found "-1"`},
+		{"SELECT * FROM todos LIMIT 1 LIMIT 2", 29, `unexpected "LIMIT"`},
+		{"SELECT * FROM todos WHERE where = 1", 27, `expected field name, found "where"`},
+	}
+
+	for _, test := range tests {
+		_, err := query.ParseSQL(test.sql)
+
+		var syntaxErr *query.SyntaxError
+		require.True(t, errors.As(err, &syntaxErr), test.sql)
+		require.Equal(t, test.pos, syntaxErr.Pos, test.sql)
+		require.Equal(t, test.msg, syntaxErr.Msg, test.sql)
+	}
+}
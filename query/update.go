@@ -0,0 +1,126 @@
+package query
+
+// Update operators.
+const (
+	SetOp = iota
+	IncOp
+	PushOp
+	PullOp
+	UnsetOp
+	SetMinOp
+	SetMaxOp
+	RenameOp
+)
+
+// UpdateOperation describes a single modification of a document field.
+// For RenameOp operations, Value holds the new name of the field.
+type UpdateOperation struct {
+	OpType int
+	Field  string
+	Value  interface{}
+}
+
+// Update is a declarative description of the modifications to apply to a document.
+// Operations are applied in the order they are added. Nested fields can be accessed using dot.
+type Update struct {
+	ops []UpdateOperation
+}
+
+// NewUpdate returns an empty Update. Use it, or any of the Set, Inc, Push, Pull, Unset, Min, Max and Rename functions, to initialize a new update.
+func NewUpdate() *Update {
+	return &Update{}
+}
+
+func (u *Update) withOperation(opType int, field string, value interface{}) *Update {
+	ops := make([]UpdateOperation, 0, len(u.ops)+1)
+	ops = append(ops, u.ops...)
+	ops = append(ops, UpdateOperation{OpType: opType, Field: field, Value: value})
+	return &Update{ops: ops}
+}
+
+// Set returns a new Update which sets field to value.
+func Set(field string, value interface{}) *Update {
+	return NewUpdate().Set(field, value)
+}
+
+// Inc returns a new Update which increments a numeric field by the supplied amount.
+func Inc(field string, amount interface{}) *Update {
+	return NewUpdate().Inc(field, amount)
+}
+
+// Push returns a new Update which appends values to an array field.
+func Push(field string, values ...interface{}) *Update {
+	return NewUpdate().Push(field, values...)
+}
+
+// Pull returns a new Update which removes all the occurrences of values from an array field.
+func Pull(field string, values ...interface{}) *Update {
+	return NewUpdate().Pull(field, values...)
+}
+
+// Unset returns a new Update which removes a field.
+func Unset(field string) *Update {
+	return NewUpdate().Unset(field)
+}
+
+// Min returns a new Update which sets field to value, if value is less than the current one.
+func Min(field string, value interface{}) *Update {
+	return NewUpdate().Min(field, value)
+}
+
+// Max returns a new Update which sets field to value, if value is greater than the current one.
+func Max(field string, value interface{}) *Update {
+	return NewUpdate().Max(field, value)
+}
+
+// Rename returns a new Update which renames a field.
+func Rename(field string, newName string) *Update {
+	return NewUpdate().Rename(field, newName)
+}
+
+// Set sets field to value.
+func (u *Update) Set(field string, value interface{}) *Update {
+	return u.withOperation(SetOp, field, value)
+}
+
+// Inc increments a numeric field by the supplied amount. A missing field is set to amount.
+// The result is an integer, unless any of the operands is a floating point number.
+func (u *Update) Inc(field string, amount interface{}) *Update {
+	return u.withOperation(IncOp, field, amount)
+}
+
+// Push appends values to an array field. A missing field is set to an array containing the values.
+func (u *Update) Push(field string, values ...interface{}) *Update {
+	return u.withOperation(PushOp, field, values)
+}
+
+// Pull removes all the occurrences of values from an array field. Missing fields are left untouched.
+func (u *Update) Pull(field string, values ...interface{}) *Update {
+	return u.withOperation(PullOp, field, values)
+}
+
+// Unset removes a field.
+func (u *Update) Unset(field string) *Update {
+	return u.withOperation(UnsetOp, field, nil)
+}
+
+// Min sets field to value, if the field is missing or value is less than the current one.
+// Values are compared in the same way documents are sorted.
+func (u *Update) Min(field string, value interface{}) *Update {
+	return u.withOperation(SetMinOp, field, value)
+}
+
+// Max sets field to value, if the field is missing or value is greater than the current one.
+// Values are compared in the same way documents are sorted.
+func (u *Update) Max(field string, value interface{}) *Update {
+	return u.withOperation(SetMaxOp, field, value)
+}
+
+// Rename renames a field, replacing the value of any field having the new name. Missing fields are left untouched.
+func (u *Update) Rename(field string, newName string) *Update {
+	return u.withOperation(RenameOp, field, newName)
+}
+
+func (u *Update) Operations() []UpdateOperation {
+	return u.ops
+}
@@ -0,0 +1,328 @@
+// Package schema implements a subset of JSON Schema, which can be used to validate the documents of a collection.
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ostafen/clover/v2/internal"
+	"github.com/ostafen/clover/v2/util"
+)
+
+// Supported types.
+const (
+	ObjectType  = "object"
+	ArrayType   = "array"
+	StringType  = "string"
+	NumberType  = "number"
+	IntegerType = "integer"
+	BooleanType = "boolean"
+	NullType    = "null"
+)
+
+// Types is the list of the types allowed by a schema. In JSON, a single type can be specified as a plain string.
+type Types []string
+
+func (t Types) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+func (t *Types) UnmarshalJSON(data []byte) error {
+	var typeName string
+	if err := json.Unmarshal(data, &typeName); err == nil {
+		*t = Types{typeName}
+		return nil
+	}
+
+	var types []string
+	if err := json.Unmarshal(data, &types); err != nil {
+		return err
+	}
+	*t = types
+	return nil
+}
+
+// Schema describes the structure of a JSON value.
+// The supported keywords are: type, properties, required, additionalProperties, items, enum, minimum, maximum,
+// exclusiveMinimum, exclusiveMaximum, minLength, maxLength, pattern, minItems and maxItems.
+// Dates, which are not a JSON type, are treated as strings.
+type Schema struct {
+	Type                 Types              `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	ExclusiveMinimum     *float64           `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum     *float64           `json:"exclusiveMaximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+}
+
+// ValidationError reports the first violation of a schema found in a value.
+type ValidationError struct {
+	// Path is the path of the value violating the schema. Nested fields are separated by dot, while array elements are denoted by their index in square brackets.
+	// The path is empty if the violation concerns the whole value.
+	Path    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Path == "" {
+		return "schema validation failed: " + e.Message
+	}
+	return fmt.Sprintf("schema validation failed at %s: %s", e.Path, e.Message)
+}
+
+// Parse parses a JSON Schema, and checks that it is well formed.
+func Parse(data []byte) (*Schema, error) {
+	s := &Schema{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	return s, s.Check()
+}
+
+// Check reports whether the schema is well formed, that is whether all its types are known and its patterns compile.
+func (s *Schema) Check() error {
+	for _, typeName := range s.Type {
+		switch typeName {
+		case ObjectType, ArrayType, StringType, NumberType, IntegerType, BooleanType, NullType:
+		default:
+			return fmt.Errorf("unknown type %q", typeName)
+		}
+	}
+
+	if s.Pattern != "" {
+		if _, err := compilePattern(s.Pattern); err != nil {
+			return err
+		}
+	}
+
+	for _, prop := range s.Properties {
+		if err := prop.Check(); err != nil {
+			return err
+		}
+	}
+
+	if s.Items != nil {
+		return s.Items.Check()
+	}
+	return nil
+}
+
+var patterns sync.Map
+
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if re, ok := patterns.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	patterns.Store(pattern, re)
+	return re, nil
+}
+
+// Validate checks that v conforms to the schema. Fields listed in ignoredFields are not subject to the additionalProperties keyword of the root schema.
+// It returns a *ValidationError describing the first violation, if any.
+func (s *Schema) Validate(v interface{}, ignoredFields ...string) error {
+	ignored := make(map[string]bool, len(ignoredFields))
+	for _, field := range ignoredFields {
+		ignored[field] = true
+	}
+	return s.validate("", v, ignored)
+}
+
+func joinPath(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+func typeOf(v interface{}) string {
+	if util.IsNumber(v) {
+		return NumberType
+	}
+
+	switch v.(type) {
+	case nil:
+		return NullType
+	case string, time.Time:
+		return StringType
+	case bool:
+		return BooleanType
+	case map[string]interface{}:
+		return ObjectType
+	case []interface{}:
+		return ArrayType
+	}
+	return ""
+}
+
+func isInteger(v interface{}) bool {
+	f, isFloat := v.(float64)
+	return !isFloat || f == math.Trunc(f)
+}
+
+func (s *Schema) matchesType(v interface{}) bool {
+	if len(s.Type) == 0 {
+		return true
+	}
+
+	vType := typeOf(v)
+	for _, typeName := range s.Type {
+		if typeName == vType || (typeName == IntegerType && vType == NumberType && isInteger(v)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Schema) validate(path string, v interface{}, ignoredFields map[string]bool) error {
+	fail := func(format string, args ...interface{}) error {
+		return &ValidationError{Path: path, Message: fmt.Sprintf(format, args...)}
+	}
+
+	if !s.matchesType(v) {
+		return fail("expected %s, found %s", strings.Join(s.Type, " or "), typeOf(v))
+	}
+
+	if len(s.Enum) > 0 {
+		found := false
+		for _, value := range s.Enum {
+			found = found || internal.Compare(value, v) == 0
+		}
+
+		if !found {
+			return fail("value is not one of the allowed values")
+		}
+	}
+
+	switch value := v.(type) {
+	case map[string]interface{}:
+		return s.validateObject(path, value, ignoredFields)
+	case []interface{}:
+		return s.validateArray(path, value)
+	case string:
+		return s.validateString(path, value)
+	}
+
+	if util.IsNumber(v) {
+		return s.validateNumber(path, util.ToFloat64(v))
+	}
+	return nil
+}
+
+func (s *Schema) validateObject(path string, m map[string]interface{}, ignoredFields map[string]bool) error {
+	for _, field := range s.Required {
+		if _, has := m[field]; !has {
+			return &ValidationError{Path: joinPath(path, field), Message: "required field is missing"}
+		}
+	}
+
+	// fields are visited in order, so that the reported violation does not depend on map iteration order
+	fields := make([]string, 0, len(m))
+	for field := range m {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		prop := s.Properties[field]
+		if prop == nil {
+			if s.AdditionalProperties != nil && !*s.AdditionalProperties && !ignoredFields[field] {
+				return &ValidationError{Path: joinPath(path, field), Message: "additional field is not allowed"}
+			}
+			continue
+		}
+
+		if err := prop.validate(joinPath(path, field), m[field], nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validateArray(path string, arr []interface{}) error {
+	if s.MinItems != nil && len(arr) < *s.MinItems {
+		return &ValidationError{Path: path, Message: fmt.Sprintf("expected at least %d items, found %d", *s.MinItems, len(arr))}
+	}
+
+	if s.MaxItems != nil && len(arr) > *s.MaxItems {
+		return &ValidationError{Path: path, Message: fmt.Sprintf("expected at most %d items, found %d", *s.MaxItems, len(arr))}
+	}
+
+	if s.Items != nil {
+		for i, elem := range arr {
+			if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), elem, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validateString(path string, str string) error {
+	length := utf8.RuneCountInString(str)
+	if s.MinLength != nil && length < *s.MinLength {
+		return &ValidationError{Path: path, Message: fmt.Sprintf("expected at least %d characters, found %d", *s.MinLength, length)}
+	}
+
+	if s.MaxLength != nil && length > *s.MaxLength {
+		return &ValidationError{Path: path, Message: fmt.Sprintf("expected at most %d characters, found %d", *s.MaxLength, length)}
+	}
+
+	if s.Pattern != "" {
+		re, err := compilePattern(s.Pattern)
+		if err != nil {
+			return err
+		}
+
+		if !re.MatchString(str) {
+			return &ValidationError{Path: path, Message: fmt.Sprintf("value does not match pattern %q", s.Pattern)}
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validateNumber(path string, f float64) error {
+	fail := func(op string, bound float64) error {
+		return &ValidationError{Path: path, Message: fmt.Sprintf("expected a value %s %v, found %v", op, bound, f)}
+	}
+
+	if s.Minimum != nil && f < *s.Minimum {
+		return fail(">=", *s.Minimum)
+	}
+
+	if s.Maximum != nil && f > *s.Maximum {
+		return fail("<=", *s.Maximum)
+	}
+
+	if s.ExclusiveMinimum != nil && f <= *s.ExclusiveMinimum {
+		return fail(">", *s.ExclusiveMinimum)
+	}
+
+	if s.ExclusiveMaximum != nil && f >= *s.ExclusiveMaximum {
+		return fail("<", *s.ExclusiveMaximum)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package schema_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ostafen/clover/v2/schema"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	s, err := schema.Parse([]byte(`{
+		"type": "object",
+		"properties": {
+			"status": {"enum": ["open", "closed"]},
+			"score": {"type": ["number", "null"], "exclusiveMinimum": 0, "maximum": 10},
+			"items": {"type": "array", "minItems": 1, "maxItems": 2, "items": {"type": "object", "required": ["id"]}},
+			"code": {"type": "string", "maxLength": 3},
+			"createdAt": {"type": "string"},
+			"done": {"type": "boolean"}
+		}
+	}`))
+	require.NoError(t, err)
+
+	cases := []struct {
+		value interface{}
+		valid bool
+		path  string
+	}{
+		{map[string]interface{}{"status": "open", "score": nil, "createdAt": time.Now(), "done": true}, true, ""},
+		{map[string]interface{}{"score": int64(10), "items": []interface{}{map[string]interface{}{"id": 1}}}, true, ""},
+		{map[string]interface{}{"status": "pending"}, false, "status"},
+		{map[string]interface{}{"score": float64(0)}, false, "score"},
+		{map[string]interface{}{"score": uint64(11)}, false, "score"},
+		{map[string]interface{}{"items": []interface{}{}}, false, "items"},
+		{map[string]interface{}{"items": []interface{}{map[string]interface{}{"id": 1}, map[string]interface{}{}}}, false, "items[1].id"},
+		{map[string]interface{}{"code": "ààà"}, true, ""},
+		{map[string]interface{}{"code": "abcd"}, false, "code"},
+		{map[string]interface{}{"done": "yes"}, false, "done"},
+		{"not an object", false, ""},
+	}
+
+	for _, c := range cases {
+		err := s.Validate(c.value)
+		if c.valid {
+			require.NoError(t, err)
+			continue
+		}
+
+		var validationErr *schema.ValidationError
+		require.True(t, errors.As(err, &validationErr))
+		require.Equal(t, c.path, validationErr.Path)
+	}
+}
+
+func TestAdditionalProperties(t *testing.T) {
+	s, err := schema.Parse([]byte(`{"additionalProperties": false, "properties": {"a": {"additionalProperties": false}}}`))
+	require.NoError(t, err)
+
+	require.NoError(t, s.Validate(map[string]interface{}{"_id": "1", "a": map[string]interface{}{}}, "_id"))
+	require.EqualError(t, s.Validate(map[string]interface{}{"b": 1}), "schema validation failed at b: additional field is not allowed")
+
+	// ignored fields are only allowed at the top level
+	require.Error(t, s.Validate(map[string]interface{}{"a": map[string]interface{}{"_id": "1"}}, "_id"))
+}
+
+func TestParse(t *testing.T) {
+	_, err := schema.Parse([]byte(`{"type": "date"}`))
+	require.Error(t, err)
+
+	_, err = schema.Parse([]byte(`{"properties": {"a": {"pattern": "("}}}`))
+	require.Error(t, err)
+
+	s, err := schema.Parse([]byte(`{"type": "string"}`))
+	require.NoError(t, err)
+
+	data, err := json.Marshal(s)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"type": "string"}`, string(data))
+}
@@ -0,0 +1,633 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	c "github.com/ostafen/clover/v2"
+	d "github.com/ostafen/clover/v2/document"
+	"github.com/ostafen/clover/v2/index"
+	"github.com/ostafen/clover/v2/query"
+)
+
+func (s *Server) listCollections(w http.ResponseWriter, r *http.Request, args []string) error {
+	collections, err := s.db.ListCollections()
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, collections)
+	return nil
+}
+
+type collectionInfo struct {
+	Name      string      `json:"name"`
+	Documents int         `json:"documents"`
+	Indexes   []indexInfo `json:"indexes"`
+}
+
+func (s *Server) getCollection(w http.ResponseWriter, r *http.Request, args []string) error {
+	n, err := s.db.Count(query.NewQuery(args[0]))
+	if err != nil {
+		return err
+	}
+
+	indexes, err := s.getIndexes(args[0])
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, collectionInfo{Name: args[0], Documents: n, Indexes: indexes})
+	return nil
+}
+
+func (s *Server) createCollection(w http.ResponseWriter, r *http.Request, args []string) error {
+	if err := s.db.CreateCollection(args[0]); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+func (s *Server) dropCollection(w http.ResponseWriter, r *http.Request, args []string) error {
+	if err := s.db.DropCollection(args[0]); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// queryRequest is the body of a query request.
+type queryRequest struct {
+	Filter json.RawMessage `json:"filter"`
+	Sort   []string        `json:"sort"`
+	Skip   int             `json:"skip"`
+	Limit  *int            `json:"limit"`
+	Select []string        `json:"select"`
+}
+
+func (req *queryRequest) toQuery(collection string) (*query.Query, error) {
+	q := query.NewQuery(collection)
+	if len(req.Filter) > 0 && string(req.Filter) != "null" {
+		criteria, err := query.ParseFilter(req.Filter)
+		if err != nil {
+			return nil, err
+		}
+
+		if criteria != nil {
+			q = q.Where(criteria)
+		}
+	}
+
+	if opts := query.ParseSortOptions(req.Sort); len(opts) > 0 {
+		q = q.Sort(opts...)
+	}
+
+	if len(req.Select) > 0 {
+		q = q.Select(req.Select...)
+	}
+
+	if req.Skip < 0 {
+		return nil, badRequest("skip must be non negative")
+	}
+	q = q.Skip(req.Skip)
+
+	if req.Limit != nil {
+		if *req.Limit < 0 {
+			return nil, badRequest("limit must be non negative")
+		}
+		q = q.Limit(*req.Limit)
+	}
+	return q, nil
+}
+
+// queryFromParams builds a query from the filter (or where), sort, skip, limit and select URL parameters.
+// Sort and select parameters are comma separated lists of fields.
+func queryFromParams(collection string, params url.Values) (*query.Query, error) {
+	req := &queryRequest{Filter: json.RawMessage(params.Get("filter"))}
+	where := params.Get("where")
+	if where != "" && len(req.Filter) > 0 {
+		return nil, badRequest("filter and where parameters cannot be used together")
+	}
+
+	if sort := params.Get("sort"); sort != "" {
+		req.Sort = strings.Split(sort, ",")
+	}
+
+	if fields := params.Get("select"); fields != "" {
+		req.Select = strings.Split(fields, ",")
+	}
+
+	if skip := params.Get("skip"); skip != "" {
+		n, err := strconv.Atoi(skip)
+		if err != nil {
+			return nil, badRequest("invalid skip parameter: %s", skip)
+		}
+		req.Skip = n
+	}
+
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			return nil, badRequest("invalid limit parameter: %s", limit)
+		}
+		req.Limit = &n
+	}
+
+	q, err := req.toQuery(collection)
+	if err != nil || where == "" {
+		return q, err
+	}
+
+	criteria, err := query.ParseWhere(where)
+	if err != nil {
+		return nil, err
+	}
+	return q.Where(criteria), nil
+}
+
+func (s *Server) findDocuments(w http.ResponseWriter, r *http.Request, args []string) error {
+	q, err := queryFromParams(args[0], r.URL.Query())
+	if err != nil {
+		return err
+	}
+	return s.writeDocuments(w, q)
+}
+
+func (s *Server) queryDocuments(w http.ResponseWriter, r *http.Request, args []string) error {
+	req := &queryRequest{}
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(req); err != nil {
+		return badRequest("invalid query: %s", err)
+	}
+
+	q, err := req.toQuery(args[0])
+	if err != nil {
+		return err
+	}
+	return s.writeDocuments(w, q)
+}
+
+// sqlQuery finds documents according to a SQL-like statement, supplied either as the q URL parameter or as the body of the request.
+func (s *Server) sqlQuery(w http.ResponseWriter, r *http.Request, args []string) error {
+	statement := r.URL.Query().Get("q")
+	if r.Method == http.MethodPost {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			return badRequest("invalid request body: %s", err)
+		}
+		statement = string(data)
+	}
+
+	if strings.TrimSpace(statement) == "" {
+		return badRequest("missing SQL statement")
+	}
+
+	q, err := query.ParseSQL(statement)
+	if err != nil {
+		return err
+	}
+	return s.writeDocuments(w, q)
+}
+
+func (s *Server) writeDocuments(w http.ResponseWriter, q *query.Query) error {
+	docs, err := s.db.FindAll(q)
+	if err != nil {
+		return err
+	}
+
+	maps := make([]map[string]interface{}, 0, len(docs))
+	for _, doc := range docs {
+		maps = append(maps, doc.AsMap())
+	}
+	writeJSON(w, http.StatusOK, maps)
+	return nil
+}
+
+type countResponse struct {
+	Count int `json:"count"`
+}
+
+func (s *Server) countDocuments(w http.ResponseWriter, r *http.Request, args []string) error {
+	q, err := queryFromParams(args[0], r.URL.Query())
+	if err != nil {
+		return err
+	}
+
+	n, err := s.db.Count(q)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, countResponse{Count: n})
+	return nil
+}
+
+// newDocument converts a decoded JSON object into a document. Since JSON has no time type,
+// an _expiresAt field is expected to be a string in RFC3339 format.
+func newDocument(fields map[string]interface{}) (*d.Document, error) {
+	if fields == nil {
+		return nil, badRequest("a document must be a JSON object")
+	}
+
+	doc := d.NewDocumentOf(fields)
+	if s, isString := doc.Get(d.ExpiresAtField).(string); isString {
+		expiresAt, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return nil, badRequest("invalid %s: %s", d.ExpiresAtField, s)
+		}
+		doc.SetExpiresAt(expiresAt)
+	}
+
+	if !doc.Has(d.ObjectIdField) || doc.Get(d.ObjectIdField) == "" {
+		doc.Set(d.ObjectIdField, c.NewObjectId())
+	}
+
+	if err := d.Validate(doc); err != nil {
+		return nil, badRequest("%s", err)
+	}
+	return doc, nil
+}
+
+type insertResponse struct {
+	Id string `json:"_id"`
+}
+
+type insertManyResponse struct {
+	Ids []string `json:"ids"`
+}
+
+// insertDocuments inserts the document contained in the body, or all the documents of an array, in a single transaction.
+func (s *Server) insertDocuments(w http.ResponseWriter, r *http.Request, args []string) error {
+	var body json.RawMessage
+	if err := decodeBody(r, &body); err != nil {
+		return err
+	}
+
+	if trimmed := strings.TrimSpace(string(body)); !strings.HasPrefix(trimmed, "[") {
+		var fields map[string]interface{}
+		if err := json.Unmarshal(body, &fields); err != nil {
+			return badRequest("a document must be a JSON object")
+		}
+
+		doc, err := newDocument(fields)
+		if err != nil {
+			return err
+		}
+
+		if err := s.db.Insert(args[0], doc); err != nil {
+			return err
+		}
+
+		w.Header().Set("Location", documentPath(args[0], doc.ObjectId()))
+		writeJSON(w, http.StatusCreated, insertResponse{Id: doc.ObjectId()})
+		return nil
+	}
+
+	var objects []map[string]interface{}
+	if err := json.Unmarshal(body, &objects); err != nil {
+		return badRequest("documents must be JSON objects")
+	}
+
+	docs := make([]*d.Document, 0, len(objects))
+	ids := make([]string, 0, len(objects))
+	for _, fields := range objects {
+		doc, err := newDocument(fields)
+		if err != nil {
+			return err
+		}
+		docs = append(docs, doc)
+		ids = append(ids, doc.ObjectId())
+	}
+
+	if err := s.db.Insert(args[0], docs...); err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusCreated, insertManyResponse{Ids: ids})
+	return nil
+}
+
+func documentPath(collection, id string) string {
+	return "/collections/" + url.PathEscape(collection) + "/documents/" + url.PathEscape(id)
+}
+
+func (s *Server) getDocument(w http.ResponseWriter, r *http.Request, args []string) error {
+	doc, err := s.db.FindById(args[0], args[1])
+	if err != nil {
+		return err
+	}
+
+	if doc == nil {
+		return c.ErrDocumentNotExist
+	}
+	writeJSON(w, http.StatusOK, doc.AsMap())
+	return nil
+}
+
+func (s *Server) replaceDocument(w http.ResponseWriter, r *http.Request, args []string) error {
+	var fields map[string]interface{}
+	if err := decodeBody(r, &fields); err != nil {
+		return err
+	}
+
+	if fields != nil {
+		if id, has := fields[d.ObjectIdField]; has && id != args[1] {
+			return badRequest("the %s field does not match the document id", d.ObjectIdField)
+		}
+		fields[d.ObjectIdField] = args[1]
+	}
+
+	doc, err := newDocument(fields)
+	if err != nil {
+		return err
+	}
+
+	if err := s.db.ReplaceById(args[0], args[1], doc); err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, doc.AsMap())
+	return nil
+}
+
+// updateDocument sets the fields of the document to the values supplied in the body, and returns the updated document.
+func (s *Server) updateDocument(w http.ResponseWriter, r *http.Request, args []string) error {
+	var fields map[string]interface{}
+	if err := decodeBody(r, &fields); err != nil {
+		return err
+	}
+
+	if fields == nil {
+		return badRequest("an update must be a JSON object")
+	}
+
+	if id, has := fields[d.ObjectIdField]; has && id != args[1] {
+		return badRequest("the %s field cannot be modified", d.ObjectIdField)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.UpdateById(args[0], args[1], func(doc *d.Document) *d.Document {
+		doc.SetAll(fields)
+		return doc
+	})
+	if err != nil {
+		return err
+	}
+
+	doc, err := tx.FindById(args[0], args[1])
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, doc.AsMap())
+	return nil
+}
+
+func (s *Server) deleteDocument(w http.ResponseWriter, r *http.Request, args []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	doc, err := tx.FindById(args[0], args[1])
+	if err != nil {
+		return err
+	}
+
+	if doc == nil {
+		return c.ErrDocumentNotExist
+	}
+
+	if err := tx.DeleteById(args[0], args[1]); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+type indexInfo struct {
+	Name   string   `json:"name"`
+	Fields []string `json:"fields"`
+	Unique bool     `json:"unique,omitempty"`
+	Text   bool     `json:"text,omitempty"`
+	Geo    bool     `json:"geo,omitempty"`
+}
+
+func (s *Server) getIndexes(collection string) ([]indexInfo, error) {
+	indexes, err := s.db.ListIndexes(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]indexInfo, 0, len(indexes))
+	for _, info := range indexes {
+		fields := []string{info.Field}
+		if info.Type != index.SingleField {
+			fields = make([]string, 0, len(info.Fields))
+			for _, opt := range info.Fields {
+				if opt.Direction < 0 {
+					fields = append(fields, "-"+opt.Field)
+				} else {
+					fields = append(fields, opt.Field)
+				}
+			}
+		}
+		infos = append(infos, indexInfo{Name: info.Field, Fields: fields, Unique: info.Unique, Text: info.Type == index.FullText, Geo: info.Type == index.Geospatial})
+	}
+	return infos, nil
+}
+
+func (s *Server) listIndexes(w http.ResponseWriter, r *http.Request, args []string) error {
+	indexes, err := s.getIndexes(args[0])
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, indexes)
+	return nil
+}
+
+type createIndexRequest struct {
+	Fields []string `json:"fields"`
+	Unique bool     `json:"unique"`
+	Text   bool     `json:"text"`
+	Geo    bool     `json:"geo"`
+}
+
+// createIndex creates a single field index, or a compound index if more fields are supplied.
+// If text (or geo) is set, a full-text (or geospatial) index is created over the only supplied field.
+func (s *Server) createIndex(w http.ResponseWriter, r *http.Request, args []string) error {
+	req := &createIndexRequest{}
+	if err := decodeBody(r, req); err != nil {
+		return err
+	}
+
+	opts := query.ParseSortOptions(req.Fields)
+	if len(opts) == 0 {
+		return badRequest("at least one field is required")
+	}
+
+	var err error
+	switch {
+	case (req.Text || req.Geo) && (len(opts) > 1 || req.Unique || opts[0].Direction < 0 || (req.Text && req.Geo)):
+		return badRequest("full-text and geospatial indexes must be built over a single ascending field, and cannot be unique")
+	case req.Geo:
+		err = s.db.CreateGeoIndex(args[0], opts[0].Field)
+	case req.Text:
+		err = s.db.CreateFullTextIndex(args[0], opts[0].Field)
+	case len(opts) > 1 && req.Unique:
+		return badRequest("compound indexes cannot be unique")
+	case len(opts) > 1:
+		err = s.db.CreateCompoundIndex(args[0], opts...)
+	case opts[0].Direction < 0:
+		return badRequest("single field indexes cannot be descending")
+	case req.Unique:
+		err = s.db.CreateUniqueIndex(args[0], opts[0].Field)
+	default:
+		err = s.db.CreateIndex(args[0], opts[0].Field)
+	}
+
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+func (s *Server) dropIndex(w http.ResponseWriter, r *http.Request, args []string) error {
+	if err := s.db.DropIndex(args[0], args[1]); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// lazyWriter delays writing the header of a response until the first write,
+// so that errors occurring before any data is produced can still be reported with the proper status code.
+type lazyWriter struct {
+	w           http.ResponseWriter
+	contentType string
+	started     bool
+}
+
+func (lw *lazyWriter) Write(p []byte) (int, error) {
+	if !lw.started {
+		lw.w.Header().Set("Content-Type", lw.contentType)
+		lw.w.WriteHeader(http.StatusOK)
+		lw.started = true
+	}
+	return lw.w.Write(p)
+}
+
+// exportDocuments streams the documents matching the query parameters in the format given by the format parameter (json, ndjson or csv).
+func (s *Server) exportDocuments(w http.ResponseWriter, r *http.Request, args []string) error {
+	params := r.URL.Query()
+	q, err := queryFromParams(args[0], params)
+	if err != nil {
+		return err
+	}
+
+	format := params.Get("format")
+	if format == "" {
+		format = "json"
+	}
+
+	// the collection is checked in advance, since exporting an empty collection produces output anyway
+	exists, err := s.db.HasCollection(args[0])
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return c.ErrCollectionNotExist
+	}
+
+	lw := &lazyWriter{w: w}
+	switch format {
+	case "json":
+		lw.contentType = "application/json"
+		_, err = s.db.Export(lw, q, c.ExportOptions{Format: c.FormatJSON})
+	case "ndjson":
+		lw.contentType = "application/x-ndjson"
+		_, err = s.db.Export(lw, q, c.ExportOptions{Format: c.FormatNDJSON})
+	case "csv":
+		lw.contentType = "text/csv"
+		_, err = s.db.ExportCSV(lw, q)
+	default:
+		return badRequest("unknown format %q", format)
+	}
+
+	// once the response has started, errors can only be reported by truncating it
+	if err != nil && lw.started {
+		panic(http.ErrAbortHandler)
+	}
+	return err
+}
+
+type importResponse struct {
+	Imported int    `json:"imported"`
+	Error    string `json:"error,omitempty"`
+}
+
+// importDocuments imports the documents contained in the body, according to the format (json, ndjson or csv),
+// mode (append, replace or upsert) and batch parameters. The number of imported documents is reported even on failure.
+func (s *Server) importDocuments(w http.ResponseWriter, r *http.Request, args []string) error {
+	params := r.URL.Query()
+
+	mode := c.ImportAppend
+	switch params.Get("mode") {
+	case "", "append":
+	case "replace":
+		mode = c.ImportReplace
+	case "upsert":
+		mode = c.ImportUpsert
+	default:
+		return badRequest("unknown import mode %q", params.Get("mode"))
+	}
+
+	batchSize := c.DefaultImportBatchSize
+	if batch := params.Get("batch"); batch != "" {
+		n, err := strconv.Atoi(batch)
+		if err != nil || n <= 0 {
+			return badRequest("invalid batch parameter: %s", batch)
+		}
+		batchSize = n
+	}
+
+	var n int
+	var err error
+	switch format := params.Get("format"); format {
+	case "", "json", "ndjson":
+		f := c.FormatJSON
+		if format == "ndjson" {
+			f = c.FormatNDJSON
+		}
+		n, err = s.db.Import(r.Body, args[0], c.ImportOptions{Format: f, Mode: mode, BatchSize: batchSize})
+	case "csv":
+		n, err = s.db.ImportCSV(r.Body, args[0], c.CSVImportOptions{Mode: mode, BatchSize: batchSize})
+	default:
+		return badRequest("unknown format %q", format)
+	}
+
+	if err != nil {
+		writeJSON(w, statusCode(err), importResponse{Imported: n, Error: err.Error()})
+		return nil
+	}
+	writeJSON(w, http.StatusOK, importResponse{Imported: n})
+	return nil
+}
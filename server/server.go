@@ -0,0 +1,250 @@
+// Package server exposes a clover database over HTTP, using JSON to represent documents.
+//
+// The following endpoints are available:
+//
+//	GET    /collections                              list collections
+//	GET    /sql                                      find documents, according to the SQL-like statement supplied in the q parameter
+//	POST   /sql                                      find documents, according to the SQL-like statement supplied in the body
+//	GET    /collections/{name}                       get the number of documents and the indexes of a collection
+//	PUT    /collections/{name}                       create a collection
+//	DELETE /collections/{name}                       drop a collection
+//	GET    /collections/{name}/documents             find documents, according to the filter (or where), sort, skip, limit and select parameters
+//	POST   /collections/{name}/documents             insert a document, or an array of documents
+//	POST   /collections/{name}/query                 find documents, according to a query object supplied in the body
+//	GET    /collections/{name}/count                 count documents, according to the filter (or where) parameter
+//	GET    /collections/{name}/documents/{id}        get a document
+//	PUT    /collections/{name}/documents/{id}        replace a document
+//	PATCH  /collections/{name}/documents/{id}        set the fields of a document to the supplied values
+//	DELETE /collections/{name}/documents/{id}        delete a document
+//	GET    /collections/{name}/indexes               list the indexes of a collection
+//	POST   /collections/{name}/indexes               create an index
+//	DELETE /collections/{name}/indexes/{index}       drop an index
+//	GET    /collections/{name}/export                export documents, according to the format parameter and to the query parameters
+//	POST   /collections/{name}/import                import documents, according to the format, mode and batch parameters
+//
+// Filters are JSON objects, such as {"age": {"$gt": 30}}: fields are matched by equality, unless their value is an object of operators.
+// The format is the one accepted by query.ParseFilter. Alternatively, the where parameter accepts SQL-like conditions, such as age > 30 AND city = 'Rome',
+// in the format accepted by query.ParseWhere, while SQL statements are in the format accepted by query.ParseSQL.
+// Sort fields are prefixed by a minus sign to sort documents in descending order.
+//
+// Errors are reported as a JSON object with an "error" field. Missing collections, documents and indexes are reported with status 404,
+// while conflicts, such as duplicate keys, with status 409.
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	c "github.com/ostafen/clover/v2"
+	"github.com/ostafen/clover/v2/query"
+	"github.com/ostafen/clover/v2/schema"
+)
+
+// maxBodySize is the maximum size of the body of requests other than imports.
+const maxBodySize = 16 << 20
+
+type handlerFunc func(w http.ResponseWriter, r *http.Request, args []string) error
+
+type route struct {
+	// pattern is a slash separated path, where each * matches a single segment, which is passed to the handler
+	pattern  string
+	handlers map[string]handlerFunc
+}
+
+// Server is an http.Handler serving the collections of a database.
+type Server struct {
+	db     *c.DB
+	routes []route
+}
+
+// New returns a server for the supplied database.
+func New(db *c.DB) *Server {
+	s := &Server{db: db}
+	s.routes = []route{
+		{"collections", map[string]handlerFunc{http.MethodGet: s.listCollections}},
+		{"sql", map[string]handlerFunc{http.MethodGet: s.sqlQuery, http.MethodPost: s.sqlQuery}},
+		{"collections/*", map[string]handlerFunc{
+			http.MethodGet:    s.getCollection,
+			http.MethodPut:    s.createCollection,
+			http.MethodDelete: s.dropCollection,
+		}},
+		{"collections/*/documents", map[string]handlerFunc{
+			http.MethodGet:  s.findDocuments,
+			http.MethodPost: s.insertDocuments,
+		}},
+		{"collections/*/documents/*", map[string]handlerFunc{
+			http.MethodGet:    s.getDocument,
+			http.MethodPut:    s.replaceDocument,
+			http.MethodPatch:  s.updateDocument,
+			http.MethodDelete: s.deleteDocument,
+		}},
+		{"collections/*/query", map[string]handlerFunc{http.MethodPost: s.queryDocuments}},
+		{"collections/*/count", map[string]handlerFunc{http.MethodGet: s.countDocuments}},
+		{"collections/*/indexes", map[string]handlerFunc{
+			http.MethodGet:  s.listIndexes,
+			http.MethodPost: s.createIndex,
+		}},
+		{"collections/*/indexes/*", map[string]handlerFunc{http.MethodDelete: s.dropIndex}},
+		{"collections/*/export", map[string]handlerFunc{http.MethodGet: s.exportDocuments}},
+		{"collections/*/import", map[string]handlerFunc{http.MethodPost: s.importDocuments}},
+	}
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments, err := splitPath(r.URL.EscapedPath())
+	if err != nil {
+		writeError(w, badRequest("invalid path: %s", err))
+		return
+	}
+
+	for _, rt := range s.routes {
+		args, ok := matchPattern(rt.pattern, segments)
+		if !ok {
+			continue
+		}
+
+		handler, has := rt.handlers[r.Method]
+		if !has {
+			w.Header().Set("Allow", allowedMethods(rt.handlers))
+			writeError(w, &httpError{status: http.StatusMethodNotAllowed, err: fmt.Errorf("method %s not allowed", r.Method)})
+			return
+		}
+
+		if r.Method != http.MethodPost || !strings.HasSuffix(rt.pattern, "/import") {
+			r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+		}
+
+		if err := handler(w, r, args); err != nil {
+			writeError(w, err)
+		}
+		return
+	}
+	writeError(w, &httpError{status: http.StatusNotFound, err: fmt.Errorf("no such endpoint: %s", r.URL.Path)})
+}
+
+func splitPath(path string) ([]string, error) {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil, nil
+	}
+
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		unescaped, err := url.PathUnescape(segment)
+		if err != nil {
+			return nil, err
+		}
+		segments[i] = unescaped
+	}
+	return segments, nil
+}
+
+func matchPattern(pattern string, segments []string) ([]string, bool) {
+	parts := strings.Split(pattern, "/")
+	if len(parts) != len(segments) {
+		return nil, false
+	}
+
+	args := make([]string, 0)
+	for i, part := range parts {
+		if part == "*" {
+			if segments[i] == "" {
+				return nil, false
+			}
+			args = append(args, segments[i])
+		} else if part != segments[i] {
+			return nil, false
+		}
+	}
+	return args, true
+}
+
+func allowedMethods(handlers map[string]handlerFunc) string {
+	methods := make([]string, 0, len(handlers))
+	for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		if _, has := handlers[method]; has {
+			methods = append(methods, method)
+		}
+	}
+	return strings.Join(methods, ", ")
+}
+
+// httpError is an error carrying the status code of the response.
+type httpError struct {
+	status int
+	err    error
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+func (e *httpError) Unwrap() error {
+	return e.err
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &httpError{status: http.StatusBadRequest, err: fmt.Errorf(format, args...)}
+}
+
+// statusCode returns the status code of the response reporting err.
+func statusCode(err error) int {
+	var httpErr *httpError
+	if errors.As(err, &httpErr) {
+		return httpErr.status
+	}
+
+	var validationErr *schema.ValidationError
+	if errors.As(err, &validationErr) {
+		return http.StatusBadRequest
+	}
+
+	var syntaxErr *query.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return http.StatusBadRequest
+	}
+
+	switch {
+	case errors.Is(err, c.ErrCollectionNotExist), errors.Is(err, c.ErrDocumentNotExist), errors.Is(err, c.ErrIndexNotExist):
+		return http.StatusNotFound
+	case errors.Is(err, c.ErrCollectionExist), errors.Is(err, c.ErrIndexExist), errors.Is(err, c.ErrDuplicateKey), errors.Is(err, c.ErrVersionConflict):
+		return http.StatusConflict
+	case errors.Is(err, c.ErrInvalidImport), errors.Is(err, c.ErrInvalidUpdate), errors.Is(err, query.ErrInvalidFilter):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	writeJSON(w, statusCode(err), errorResponse{Error: err.Error()})
+}
+
+// writeJSON writes v as the body of the response. Since the status code has already been sent,
+// encoding errors (usually caused by the client closing the connection) are ignored.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// decodeBody decodes the JSON body of a request into v.
+func decodeBody(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(v); err != nil {
+		return badRequest("invalid request body: %s", err)
+	}
+
+	if decoder.More() {
+		return badRequest("invalid request body: unexpected data after JSON value")
+	}
+	return nil
+}
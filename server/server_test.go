@@ -0,0 +1,334 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	c "github.com/ostafen/clover/v2"
+	"github.com/ostafen/clover/v2/schema"
+	"github.com/ostafen/clover/v2/server"
+	"github.com/ostafen/clover/v2/store"
+	badgerstore "github.com/ostafen/clover/v2/store/badger"
+	"github.com/ostafen/clover/v2/store/bbolt"
+	"github.com/stretchr/testify/require"
+)
+
+func runServerTest(t *testing.T, test func(t *testing.T, db *c.DB, h http.Handler)) {
+	for _, openStore := range []func(string) (store.Store, error){badgerstore.Open, bbolt.Open} {
+		dir, err := os.MkdirTemp("", "clover-server")
+		require.NoError(t, err)
+
+		dataStore, err := openStore(dir)
+		require.NoError(t, err)
+
+		db, err := c.OpenWithStore(dataStore)
+		require.NoError(t, err)
+
+		test(t, db, server.New(db))
+
+		require.NoError(t, db.Close())
+		require.NoError(t, os.RemoveAll(dir))
+	}
+}
+
+func do(h http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// decode unmarshals the JSON body of a response into v, which is reset first.
+func decode(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	rv := reflect.ValueOf(v).Elem()
+	rv.Set(reflect.Zero(rv.Type()))
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), v))
+}
+
+func requireError(t *testing.T, rec *httptest.ResponseRecorder, status int, msg string) {
+	require.Equal(t, status, rec.Code)
+
+	var body map[string]string
+	decode(t, rec, &body)
+	require.Contains(t, body["error"], msg)
+}
+
+func TestCollections(t *testing.T) {
+	runServerTest(t, func(t *testing.T, db *c.DB, h http.Handler) {
+		require.Equal(t, http.StatusCreated, do(h, "PUT", "/collections/todos", "").Code)
+		requireError(t, do(h, "PUT", "/collections/todos", ""), http.StatusConflict, c.ErrCollectionExist.Error())
+
+		// names are unescaped
+		require.Equal(t, http.StatusCreated, do(h, "PUT", "/collections/my%20notes", "").Code)
+
+		var names []string
+		decode(t, do(h, "GET", "/collections", ""), &names)
+		require.Equal(t, []string{"my notes", "todos"}, names)
+
+		require.Equal(t, http.StatusCreated, do(h, "POST", "/collections/todos/documents", `{"title": "a"}`).Code)
+
+		var info map[string]interface{}
+		decode(t, do(h, "GET", "/collections/todos", ""), &info)
+		require.Equal(t, map[string]interface{}{"name": "todos", "documents": 1.0, "indexes": []interface{}{}}, info)
+
+		require.Equal(t, http.StatusNoContent, do(h, "DELETE", "/collections/todos", "").Code)
+		requireError(t, do(h, "DELETE", "/collections/todos", ""), http.StatusNotFound, c.ErrCollectionNotExist.Error())
+		requireError(t, do(h, "GET", "/collections/todos", ""), http.StatusNotFound, c.ErrCollectionNotExist.Error())
+	})
+}
+
+func TestDocuments(t *testing.T) {
+	runServerTest(t, func(t *testing.T, db *c.DB, h http.Handler) {
+		requireError(t, do(h, "POST", "/collections/todos/documents", `{"title": "a"}`), http.StatusNotFound, c.ErrCollectionNotExist.Error())
+		require.NoError(t, db.CreateCollection("todos"))
+
+		rec := do(h, "POST", "/collections/todos/documents", `{"title": "a", "tags": ["x"], "meta": {"priority": 1}}`)
+		require.Equal(t, http.StatusCreated, rec.Code)
+
+		var inserted map[string]string
+		decode(t, rec, &inserted)
+		id := inserted["_id"]
+		require.Equal(t, "/collections/todos/documents/"+id, rec.Header().Get("Location"))
+
+		var doc map[string]interface{}
+		decode(t, do(h, "GET", "/collections/todos/documents/"+id, ""), &doc)
+		require.Equal(t, map[string]interface{}{"_id": id, "title": "a", "tags": []interface{}{"x"}, "meta": map[string]interface{}{"priority": 1.0}}, doc)
+
+		var insertedMany map[string][]string
+		rec = do(h, "POST", "/collections/todos/documents", `[{"title": "b"}, {"_id": "`+c.NewObjectId()+`", "title": "c", "_expiresAt": "2100-01-01T00:00:00Z"}]`)
+		require.Equal(t, http.StatusCreated, rec.Code)
+		decode(t, rec, &insertedMany)
+		require.Len(t, insertedMany["ids"], 2)
+
+		decode(t, do(h, "GET", "/collections/todos/documents/"+insertedMany["ids"][1], ""), &doc)
+		require.Equal(t, "2100-01-01T00:00:00Z", doc["_expiresAt"])
+
+		requireError(t, do(h, "POST", "/collections/todos/documents", `{"_id": "`+id+`"}`), http.StatusConflict, c.ErrDuplicateKey.Error())
+		requireError(t, do(h, "POST", "/collections/todos/documents", `{"_id": "abc"}`), http.StatusBadRequest, "invalid _id")
+		requireError(t, do(h, "POST", "/collections/todos/documents", `{"_expiresAt": "tomorrow"}`), http.StatusBadRequest, "invalid _expiresAt")
+		requireError(t, do(h, "POST", "/collections/todos/documents", `[1, 2]`), http.StatusBadRequest, "JSON objects")
+		requireError(t, do(h, "POST", "/collections/todos/documents", `{"title": `), http.StatusBadRequest, "invalid request body")
+
+		missing := "/collections/todos/documents/" + c.NewObjectId()
+		requireError(t, do(h, "GET", missing, ""), http.StatusNotFound, c.ErrDocumentNotExist.Error())
+		requireError(t, do(h, "PUT", missing, `{"title": "z"}`), http.StatusNotFound, c.ErrDocumentNotExist.Error())
+		requireError(t, do(h, "PATCH", missing, `{"title": "z"}`), http.StatusNotFound, c.ErrDocumentNotExist.Error())
+		requireError(t, do(h, "DELETE", missing, ""), http.StatusNotFound, c.ErrDocumentNotExist.Error())
+
+		decode(t, do(h, "PUT", "/collections/todos/documents/"+id, `{"title": "a2"}`), &doc)
+		require.Equal(t, map[string]interface{}{"_id": id, "title": "a2"}, doc)
+		requireError(t, do(h, "PUT", "/collections/todos/documents/"+id, `{"_id": "other"}`), http.StatusBadRequest, "does not match")
+
+		decode(t, do(h, "PATCH", "/collections/todos/documents/"+id, `{"done": true, "meta.priority": 2}`), &doc)
+		require.Equal(t, map[string]interface{}{"_id": id, "title": "a2", "done": true, "meta": map[string]interface{}{"priority": 2.0}}, doc)
+
+		require.Equal(t, http.StatusNoContent, do(h, "DELETE", "/collections/todos/documents/"+id, "").Code)
+		requireError(t, do(h, "GET", "/collections/todos/documents/"+id, ""), http.StatusNotFound, c.ErrDocumentNotExist.Error())
+	})
+}
+
+func TestSchemaViolations(t *testing.T) {
+	runServerTest(t, func(t *testing.T, db *c.DB, h http.Handler) {
+		s, err := schema.Parse([]byte(`{"type": "object", "required": ["title"]}`))
+		require.NoError(t, err)
+		require.NoError(t, db.CreateCollectionWithOptions("todos", c.CollectionOptions{Schema: s}))
+
+		requireError(t, do(h, "POST", "/collections/todos/documents", `{"done": false}`), http.StatusBadRequest, "schema validation failed")
+	})
+}
+
+func TestQuery(t *testing.T) {
+	runServerTest(t, func(t *testing.T, db *c.DB, h http.Handler) {
+		require.NoError(t, db.CreateCollection("people"))
+		require.Equal(t, http.StatusCreated, do(h, "POST", "/collections/people/documents", `[
+			{"name": "a", "age": 20, "city": "Rome"},
+			{"name": "b", "age": 30, "city": "Paris"},
+			{"name": "c", "age": 40, "city": "Rome"},
+			{"name": "d", "age": 50, "city": "Rome"}
+		]`).Code)
+
+		names := func(rec *httptest.ResponseRecorder) []string {
+			require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+			var docs []map[string]interface{}
+			decode(t, rec, &docs)
+
+			names := make([]string, 0)
+			for _, doc := range docs {
+				names = append(names, doc["name"].(string))
+			}
+			return names
+		}
+
+		filter := `{"city": "Rome", "age": {"$gt": 20}}`
+		require.Equal(t, []string{"d", "c"}, names(do(h, "GET", "/collections/people/documents?sort=-age&filter="+url.QueryEscape(filter), "")))
+		require.Equal(t, []string{"c"}, names(do(h, "GET", "/collections/people/documents?sort=-age&skip=1&limit=1&filter="+url.QueryEscape(filter), "")))
+
+		var docs []map[string]interface{}
+		decode(t, do(h, "GET", "/collections/people/documents?select=name&limit=1&sort=name", ""), &docs)
+		require.Len(t, docs, 1)
+		require.Equal(t, "a", docs[0]["name"])
+		require.NotContains(t, docs[0], "age")
+
+		body := `{"filter": {"$or": [{"name": "a"}, {"age": {"$gte": 50}}]}, "sort": ["name"], "limit": 5}`
+		require.Equal(t, []string{"a", "d"}, names(do(h, "POST", "/collections/people/query", body)))
+		require.ElementsMatch(t, []string{"a", "b", "c", "d"}, names(do(h, "POST", "/collections/people/query", `{}`)))
+
+		var count map[string]int
+		decode(t, do(h, "GET", "/collections/people/count?filter="+url.QueryEscape(`{"city": "Rome"}`), ""), &count)
+		require.Equal(t, 3, count["count"])
+
+		requireError(t, do(h, "GET", "/collections/people/documents?filter="+url.QueryEscape(`{"age": {"$foo": 1}}`), ""), http.StatusBadRequest, "unknown operator $foo")
+		requireError(t, do(h, "GET", "/collections/people/documents?limit=x", ""), http.StatusBadRequest, "invalid limit parameter")
+		requireError(t, do(h, "GET", "/collections/people/documents?skip=-1", ""), http.StatusBadRequest, "skip must be non negative")
+		requireError(t, do(h, "POST", "/collections/people/query", `{"where": {}}`), http.StatusBadRequest, "unknown field")
+		requireError(t, do(h, "POST", "/collections/unknown/query", `{}`), http.StatusNotFound, c.ErrCollectionNotExist.Error())
+
+		where := "city = 'Rome' AND age > 20"
+		require.Equal(t, []string{"d", "c"}, names(do(h, "GET", "/collections/people/documents?sort=-age&where="+url.QueryEscape(where), "")))
+
+		decode(t, do(h, "GET", "/collections/people/count?where="+url.QueryEscape("name LIKE '_'"), ""), &count)
+		require.Equal(t, 4, count["count"])
+
+		requireError(t, do(h, "GET", "/collections/people/count?where="+url.QueryEscape("age >"), ""), http.StatusBadRequest, "syntax error at position 6")
+		requireError(t, do(h, "GET", "/collections/people/documents?where=a%3D1&filter=%7B%7D", ""), http.StatusBadRequest, "cannot be used together")
+	})
+}
+
+func TestSQL(t *testing.T) {
+	runServerTest(t, func(t *testing.T, db *c.DB, h http.Handler) {
+		require.NoError(t, db.CreateCollection("people"))
+		require.Equal(t, http.StatusCreated, do(h, "POST", "/collections/people/documents", `[
+			{"name": "a", "age": 20, "city": "Rome"},
+			{"name": "b", "age": 30, "city": "Paris"},
+			{"name": "c", "age": 40, "city": "Rome"}
+		]`).Code)
+
+		statement := "SELECT name FROM people WHERE city = 'Rome' ORDER BY age DESC"
+
+		var docs []map[string]interface{}
+		decode(t, do(h, "GET", "/sql?q="+url.QueryEscape(statement), ""), &docs)
+		require.Len(t, docs, 2)
+		require.Equal(t, "c", docs[0]["name"])
+		require.NotContains(t, docs[0], "age")
+
+		decode(t, do(h, "POST", "/sql", statement+" LIMIT 1"), &docs)
+		require.Len(t, docs, 1)
+		require.Equal(t, "c", docs[0]["name"])
+
+		requireError(t, do(h, "POST", "/sql", "SELECT * FROM people WHERE"), http.StatusBadRequest, "syntax error at position 27")
+		requireError(t, do(h, "GET", "/sql", ""), http.StatusBadRequest, "missing SQL statement")
+		requireError(t, do(h, "GET", "/sql?q="+url.QueryEscape("SELECT * FROM unknown"), ""), http.StatusNotFound, c.ErrCollectionNotExist.Error())
+	})
+}
+
+func TestIndexes(t *testing.T) {
+	runServerTest(t, func(t *testing.T, db *c.DB, h http.Handler) {
+		require.NoError(t, db.CreateCollection("people"))
+
+		require.Equal(t, http.StatusCreated, do(h, "POST", "/collections/people/indexes", `{"fields": ["email"], "unique": true}`).Code)
+		require.Equal(t, http.StatusCreated, do(h, "POST", "/collections/people/indexes", `{"fields": ["city", "-age"]}`).Code)
+		requireError(t, do(h, "POST", "/collections/people/indexes", `{"fields": ["email"]}`), http.StatusConflict, c.ErrIndexExist.Error())
+		requireError(t, do(h, "POST", "/collections/people/indexes", `{"fields": ["a", "b"], "unique": true}`), http.StatusBadRequest, "cannot be unique")
+		requireError(t, do(h, "POST", "/collections/people/indexes", `{"fields": []}`), http.StatusBadRequest, "at least one field")
+		require.Equal(t, http.StatusCreated, do(h, "POST", "/collections/people/indexes", `{"fields": ["bio"], "text": true}`).Code)
+		requireError(t, do(h, "POST", "/collections/people/indexes", `{"fields": ["a", "b"], "text": true}`), http.StatusBadRequest, "single ascending field")
+		require.Equal(t, http.StatusCreated, do(h, "POST", "/collections/people/indexes", `{"fields": ["home"], "geo": true}`).Code)
+		requireError(t, do(h, "POST", "/collections/people/indexes", `{"fields": ["-home"], "geo": true}`), http.StatusBadRequest, "single ascending field")
+
+		var indexes []map[string]interface{}
+		decode(t, do(h, "GET", "/collections/people/indexes", ""), &indexes)
+		require.ElementsMatch(t, []map[string]interface{}{
+			{"name": "email", "fields": []interface{}{"email"}, "unique": true},
+			{"name": "city_1_age_-1", "fields": []interface{}{"city", "-age"}},
+			{"name": "bio_text", "fields": []interface{}{"bio"}, "text": true},
+			{"name": "home_geo", "fields": []interface{}{"home"}, "geo": true},
+		}, indexes)
+
+		require.Equal(t, http.StatusCreated, do(h, "POST", "/collections/people/documents", `{"email": "a@b.c"}`).Code)
+		requireError(t, do(h, "POST", "/collections/people/documents", `{"email": "a@b.c"}`), http.StatusConflict, c.ErrDuplicateKey.Error())
+
+		require.Equal(t, http.StatusCreated, do(h, "POST", "/collections/people/documents", `{"email": "d@e.f", "bio": "Loves running and runners"}`).Code)
+		require.Equal(t, http.StatusCreated, do(h, "POST", "/collections/people/documents", `{"email": "g@h.i", "bio": "Runs a bakery, and runs marathons"}`).Code)
+
+		var docs []map[string]interface{}
+		decode(t, do(h, "GET", "/collections/people/documents?sort=-_score&filter="+url.QueryEscape(`{"bio": {"$search": "run"}}`), ""), &docs)
+		require.Len(t, docs, 2)
+		require.Equal(t, "g@h.i", docs[0]["email"])
+
+		require.Equal(t, http.StatusCreated, do(h, "POST", "/collections/people/documents", `{"email": "j@k.l", "home": [12.49, 41.90]}`).Code)
+		require.Equal(t, http.StatusCreated, do(h, "POST", "/collections/people/documents", `{"email": "m@n.o", "home": [2.35, 48.85]}`).Code)
+
+		decode(t, do(h, "GET", "/collections/people/documents?sort=_distance&filter="+url.QueryEscape(`{"home": {"$near": [9.19, 45.46, 1000000]}}`), ""), &docs)
+		require.Len(t, docs, 2)
+		require.Equal(t, "j@k.l", docs[0]["email"])
+
+		require.Equal(t, http.StatusNoContent, do(h, "DELETE", "/collections/people/indexes/city_1_age_-1", "").Code)
+		requireError(t, do(h, "DELETE", "/collections/people/indexes/city_1_age_-1", ""), http.StatusNotFound, c.ErrIndexNotExist.Error())
+	})
+}
+
+func TestImportExport(t *testing.T) {
+	runServerTest(t, func(t *testing.T, db *c.DB, h http.Handler) {
+		var result map[string]interface{}
+		decode(t, do(h, "POST", "/collections/todos/import?format=ndjson", "{\"id\": 1, \"done\": true}\n{\"id\": 2, \"done\": false}\n{\"id\": 3, \"done\": true}\n"), &result)
+		require.Equal(t, map[string]interface{}{"imported": 3.0}, result)
+
+		rec := do(h, "GET", "/collections/todos/export?format=csv&sort=id&select=id,done&filter="+url.QueryEscape(`{"done": true}`), "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "text/csv", rec.Header().Get("Content-Type"))
+
+		lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+		require.Len(t, lines, 3)
+		require.Equal(t, "_id,done,id", lines[0])
+
+		decode(t, do(h, "POST", "/collections/done/import?format=csv", rec.Body.String()), &result)
+		require.Equal(t, map[string]interface{}{"imported": 2.0}, result)
+
+		rec = do(h, "GET", "/collections/done/export", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var docs []map[string]interface{}
+		decode(t, rec, &docs)
+		require.Len(t, docs, 2)
+
+		rec = do(h, "POST", "/collections/todos/import?mode=upsert&batch=1", `[{"id": 4}, {"id": `)
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+		decode(t, rec, &result)
+		require.Equal(t, 1.0, result["imported"])
+		require.Contains(t, result["error"], c.ErrInvalidImport.Error())
+
+		requireError(t, do(h, "POST", "/collections/todos/import?mode=merge", ""), http.StatusBadRequest, "unknown import mode")
+		requireError(t, do(h, "GET", "/collections/todos/export?format=xml", ""), http.StatusBadRequest, "unknown format")
+		requireError(t, do(h, "GET", "/collections/unknown/export", ""), http.StatusNotFound, c.ErrCollectionNotExist.Error())
+	})
+}
+
+func TestRouting(t *testing.T) {
+	runServerTest(t, func(t *testing.T, db *c.DB, h http.Handler) {
+		requireError(t, do(h, "GET", "/", ""), http.StatusNotFound, "no such endpoint")
+		requireError(t, do(h, "GET", "/collections/todos/unknown", ""), http.StatusNotFound, "no such endpoint")
+		requireError(t, do(h, "GET", "/collections//documents", ""), http.StatusNotFound, "no such endpoint")
+
+		rec := do(h, "POST", "/collections", "")
+		requireError(t, rec, http.StatusMethodNotAllowed, "method POST not allowed")
+		require.Equal(t, "GET", rec.Header().Get("Allow"))
+
+		// the server can also be reached through a real connection
+		srv := httptest.NewServer(h)
+		defer srv.Close()
+
+		resp, err := http.Get(srv.URL + "/collections")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.NoError(t, resp.Body.Close())
+	})
+}
@@ -0,0 +1,95 @@
+package shell
+
+import (
+	"sort"
+	"strings"
+)
+
+var commandNames = []string{"collections", "count", "exit", "explain", "fields", "find", "format", "help", "history", "indexes", "quit"}
+
+// clauseKeywords are the keywords which can follow the collection name in a query.
+var clauseKeywords = []string{"select", "where", "sort", "skip", "limit"}
+
+// conditionKeywords are the keywords which can appear inside query clauses.
+var conditionKeywords = []string{"and", "or", "not", "in", "contains", "like", "exists", "is", "null", "true", "false", "asc", "desc"}
+
+func isClauseKeyword(s string) bool {
+	for _, kw := range clauseKeywords {
+		if strings.EqualFold(kw, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Complete returns the candidate completions of the last word of line. Commands are completed first,
+// followed by collection names and then by keywords and the names of the fields found in a sample of the documents of the collection.
+func (s *Shell) Complete(line string) []string {
+	start := wordStart(line)
+	word := line[start:]
+
+	tokens, err := tokenize(line[:start])
+	if err != nil {
+		return nil
+	}
+	tokens = tokens[:len(tokens)-1] // drop EOF
+
+	if len(tokens) == 0 {
+		return filterPrefix(commandNames, word)
+	}
+
+	cmd := strings.ToLower(tokens[0].val)
+	switch cmd {
+	case "format":
+		if len(tokens) == 1 {
+			return filterPrefix([]string{FormatJSON, FormatTable}, word)
+		}
+		return nil
+	case "find", "count", "explain", "indexes", "fields":
+	default:
+		return nil
+	}
+
+	collectionPos := 1
+	if cmd == "explain" && len(tokens) > 1 && strings.EqualFold(tokens[1].val, "analyze") {
+		collectionPos = 2
+	}
+
+	if len(tokens) == collectionPos {
+		collections, err := s.db.ListCollections()
+		if err != nil {
+			return nil
+		}
+
+		if cmd == "explain" && collectionPos == 1 {
+			collections = append(collections, "analyze")
+		}
+		return filterPrefix(collections, word)
+	}
+
+	if cmd == "indexes" || cmd == "fields" {
+		return nil
+	}
+
+	candidates := append(append([]string{}, clauseKeywords...), conditionKeywords...)
+	if fields, err := s.sampleFields(tokens[collectionPos].val); err == nil {
+		candidates = append(candidates, fields...)
+	}
+	return filterPrefix(candidates, word)
+}
+
+func filterPrefix(words []string, prefix string) []string {
+	set := make(map[string]bool)
+	for _, w := range words {
+		if strings.HasPrefix(w, prefix) {
+			set[w] = true
+		}
+	}
+
+	matches := make([]string, 0, len(set))
+	for w := range set {
+		matches = append(matches, w)
+	}
+	sort.Strings(matches)
+	return matches
+}
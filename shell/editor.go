@@ -0,0 +1,243 @@
+package shell
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// errInterrupted is returned by readLine when the user discards the current line with Ctrl-C.
+var errInterrupted = errors.New("interrupted")
+
+const (
+	keyCtrlA     = 1
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyCtrlE     = 5
+	keyCtrlH     = 8
+	keyTab       = 9
+	keyLF        = 10
+	keyCtrlK     = 11
+	keyCR        = 13
+	keyCtrlU     = 21
+	keyEscape    = 27
+	keyBackspace = 127
+)
+
+// lineEditor implements a minimal line editor for terminals in raw mode, supporting cursor movement,
+// navigation through the history with the arrow keys and tab completion.
+type lineEditor struct {
+	r        *bufio.Reader
+	w        io.Writer
+	history  []string
+	complete func(line string) []string
+}
+
+func newLineEditor(r io.Reader, w io.Writer, complete func(line string) []string) *lineEditor {
+	return &lineEditor{
+		r:        bufio.NewReader(r),
+		w:        w,
+		complete: complete,
+	}
+}
+
+// lineState holds the content of the line being edited.
+type lineState struct {
+	prompt string
+	buf    []rune
+	pos    int
+}
+
+func (s *lineState) insert(runes ...rune) {
+	buf := make([]rune, 0, len(s.buf)+len(runes))
+	buf = append(buf, s.buf[:s.pos]...)
+	buf = append(buf, runes...)
+	s.buf = append(buf, s.buf[s.pos:]...)
+	s.pos += len(runes)
+}
+
+func (s *lineState) set(line string) {
+	s.buf = []rune(line)
+	s.pos = len(s.buf)
+}
+
+func (e *lineEditor) refresh(s *lineState) {
+	fmt.Fprintf(e.w, "\r%s%s\x1b[K", s.prompt, string(s.buf))
+	if n := len(s.buf) - s.pos; n > 0 {
+		fmt.Fprintf(e.w, "\x1b[%dD", n)
+	}
+}
+
+// readLine reads a line of input, echoing it to the terminal. It returns io.EOF when Ctrl-D is pressed on an empty line.
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	s := &lineState{prompt: prompt}
+	e.refresh(s)
+
+	// historyPos indexes the history entry being displayed, len(e.history) being the line under edit.
+	historyPos := len(e.history)
+	pending := ""
+
+	for {
+		r, _, err := e.r.ReadRune()
+		if err != nil {
+			if err == io.EOF && len(s.buf) > 0 {
+				fmt.Fprint(e.w, "\r\n")
+				return string(s.buf), nil
+			}
+			return "", err
+		}
+
+		switch r {
+		case keyCR, keyLF:
+			fmt.Fprint(e.w, "\r\n")
+			return string(s.buf), nil
+		case keyCtrlC:
+			fmt.Fprint(e.w, "^C\r\n")
+			return "", errInterrupted
+		case keyCtrlD:
+			if len(s.buf) == 0 {
+				fmt.Fprint(e.w, "\r\n")
+				return "", io.EOF
+			}
+		case keyBackspace, keyCtrlH:
+			if s.pos > 0 {
+				s.buf = append(s.buf[:s.pos-1], s.buf[s.pos:]...)
+				s.pos--
+			}
+		case keyCtrlA:
+			s.pos = 0
+		case keyCtrlE:
+			s.pos = len(s.buf)
+		case keyCtrlK:
+			s.buf = s.buf[:s.pos]
+		case keyCtrlU:
+			s.buf = s.buf[s.pos:]
+			s.pos = 0
+		case keyTab:
+			e.completeLine(s)
+		case keyEscape:
+			switch e.readEscape() {
+			case 'A':
+				if historyPos > 0 {
+					if historyPos == len(e.history) {
+						pending = string(s.buf)
+					}
+					historyPos--
+					s.set(e.history[historyPos])
+				}
+			case 'B':
+				if historyPos < len(e.history) {
+					historyPos++
+					if historyPos == len(e.history) {
+						s.set(pending)
+					} else {
+						s.set(e.history[historyPos])
+					}
+				}
+			case 'C':
+				if s.pos < len(s.buf) {
+					s.pos++
+				}
+			case 'D':
+				if s.pos > 0 {
+					s.pos--
+				}
+			case 'H':
+				s.pos = 0
+			case 'F':
+				s.pos = len(s.buf)
+			case '3':
+				if s.pos < len(s.buf) {
+					s.buf = append(s.buf[:s.pos], s.buf[s.pos+1:]...)
+				}
+			}
+		default:
+			if unicode.IsPrint(r) {
+				s.insert(r)
+			}
+		}
+		e.refresh(s)
+	}
+}
+
+// readEscape consumes an ANSI escape sequence, returning its final character.
+// Sequences of the form "ESC [ n ~", sent by some terminals for the Home, End and Delete keys, are mapped to 'H', 'F' and '3'.
+func (e *lineEditor) readEscape() rune {
+	r, _, err := e.r.ReadRune()
+	if err != nil || (r != '[' && r != 'O') {
+		return 0
+	}
+
+	params := ""
+	for {
+		r, _, err = e.r.ReadRune()
+		if err != nil {
+			return 0
+		}
+
+		if (r >= '0' && r <= '9') || r == ';' {
+			params += string(r)
+			continue
+		}
+
+		if r != '~' {
+			return r
+		}
+
+		switch params {
+		case "1", "7":
+			return 'H'
+		case "4", "8":
+			return 'F'
+		case "3":
+			return '3'
+		}
+		return 0
+	}
+}
+
+// completeLine completes the word under the cursor. If more candidates are available,
+// the word is extended to their longest common prefix, and candidates are listed when no progress can be made.
+func (e *lineEditor) completeLine(s *lineState) {
+	if e.complete == nil {
+		return
+	}
+
+	line := string(s.buf[:s.pos])
+	candidates := e.complete(line)
+	if len(candidates) == 0 {
+		return
+	}
+
+	word := []rune(line[wordStart(line):])
+	completion := []rune(commonPrefix(candidates))
+	if len(candidates) == 1 {
+		completion = append(completion, ' ')
+	}
+
+	if len(completion) > len(word) {
+		s.insert(completion[len(word):]...)
+		return
+	}
+	fmt.Fprintf(e.w, "\r\n%s\r\n", strings.Join(candidates, "  "))
+}
+
+func commonPrefix(words []string) string {
+	prefix := words[0]
+	for _, w := range words[1:] {
+		for !strings.HasPrefix(w, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+// wordStart returns the index of the first byte of the last word in line.
+func wordStart(line string) int {
+	return strings.LastIndexFunc(line, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune("(),=<>!", r)
+	}) + 1
+}
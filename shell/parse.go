@@ -0,0 +1,445 @@
+package shell
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/ostafen/clover/v2/query"
+)
+
+type tokenType int
+
+const (
+	tokenEOF tokenType = iota
+	tokenIdent
+	tokenNumber
+	tokenString
+	tokenPunct
+)
+
+type token struct {
+	typ tokenType
+	val string
+	pos int
+}
+
+func (t token) String() string {
+	switch t.typ {
+	case tokenEOF:
+		return "end of input"
+	case tokenString:
+		return strconv.Quote(t.val)
+	}
+	return fmt.Sprintf("%q", t.val)
+}
+
+// SyntaxError reports a malformed command. Pos is the position of the offending character, starting from 1.
+type SyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at column %d: %s", e.Pos, e.Msg)
+}
+
+func isIdentRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.' || r == '$'
+}
+
+func isTwoCharOperator(s string) bool {
+	switch s {
+	case "!=", "<=", ">=", "<>":
+		return true
+	}
+	return false
+}
+
+func tokenize(line string) ([]token, error) {
+	runes := []rune(line)
+	tokens := make([]token, 0)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '\'' || r == '"':
+			start := i
+			var sb strings.Builder
+			for i++; i < len(runes) && runes[i] != r; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				sb.WriteRune(runes[i])
+			}
+
+			if i == len(runes) {
+				return nil, &SyntaxError{Pos: start + 1, Msg: "unterminated string"}
+			}
+			i++
+			tokens = append(tokens, token{typ: tokenString, val: sb.String(), pos: start + 1})
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			for i++; i < len(runes) && (unicode.IsDigit(runes[i]) || strings.ContainsRune(".eE+-", runes[i])); i++ {
+			}
+			tokens = append(tokens, token{typ: tokenNumber, val: string(runes[start:i]), pos: start + 1})
+		case isIdentRune(r):
+			start := i
+			for ; i < len(runes) && isIdentRune(runes[i]); i++ {
+			}
+			tokens = append(tokens, token{typ: tokenIdent, val: string(runes[start:i]), pos: start + 1})
+		default:
+			start := i
+			if i+1 < len(runes) && isTwoCharOperator(string(runes[i:i+2])) {
+				i += 2
+			} else if strings.ContainsRune("(),=<>*", r) {
+				i++
+			} else {
+				return nil, &SyntaxError{Pos: start + 1, Msg: fmt.Sprintf("unexpected character %q", r)}
+			}
+			tokens = append(tokens, token{typ: tokenPunct, val: string(runes[start:i]), pos: start + 1})
+		}
+	}
+	return append(tokens, token{typ: tokenEOF, pos: len(runes) + 1}), nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func newParser(line string) (*parser, error) {
+	tokens, err := tokenize(line)
+	if err != nil {
+		return nil, err
+	}
+	return &parser{tokens: tokens}, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.typ != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) errorf(tok token, format string, args ...interface{}) error {
+	return &SyntaxError{Pos: tok.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// isKeyword reports whether the next token is the supplied keyword. Keywords are case insensitive.
+func (p *parser) isKeyword(kw string) bool {
+	tok := p.peek()
+	return tok.typ == tokenIdent && strings.EqualFold(tok.val, kw)
+}
+
+func (p *parser) acceptKeyword(kw string) bool {
+	if p.isKeyword(kw) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectKeyword(kw string) error {
+	if !p.acceptKeyword(kw) {
+		return p.errorf(p.peek(), "expected %s, found %s", kw, p.peek())
+	}
+	return nil
+}
+
+func (p *parser) acceptPunct(punct string) bool {
+	tok := p.peek()
+	if tok.typ == tokenPunct && tok.val == punct {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectPunct(punct string) error {
+	if !p.acceptPunct(punct) {
+		return p.errorf(p.peek(), "expected %q, found %s", punct, p.peek())
+	}
+	return nil
+}
+
+func (p *parser) expectIdent(what string) (string, error) {
+	tok := p.next()
+	if tok.typ != tokenIdent && tok.typ != tokenString {
+		return "", p.errorf(tok, "expected %s, found %s", what, tok)
+	}
+	return tok.val, nil
+}
+
+func (p *parser) expectEOF() error {
+	if tok := p.peek(); tok.typ != tokenEOF {
+		return p.errorf(tok, "unexpected %s", tok)
+	}
+	return nil
+}
+
+func (p *parser) parseInt(what string) (int, error) {
+	tok := p.next()
+	n, err := strconv.Atoi(tok.val)
+	if tok.typ != tokenNumber || err != nil || n < 0 {
+		return 0, p.errorf(tok, "expected %s, found %s", what, tok)
+	}
+	return n, nil
+}
+
+// parseQuery parses the part of a query following the collection name:
+//
+//	[select <field>, ...] [where <condition>] [sort <field> [asc|desc], ...] [skip <n>] [limit <n>]
+func (p *parser) parseQuery(collection string) (*query.Query, error) {
+	q := query.NewQuery(collection)
+
+	if p.acceptKeyword("select") {
+		fields, err := p.parseFieldList()
+		if err != nil {
+			return nil, err
+		}
+		q = q.Select(fields...)
+	}
+
+	if p.acceptKeyword("where") {
+		c, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		q = q.Where(c)
+	}
+
+	if p.acceptKeyword("sort") {
+		opts, err := p.parseSortOptions()
+		if err != nil {
+			return nil, err
+		}
+		q = q.Sort(opts...)
+	}
+
+	if p.acceptKeyword("skip") {
+		n, err := p.parseInt("number of documents to skip")
+		if err != nil {
+			return nil, err
+		}
+		q = q.Skip(n)
+	}
+
+	if p.acceptKeyword("limit") {
+		n, err := p.parseInt("maximum number of documents")
+		if err != nil {
+			return nil, err
+		}
+		q = q.Limit(n)
+	}
+	return q, p.expectEOF()
+}
+
+func (p *parser) parseFieldList() ([]string, error) {
+	fields := make([]string, 0)
+	for {
+		field, err := p.expectIdent("field name")
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, field)
+
+		if !p.acceptPunct(",") {
+			return fields, nil
+		}
+	}
+}
+
+func (p *parser) parseSortOptions() ([]query.SortOption, error) {
+	opts := make([]query.SortOption, 0)
+	for {
+		field, err := p.expectIdent("field name")
+		if err != nil {
+			return nil, err
+		}
+
+		direction := 1
+		if p.acceptKeyword("desc") {
+			direction = -1
+		} else {
+			p.acceptKeyword("asc")
+		}
+		opts = append(opts, query.SortOption{Field: field, Direction: direction})
+
+		if !p.acceptPunct(",") {
+			return opts, nil
+		}
+	}
+}
+
+func (p *parser) parseOr() (query.Criteria, error) {
+	c, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.acceptKeyword("or") {
+		other, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		c = c.Or(other)
+	}
+	return c, nil
+}
+
+func (p *parser) parseAnd() (query.Criteria, error) {
+	c, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.acceptKeyword("and") {
+		other, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		c = c.And(other)
+	}
+	return c, nil
+}
+
+func (p *parser) parseUnary() (query.Criteria, error) {
+	if p.acceptKeyword("not") {
+		c, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return c.Not(), nil
+	}
+
+	if p.acceptPunct("(") {
+		c, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return c, p.expectPunct(")")
+	}
+	return p.parsePredicate()
+}
+
+func (p *parser) parsePredicate() (query.Criteria, error) {
+	name, err := p.expectIdent("field name")
+	if err != nil {
+		return nil, err
+	}
+	f := query.Field(name)
+
+	switch {
+	case p.acceptKeyword("exists"):
+		return f.Exists(), nil
+	case p.acceptKeyword("is"):
+		negate := p.acceptKeyword("not")
+		if err := p.expectKeyword("null"); err != nil {
+			return nil, err
+		}
+
+		// as in SQL, missing fields are considered null
+		if negate {
+			return f.IsNilOrNotExists().Not(), nil
+		}
+		return f.IsNilOrNotExists(), nil
+	case p.acceptKeyword("in"), p.acceptKeyword("contains"):
+		isIn := strings.EqualFold(p.tokens[p.pos-1].val, "in")
+		values, err := p.parseValueList()
+		if err != nil {
+			return nil, err
+		}
+
+		if isIn {
+			return f.In(values...), nil
+		}
+		return f.Contains(values...), nil
+	case p.acceptKeyword("like"):
+		tok := p.next()
+		if tok.typ != tokenString {
+			return nil, p.errorf(tok, "expected pattern, found %s", tok)
+		}
+		return f.Like(tok.val), nil
+	}
+
+	opTok := p.next()
+	if opTok.typ != tokenPunct {
+		return nil, p.errorf(opTok, "expected operator, found %s", opTok)
+	}
+
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+
+	switch opTok.val {
+	case "=":
+		return f.Eq(value), nil
+	case "!=", "<>":
+		return f.Neq(value), nil
+	case ">":
+		return f.Gt(value), nil
+	case ">=":
+		return f.GtEq(value), nil
+	case "<":
+		return f.Lt(value), nil
+	case "<=":
+		return f.LtEq(value), nil
+	}
+	return nil, p.errorf(opTok, "expected operator, found %s", opTok)
+}
+
+func (p *parser) parseValueList() ([]interface{}, error) {
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+
+	values := make([]interface{}, 0)
+	for {
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+
+		if !p.acceptPunct(",") {
+			return values, p.expectPunct(")")
+		}
+	}
+}
+
+func (p *parser) parseValue() (interface{}, error) {
+	tok := p.next()
+	switch tok.typ {
+	case tokenString:
+		return tok.val, nil
+	case tokenNumber:
+		if n, err := strconv.ParseInt(tok.val, 10, 64); err == nil {
+			return n, nil
+		}
+
+		if f, err := strconv.ParseFloat(tok.val, 64); err == nil {
+			return f, nil
+		}
+	case tokenIdent:
+		switch strings.ToLower(tok.val) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+	}
+	return nil, p.errorf(tok, "expected value, found %s", tok)
+}
@@ -0,0 +1,561 @@
+// Package shell implements an interactive shell to run ad-hoc queries against a clover database.
+//
+// Queries are written in a small language which is translated to query.Field() criteria. For example:
+//
+//	find todos where completed = false and (userId in (1, 2) or title like 'qui.*') sort userId desc limit 10
+//
+// Type "help" inside the shell for the list of the available commands.
+package shell
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	c "github.com/ostafen/clover/v2"
+	d "github.com/ostafen/clover/v2/document"
+	"github.com/ostafen/clover/v2/index"
+	"github.com/ostafen/clover/v2/query"
+)
+
+// ErrExit is returned by Exec when the user asks to leave the shell.
+var ErrExit = errors.New("exit")
+
+const (
+	prompt = "clover> "
+
+	// maxHistorySize is the maximum number of lines kept in the history.
+	maxHistorySize = 1000
+
+	// fieldSampleSize is the number of documents inspected to collect the field names of a collection.
+	fieldSampleSize = 100
+
+	// maxCellWidth is the maximum number of characters displayed for each value in table output.
+	maxCellWidth = 40
+)
+
+// Output formats supported by the shell.
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+)
+
+// Options configures a shell.
+type Options struct {
+	// HistoryFile is the file where the history is persisted across sessions. If empty, the history is kept in memory.
+	HistoryFile string
+	// Format is the initial output format. It defaults to FormatTable.
+	Format string
+}
+
+// Shell runs commands against a database. Since it only relies on the public API of clover.DB,
+// it works with any store.Store implementation.
+type Shell struct {
+	db      *c.DB
+	opts    Options
+	format  string
+	history []string
+	fields  map[string][]string
+}
+
+// New creates a shell operating on the supplied database.
+func New(db *c.DB, opts ...Options) *Shell {
+	o := Options{}
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+
+	if o.Format == "" {
+		o.Format = FormatTable
+	}
+
+	return &Shell{
+		db:     db,
+		opts:   o,
+		format: o.Format,
+		fields: make(map[string][]string),
+	}
+}
+
+// Run reads commands from in until the end of input or the exit command, writing results and errors to out.
+// If in is a terminal, line editing, history navigation and tab completion are enabled.
+func (s *Shell) Run(in io.Reader, out io.Writer) error {
+	if err := s.loadHistory(); err != nil {
+		return err
+	}
+
+	if f, ok := in.(*os.File); ok && isTerminal(int(f.Fd())) {
+		return s.runInteractive(f, out)
+	}
+
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		if exit := s.execLine(scanner.Text(), out); exit {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+func (s *Shell) runInteractive(f *os.File, out io.Writer) error {
+	fmt.Fprintln(out, `Type "help" for the list of commands, "exit" or Ctrl-D to quit.`)
+
+	editor := newLineEditor(f, out, s.Complete)
+	for {
+		restore, err := makeRaw(int(f.Fd()))
+		if err != nil {
+			return err
+		}
+
+		editor.history = s.history
+		line, err := editor.readLine(prompt)
+		if restoreErr := restore(); err == nil {
+			err = restoreErr
+		}
+
+		if err == errInterrupted {
+			continue
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if exit := s.execLine(line, out); exit {
+			return nil
+		}
+	}
+}
+
+// execLine executes a line, reporting errors to out. It returns true if the shell must exit.
+func (s *Shell) execLine(line string, out io.Writer) bool {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return false
+	}
+	s.addHistory(line)
+
+	err := s.Exec(line, out)
+	if err == ErrExit {
+		return true
+	}
+
+	if err != nil {
+		fmt.Fprintln(out, "error:", err)
+	}
+	return false
+}
+
+func (s *Shell) loadHistory() error {
+	if s.opts.HistoryFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.opts.HistoryFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			s.appendHistory(line)
+		}
+	}
+	return nil
+}
+
+func (s *Shell) appendHistory(line string) {
+	if n := len(s.history); n > 0 && s.history[n-1] == line {
+		return
+	}
+
+	s.history = append(s.history, line)
+	if len(s.history) > maxHistorySize {
+		s.history = s.history[len(s.history)-maxHistorySize:]
+	}
+}
+
+func (s *Shell) addHistory(line string) {
+	s.appendHistory(line)
+
+	if s.opts.HistoryFile == "" {
+		return
+	}
+
+	f, err := os.OpenFile(s.opts.HistoryFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err == nil {
+		fmt.Fprintln(f, line)
+		f.Close()
+	}
+}
+
+// Exec executes a single command, writing its output to out. It returns ErrExit if the command is "exit" or "quit".
+func (s *Shell) Exec(line string, out io.Writer) error {
+	p, err := newParser(line)
+	if err != nil {
+		return err
+	}
+
+	tok := p.next()
+	if tok.typ == tokenEOF {
+		return nil
+	}
+
+	if tok.typ != tokenIdent {
+		return p.errorf(tok, "expected command, found %s", tok)
+	}
+
+	switch strings.ToLower(tok.val) {
+	case "find":
+		return s.find(p, out)
+	case "count":
+		return s.count(p, out)
+	case "explain":
+		return s.explain(p, out)
+	case "collections":
+		return s.listCollections(p, out)
+	case "indexes":
+		return s.listIndexes(p, out)
+	case "fields":
+		return s.listFields(p, out)
+	case "format":
+		return s.setFormat(p, out)
+	case "history":
+		return s.printHistory(p, out)
+	case "help":
+		_, err := fmt.Fprint(out, helpText)
+		return err
+	case "exit", "quit":
+		return ErrExit
+	}
+	return p.errorf(tok, "unknown command %s", tok)
+}
+
+const helpText = `Commands:
+  find <collection> [select <field>, ...] [where <condition>] [sort <field> [asc|desc], ...] [skip <n>] [limit <n>]
+  count <collection> [where <condition>]
+  explain [analyze] <collection> [where <condition>] [sort ...] [skip <n>] [limit <n>]
+  collections                 list collections
+  indexes <collection>        list the indexes of a collection
+  fields <collection>         list the fields found in a sample of documents
+  format [table|json]         print or change the output format
+  history                     print the command history
+  exit, quit                  leave the shell
+
+Conditions combine predicates with "and", "or", "not" and parentheses. Predicates are:
+  <field> =|!=|<|<=|>|>= <value>
+  <field> in (<value>, ...)
+  <field> contains (<value>, ...)
+  <field> like '<regex>'
+  <field> exists
+  <field> is [not] null       missing fields are null
+
+Values are numbers, quoted strings, true, false and null. Nested fields use dot notation.
+`
+
+func (s *Shell) parseQuery(p *parser) (*query.Query, error) {
+	collection, err := p.expectIdent("collection name")
+	if err != nil {
+		return nil, err
+	}
+	return p.parseQuery(collection)
+}
+
+func (s *Shell) find(p *parser, out io.Writer) error {
+	q, err := s.parseQuery(p)
+	if err != nil {
+		return err
+	}
+
+	docs, err := s.db.FindAll(q)
+	if err != nil {
+		return err
+	}
+
+	if s.format == FormatJSON {
+		err = printJSON(out, docs)
+	} else {
+		err = printTable(out, docs, q.SelectedFields())
+	}
+
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(out, "(%d documents)\n", len(docs))
+	return err
+}
+
+func (s *Shell) count(p *parser, out io.Writer) error {
+	q, err := s.parseQuery(p)
+	if err != nil {
+		return err
+	}
+
+	n, err := s.db.Count(q)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(out, n)
+	return err
+}
+
+func (s *Shell) explain(p *parser, out io.Writer) error {
+	analyze := false
+	if p.isKeyword("analyze") && p.tokens[p.pos+1].typ != tokenEOF && !isClauseKeyword(p.tokens[p.pos+1].val) {
+		p.next()
+		analyze = true
+	}
+
+	q, err := s.parseQuery(p)
+	if err != nil {
+		return err
+	}
+
+	var plan *c.QueryPlan
+	if analyze {
+		plan, err = s.db.ExplainAnalyze(q)
+	} else {
+		plan, err = s.db.Explain(q)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(out, plan.String())
+	return err
+}
+
+func (s *Shell) listCollections(p *parser, out io.Writer) error {
+	if err := p.expectEOF(); err != nil {
+		return err
+	}
+
+	collections, err := s.db.ListCollections()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range collections {
+		fmt.Fprintln(out, name)
+	}
+	return nil
+}
+
+func (s *Shell) listIndexes(p *parser, out io.Writer) error {
+	collection, err := p.expectIdent("collection name")
+	if err != nil {
+		return err
+	}
+
+	if err := p.expectEOF(); err != nil {
+		return err
+	}
+
+	indexes, err := s.db.ListIndexes(collection)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "INDEX\tFIELDS\tUNIQUE")
+	for _, info := range indexes {
+		fields := info.Field
+		if info.Type != index.SingleField {
+			fields = formatSortOptions(info.Fields)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%t\n", info.Field, fields, info.Unique)
+	}
+	return w.Flush()
+}
+
+func formatSortOptions(opts []query.SortOption) string {
+	fields := make([]string, 0, len(opts))
+	for _, opt := range opts {
+		if opt.Direction < 0 {
+			fields = append(fields, opt.Field+" desc")
+		} else {
+			fields = append(fields, opt.Field)
+		}
+	}
+	return strings.Join(fields, ", ")
+}
+
+func (s *Shell) listFields(p *parser, out io.Writer) error {
+	collection, err := p.expectIdent("collection name")
+	if err != nil {
+		return err
+	}
+
+	if err := p.expectEOF(); err != nil {
+		return err
+	}
+
+	// always sample again, since documents could have changed
+	delete(s.fields, collection)
+
+	fields, err := s.sampleFields(collection)
+	if err != nil {
+		return err
+	}
+
+	for _, field := range fields {
+		fmt.Fprintln(out, field)
+	}
+	return nil
+}
+
+// sampleFields returns the sorted list of the (possibly nested) fields contained in a sample of the documents of a collection.
+// Results are cached for the lifetime of the shell.
+func (s *Shell) sampleFields(collection string) ([]string, error) {
+	if fields, has := s.fields[collection]; has {
+		return fields, nil
+	}
+
+	docs, err := s.db.FindAll(query.NewQuery(collection).Limit(fieldSampleSize))
+	if err != nil {
+		return nil, err
+	}
+
+	fields := collectFields(docs)
+	s.fields[collection] = fields
+	return fields, nil
+}
+
+func collectFields(docs []*d.Document) []string {
+	set := make(map[string]bool)
+	for _, doc := range docs {
+		for _, field := range doc.Fields(true) {
+			set[field] = true
+		}
+	}
+
+	fields := make([]string, 0, len(set))
+	for field := range set {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func (s *Shell) setFormat(p *parser, out io.Writer) error {
+	tok := p.next()
+	if tok.typ == tokenEOF {
+		_, err := fmt.Fprintln(out, s.format)
+		return err
+	}
+
+	if err := p.expectEOF(); err != nil {
+		return err
+	}
+
+	format := strings.ToLower(tok.val)
+	if format != FormatTable && format != FormatJSON {
+		return p.errorf(tok, "unknown format %s", tok)
+	}
+	s.format = format
+	return nil
+}
+
+func (s *Shell) printHistory(p *parser, out io.Writer) error {
+	if err := p.expectEOF(); err != nil {
+		return err
+	}
+
+	for i, line := range s.history {
+		fmt.Fprintf(out, "%4d  %s\n", i+1, line)
+	}
+	return nil
+}
+
+func printJSON(out io.Writer, docs []*d.Document) error {
+	for _, doc := range docs {
+		data, err := json.MarshalIndent(doc.AsMap(), "", "  ")
+		if err != nil {
+			return err
+		}
+
+		if _, err := fmt.Fprintln(out, string(data)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printTable prints documents as a table, with a column for each field. If no fields are supplied,
+// the columns are given by all the fields of the documents, with nested fields flattened using dot notation.
+func printTable(out io.Writer, docs []*d.Document, fields []string) error {
+	if len(docs) == 0 {
+		return nil
+	}
+
+	if len(fields) == 0 {
+		fields = make([]string, 0)
+		for _, field := range collectFields(docs) {
+			if field != d.ObjectIdField {
+				fields = append(fields, field)
+			}
+		}
+		fields = append([]string{d.ObjectIdField}, fields...)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(fields, "\t"))
+	for _, doc := range docs {
+		cells := make([]string, 0, len(fields))
+		for _, field := range fields {
+			cells = append(cells, formatCell(doc, field))
+		}
+		fmt.Fprintln(w, strings.Join(cells, "\t"))
+	}
+	return w.Flush()
+}
+
+func formatCell(doc *d.Document, field string) string {
+	if !doc.Has(field) {
+		return ""
+	}
+
+	var s string
+	switch v := doc.Get(field).(type) {
+	case nil:
+		s = "null"
+	case string:
+		s = v
+	case time.Time:
+		s = v.Format(time.RFC3339Nano)
+	case map[string]interface{}, []interface{}:
+		data, err := json.Marshal(v)
+		if err != nil {
+			s = fmt.Sprint(v)
+		} else {
+			s = string(data)
+		}
+	default:
+		s = fmt.Sprint(v)
+	}
+
+	s = strings.NewReplacer("\t", " ", "\n", " ", "\r", " ").Replace(s)
+	if utf8.RuneCountInString(s) > maxCellWidth {
+		s = string([]rune(s)[:maxCellWidth-3]) + "..."
+	}
+	return s
+}
@@ -0,0 +1,236 @@
+package shell
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	c "github.com/ostafen/clover/v2"
+	d "github.com/ostafen/clover/v2/document"
+	"github.com/ostafen/clover/v2/query"
+	"github.com/ostafen/clover/v2/store"
+	badgerstore "github.com/ostafen/clover/v2/store/badger"
+	"github.com/ostafen/clover/v2/store/bbolt"
+	"github.com/stretchr/testify/require"
+)
+
+func runShellTest(t *testing.T, test func(t *testing.T, db *c.DB)) {
+	for _, openStore := range []func(string) (store.Store, error){badgerstore.Open, bbolt.Open} {
+		dir, err := os.MkdirTemp("", "clover-shell")
+		require.NoError(t, err)
+
+		dataStore, err := openStore(dir)
+		require.NoError(t, err)
+
+		db, err := c.OpenWithStore(dataStore)
+		require.NoError(t, err)
+
+		require.NoError(t, db.CreateCollection("people"))
+		for i := 0; i < 10; i++ {
+			doc := d.NewDocumentOf(map[string]interface{}{
+				"name": "person" + string(rune('a'+i)),
+				"age":  20 + i,
+				"address": map[string]interface{}{
+					"city": []string{"Rome", "Paris"}[i%2],
+				},
+			})
+
+			if i%3 == 0 {
+				doc.Set("email", nil)
+			}
+			_, err := db.InsertOne("people", doc)
+			require.NoError(t, err)
+		}
+
+		test(t, db)
+
+		require.NoError(t, db.Close())
+		require.NoError(t, os.RemoveAll(dir))
+	}
+}
+
+func exec(t *testing.T, sh *Shell, line string) string {
+	var out bytes.Buffer
+	require.NoError(t, sh.Exec(line, &out))
+	return out.String()
+}
+
+func TestParseQuery(t *testing.T) {
+	runShellTest(t, func(t *testing.T, db *c.DB) {
+		tests := []struct {
+			where string
+			c     query.Criteria
+		}{
+			{"age >= 25", query.Field("age").GtEq(25)},
+			{"age > 25 and address.city = 'Rome'", query.Field("age").Gt(25).And(query.Field("address.city").Eq("Rome"))},
+			{"age < 22 or age <> 28 and not name like 'person[ab]'", query.Field("age").Lt(22).Or(query.Field("age").Neq(28).And(query.Field("name").Like("person[ab]").Not()))},
+			{"(age < 22 or age = 28.0) and name != \"persona\"", query.Field("age").Lt(22).Or(query.Field("age").Eq(28.0)).And(query.Field("name").Neq("persona"))},
+			{"age in (20, 21, 100) AND email exists", query.Field("age").In(20, 21, 100).And(query.Field("email").Exists())},
+			{"email is null", query.Field("email").IsNilOrNotExists()},
+			{"email is not null or email = null", query.Field("email").IsNilOrNotExists().Not().Or(query.Field("email").IsNil())},
+		}
+
+		for _, test := range tests {
+			p, err := newParser(test.where)
+			require.NoError(t, err)
+
+			criteria, err := p.parseOr()
+			require.NoError(t, err)
+			require.NoError(t, p.expectEOF())
+
+			expected, err := db.Count(query.NewQuery("people").Where(test.c))
+			require.NoError(t, err)
+
+			n, err := db.Count(query.NewQuery("people").Where(criteria))
+			require.NoError(t, err)
+			require.Equal(t, expected, n, test.where)
+		}
+	})
+}
+
+func TestSyntaxErrors(t *testing.T) {
+	runShellTest(t, func(t *testing.T, db *c.DB) {
+		sh := New(db)
+
+		tests := []struct {
+			line string
+			err  string
+		}{
+			{"select * from people", `syntax error at column 1: unknown command "select"`},
+			{"find people where age >", "syntax error at column 24: expected value, found end of input"},
+			{"find people where age = 'abc", "syntax error at column 25: unterminated string"},
+			{"find people where age ~ 1", "syntax error at column 23: unexpected character '~'"},
+			{"find people where (age = 1", `syntax error at column 27: expected ")", found end of input`},
+			{"find people limit -1", `syntax error at column 19: expected maximum number of documents, found "-1"`},
+			{"find people limit 1 where age = 1", `syntax error at column 21: unexpected "where"`},
+			{"format yaml", `syntax error at column 8: unknown format "yaml"`},
+		}
+
+		for _, test := range tests {
+			err := sh.Exec(test.line, &bytes.Buffer{})
+			require.EqualError(t, err, test.err)
+		}
+
+		require.Equal(t, c.ErrCollectionNotExist, sh.Exec("find unknown", &bytes.Buffer{}))
+		require.Equal(t, ErrExit, sh.Exec("quit", &bytes.Buffer{}))
+	})
+}
+
+func TestExec(t *testing.T) {
+	runShellTest(t, func(t *testing.T, db *c.DB) {
+		sh := New(db)
+
+		require.Equal(t, "people\n", exec(t, sh, "collections"))
+		require.Equal(t, "5\n", exec(t, sh, "count people where address.city = 'Rome'"))
+
+		out := exec(t, sh, "find people select name, age where age >= 25 sort age desc skip 1 limit 2")
+		require.Equal(t, "name     age\npersoni  28\npersonh  27\n(2 documents)\n", out)
+
+		out = exec(t, sh, "find people where name = 'persona'")
+		lines := strings.Split(out, "\n")
+		require.Equal(t, []string{"_id", "address.city", "age", "email", "name"}, strings.Fields(lines[0]))
+		require.Equal(t, []string{"Rome", "20", "null", "persona"}, strings.Fields(lines[1])[1:])
+
+		require.Equal(t, "table\n", exec(t, sh, "format"))
+		require.Empty(t, exec(t, sh, "format json"))
+
+		out = exec(t, sh, "find people select age where name = 'personb'")
+		require.Contains(t, out, "\n  \"age\": 21\n")
+		require.True(t, strings.HasSuffix(out, "}\n(1 documents)\n"))
+
+		require.NoError(t, db.CreateIndex("people", "age"))
+		require.Contains(t, exec(t, sh, "indexes people"), "age    age     false")
+		require.Contains(t, exec(t, sh, "explain people where age > 25"), "IndexScan")
+		require.Contains(t, exec(t, sh, "explain analyze people where age > 25"), "Total elapsed")
+
+		require.Equal(t, "_id\naddress.city\nage\nemail\nname\n", exec(t, sh, "fields people"))
+	})
+}
+
+func TestRun(t *testing.T) {
+	runShellTest(t, func(t *testing.T, db *c.DB) {
+		dir, err := os.MkdirTemp("", "clover-shell-history")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+
+		historyFile := filepath.Join(dir, "history")
+
+		var out bytes.Buffer
+		in := strings.NewReader("count people\n\nfind nothing\nexit\ncount people\n")
+		require.NoError(t, New(db, Options{HistoryFile: historyFile}).Run(in, &out))
+		require.Equal(t, "10\nerror: no such collection\n", out.String())
+
+		// history is restored from the history file
+		out.Reset()
+		require.NoError(t, New(db, Options{HistoryFile: historyFile}).Run(strings.NewReader("history"), &out))
+		require.Equal(t, "   1  count people\n   2  find nothing\n   3  exit\n   4  history\n", out.String())
+	})
+}
+
+func TestComplete(t *testing.T) {
+	runShellTest(t, func(t *testing.T, db *c.DB) {
+		sh := New(db)
+
+		require.Equal(t, []string{"fields", "find", "format"}, sh.Complete("f"))
+		require.Equal(t, []string{"people"}, sh.Complete("find p"))
+		require.Equal(t, []string{"analyze", "people"}, sh.Complete("explain "))
+		require.Equal(t, []string{"people"}, sh.Complete("explain analyze pe"))
+		require.Equal(t, []string{"address.city", "age", "and", "asc"}, sh.Complete("find people where a"))
+		require.Equal(t, []string{"name", "not", "null"}, sh.Complete("count people where (age > 1 or n"))
+		require.Equal(t, []string{"json"}, sh.Complete("format j"))
+		require.Empty(t, sh.Complete("find people where name = 'a"))
+		require.Empty(t, sh.Complete("collections "))
+	})
+}
+
+func TestLineEditor(t *testing.T) {
+	complete := func(line string) []string {
+		return filterPrefix([]string{"find", "format", "people"}, line[wordStart(line):])
+	}
+
+	readLine := func(input string, history ...string) (string, string, error) {
+		var out bytes.Buffer
+		e := newLineEditor(strings.NewReader(input), &out, complete)
+		e.history = history
+
+		line, err := e.readLine("> ")
+		return line, out.String(), err
+	}
+
+	line, _, err := readLine("fi\tp\t\r")
+	require.NoError(t, err)
+	require.Equal(t, "find people ", line)
+
+	// ambiguous completions are listed
+	line, out, err := readLine("f\t\t\x7f\x7fcount\r")
+	require.NoError(t, err)
+	require.Equal(t, "count", line)
+	require.Contains(t, out, "find  format")
+
+	// cursor movement, deletion and line editing keys
+	line, _, err = readLine("acd\x1b[D\x1b[Db\x1b[F!\x01>\x1b[C\x1b[3~\r")
+	require.NoError(t, err)
+	require.Equal(t, ">acd!", line)
+
+	line, _, err = readLine("abc\x01\x0bxyz\x05\x15w\r")
+	require.NoError(t, err)
+	require.Equal(t, "w", line)
+
+	// history navigation restores the line being edited
+	line, _, err = readLine("new\x1b[A\x1b[A\x1b[A\x1b[B\r", "first", "second")
+	require.NoError(t, err)
+	require.Equal(t, "second", line)
+
+	line, _, err = readLine("new\x1b[A\x1b[B\r", "first")
+	require.NoError(t, err)
+	require.Equal(t, "new", line)
+
+	_, _, err = readLine("abc\x03")
+	require.Equal(t, errInterrupted, err)
+
+	_, _, err = readLine("\x04")
+	require.Equal(t, io.EOF, err)
+}
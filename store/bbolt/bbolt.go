@@ -18,15 +18,38 @@ const (
 )
 
 func Open(dir string) (store.Store, error) {
-	db, err := bbolt.Open(filepath.Join(dir, dbFileName), 0600, nil)
+	return OpenWithOptions(dir, nil)
+}
+
+// OpenWithOptions opens the store using the supplied bbolt options. A read-only store can only be opened on an existing database.
+func OpenWithOptions(dir string, opts *bbolt.Options) (store.Store, error) {
+	db, err := bbolt.Open(filepath.Join(dir, dbFileName), 0600, opts)
 	if err != nil {
 		return nil, err
 	}
 	dataStore := &boltStore{db: db}
+
+	if opts != nil && opts.ReadOnly {
+		if err := dataStore.checkRootBucket(); err != nil {
+			db.Close()
+			return nil, err
+		}
+		return dataStore, nil
+	}
+
 	err = dataStore.createRootBucketIfNotExists()
 	return dataStore, err
 }
 
+func (store *boltStore) checkRootBucket() error {
+	return store.db.View(func(tx *bbolt.Tx) error {
+		if tx.Bucket([]byte(rootBucket)) == nil {
+			return bbolt.ErrBucketNotFound
+		}
+		return nil
+	})
+}
+
 func (store *boltStore) createRootBucketIfNotExists() error {
 	tx, err := store.db.Begin(true)
 	if err != nil {
@@ -99,11 +122,9 @@ type boltCursor struct {
 
 func (c *boltCursor) Seek(seek []byte) error {
 	key, value := c.Cursor.Seek(seek)
-	if key != nil && value != nil {
-		c.currItem = &store.Item{
-			Key:   key,
-			Value: value,
-		}
+	c.currItem = &store.Item{
+		Key:   key,
+		Value: value,
 	}
 
 	c.adjustSeek(key, seek)
@@ -111,7 +132,17 @@ func (c *boltCursor) Seek(seek []byte) error {
 }
 
 func (c *boltCursor) adjustSeek(key []byte, seek []byte) {
-	if key != nil && !bytes.Equal(key, seek) && !c.forward {
+	if c.forward {
+		return
+	}
+
+	if key == nil { // seek is past the last key
+		key, value := c.Cursor.Last()
+		c.currItem = &store.Item{
+			Key:   key,
+			Value: value,
+		}
+	} else if !bytes.Equal(key, seek) {
 		key, value := c.Cursor.Prev()
 		c.currItem = &store.Item{
 			Key:   key,
@@ -134,8 +165,11 @@ func (c *boltCursor) Next() {
 	}
 }
 
+// Valid returns true if the cursor points to an item.
+// Note that values written within the current transaction with a nil value are reported as nil by bbolt,
+// so only the key is checked.
 func (c *boltCursor) Valid() bool {
-	return c.currItem != nil && c.currItem.Key != nil && c.currItem.Value != nil
+	return c.currItem != nil && c.currItem.Key != nil
 }
 
 func (c *boltCursor) Item() (store.Item, error) {
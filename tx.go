@@ -0,0 +1,341 @@
+package clover
+
+import (
+	"errors"
+
+	d "github.com/ostafen/clover/v2/document"
+	"github.com/ostafen/clover/v2/internal"
+	"github.com/ostafen/clover/v2/query"
+	"github.com/ostafen/clover/v2/store"
+)
+
+// ErrTxDone is returned when a transaction is used after being committed or rolled back.
+var ErrTxDone = errors.New("transaction has already been committed or rolled back")
+
+// ErrTxAborted is returned when a transaction is used after one of its operations failed, leaving partial effects.
+var ErrTxAborted = errors.New("transaction aborted by a failed operation")
+
+// Tx represents a read-write transaction, which groups together multiple operations, possibly spanning different collections.
+// Changes made within a transaction are visible to the transaction itself, and are atomically applied to the database only when Commit is called.
+// Each operation is atomic: if an operation fails after having modified some data (for example, when inserting several documents,
+// one of which violates a unique index), the transaction is aborted. Any subsequent operation returns an ErrTxAborted,
+// and Commit rolls the transaction back and returns an ErrTxAborted too.
+// A Tx must not be used concurrently by multiple goroutines.
+type Tx struct {
+	db      *DB
+	tx      *changeTx
+	done    bool
+	aborted bool
+}
+
+// Begin starts a new read-write transaction. The transaction must be terminated by calling either Commit or Rollback.
+// Depending on the underlying store, other writers could be blocked until the transaction is terminated.
+func (db *DB) Begin() (*Tx, error) {
+	tx, err := db.beginUpdate()
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{db: db, tx: tx}, nil
+}
+
+// RunInTransaction runs fn inside a new transaction, which is committed if fn returns nil and rolled back otherwise.
+func (db *DB) RunInTransaction(fn func(tx *Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Commit atomically applies all the changes made within the transaction.
+// If the transaction has been aborted by a failed operation, it is rolled back and an ErrTxAborted is returned.
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+
+	if tx.aborted {
+		tx.tx.Rollback()
+		return ErrTxAborted
+	}
+	return tx.tx.Commit()
+}
+
+// Rollback discards all the changes made within the transaction. Calling Rollback on a terminated transaction has no effect.
+func (tx *Tx) Rollback() error {
+	if tx.done {
+		return nil
+	}
+	tx.done = true
+	return tx.tx.Rollback()
+}
+
+func (tx *Tx) storeTx() (store.Tx, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+
+	if tx.aborted {
+		return nil, ErrTxAborted
+	}
+	return tx.tx, nil
+}
+
+// write runs an operation which modifies the database. If the operation fails after writing some data,
+// its partial effects cannot be undone, so the transaction is aborted.
+func (tx *Tx) write(op func(stx store.Tx) error) error {
+	stx, err := tx.storeTx()
+	if err != nil {
+		return err
+	}
+
+	writes := tx.tx.writes
+	if err := op(stx); err != nil {
+		tx.aborted = tx.tx.writes != writes
+		return err
+	}
+	return nil
+}
+
+// CreateCollection creates a new empty collection with the given name.
+func (tx *Tx) CreateCollection(name string) error {
+	return tx.write(func(stx store.Tx) error {
+		return tx.db.createCollection(stx, name)
+	})
+}
+
+// CreateCollectionWithOptions creates a new empty collection with the given name and options.
+func (tx *Tx) CreateCollectionWithOptions(name string, opts CollectionOptions) error {
+	return tx.write(func(stx store.Tx) error {
+		return tx.db.createCollectionWithOptions(stx, name, opts)
+	})
+}
+
+// CreateCollectionByQuery creates a new collection containing the documents selected by q.
+func (tx *Tx) CreateCollectionByQuery(name string, q *query.Query) error {
+	return tx.write(func(stx store.Tx) error {
+		return tx.db.createCollectionByQuery(stx, name, q)
+	})
+}
+
+// DropCollection removes the collection with the given name, deleting all its documents and indexes.
+func (tx *Tx) DropCollection(name string) error {
+	return tx.write(func(stx store.Tx) error {
+		return tx.db.dropCollection(stx, name)
+	})
+}
+
+// HasCollection returns true if and only if the database contains a collection with the given name.
+func (tx *Tx) HasCollection(name string) (bool, error) {
+	stx, err := tx.storeTx()
+	if err != nil {
+		return false, err
+	}
+	return tx.db.hasCollection(name, stx)
+}
+
+// Insert adds the supplied documents to a collection.
+func (tx *Tx) Insert(collectionName string, docs ...*d.Document) error {
+	return tx.write(func(stx store.Tx) error {
+		return tx.db.insert(stx, collectionName, docs...)
+	})
+}
+
+// InsertOne inserts a single document to an existing collection. It returns the id of the inserted document.
+func (tx *Tx) InsertOne(collectionName string, doc *d.Document) (string, error) {
+	err := tx.Insert(collectionName, doc)
+	return doc.ObjectId(), err
+}
+
+// Save inserts the supplied document, or replaces it if it already has an id.
+func (tx *Tx) Save(collectionName string, data interface{}) error {
+	doc := d.NewDocumentOf(data)
+	if !doc.Has(d.ObjectIdField) || doc.Get(d.ObjectIdField) == "" {
+		return tx.Insert(collectionName, doc)
+	}
+	return tx.ReplaceById(collectionName, doc.ObjectId(), doc)
+}
+
+// FindAll selects all the documents satisfying q.
+func (tx *Tx) FindAll(q *query.Query) ([]*d.Document, error) {
+	stx, err := tx.storeTx()
+	if err != nil {
+		return nil, err
+	}
+	return tx.db.findAll(stx, q)
+}
+
+// FindFirst returns the first document (if any) satisfying the query.
+func (tx *Tx) FindFirst(q *query.Query) (*d.Document, error) {
+	docs, err := tx.FindAll(q.Limit(1))
+
+	var doc *d.Document
+	if len(docs) > 0 {
+		doc = docs[0]
+	}
+	return doc, err
+}
+
+// FindById returns the document with the given id, if such a document exists, or null.
+func (tx *Tx) FindById(collection string, id string) (*d.Document, error) {
+	stx, err := tx.storeTx()
+	if err != nil {
+		return nil, err
+	}
+	return tx.db.findById(stx, collection, id)
+}
+
+// ForEach runs the consumer function for each document matching the provided query.
+// If false is returned from the consumer function, then the iteration is stopped.
+// The consumer must not modify the database through the same transaction.
+func (tx *Tx) ForEach(q *query.Query, consumer func(_ *d.Document) bool) error {
+	stx, err := tx.storeTx()
+	if err != nil {
+		return err
+	}
+
+	q, err = normalizeCriteria(q)
+	if err != nil {
+		return err
+	}
+
+	return tx.db.iterateDocs(stx, q, func(doc *d.Document) error {
+		if !consumer(doc) {
+			return internal.ErrStopIteration
+		}
+		return nil
+	})
+}
+
+// Count returns the number of documents which satisfy the query.
+// As for DB.Count, its cost grows with the number of expired documents which have not been purged yet.
+func (tx *Tx) Count(q *query.Query) (int, error) {
+	stx, err := tx.storeTx()
+	if err != nil {
+		return -1, err
+	}
+	return tx.db.count(stx, q)
+}
+
+// Exists returns true if and only if the query result set is not empty.
+func (tx *Tx) Exists(q *query.Query) (bool, error) {
+	doc, err := tx.FindFirst(q)
+	return doc != nil, err
+}
+
+// Aggregate groups the documents satisfying the criteria of q according to agg, and returns a document for each group.
+func (tx *Tx) Aggregate(q *query.Query, agg *query.Aggregation) ([]*d.Document, error) {
+	stx, err := tx.storeTx()
+	if err != nil {
+		return nil, err
+	}
+	return tx.db.aggregate(stx, q, agg)
+}
+
+// Update updates all the document selected by q using the provided updateMap.
+// Each update is specified by a mapping fieldName -> newValue.
+func (tx *Tx) Update(q *query.Query, updateMap map[string]interface{}, opts ...UpdateOptions) (*UpdateResult, error) {
+	var res *UpdateResult
+	err := tx.write(func(stx store.Tx) (err error) {
+		res, err = tx.db.updateFunc(stx, q, updateMapFunc(updateMap), getUpdateOptions(opts))
+		return err
+	})
+	return res, err
+}
+
+// UpdateFunc updates all the document selected by q using the provided function.
+func (tx *Tx) UpdateFunc(q *query.Query, updateFunc func(doc *d.Document) *d.Document, opts ...UpdateOptions) (*UpdateResult, error) {
+	var res *UpdateResult
+	err := tx.write(func(stx store.Tx) (err error) {
+		res, err = tx.db.updateFunc(stx, q, funcUpdater(updateFunc), getUpdateOptions(opts))
+		return err
+	})
+	return res, err
+}
+
+// UpdateById updates the document with the specified id using the supplied function.
+// If no document with the specified id exists, an ErrDocumentNotExist is returned.
+func (tx *Tx) UpdateById(collectionName string, docId string, updater func(doc *d.Document) *d.Document, opts ...UpdateOptions) error {
+	return tx.write(func(stx store.Tx) error {
+		return tx.db.updateById(stx, collectionName, docId, funcUpdater(updater), ChangeUpdate, getUpdateOptions(opts))
+	})
+}
+
+// UpdateWith applies u to all the documents selected by q.
+func (tx *Tx) UpdateWith(q *query.Query, u *query.Update, opts ...UpdateOptions) (*UpdateResult, error) {
+	var res *UpdateResult
+	err := tx.write(func(stx store.Tx) (err error) {
+		res, err = tx.db.updateFunc(stx, q, updateSpecFunc(u), getUpdateOptions(opts))
+		return err
+	})
+	return res, err
+}
+
+// UpdateByIdWith applies u to the document with the specified id.
+// If no document with the specified id exists, an ErrDocumentNotExist is returned.
+func (tx *Tx) UpdateByIdWith(collectionName string, docId string, u *query.Update, opts ...UpdateOptions) error {
+	return tx.write(func(stx store.Tx) error {
+		return tx.db.updateById(stx, collectionName, docId, updateSpecFunc(u), ChangeUpdate, getUpdateOptions(opts))
+	})
+}
+
+// ReplaceById replaces the document with the specified id with the one provided.
+// If no document exists, an ErrDocumentNotExist is returned.
+// In versioned collections, the document must carry the version of the stored one, otherwise an ErrVersionConflict is returned.
+func (tx *Tx) ReplaceById(collection, docId string, doc *d.Document) error {
+	return tx.write(func(stx store.Tx) error {
+		return tx.db.replaceById(stx, collection, docId, doc)
+	})
+}
+
+// Delete removes all the documents selected by q from the underlying collection.
+func (tx *Tx) Delete(q *query.Query) (*DeleteResult, error) {
+	var res *DeleteResult
+	err := tx.write(func(stx store.Tx) (err error) {
+		res, err = tx.db.delete(stx, q)
+		return err
+	})
+	return res, err
+}
+
+// FindOneAndUpdate applies u to the first document selected by q, and returns the original document (or the updated one, if the ReturnNew option is set).
+func (tx *Tx) FindOneAndUpdate(q *query.Query, u *query.Update, opts ...FindOneAndUpdateOptions) (*d.Document, error) {
+	var res *d.Document
+	err := tx.write(func(stx store.Tx) (err error) {
+		res, err = tx.db.findOneAndUpdate(stx, q, updateSpecFunc(u), getFindOneAndUpdateOptions(opts))
+		return err
+	})
+	return res, err
+}
+
+// FindOneAndDelete deletes the first document selected by q, and returns it. If no document is selected, nil is returned.
+func (tx *Tx) FindOneAndDelete(q *query.Query) (*d.Document, error) {
+	var res *d.Document
+	err := tx.write(func(stx store.Tx) (err error) {
+		res, err = tx.db.findOneAndDelete(stx, q)
+		return err
+	})
+	return res, err
+}
+
+// DeleteById removes the document with the given id from the underlying collection.
+func (tx *Tx) DeleteById(collection string, id string) error {
+	return tx.write(func(stx store.Tx) error {
+		return tx.db.deleteById(stx, collection, id)
+	})
+}
+
+// ValidateCollection checks the documents of a collection against its schema, and returns the ones which do not conform to it.
+func (tx *Tx) ValidateCollection(name string) ([]InvalidDocument, error) {
+	stx, err := tx.storeTx()
+	if err != nil {
+		return nil, err
+	}
+	return tx.db.validateCollection(stx, name)
+}
@@ -0,0 +1,174 @@
+package clover
+
+import (
+	"errors"
+	"fmt"
+
+	d "github.com/ostafen/clover/v2/document"
+	"github.com/ostafen/clover/v2/internal"
+	"github.com/ostafen/clover/v2/query"
+	"github.com/ostafen/clover/v2/util"
+)
+
+// ErrInvalidUpdate is returned when an update operation cannot be applied to a document, for example when incrementing a non numeric field.
+var ErrInvalidUpdate = errors.New("invalid update")
+
+// UpdateWith applies u to all the documents selected by q.
+// If u cannot be applied to any of the documents, no document is modified and an error wrapping ErrInvalidUpdate is returned.
+func (db *DB) UpdateWith(q *query.Query, u *query.Update, opts ...UpdateOptions) (*UpdateResult, error) {
+	return db.runUpdate(q, updateSpecFunc(u), getUpdateOptions(opts))
+}
+
+// UpdateByIdWith applies u to the document with the specified id.
+// If no document with the specified id exists, an ErrDocumentNotExist is returned, unless the Upsert option is set.
+func (db *DB) UpdateByIdWith(collectionName string, docId string, u *query.Update, opts ...UpdateOptions) error {
+	tx, err := db.beginUpdate()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := db.updateById(tx, collectionName, docId, updateSpecFunc(u), ChangeUpdate, getUpdateOptions(opts)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func updateSpecFunc(u *query.Update) docUpdater {
+	return func(doc *d.Document) (*d.Document, error) {
+		newDoc := doc.Copy()
+		for _, op := range u.Operations() {
+			if err := applyUpdateOperation(newDoc, op); err != nil {
+				return nil, err
+			}
+		}
+		return newDoc, nil
+	}
+}
+
+func applyUpdateOperation(doc *d.Document, op query.UpdateOperation) error {
+	if op.Field == d.ObjectIdField {
+		return fmt.Errorf("%w: the %s field cannot be modified", ErrInvalidUpdate, d.ObjectIdField)
+	}
+
+	value, err := internal.Normalize(op.Value)
+	if err != nil {
+		return err
+	}
+
+	switch op.OpType {
+	case query.SetOp:
+		doc.Set(op.Field, value)
+	case query.IncOp:
+		return applyInc(doc, op.Field, value)
+	case query.PushOp:
+		values, _ := value.([]interface{})
+		return applyPush(doc, op.Field, values)
+	case query.PullOp:
+		values, _ := value.([]interface{})
+		return applyPull(doc, op.Field, values)
+	case query.UnsetOp:
+		doc.Unset(op.Field)
+	case query.SetMinOp, query.SetMaxOp:
+		if !doc.Has(op.Field) {
+			doc.Set(op.Field, value)
+			return nil
+		}
+
+		res := internal.Compare(value, doc.Get(op.Field))
+		if (op.OpType == query.SetMinOp && res < 0) || (op.OpType == query.SetMaxOp && res > 0) {
+			doc.Set(op.Field, value)
+		}
+	case query.RenameOp:
+		newName, _ := value.(string)
+		if newName == "" || newName == d.ObjectIdField {
+			return fmt.Errorf("%w: invalid new name for field %s", ErrInvalidUpdate, op.Field)
+		}
+
+		if doc.Has(op.Field) {
+			v := doc.Get(op.Field)
+			doc.Unset(op.Field)
+			doc.Set(newName, v)
+		}
+	default:
+		return fmt.Errorf("%w: unknown operator %d", ErrInvalidUpdate, op.OpType)
+	}
+	return nil
+}
+
+func applyInc(doc *d.Document, field string, amount interface{}) error {
+	if !util.IsNumber(amount) {
+		return fmt.Errorf("%w: cannot increment field %s by a non numeric value", ErrInvalidUpdate, field)
+	}
+
+	if !doc.Has(field) {
+		doc.Set(field, amount)
+		return nil
+	}
+
+	v := doc.Get(field)
+	if !util.IsNumber(v) {
+		return fmt.Errorf("%w: cannot increment field %s of type %s", ErrInvalidUpdate, field, internal.TypeName(v))
+	}
+
+	_, isFloat := v.(float64)
+	_, isFloatAmount := amount.(float64)
+	if isFloat || isFloatAmount {
+		doc.Set(field, util.ToFloat64(v)+util.ToFloat64(amount))
+	} else {
+		doc.Set(field, util.ToInt64(v)+util.ToInt64(amount))
+	}
+	return nil
+}
+
+// getArrayField returns the array stored in field. Missing fields and null values are treated as nil arrays.
+func getArrayField(doc *d.Document, field string) ([]interface{}, error) {
+	v := doc.Get(field)
+	if v == nil {
+		return nil, nil
+	}
+
+	arr, isArray := v.([]interface{})
+	if !isArray {
+		return nil, fmt.Errorf("%w: field %s of type %s is not an array", ErrInvalidUpdate, field, internal.TypeName(v))
+	}
+	return arr, nil
+}
+
+func applyPush(doc *d.Document, field string, values []interface{}) error {
+	arr, err := getArrayField(doc, field)
+	if err != nil {
+		return err
+	}
+
+	// a new slice is always allocated, since the old one is shared with the original document
+	newArr := make([]interface{}, 0, len(arr)+len(values))
+	newArr = append(newArr, arr...)
+	doc.Set(field, append(newArr, values...))
+	return nil
+}
+
+func applyPull(doc *d.Document, field string, values []interface{}) error {
+	arr, err := getArrayField(doc, field)
+	if err != nil || arr == nil {
+		return err
+	}
+
+	newArr := make([]interface{}, 0, len(arr))
+	for _, elem := range arr {
+		if !containsValue(values, elem) {
+			newArr = append(newArr, elem)
+		}
+	}
+	doc.Set(field, newArr)
+	return nil
+}
+
+func containsValue(values []interface{}, v interface{}) bool {
+	for _, value := range values {
+		if internal.Compare(value, v) == 0 {
+			return true
+		}
+	}
+	return false
+}
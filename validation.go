@@ -0,0 +1,109 @@
+package clover
+
+import (
+	"log"
+
+	d "github.com/ostafen/clover/v2/document"
+	"github.com/ostafen/clover/v2/query"
+	"github.com/ostafen/clover/v2/store"
+)
+
+// ValidationMode tells what happens when a document violates the schema of its collection.
+type ValidationMode int
+
+const (
+	// ValidationStrict rejects documents violating the schema. The write operation fails with a *schema.ValidationError.
+	ValidationStrict ValidationMode = iota
+	// ValidationWarn accepts documents violating the schema, and reports each violation to the SchemaWarningHandler of the database
+	// once the transaction performing the write commits.
+	ValidationWarn
+)
+
+// SchemaWarningHandler is a function which is notified of documents violating the schema of a collection created with the ValidationWarn mode.
+type SchemaWarningHandler func(collection string, docId string, err error)
+
+func logSchemaWarning(collection string, docId string, err error) {
+	log.Printf("document %s of collection %s: %s\n", docId, collection, err.Error())
+}
+
+// schemaIgnoredFields are managed by the database, so that they are allowed even by schemas not listing them.
+var schemaIgnoredFields = []string{d.ObjectIdField, d.ExpiresAtField, d.VersionField}
+
+func validateAgainstSchema(meta *collectionMetadata, doc *d.Document) error {
+	if meta.Schema == nil {
+		return nil
+	}
+	return meta.Schema.Validate(doc.AsMap(), schemaIgnoredFields...)
+}
+
+// schemaWarning is a schema violation accepted in ValidationWarn mode, which is reported once the transaction commits.
+type schemaWarning struct {
+	collection string
+	docId      string
+	err        error
+}
+
+// validateSchema checks that doc conforms to the schema of the collection, if any.
+// In ValidationWarn mode, violations are recorded into the transaction, to be reported to the warning handler on commit, and nil is returned.
+func (db *DB) validateSchema(tx store.Tx, collection string, meta *collectionMetadata, doc *d.Document) error {
+	err := validateAgainstSchema(meta, doc)
+	if err == nil || meta.ValidationMode != ValidationWarn {
+		return err
+	}
+
+	warning := schemaWarning{collection: collection, docId: doc.ObjectId(), err: err}
+	if ctx, ok := tx.(*changeTx); ok {
+		ctx.warnings = append(ctx.warnings, warning)
+	} else {
+		db.reportSchemaWarnings([]schemaWarning{warning})
+	}
+	return nil
+}
+
+func (db *DB) reportSchemaWarnings(warnings []schemaWarning) {
+	if db.schemaWarningHandler == nil {
+		return
+	}
+
+	for _, w := range warnings {
+		db.schemaWarningHandler(w.collection, w.docId, w.err)
+	}
+}
+
+// InvalidDocument describes a document violating the schema of its collection.
+type InvalidDocument struct {
+	DocumentId string
+	Err        error
+}
+
+// ValidateCollection checks the documents of a collection against its schema, and returns the ones which do not conform to it.
+// This is useful to find the documents which have been accepted in ValidationWarn mode.
+func (db *DB) ValidateCollection(name string) ([]InvalidDocument, error) {
+	tx, err := db.store.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	return db.validateCollection(tx, name)
+}
+
+func (db *DB) validateCollection(tx store.Tx, name string) ([]InvalidDocument, error) {
+	meta, err := db.getCollectionMeta(name, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	invalidDocs := make([]InvalidDocument, 0)
+	if meta.Schema == nil {
+		return invalidDocs, nil
+	}
+
+	err = db.iterateDocs(tx, query.NewQuery(name), func(doc *d.Document) error {
+		if err := validateAgainstSchema(meta, doc); err != nil {
+			invalidDocs = append(invalidDocs, InvalidDocument{DocumentId: doc.ObjectId(), Err: err})
+		}
+		return nil
+	})
+	return invalidDocs, err
+}
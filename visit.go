@@ -1,6 +1,8 @@
 package clover
 
 import (
+	"strings"
+
 	"github.com/ostafen/clover/v2/index"
 	"github.com/ostafen/clover/v2/internal"
 	"github.com/ostafen/clover/v2/query"
@@ -95,40 +97,151 @@ func (v *NotFlattenVisitor) removeNotCriteria(c *query.NotCriteria) query.Criter
 	return c
 }
 
-type IndexSelectVisitor struct {
-	Fields map[string]*index.Info
+// IndexQueryVisitor builds an index query selecting a superset of the documents which satisfy the criteria,
+// or returns nil if the criteria cannot be answered using the available single field indexes.
+// Logical ors are mapped to the union of the queries of their operands (provided that both of them are indexed),
+// while logical ands are mapped to the intersection of the queries over different fields.
+// Ranges over the same field within a conjunction are merged into a single range query.
+// Search and geospatial criteria are mapped to queries over the full-text and geospatial indexes of their field.
+type IndexQueryVisitor struct {
+	Indexes     map[string]index.RangeIndex
+	TextIndexes map[string]index.FullTextIndex // full-text indexes, by indexed field
+	GeoIndexes  map[string]index.GeoIndex      // geospatial indexes, by indexed field
+}
+
+func (v *IndexQueryVisitor) rangeQuery(c *query.UnaryCriteria) *index.RangeIndexQuery {
+	idx := v.Indexes[c.Field]
+	if idx == nil || isFieldReference(c.Value) {
+		return nil
+	}
+
+	r := unaryCriteriaToRange(c)
+	if r == nil {
+		return nil
+	}
+	return &index.RangeIndexQuery{Range: r, Idx: idx}
 }
 
-func (v *IndexSelectVisitor) VisitUnaryCriteria(c *query.UnaryCriteria) interface{} {
-	info := v.Fields[c.Field]
-	if info != nil {
-		return []*index.Info{info}
+func (v *IndexQueryVisitor) VisitUnaryCriteria(c *query.UnaryCriteria) interface{} {
+	if c.OpType == query.InOp {
+		return v.inQuery(c)
+	}
+
+	if c.OpType == query.SearchOp {
+		return v.searchQuery(c)
+	}
+
+	if region, isGeo := query.GeoRegionOf(c); isGeo {
+		return v.geoQuery(c, region)
 	}
-	return []*index.Info{}
+
+	if q := v.rangeQuery(c); q != nil {
+		return q
+	}
+	return nil
 }
 
-func (v *IndexSelectVisitor) VisitBinaryCriteria(c *query.BinaryCriteria) interface{} {
-	leftIndexes := c.C1.Accept(v).([]*index.Info)
-	rightIndexes := c.C2.Accept(v).([]*index.Info)
+// inQuery maps an InOp criteria to the union of the equality queries of its values.
+func (v *IndexQueryVisitor) inQuery(c *query.UnaryCriteria) interface{} {
+	values, _ := c.Value.([]interface{})
+	if v.Indexes[c.Field] == nil || values == nil {
+		return nil
+	}
 
-	if c.OpType == query.LogicalAnd { // select the indexes with the lowest number of queries
-		if len(leftIndexes) > 0 && len(leftIndexes) < len(rightIndexes) {
-			return leftIndexes
+	queries := make([]index.Query, 0, len(values))
+	for _, value := range values {
+		q := v.rangeQuery(&query.UnaryCriteria{OpType: query.EqOp, Field: c.Field, Value: value})
+		if q == nil {
+			return nil
 		}
-		return rightIndexes
+		queries = append(queries, q)
 	}
+	return &index.UnionQuery{Queries: queries}
+}
 
-	if len(leftIndexes) == 0 || len(rightIndexes) == 0 {
-		return []*index.Info{}
+func (v *IndexQueryVisitor) searchQuery(c *query.UnaryCriteria) interface{} {
+	idx := v.TextIndexes[c.Field]
+	text, isString := c.Value.(string)
+	if idx == nil || !isString {
+		return nil
 	}
+	return &index.FullTextQuery{Terms: internal.Tokenize(text), Idx: idx}
+}
 
-	res := make([]*index.Info, 0, len(leftIndexes)+len(rightIndexes))
-	res = append(res, leftIndexes...)
-	res = append(res, rightIndexes...)
-	return res
+func (v *IndexQueryVisitor) geoQuery(c *query.UnaryCriteria, region *query.GeoRegion) interface{} {
+	idx := v.GeoIndexes[c.Field]
+	if idx == nil {
+		return nil
+	}
+	return &index.GeoQuery{Region: region, Idx: idx}
 }
 
-func (v *IndexSelectVisitor) VisitNotCriteria(c *query.NotCriteria) interface{} {
+func appendConjuncts(conjuncts []query.Criteria, c query.Criteria) []query.Criteria {
+	if binCriteria, ok := c.(*query.BinaryCriteria); ok && binCriteria.OpType == query.LogicalAnd {
+		conjuncts = appendConjuncts(conjuncts, binCriteria.C1)
+		return appendConjuncts(conjuncts, binCriteria.C2)
+	}
+	return append(conjuncts, c)
+}
+
+func (v *IndexQueryVisitor) VisitBinaryCriteria(c *query.BinaryCriteria) interface{} {
+	if c.OpType == query.LogicalOr {
+		left, _ := c.C1.Accept(v).(index.Query)
+		right, _ := c.C2.Accept(v).(index.Query)
+
+		if left == nil || right == nil { // a full scan is needed anyway
+			return nil
+		}
+
+		queries := make([]index.Query, 0, 2)
+		for _, q := range []index.Query{left, right} {
+			if union, ok := q.(*index.UnionQuery); ok {
+				queries = append(queries, union.Queries...)
+			} else {
+				queries = append(queries, q)
+			}
+		}
+		return &index.UnionQuery{Queries: queries}
+	}
+
+	fields := make([]string, 0)
+	ranges := make(map[string]*index.RangeIndexQuery)
+	queries := make([]index.Query, 0)
+
+	for _, conjunct := range appendConjuncts(nil, c) {
+		if unaryCriteria, ok := conjunct.(*query.UnaryCriteria); ok && unaryCriteria.OpType != query.InOp {
+			if q := v.rangeQuery(unaryCriteria); q != nil {
+				if prev := ranges[unaryCriteria.Field]; prev != nil {
+					prev.Range = prev.Range.Intersect(q.Range)
+				} else {
+					ranges[unaryCriteria.Field] = q
+					fields = append(fields, unaryCriteria.Field)
+				}
+			}
+			continue
+		}
+
+		if q, ok := conjunct.Accept(v).(index.Query); ok {
+			queries = append(queries, q)
+		}
+	}
+
+	rangeQueries := make([]index.Query, 0, len(fields))
+	for _, field := range fields {
+		rangeQueries = append(rangeQueries, ranges[field])
+	}
+	queries = append(rangeQueries, queries...)
+
+	switch len(queries) {
+	case 0:
+		return nil
+	case 1:
+		return queries[0]
+	}
+	return &index.IntersectionQuery{Queries: queries}
+}
+
+func (v *IndexQueryVisitor) VisitNotCriteria(c *query.NotCriteria) interface{} {
 	return nil
 }
 
@@ -176,6 +289,48 @@ func (v *FieldRangeVisitor) VisitNotCriteria(c *query.NotCriteria) interface{} {
 	return c.C.Accept(v)
 }
 
+// ConjunctiveRangeVisitor computes, for each field, the range of values implied by the criteria.
+// Unlike FieldRangeVisitor, only the operands of logical ands are considered: any other node
+// (for example, a logical or) does not restrict the range of its fields. As a consequence, each returned range
+// is a necessary condition for a document to satisfy the criteria.
+type ConjunctiveRangeVisitor struct {
+}
+
+func (v *ConjunctiveRangeVisitor) VisitUnaryCriteria(c *query.UnaryCriteria) interface{} {
+	if isFieldReference(c.Value) { // the value is only known when the document is evaluated
+		return map[string]*index.Range{}
+	}
+
+	r := unaryCriteriaToRange(c)
+	if r != nil {
+		return map[string]*index.Range{c.Field: r}
+	}
+	return map[string]*index.Range{}
+}
+
+func (v *ConjunctiveRangeVisitor) VisitBinaryCriteria(c *query.BinaryCriteria) interface{} {
+	if c.OpType != query.LogicalAnd {
+		return map[string]*index.Range{}
+	}
+
+	leftRanges := c.C1.Accept(v).(map[string]*index.Range)
+	rightRanges := c.C2.Accept(v).(map[string]*index.Range)
+
+	for key, value := range rightRanges {
+		vRange := leftRanges[key]
+		if vRange == nil {
+			leftRanges[key] = value
+		} else {
+			leftRanges[key] = vRange.Intersect(value)
+		}
+	}
+	return leftRanges
+}
+
+func (v *ConjunctiveRangeVisitor) VisitNotCriteria(c *query.NotCriteria) interface{} {
+	return map[string]*index.Range{}
+}
+
 type CriteriaNormalizeVisitor struct {
 	err error
 }
@@ -224,6 +379,62 @@ func (v *CriteriaNormalizeVisitor) VisitNotCriteria(c *query.NotCriteria) interf
 	return &query.NotCriteria{C: res.(query.Criteria)}
 }
 
+// CriteriaFieldsVisitor returns the names of the fields referenced by a criteria, including the fields whose values are compared.
+// It returns nil if the criteria contains a function, since the fields accessed by the function cannot be known.
+type CriteriaFieldsVisitor struct {
+}
+
+func (v *CriteriaFieldsVisitor) VisitUnaryCriteria(c *query.UnaryCriteria) interface{} {
+	if c.OpType == query.FunctionOp {
+		return nil
+	}
+
+	fields := []string{c.Field}
+
+	values, isSlice := c.Value.([]interface{})
+	if !isSlice || (c.OpType != query.InOp && c.OpType != query.ContainsOp) {
+		values = []interface{}{c.Value}
+	}
+
+	for _, value := range values {
+		if name := getReferencedField(value); name != "" {
+			fields = append(fields, name)
+		}
+	}
+	return fields
+}
+
+func (v *CriteriaFieldsVisitor) VisitBinaryCriteria(c *query.BinaryCriteria) interface{} {
+	leftFields, _ := c.C1.Accept(v).([]string)
+	rightFields, _ := c.C2.Accept(v).([]string)
+
+	if leftFields == nil || rightFields == nil {
+		return nil
+	}
+	return append(leftFields, rightFields...)
+}
+
+func (v *CriteriaFieldsVisitor) VisitNotCriteria(c *query.NotCriteria) interface{} {
+	return c.C.Accept(v)
+}
+
+// getReferencedField returns the name of the field referenced by v, if v is a field reference, or the empty string.
+func getReferencedField(v interface{}) string {
+	if f, ok := v.(interface{ Name() string }); ok && query.IsField(v) {
+		return f.Name()
+	}
+
+	if s, isString := v.(string); isString && strings.HasPrefix(s, "$") {
+		return strings.TrimLeft(s, "$")
+	}
+	return ""
+}
+
+func isFieldReference(v interface{}) bool {
+	s, isString := v.(string)
+	return query.IsField(v) || (isString && strings.HasPrefix(s, "$"))
+}
+
 func unaryCriteriaToRange(c *query.UnaryCriteria) *index.Range {
 	switch c.OpType {
 	case query.EqOp:
@@ -3,7 +3,6 @@ package clover
 import (
 	"testing"
 
-	"github.com/ostafen/clover/v2/index"
 	q "github.com/ostafen/clover/v2/query"
 	"github.com/stretchr/testify/require"
 )
@@ -58,30 +57,3 @@ func TestFlattenNot3(t *testing.T) {
 	require.Equal(t, c1.OpType, q.LtOp)
 	require.Equal(t, c2.OpType, q.GtOp)
 }
-
-func TestSelectIndexes(t *testing.T) {
-	c := q.Field("a").Gt(1).And(q.Field("a").Lt(2)).Or(q.Field("b").Eq(100))
-
-	c = c.Accept(&CriteriaNormalizeVisitor{}).(q.Criteria)
-	c = c.Accept(&NotFlattenVisitor{}).(q.Criteria)
-
-	s := c.Accept(&IndexSelectVisitor{Fields: map[string]*index.Info{
-		"a": {Field: "a"},
-	}}).([]*index.Info)
-	require.Len(t, s, 0)
-
-	s = c.Accept(&IndexSelectVisitor{Fields: map[string]*index.Info{
-		"b": {Field: "b"},
-	}}).([]*index.Info)
-	require.Len(t, s, 0)
-
-	s = c.Accept(&IndexSelectVisitor{Fields: map[string]*index.Info{
-		"a": {Field: "a"},
-		"b": {Field: "b"},
-	}}).([]*index.Info)
-
-	require.Len(t, s, 2)
-
-	require.Equal(t, s[0], &index.Info{Field: "a"})
-	require.Equal(t, s[1], &index.Info{Field: "b"})
-}
@@ -0,0 +1,273 @@
+package clover
+
+import (
+	"errors"
+
+	d "github.com/ostafen/clover/v2/document"
+	"github.com/ostafen/clover/v2/query"
+	"github.com/ostafen/clover/v2/store"
+)
+
+// DefaultWatchBufferSize is the default number of events which can be buffered by a ChangeStream.
+const DefaultWatchBufferSize = 1024
+
+// ErrChangeStreamOverflow is returned by ChangeStream.Err when the stream has been closed because its consumer could not keep up with the events.
+var ErrChangeStreamOverflow = errors.New("change stream buffer overflow")
+
+// ChangeType identifies the kind of mutation described by a ChangeEvent.
+type ChangeType int
+
+const (
+	ChangeInsert ChangeType = iota
+	ChangeUpdate
+	ChangeReplace
+	ChangeDelete
+	ChangeDrop
+)
+
+func (t ChangeType) String() string {
+	switch t {
+	case ChangeInsert:
+		return "insert"
+	case ChangeUpdate:
+		return "update"
+	case ChangeReplace:
+		return "replace"
+	case ChangeDelete:
+		return "delete"
+	case ChangeDrop:
+		return "drop"
+	}
+	return "unknown"
+}
+
+// ChangeEvent describes a committed mutation of a collection.
+// OldDoc is nil for insertions, while NewDoc is nil for deletions. Both are nil when a collection is dropped.
+// Events are shared among all the streams receiving them, and must not be modified.
+type ChangeEvent struct {
+	// Seq is a monotonically increasing sequence number, assigned in commit order. Sequence numbers restart from 1 when the database is opened.
+	Seq        uint64
+	Type       ChangeType
+	Collection string
+	DocumentId string
+	OldDoc     *d.Document
+	NewDoc     *d.Document
+}
+
+// WatchOptions contains the options of a ChangeStream.
+type WatchOptions struct {
+	// Filter, if not nil, restricts the stream to the events whose old or new document satisfies the criteria.
+	// Drop events are always delivered.
+	Filter query.Criteria
+	// BufferSize is the number of events which can be buffered before the stream is closed with ErrChangeStreamOverflow.
+	// If zero, DefaultWatchBufferSize is used.
+	BufferSize int
+}
+
+// ChangeStream delivers the change events of a collection.
+type ChangeStream struct {
+	db         *DB
+	collection string
+	filter     query.Criteria
+	ch         chan *ChangeEvent
+	err        error
+	closed     bool
+}
+
+// Watch returns a stream of the changes committed to the given collection after the call.
+// The stream must be closed by calling Close when it is no longer needed.
+func (db *DB) Watch(collection string, opts *WatchOptions) (*ChangeStream, error) {
+	if opts == nil {
+		opts = &WatchOptions{}
+	}
+
+	var filter query.Criteria
+	if opts.Filter != nil {
+		q, err := normalizeCriteria(query.NewQuery(collection).Where(opts.Filter))
+		if err != nil {
+			return nil, err
+		}
+		filter = q.Criteria()
+	}
+
+	bufferSize := opts.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = DefaultWatchBufferSize
+	}
+
+	cs := &ChangeStream{
+		db:         db,
+		collection: collection,
+		filter:     filter,
+		ch:         make(chan *ChangeEvent, bufferSize),
+	}
+
+	db.watchMu.Lock()
+	defer db.watchMu.Unlock()
+
+	if db.streams == nil {
+		db.streams = make(map[*ChangeStream]bool)
+	}
+	db.streams[cs] = true
+	return cs, nil
+}
+
+// Events returns the channel delivering the events of the stream. The channel is closed when the stream is closed.
+func (cs *ChangeStream) Events() <-chan *ChangeEvent {
+	return cs.ch
+}
+
+// Err returns the error which caused the stream to be closed, if any.
+func (cs *ChangeStream) Err() error {
+	cs.db.watchMu.Lock()
+	defer cs.db.watchMu.Unlock()
+	return cs.err
+}
+
+// Close stops the delivery of events and closes the channel returned by Events.
+func (cs *ChangeStream) Close() error {
+	cs.db.watchMu.Lock()
+	defer cs.db.watchMu.Unlock()
+
+	cs.db.closeStream(cs, nil)
+	return nil
+}
+
+func (cs *ChangeStream) accepts(event *ChangeEvent) bool {
+	if event.Collection != cs.collection {
+		return false
+	}
+
+	if cs.filter == nil || event.Type == ChangeDrop {
+		return true
+	}
+	return (event.OldDoc != nil && cs.filter.Satisfy(event.OldDoc)) || (event.NewDoc != nil && cs.filter.Satisfy(event.NewDoc))
+}
+
+// closeStream must be called while holding watchMu.
+func (db *DB) closeStream(cs *ChangeStream, err error) {
+	if cs.closed {
+		return
+	}
+
+	cs.closed = true
+	cs.err = err
+	close(cs.ch)
+
+	delete(db.streams, cs)
+}
+
+func (db *DB) closeAllStreams() {
+	db.watchMu.Lock()
+	defer db.watchMu.Unlock()
+
+	for cs := range db.streams {
+		db.closeStream(cs, nil)
+	}
+}
+
+func (db *DB) publishChanges(events []*ChangeEvent) {
+	if len(events) == 0 {
+		return
+	}
+
+	db.watchMu.Lock()
+	defer db.watchMu.Unlock()
+
+	for _, event := range events {
+		db.seq++
+		event.Seq = db.seq
+
+		for cs := range db.streams {
+			if !cs.accepts(event) {
+				continue
+			}
+
+			select {
+			case cs.ch <- event:
+			default: // never block writers because of a slow consumer
+				db.closeStream(cs, ErrChangeStreamOverflow)
+			}
+		}
+	}
+}
+
+// changeTx collects the change events produced within a read-write transaction, which are published once the transaction commits.
+// Schema warnings are deferred until commit as well, so that they are never reported for writes which are rolled back.
+// It also counts the writes performed through it, so that a Tx can tell whether a failed operation left partial effects.
+type changeTx struct {
+	store.Tx
+	db       *DB
+	events   []*ChangeEvent
+	warnings []schemaWarning
+	writes   int
+}
+
+func (tx *changeTx) Set(key, value []byte) error {
+	tx.writes++
+	return tx.Tx.Set(key, value)
+}
+
+func (tx *changeTx) Delete(key []byte) error {
+	tx.writes++
+	return tx.Tx.Delete(key)
+}
+
+// Commit commits the underlying transaction, publishes its events and reports its schema warnings.
+func (tx *changeTx) Commit() error {
+	if err := tx.commitAndPublish(); err != nil {
+		return err
+	}
+	tx.db.reportSchemaWarnings(tx.warnings)
+	return nil
+}
+
+// Transactions producing events are committed one at a time, so that sequence numbers follow the commit order.
+func (tx *changeTx) commitAndPublish() error {
+	if len(tx.events) == 0 {
+		return tx.Tx.Commit()
+	}
+
+	tx.db.commitMu.Lock()
+	defer tx.db.commitMu.Unlock()
+
+	if err := tx.Tx.Commit(); err != nil {
+		return err
+	}
+	tx.db.publishChanges(tx.events)
+	return nil
+}
+
+func (db *DB) beginUpdate() (*changeTx, error) {
+	tx, err := db.store.Begin(true)
+	if err != nil {
+		return nil, err
+	}
+	return &changeTx{Tx: tx, db: db}, nil
+}
+
+// recordChange adds an event to the ones produced by the transaction.
+// Events are recorded even if no stream is open, since a stream could be opened before the transaction commits.
+func recordChange(tx store.Tx, changeType ChangeType, collection string, oldDoc, newDoc *d.Document) {
+	ctx, ok := tx.(*changeTx)
+	if !ok {
+		return
+	}
+
+	event := &ChangeEvent{
+		Type:       changeType,
+		Collection: collection,
+	}
+
+	// documents are copied, since they could be modified by the caller after the transaction commits
+	if oldDoc != nil {
+		event.OldDoc = oldDoc.Copy()
+		event.DocumentId = oldDoc.ObjectId()
+	}
+
+	if newDoc != nil {
+		event.NewDoc = newDoc.Copy()
+		event.DocumentId = newDoc.ObjectId()
+	}
+	ctx.events = append(ctx.events, event)
+}